@@ -10,6 +10,7 @@ from uuid import uuid4
 SEARCH_REQUEST_TOPIC = "seraph.search"
 SEARCH_ACK_TOPIC_PATTERN = "seraph.search.%s.ack"
 SEARCH_REPLY_TOPIC_PATTERN = "seraph.search.%s.reply"
+SEARCH_CANCEL_TOPIC_PATTERN = "seraph.search.%s.cancel"
 SEARCH_TYPE_FILES = "files"
 
 
@@ -36,6 +37,7 @@ class AgentSearchClient:
         request_id = self._request_id_factory()
         ack_sub = await self._nc.subscribe(SEARCH_ACK_TOPIC_PATTERN % request_id)
         reply_sub = await self._nc.subscribe(SEARCH_REPLY_TOPIC_PATTERN % request_id)
+        completed = False
         try:
             payload = {
                 "requestId": request_id,
@@ -60,6 +62,7 @@ class AgentSearchClient:
                 if reply.get("error"):
                     raise RuntimeError(str(reply["error"]))
                 if reply.get("last"):
+                    completed = True
                     return hits
                 if reply.get("type") != SEARCH_TYPE_FILES:
                     continue
@@ -71,5 +74,9 @@ class AgentSearchClient:
                 path = "/" + path_value.lstrip("/")
                 hits.append(SearchFileHit(provider_id=provider_id, path=path))
         finally:
+            if not completed:
+                # participants stop working on a request that is no longer awaited
+                cancel = {"requestId": request_id}
+                await self._nc.publish(SEARCH_CANCEL_TOPIC_PATTERN % request_id, json.dumps(cancel).encode("utf-8"))
             await ack_sub.unsubscribe()
             await reply_sub.unsubscribe()
@@ -174,6 +174,33 @@ class DocumentsRepository:
         await self._session.delete(document)
         await self._session.commit()
 
+    async def move_document(self, *, provider_id: str, old_path: str, new_path: str) -> bool:
+        result = await self._session.execute(
+            select(IndexedDocument).where(
+                IndexedDocument.provider_id == provider_id,
+                IndexedDocument.path == old_path,
+            )
+        )
+        document = result.scalar_one_or_none()
+        if document is None:
+            return False
+
+        result = await self._session.execute(
+            select(IndexedDocument).where(
+                IndexedDocument.provider_id == provider_id,
+                IndexedDocument.path == new_path,
+            )
+        )
+        existing = result.scalar_one_or_none()
+        if existing is not None:
+            await self._session.execute(delete(DocumentChunk).where(DocumentChunk.document_id == existing.id))
+            await self._session.delete(existing)
+            await self._session.flush()
+
+        document.path = new_path
+        await self._session.commit()
+        return True
+
     async def get_document_with_chunks(
         self, provider_id: str, path: str
     ) -> tuple[IndexedDocument | None, list[DocumentChunk]]:
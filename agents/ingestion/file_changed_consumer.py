@@ -306,6 +306,19 @@ class FileChangedIngestionService:
 
     async def _process_document_event(self, event: FileChangedEvent, normalized_mime: str) -> None:
 
+        if event.change == "moved" and event.old_path:
+            async with self._session_factory() as session:
+                repository = DocumentsRepository(session)
+                moved = await repository.move_document(
+                    provider_id=event.provider_id, old_path=event.old_path, new_path=event.path
+                )
+            if moved:
+                self._log.debug(
+                    "Processed move event",
+                    extra={"path": event.path, "old_path": event.old_path, "provider_id": event.provider_id},
+                )
+                return
+
         if event.change == "deleted":
             async with self._session_factory() as session:
                 repository = DocumentsRepository(session)
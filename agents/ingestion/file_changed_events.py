@@ -33,6 +33,7 @@ FILE_CHANGED_SCHEMA: Dict[str, Any] = {
         {"name": "modTime", "type": "long"},
         {"name": "isDir", "type": "boolean"},
         {"name": "mime", "type": "string"},
+        {"name": "oldPath", "type": "string", "default": ""},
     ],
 }
 
@@ -53,6 +54,7 @@ class FileChangedEvent:
     mod_time: int
     is_dir: bool
     mime: str
+    old_path: str = ""
 
 
 def decode_file_changed_event(payload: bytes) -> FileChangedEvent:
@@ -72,4 +74,5 @@ def decode_file_changed_event(payload: bytes) -> FileChangedEvent:
         mod_time=int(data.get("modTime", 0) or 0),
         is_dir=bool(data.get("isDir", False)),
         mime=data.get("mime", "") or "",
+        old_path=data.get("oldPath", "") or "",
     )
@@ -120,6 +120,43 @@ async def test_delete_document_removes_document_and_chunks() -> None:
     await engine.dispose()
 
 
+@pytest.mark.asyncio
+async def test_move_document_keeps_chunks() -> None:
+    engine = create_async_engine("sqlite+aiosqlite:///:memory:")
+    async with engine.begin() as conn:
+        await conn.run_sync(Base.metadata.create_all)
+
+    session_factory = async_sessionmaker(engine, expire_on_commit=False)
+    async with session_factory() as db_session:
+        repo = DocumentsRepository(db_session)
+
+        await repo.upsert_document(
+            provider_id="provider-a",
+            file_id="file-1",
+            path="/team/spec.md",
+            mime="text/plain",
+            size=10,
+            mod_time=1,
+            text="hello world",
+        )
+        moved = await repo.move_document(provider_id="provider-a", old_path="/team/spec.md", new_path="/docs/spec.md")
+        missing = await repo.move_document(
+            provider_id="provider-a", old_path="/team/other.md", new_path="/docs/other.md"
+        )
+
+        old_document, _ = await repo.get_document_with_chunks("provider-a", "/team/spec.md")
+        document, chunks = await repo.get_document_with_chunks("provider-a", "/docs/spec.md")
+
+    assert moved is True
+    assert missing is False
+    assert old_document is None
+    assert document is not None
+    assert document.file_id == "file-1"
+    assert [chunk.content for chunk in chunks] == ["hello world"]
+
+    await engine.dispose()
+
+
 @pytest.mark.asyncio
 async def test_upsert_document_splits_large_text_into_multiple_chunks() -> None:
     engine = create_async_engine("sqlite+aiosqlite:///:memory:")
@@ -163,3 +163,45 @@ async def test_search_client_times_out_when_ack_never_arrives() -> None:
 
     with pytest.raises(RuntimeError, match="acknowledgement timed out"):
         await client.search_files(user_id="alice", query="spec")
+
+
+@pytest.mark.asyncio
+async def test_search_client_cancels_request_when_replies_time_out() -> None:
+    published: list[tuple[str, bytes]] = []
+
+    class StubSubscription:
+        def __init__(self, messages):
+            self._messages = iter(messages)
+
+        async def next_msg(self):
+            try:
+                return next(self._messages)
+            except StopIteration:
+                await asyncio.Future()
+
+        async def unsubscribe(self) -> None:
+            return None
+
+    class StubNats:
+        async def subscribe(self, subject: str):
+            if subject.endswith(".ack"):
+                return StubSubscription(
+                    [type("Msg", (), {"data": b'{"requestId":"req-1","replyId":"reply-1","ack":true}'})()]
+                )
+            return StubSubscription([])
+
+        async def publish(self, subject: str, payload: bytes) -> None:
+            published.append((subject, payload))
+
+    client = AgentSearchClient(
+        nc=StubNats(),
+        request_id_factory=lambda: "req-1",
+        ack_timeout=0.01,
+        reply_timeout=0.01,
+    )
+
+    with pytest.raises(RuntimeError, match="reply timed out"):
+        await client.search_files(user_id="alice", query="spec")
+
+    assert published[-1][0] == "seraph.search.req-1.cancel"
+    assert json.loads(published[-1][1].decode("utf-8")) == {"requestId": "req-1"}
@@ -0,0 +1,156 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package deadletters
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+)
+
+var Module = fx.Module("deadletters",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log  *logging.Logger
+	Nc   *nats.Conn
+	Auth auth.Auth
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type deadLettersHandler struct {
+	log  *slog.Logger
+	nc   *nats.Conn
+	auth auth.Auth
+}
+
+func New(p Params) Result {
+	return Result{
+		Handler: &deadLettersHandler{
+			log:  p.Log.GetLogger("deadletters"),
+			nc:   p.Nc,
+			auth: p.Auth,
+		},
+	}
+}
+
+func (h *deadLettersHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	// lists the file events that the file indexer failed to process, ?after=<id> continues the list
+	apiGroup.GET("index/deadletters", func(ctx *gin.Context) {
+		if !h.checkAdmin(ctx) {
+			return
+		}
+		req := events.DeadLetterRequest{Action: events.DeadLetterActionList}
+		var err error
+		if after := ctx.Query("after"); after != "" {
+			req.Id, err = strconv.ParseUint(after, 10, 64)
+		}
+		if limit := ctx.Query("limit"); err == nil && limit != "" {
+			req.Limit, err = strconv.Atoi(limit)
+		}
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		res, ok := h.request(ctx, &req)
+		if !ok {
+			return
+		}
+		deadLetters := res.DeadLetters
+		if deadLetters == nil {
+			deadLetters = []events.DeadLetter{}
+		}
+		ctx.JSON(http.StatusOK, deadLetters)
+	})
+
+	// processes a dead-lettered file event again
+	apiGroup.POST("index/deadletters/:id/retry", func(ctx *gin.Context) {
+		h.handleAction(ctx, events.DeadLetterActionRetry)
+	})
+
+	// removes a dead-lettered file event
+	apiGroup.DELETE("index/deadletters/:id", func(ctx *gin.Context) {
+		h.handleAction(ctx, events.DeadLetterActionDiscard)
+	})
+}
+
+func (h *deadLettersHandler) handleAction(ctx *gin.Context, action string) {
+	if !h.checkAdmin(ctx) {
+		return
+	}
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	req := events.DeadLetterRequest{Action: action, Id: id}
+	if _, ok := h.request(ctx, &req); !ok {
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (h *deadLettersHandler) checkAdmin(ctx *gin.Context) bool {
+	if !h.auth.IsSpaceAdmin(ctx) {
+		ctx.AbortWithError(http.StatusForbidden, errors.New("only space admin can manage dead letters"))
+		return false
+	}
+	return true
+}
+
+func (h *deadLettersHandler) request(ctx *gin.Context, req *events.DeadLetterRequest) (*events.DeadLetterResponse, bool) {
+	res := events.DeadLetterResponse{}
+	err := messaging.Request(ctx.Request.Context(), h.nc, events.DeadLetterTopic, messaging.Json(req), messaging.Json(&res))
+	if err != nil {
+		h.log.Error("error while requesting dead letters", "error", err, "action", req.Action)
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if res.NotFound {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return nil, false
+	}
+	if res.Error != "" {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New(res.Error))
+		return nil, false
+	}
+	return &res, true
+}
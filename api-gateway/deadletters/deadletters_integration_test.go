@@ -0,0 +1,142 @@
+package deadletters
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+)
+
+var natsServer *server.Server
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	setup()
+	code := m.Run()
+	shutdown()
+	os.Exit(code)
+}
+
+func setup() {
+	var err error
+	natsServer, err = server.NewServer(&server.Options{Port: -1})
+	if err != nil {
+		panic(err)
+	}
+	natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		panic("nats server not ready")
+	}
+}
+
+func shutdown() {
+	if natsServer != nil {
+		natsServer.Shutdown()
+		natsServer = nil
+	}
+}
+
+func connectNats(t *testing.T) *nats.Conn {
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newDeadLettersApp(t *testing.T, nc *nats.Conn) *gin.Engine {
+	logger := logging.New(logging.Params{})
+	config := viper.New()
+	config.Set("auth.enabled", false)
+	authResult, err := auth.New(auth.Params{
+		Log:   logger,
+		Viper: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(Params{
+		Log:  logger,
+		Nc:   nc,
+		Auth: authResult.Auth,
+	})
+
+	app := gin.New()
+	res.Handler.Setup(app, app.Group("/api"), app.Group("/public"))
+	return app
+}
+
+// respondWith answers dead letter requests with the response for their action
+func respondWith(t *testing.T, nc *nats.Conn, responses map[string]events.DeadLetterResponse) chan events.DeadLetterRequest {
+	requests := make(chan events.DeadLetterRequest, 1)
+	sub, err := nc.Subscribe(events.DeadLetterTopic, func(msg *nats.Msg) {
+		req := events.DeadLetterRequest{}
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		data, _ := json.Marshal(responses[req.Action])
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	return requests
+}
+
+func TestListDeadLetters(t *testing.T) {
+	nc := connectNats(t)
+	deadLetter := events.DeadLetter{Id: 7, ProviderId: "p1", Path: "/broken.jpg", Reason: "failed", Deliveries: 5}
+	requests := respondWith(t, nc, map[string]events.DeadLetterResponse{
+		events.DeadLetterActionList: {DeadLetters: []events.DeadLetter{deadLetter}},
+	})
+	app := newDeadLettersApp(t, nc)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/index/deadletters?after=3&limit=10", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, events.DeadLetterRequest{Action: events.DeadLetterActionList, Id: 3, Limit: 10}, <-requests)
+	res := []events.DeadLetter{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, []events.DeadLetter{deadLetter}, res)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/index/deadletters?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRetryAndDiscardDeadLetters(t *testing.T) {
+	nc := connectNats(t)
+	requests := respondWith(t, nc, map[string]events.DeadLetterResponse{
+		events.DeadLetterActionRetry:   {},
+		events.DeadLetterActionDiscard: {NotFound: true},
+	})
+	app := newDeadLettersApp(t, nc)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/index/deadletters/7/retry", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, events.DeadLetterRequest{Action: events.DeadLetterActionRetry, Id: 7}, <-requests)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/index/deadletters/8", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, events.DeadLetterRequest{Action: events.DeadLetterActionDiscard, Id: 8}, <-requests)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/index/deadletters/abc", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,316 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package duplicates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/api-gateway/webdav"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+)
+
+const (
+	// keep the file with the oldest modification time
+	KeepOldest = "oldest"
+	// keep the file with the newest modification time
+	KeepNewest = "newest"
+)
+
+// time for finding the candidates, and for verifying them by hashing the full content of the files
+var duplicatesTimeout = time.Minute
+var verifyTimeout = time.Hour
+
+// maximum number of groups that are handled by one delete job
+const maxGroups = 1000
+
+var Module = fx.Module("duplicates",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log    *logging.Logger
+	Nc     *nats.Conn
+	Auth   auth.Auth
+	WebDav webdav.WebDavServer
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type duplicatesHandler struct {
+	log    *slog.Logger
+	nc     *nats.Conn
+	auth   auth.Auth
+	webdav webdav.WebDavServer
+}
+
+type DeleteRequest struct {
+	// which file of each group is kept, KeepOldest (default) or KeepNewest
+	Keep string `json:"keep"`
+	// only delete duplicates in these groups, all groups if empty
+	ImoHashes []string `json:"imoHashes"`
+	MinSize   int64    `json:"minSize"`
+}
+
+type DeleteResponse struct {
+	// key of the job that tracks the progress, see /api/jobs
+	Job string `json:"job"`
+}
+
+func New(p Params) Result {
+	return Result{
+		Handler: &duplicatesHandler{
+			log:    p.Log.GetLogger("duplicates"),
+			nc:     p.Nc,
+			auth:   p.Auth,
+			webdav: p.WebDav,
+		},
+	}
+}
+
+func (h *duplicatesHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	apiGroup.GET("duplicates", func(ctx *gin.Context) {
+		req := events.DuplicatesRequest{
+			UserId: h.auth.GetUserId(ctx.Request.Context()),
+			Verify: ctx.Query("verify") == "true",
+		}
+		var err error
+		if req.MinSize, err = int64Query(ctx, "minSize"); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		limit, err := int64Query(ctx, "limit")
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		req.Limit = int(limit)
+		req.ImoHashes = ctx.QueryArray("imoHash")
+		if req.Verify {
+			// the progress of the verification can be followed in /api/jobs
+			req.Job = "SERAPH_DUPLICATES_" + uuid.NewString()
+		}
+
+		res, err := h.findDuplicates(ctx.Request.Context(), &req)
+		if err != nil {
+			h.log.Error("error while finding duplicates", "error", err)
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, res)
+	})
+
+	// deletes all but one file of each group of duplicates, the files are verified by their full content first
+	apiGroup.POST("duplicates/delete", func(ctx *gin.Context) {
+		req := DeleteRequest{}
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if req.Keep == "" {
+			req.Keep = KeepOldest
+		}
+		if req.Keep != KeepOldest && req.Keep != KeepNewest {
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid keep: %s", req.Keep))
+			return
+		}
+
+		jobKey := "SERAPH_DUPLICATES_" + uuid.NewString()
+		h.publishJob(ctx.Request.Context(), jobKey, "Finding duplicates", nil)
+
+		// the job continues when the client disconnects, the context keeps the user
+		jobCtx := context.WithoutCancel(ctx.Request.Context())
+		go h.deleteDuplicates(jobCtx, jobKey, &req)
+
+		ctx.JSON(http.StatusAccepted, DeleteResponse{Job: jobKey})
+	})
+}
+
+// findDuplicates returns the groups of duplicates.
+// With verification, it waits until the job of the request has verified the groups.
+func (h *duplicatesHandler) findDuplicates(ctx context.Context, req *events.DuplicatesRequest) (*events.DuplicatesResponse, error) {
+	var results chan *nats.Msg
+	if req.Verify {
+		results = make(chan *nats.Msg, 1)
+		sub, err := h.nc.ChanSubscribe(fmt.Sprintf(events.DuplicatesResultTopicPattern, req.Job), results)
+		if err != nil {
+			return nil, err
+		}
+		defer sub.Unsubscribe()
+	}
+
+	res := events.DuplicatesResponse{}
+	err := messaging.RequestTimeout(ctx, h.nc, events.DuplicatesTopic, duplicatesTimeout, messaging.Json(req), messaging.Json(&res))
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	if !req.Verify {
+		return &res, nil
+	}
+
+	select {
+	case msg := <-results:
+		res = events.DuplicatesResponse{}
+		if err := json.Unmarshal(msg.Data, &res); err != nil {
+			return nil, err
+		}
+		if res.Error != "" {
+			return nil, errors.New(res.Error)
+		}
+		return &res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(verifyTimeout):
+		return nil, fmt.Errorf("timeout while verifying duplicates in job %s", req.Job)
+	}
+}
+
+func (h *duplicatesHandler) deleteDuplicates(ctx context.Context, jobKey string, req *DeleteRequest) {
+	res, err := h.findDuplicates(ctx, &events.DuplicatesRequest{
+		UserId:    h.auth.GetUserId(ctx),
+		Verify:    true,
+		Job:       jobKey,
+		MinSize:   req.MinSize,
+		Limit:     maxGroups,
+		ImoHashes: req.ImoHashes,
+	})
+	if err != nil {
+		h.log.Error("error while finding duplicates", "error", err)
+		h.publishJob(ctx, jobKey, "Finding duplicates failed: "+err.Error(), nil)
+		return
+	}
+
+	total := 0
+	for _, group := range res.Groups {
+		total += len(group.Files) - 1
+	}
+
+	deleted, failed := 0, 0
+	var reclaimed int64
+	for _, group := range res.Groups {
+		keep, remove := splitGroup(group, req.Keep)
+		if _, err := h.webdav.FileSystem().Stat(ctx, filePath(keep)); err != nil {
+			// never delete the last copy
+			h.log.Warn("skipping duplicates, kept file is not available", "providerId", keep.ProviderId, "path", keep.Path, "error", err)
+			failed += len(remove)
+			continue
+		}
+
+		for _, file := range remove {
+			err := h.deleteFile(ctx, file, group.Size)
+			if err != nil {
+				h.log.Warn("error while deleting duplicate", "providerId", file.ProviderId, "path", file.Path, "error", err)
+				failed++
+			} else {
+				deleted++
+				reclaimed += group.Size
+			}
+			h.publishJob(ctx, jobKey, fmt.Sprintf("Deleted %d of %d duplicates", deleted, total), nil)
+		}
+	}
+
+	h.publishJob(ctx, jobKey, fmt.Sprintf("Deleted %d duplicates, %d failed.", deleted, failed), map[string]string{
+		"deleted":   strconv.Itoa(deleted),
+		"failed":    strconv.Itoa(failed),
+		"reclaimed": strconv.FormatInt(reclaimed, 10),
+	})
+}
+
+// deleteFile removes a duplicate, unless it was changed since it was indexed
+func (h *duplicatesHandler) deleteFile(ctx context.Context, file events.DuplicateFile, size int64) error {
+	name := filePath(file)
+	info, err := h.webdav.FileSystem().Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Size() != size {
+		return fmt.Errorf("file has changed: %s", name)
+	}
+	return h.webdav.FileSystem().RemoveAll(ctx, name)
+}
+
+func (h *duplicatesHandler) publishJob(ctx context.Context, jobKey string, statusMessage string, properties map[string]string) {
+	ev := events.JobEvent{
+		Event: events.Event{
+			ID:      uuid.NewString(),
+			Version: 1,
+		},
+		Key:           jobKey,
+		Description:   "Deleting duplicates",
+		StatusMessage: statusMessage,
+		Properties:    properties,
+	}
+	data, _ := ev.Marshal()
+	topic := fmt.Sprintf(events.JobsTopicPattern, jobKey)
+	messaging.PublishEvent(ctx, h.nc, topic, data)
+}
+
+// splitGroup returns the file that is kept and the files that are deleted.
+// The files of a group are ordered by modification time.
+func splitGroup(group events.DuplicateGroup, keep string) (events.DuplicateFile, []events.DuplicateFile) {
+	if keep == KeepNewest {
+		last := len(group.Files) - 1
+		return group.Files[last], group.Files[:last]
+	}
+	return group.Files[0], group.Files[1:]
+}
+
+// filePath returns the path of the file in the webdav file system
+func filePath(file events.DuplicateFile) string {
+	return path.Join("/p", file.ProviderId, file.Path)
+}
+
+func int64Query(ctx *gin.Context, key string) (int64, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return i, nil
+}
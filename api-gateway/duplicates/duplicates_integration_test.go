@@ -0,0 +1,244 @@
+package duplicates
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/api-gateway/auth"
+	seraphWebdav "umbasa.net/seraph/api-gateway/webdav"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+)
+
+type memWebDav struct {
+	seraphWebdav.WebDavServer
+	fs webdav.FileSystem
+}
+
+func (m *memWebDav) FileSystem() webdav.FileSystem {
+	return m.fs
+}
+
+var natsServer *server.Server
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	setup()
+	code := m.Run()
+	shutdown()
+	os.Exit(code)
+}
+
+func setup() {
+	var err error
+	natsServer, err = server.NewServer(&server.Options{Port: -1})
+	if err != nil {
+		panic(err)
+	}
+	natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		panic("nats server not ready")
+	}
+}
+
+func shutdown() {
+	if natsServer != nil {
+		natsServer.Shutdown()
+		natsServer = nil
+	}
+}
+
+func connectNats(t *testing.T) *nats.Conn {
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newDuplicatesApp(t *testing.T, nc *nats.Conn, fs webdav.FileSystem) *gin.Engine {
+	logger := logging.New(logging.Params{})
+	config := viper.New()
+	config.Set("auth.enabled", false)
+	authResult, err := auth.New(auth.Params{
+		Log:   logger,
+		Viper: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(Params{
+		Log:    logger,
+		Nc:     nc,
+		Auth:   authResult.Auth,
+		WebDav: &memWebDav{fs: fs},
+	})
+
+	app := gin.New()
+	res.Handler.Setup(app, app.Group("/api"), app.Group("/public"))
+	return app
+}
+
+func respondWithGroups(t *testing.T, nc *nats.Conn, requests chan events.DuplicatesRequest, groups []events.DuplicateGroup) {
+	sub, err := nc.Subscribe(events.DuplicatesTopic, func(msg *nats.Msg) {
+		req := events.DuplicatesRequest{}
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		res := events.DuplicatesResponse{Groups: groups}
+		for _, group := range groups {
+			res.Reclaimable += group.Reclaimable
+		}
+		if !req.Verify {
+			data, _ := json.Marshal(res)
+			msg.Respond(data)
+			return
+		}
+		// verified groups are published when the job is done
+		data, _ := json.Marshal(events.DuplicatesResponse{Job: req.Job})
+		msg.Respond(data)
+		res.Job = req.Job
+		data, _ = json.Marshal(res)
+		nc.Publish(fmt.Sprintf(events.DuplicatesResultTopicPattern, req.Job), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+}
+
+func writeFile(t *testing.T, fs webdav.FileSystem, name string, content string) {
+	f, err := fs.OpenFile(context.Background(), name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(content))
+	f.Close()
+}
+
+func TestListDuplicates(t *testing.T) {
+	nc := connectNats(t)
+	requests := make(chan events.DuplicatesRequest, 1)
+	respondWithGroups(t, nc, requests, []events.DuplicateGroup{{
+		ImoHash:     "hash",
+		Size:        10,
+		Reclaimable: 10,
+		Files: []events.DuplicateFile{
+			{ProviderId: "space", Path: "a.jpg"},
+			{ProviderId: "space", Path: "b.jpg"},
+		},
+	}})
+
+	app := newDuplicatesApp(t, nc, webdav.NewMemFS())
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/duplicates?verify=true&minSize=5&limit=10", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	req := <-requests
+	assert.True(t, req.Verify)
+	assert.NotEmpty(t, req.Job)
+	assert.Equal(t, int64(5), req.MinSize)
+	assert.Equal(t, 10, req.Limit)
+	res := events.DuplicatesResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, int64(10), res.Reclaimable)
+	assert.Len(t, res.Groups, 1)
+	assert.Equal(t, req.Job, res.Job)
+
+	// without verification the groups are returned right away
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/duplicates", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = <-requests
+	assert.False(t, req.Verify)
+	assert.Empty(t, req.Job)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/duplicates?minSize=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteDuplicates(t *testing.T) {
+	nc := connectNats(t)
+	fs := webdav.NewMemFS()
+	ctx := context.Background()
+	assert.NoError(t, fs.Mkdir(ctx, "/p", 0755))
+	assert.NoError(t, fs.Mkdir(ctx, "/p/space", 0755))
+	writeFile(t, fs, "/p/space/old.jpg", "0123456789")
+	writeFile(t, fs, "/p/space/new.jpg", "0123456789")
+	writeFile(t, fs, "/p/space/changed.jpg", "changed")
+
+	requests := make(chan events.DuplicatesRequest, 1)
+	respondWithGroups(t, nc, requests, []events.DuplicateGroup{{
+		ImoHash:     "hash",
+		Size:        10,
+		Reclaimable: 20,
+		Files: []events.DuplicateFile{
+			{ProviderId: "space", Path: "old.jpg", ModTime: 1},
+			{ProviderId: "space", Path: "new.jpg", ModTime: 2},
+			{ProviderId: "space", Path: "changed.jpg", ModTime: 3},
+		},
+	}})
+
+	jobs := make(chan *nats.Msg, 10)
+	jobSub, err := nc.ChanSubscribe(events.JobsTopic, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobSub.Unsubscribe()
+
+	app := newDuplicatesApp(t, nc, fs)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/duplicates/delete", bytes.NewBufferString(`{"imoHashes": ["hash"]}`)))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	res := DeleteResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.NotEmpty(t, res.Job)
+
+	req := <-requests
+	assert.True(t, req.Verify)
+	assert.Equal(t, res.Job, req.Job)
+	assert.Equal(t, []string{"hash"}, req.ImoHashes)
+
+	// wait for the final job status
+	var job events.JobEvent
+	for job.Properties["deleted"] == "" {
+		select {
+		case msg := <-jobs:
+			job = events.JobEvent{}
+			assert.NoError(t, job.Unmarshal(msg.Data))
+			assert.Equal(t, res.Job, job.Key)
+		case <-time.After(2 * time.Second):
+			t.Fatal("job did not finish")
+		}
+	}
+	assert.Equal(t, "1", job.Properties["deleted"])
+	assert.Equal(t, "1", job.Properties["failed"])
+	assert.Equal(t, "10", job.Properties["reclaimed"])
+
+	_, err = fs.Stat(ctx, "/p/space/old.jpg")
+	assert.NoError(t, err)
+	_, err = fs.Stat(ctx, "/p/space/new.jpg")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fs.Stat(ctx, "/p/space/changed.jpg")
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/duplicates/delete", bytes.NewBufferString(`{"keep": "biggest"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,161 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+)
+
+var Module = fx.Module("files",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log  *logging.Logger
+	Nc   *nats.Conn
+	Auth auth.Auth
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type filesHandler struct {
+	log  *slog.Logger
+	nc   *nats.Conn
+	auth auth.Auth
+}
+
+func New(p Params) Result {
+	return Result{
+		Handler: &filesHandler{
+			log:  p.Log.GetLogger("files"),
+			nc:   p.Nc,
+			auth: p.Auth,
+		},
+	}
+}
+
+func (h *filesHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	// list a directory from the index, including the metadata that was extracted from the files
+	apiGroup.GET("files/:providerId/*path", func(ctx *gin.Context) {
+		req := events.ListFilesRequest{
+			UserId:     h.auth.GetUserId(ctx.Request.Context()),
+			ProviderId: ctx.Param("providerId"),
+			Path:       strings.TrimPrefix(ctx.Param("path"), "/"),
+			Cursor:     ctx.Query("cursor"),
+			Sort:       ctx.Query("sort"),
+			Order:      ctx.Query("order"),
+		}
+		switch req.Sort {
+		case "", events.SearchSortName, events.SearchSortModified, events.SearchSortSize, events.SearchSortTaken:
+		default:
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid sort: %s", req.Sort))
+			return
+		}
+		switch req.Order {
+		case "", events.SearchOrderAsc, events.SearchOrderDesc:
+		default:
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid order: %s", req.Order))
+			return
+		}
+		if limit := ctx.Query("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l < 0 {
+				ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid limit: %s", limit))
+				return
+			}
+			req.Limit = l
+		}
+
+		res := events.ListFilesResponse{}
+		err := messaging.Request(ctx.Request.Context(), h.nc, events.ListFilesTopic, messaging.Json(&req), messaging.Json(&res))
+		if err != nil {
+			h.log.Error("error while listing files", "providerId", req.ProviderId, "path", req.Path, "error", err)
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if res.NotFound {
+			ctx.AbortWithError(http.StatusNotFound, errors.New(res.Error))
+			return
+		}
+		if res.Error != "" {
+			h.log.Error("error while listing files", "providerId", req.ProviderId, "path", req.Path, "error", res.Error)
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New(res.Error))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, res)
+	})
+
+	// disk usage of a space: the size of each space provider and its largest files and directories
+	apiGroup.GET("storage/:spaceId", func(ctx *gin.Context) {
+		req := events.StorageRequest{
+			UserId:  h.auth.GetUserId(ctx.Request.Context()),
+			SpaceId: ctx.Param("spaceId"),
+		}
+		if limit := ctx.Query("limit"); limit != "" {
+			l, err := strconv.Atoi(limit)
+			if err != nil || l < 0 {
+				ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid limit: %s", limit))
+				return
+			}
+			req.Limit = l
+		}
+
+		res := events.StorageResponse{}
+		err := messaging.Request(ctx.Request.Context(), h.nc, events.StorageTopic, messaging.Json(&req), messaging.Json(&res))
+		if err != nil {
+			h.log.Error("error while reading storage", "spaceId", req.SpaceId, "error", err)
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if res.NotFound {
+			ctx.AbortWithError(http.StatusNotFound, errors.New(res.Error))
+			return
+		}
+		if res.Error != "" {
+			h.log.Error("error while reading storage", "spaceId", req.SpaceId, "error", res.Error)
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New(res.Error))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, res)
+	})
+}
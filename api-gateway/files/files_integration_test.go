@@ -0,0 +1,198 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+)
+
+var natsServer *server.Server
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	setup()
+	code := m.Run()
+	shutdown()
+	os.Exit(code)
+}
+
+func setup() {
+	var err error
+	natsServer, err = server.NewServer(&server.Options{Port: -1})
+	if err != nil {
+		panic(err)
+	}
+	natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		panic("nats server not ready")
+	}
+}
+
+func shutdown() {
+	if natsServer != nil {
+		natsServer.Shutdown()
+		natsServer = nil
+	}
+}
+
+func connectNats(t *testing.T) *nats.Conn {
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newFilesApp(t *testing.T, nc *nats.Conn) *gin.Engine {
+	logger := logging.New(logging.Params{})
+	config := viper.New()
+	config.Set("auth.enabled", false)
+	authResult, err := auth.New(auth.Params{
+		Log:   logger,
+		Viper: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(Params{
+		Log:  logger,
+		Nc:   nc,
+		Auth: authResult.Auth,
+	})
+
+	app := gin.New()
+	res.Handler.Setup(app, app.Group("/api"), app.Group("/public"))
+	return app
+}
+
+func respondWith(t *testing.T, nc *nats.Conn, requests chan events.ListFilesRequest, res events.ListFilesResponse) {
+	sub, err := nc.Subscribe(events.ListFilesTopic, func(msg *nats.Msg) {
+		req := events.ListFilesRequest{}
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		data, _ := json.Marshal(res)
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+}
+
+func TestListFiles(t *testing.T) {
+	nc := connectNats(t)
+	requests := make(chan events.ListFilesRequest, 1)
+	respondWith(t, nc, requests, events.ListFilesResponse{
+		Files: []events.FileEntry{{
+			ProviderId: "space",
+			Path:       "videos/clip.mp4",
+			Size:       1000,
+			Mime:       "video/mp4",
+			Taken:      1700000000,
+			Video:      &events.VideoMetadata{Duration: 5000, Width: 1920, Height: 1080, VideoCodec: "h264"},
+		}},
+		Total: 1,
+	})
+
+	app := newFilesApp(t, nc)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files/space/videos?sort=taken&order=desc&limit=10", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	req := <-requests
+	assert.Equal(t, "space", req.ProviderId)
+	assert.Equal(t, "videos", req.Path)
+	assert.Equal(t, events.SearchSortTaken, req.Sort)
+	assert.Equal(t, events.SearchOrderDesc, req.Order)
+	assert.Equal(t, 10, req.Limit)
+
+	res := events.ListFilesResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	if assert.Len(t, res.Files, 1) {
+		assert.Equal(t, int64(5000), res.Files[0].Video.Duration)
+		assert.Equal(t, "h264", res.Files[0].Video.VideoCodec)
+	}
+}
+
+func TestListFilesErrors(t *testing.T) {
+	nc := connectNats(t)
+	requests := make(chan events.ListFilesRequest, 1)
+	respondWith(t, nc, requests, events.ListFilesResponse{NotFound: true, Error: "space provider not found"})
+
+	app := newFilesApp(t, nc)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files/unknown/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "", (<-requests).Path)
+
+	for _, query := range []string{"sort=relevance", "order=up", "limit=-1"} {
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files/space/?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	nc := connectNats(t)
+	requests := make(chan events.StorageRequest, 1)
+	sub, err := nc.Subscribe(events.StorageTopic, func(msg *nats.Msg) {
+		req := events.StorageRequest{}
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		res := events.StorageResponse{NotFound: true, Error: "space not found"}
+		if req.SpaceId == "space1" {
+			res = events.StorageResponse{
+				SpaceId:   "space1",
+				Size:      3000,
+				FileCount: 2,
+				Providers: []events.ProviderStorage{{
+					ProviderId: "photos",
+					Size:       3000,
+					FileCount:  2,
+					Entries:    []events.FileEntry{{ProviderId: "photos", Path: "2023", IsDir: true, Size: 3000, FileCount: 2}},
+				}},
+			}
+		}
+		data, _ := json.Marshal(res)
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	app := newFilesApp(t, nc)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/storage/space1?limit=5", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, events.StorageRequest{UserId: "anonymous", SpaceId: "space1", Limit: 5}, <-requests)
+	res := events.StorageResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	if assert.Len(t, res.Providers, 1) {
+		assert.Equal(t, int64(2), res.Providers[0].Entries[0].FileCount)
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/storage/other", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	<-requests
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/storage/space1?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -24,14 +24,20 @@ import (
 	"go.uber.org/fx"
 	"umbasa.net/seraph/api-gateway/agents"
 	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/api-gateway/deadletters"
 	"umbasa.net/seraph/api-gateway/download"
+	"umbasa.net/seraph/api-gateway/duplicates"
+	"umbasa.net/seraph/api-gateway/files"
 	"umbasa.net/seraph/api-gateway/gateway"
 	"umbasa.net/seraph/api-gateway/jobs"
 	"umbasa.net/seraph/api-gateway/preview"
+	"umbasa.net/seraph/api-gateway/reindex"
 	"umbasa.net/seraph/api-gateway/search"
 	"umbasa.net/seraph/api-gateway/services"
 	"umbasa.net/seraph/api-gateway/shares"
 	"umbasa.net/seraph/api-gateway/spaces"
+	"umbasa.net/seraph/api-gateway/trash"
+	"umbasa.net/seraph/api-gateway/versions"
 	"umbasa.net/seraph/api-gateway/webdav"
 	"umbasa.net/seraph/config"
 	"umbasa.net/seraph/logging"
@@ -54,13 +60,19 @@ func main() {
 		auth.Module,
 		logging.FxLogger(),
 
+		deadletters.Module,
 		download.Module,
+		duplicates.Module,
+		files.Module,
 		jobs.Module,
 		preview.Module,
+		reindex.Module,
 		search.Module,
 		spaces.Module,
 		services.Module,
 		shares.Module,
+		trash.Module,
+		versions.Module,
 		webdav.Module,
 
 		fx.Provide(auth.NewMigrations),
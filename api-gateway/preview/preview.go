@@ -19,10 +19,11 @@
 package preview
 
 import (
+	"errors"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
-	"path"
 	"strconv"
 	"strings"
 
@@ -31,11 +32,10 @@ import (
 	"go.uber.org/fx"
 	"umbasa.net/seraph/api-gateway/auth"
 	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/api-gateway/webdav"
 	"umbasa.net/seraph/file-provider/fileprovider"
 	"umbasa.net/seraph/logging"
 	"umbasa.net/seraph/messaging"
-	"umbasa.net/seraph/shares/shares"
-	"umbasa.net/seraph/spaces/spaces"
 	"umbasa.net/seraph/thumbnailer/thumbnailer"
 	"umbasa.net/seraph/util"
 )
@@ -49,9 +49,10 @@ var Module = fx.Module("preview",
 type Params struct {
 	fx.In
 
-	Log  *logging.Logger
-	Nc   *nats.Conn
-	Auth auth.Auth
+	Log    *logging.Logger
+	Nc     *nats.Conn
+	Auth   auth.Auth
+	WebDav webdav.WebDavServer
 }
 
 type Result struct {
@@ -66,6 +67,7 @@ type previewHandler struct {
 	nc          *nats.Conn
 	auth        auth.Auth
 	authHandler func(*gin.Context) bool
+	webdav      webdav.WebDavServer
 }
 
 func New(p Params) Result {
@@ -76,13 +78,13 @@ func New(p Params) Result {
 			nc:          p.Nc,
 			auth:        p.Auth,
 			authHandler: p.Auth.AuthMiddleware(false, ""),
+			webdav:      p.WebDav,
 		},
 	}
 }
 
 func (h *previewHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
-	app.GET("preview", func(ctx *gin.Context) {
-		var err error
+	app.GET("preview", webdav.CacheMiddleware(), func(ctx *gin.Context) {
 		parameterP := ctx.Query("p")
 		parameterS := ctx.Query("s")
 
@@ -98,32 +100,9 @@ func (h *previewHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publi
 			return
 		}
 
-		var providerId, filePath string
+		var name string
 		if parameterS != "" {
-			shareId, sharePath := getProviderAndPath(parameterS)
-			resolveReq := shares.ShareResolveRequest{
-				ShareId: shareId,
-				Path:    sharePath,
-			}
-			resolveRes := shares.ShareResolveResponse{}
-			err = messaging.Request(ctx.Request.Context(), h.nc, shares.ShareResolveTopic, messaging.Json(&resolveReq), messaging.Json(&resolveRes))
-			if err != nil {
-				h.log.Error("While retrieving preview: error while resolving share", "error", err)
-				ctx.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-			if resolveRes.Error != "" {
-				h.log.Error("While retrieving preview: error while resolving share", "error", resolveRes.Error)
-				ctx.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-			if resolveRes.ProviderId == "" {
-				ctx.AbortWithStatus(http.StatusNotFound)
-				return
-			}
-
-			providerId = resolveRes.ProviderId
-			filePath = resolveRes.Path
+			name = "/s/" + strings.TrimPrefix(parameterS, "/")
 		} else {
 			if !h.authHandler(ctx) {
 				return
@@ -136,29 +115,19 @@ func (h *previewHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publi
 				return
 			}
 
-			resolveReq := spaces.SpaceResolveRequest{
-				UserId:          h.auth.GetUserId(ctx.Request.Context()),
-				SpaceProviderId: spaceProviderId,
-			}
-			resolveRes := spaces.SpaceResolveResponse{}
-			err = messaging.Request(ctx.Request.Context(), h.nc, spaces.SpaceResolveTopic, messaging.Json(&resolveReq), messaging.Json(&resolveRes))
-			if err != nil {
-				h.log.Error("While retrieving preview: error while resolving space", "error", err)
-				ctx.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-			if resolveRes.Error != "" {
-				h.log.Error("While retrieving preview: error while resolving space", "error", resolveRes.Error)
-				ctx.AbortWithStatus(http.StatusInternalServerError)
-				return
-			}
-			if resolveRes.ProviderId == "" {
-				ctx.AbortWithStatus(http.StatusNotFound)
-				return
-			}
+			name = "/p/" + spaceProviderId + "/" + spacePath
+		}
 
-			providerId = resolveRes.ProviderId
-			filePath = path.Join(resolveRes.Path, spacePath)
+		// resolve to the provider that holds the file, which is not necessarily the same for every file in a union space provider
+		providerId, filePath, err := h.webdav.Locate(ctx.Request.Context(), name)
+		if errors.Is(err, fs.ErrNotExist) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.log.Error("While retrieving preview: error while resolving path", "error", err)
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
 
 		var width, height int
@@ -0,0 +1,105 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package reindex
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+)
+
+var Module = fx.Module("reindex",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log  *logging.Logger
+	Nc   *nats.Conn
+	Auth auth.Auth
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type reindexHandler struct {
+	log  *slog.Logger
+	nc   *nats.Conn
+	auth auth.Auth
+}
+
+func New(p Params) Result {
+	return Result{
+		Handler: &reindexHandler{
+			log:  p.Log.GetLogger("reindex"),
+			nc:   p.Nc,
+			auth: p.Auth,
+		},
+	}
+}
+
+func (h *reindexHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	// starts a reindex of a file provider, a path in a file provider or all files,
+	// the progress is reported as a job, see /api/jobs
+	apiGroup.POST("index/reindex", func(ctx *gin.Context) {
+		if !h.auth.IsSpaceAdmin(ctx) {
+			ctx.AbortWithError(http.StatusForbidden, errors.New("only space admin can reindex files"))
+			return
+		}
+
+		req := events.ReindexRequest{}
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if req.Path != "" && req.ProviderId == "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("reindexing a path requires a providerId"))
+			return
+		}
+
+		res := events.ReindexResponse{}
+		err := messaging.Request(ctx.Request.Context(), h.nc, events.ReindexTopic, messaging.Json(&req), messaging.Json(&res))
+		if err != nil {
+			h.log.Error("error while starting reindex", "error", err)
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if res.Error != "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New(res.Error))
+			return
+		}
+
+		ctx.JSON(http.StatusAccepted, res)
+	})
+}
@@ -0,0 +1,122 @@
+package reindex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+)
+
+var natsServer *server.Server
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	setup()
+	code := m.Run()
+	shutdown()
+	os.Exit(code)
+}
+
+func setup() {
+	var err error
+	natsServer, err = server.NewServer(&server.Options{Port: -1})
+	if err != nil {
+		panic(err)
+	}
+	natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		panic("nats server not ready")
+	}
+}
+
+func shutdown() {
+	if natsServer != nil {
+		natsServer.Shutdown()
+		natsServer = nil
+	}
+}
+
+func connectNats(t *testing.T) *nats.Conn {
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func newReindexApp(t *testing.T, nc *nats.Conn) *gin.Engine {
+	logger := logging.New(logging.Params{})
+	config := viper.New()
+	config.Set("auth.enabled", false)
+	authResult, err := auth.New(auth.Params{
+		Log:   logger,
+		Viper: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(Params{
+		Log:  logger,
+		Nc:   nc,
+		Auth: authResult.Auth,
+	})
+
+	app := gin.New()
+	res.Handler.Setup(app, app.Group("/api"), app.Group("/public"))
+	return app
+}
+
+func TestReindex(t *testing.T) {
+	nc := connectNats(t)
+	requests := make(chan events.ReindexRequest, 1)
+	sub, err := nc.Subscribe(events.ReindexTopic, func(msg *nats.Msg) {
+		req := events.ReindexRequest{}
+		json.Unmarshal(msg.Data, &req)
+		requests <- req
+		data, _ := json.Marshal(events.ReindexResponse{Job: "SERAPH_REINDEX_1"})
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	app := newReindexApp(t, nc)
+	w := httptest.NewRecorder()
+	body := `{"providerId": "p1", "path": "/photos", "rebuild": true}`
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/index/reindex", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, events.ReindexRequest{ProviderId: "p1", Path: "/photos", Rebuild: true}, <-requests)
+
+	res := events.ReindexResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "SERAPH_REINDEX_1", res.Job)
+}
+
+func TestReindexInvalid(t *testing.T) {
+	nc := connectNats(t)
+	app := newReindexApp(t, nc)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/index/reindex", strings.NewReader(`{"path": "/photos"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/index/reindex", strings.NewReader(`not json`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
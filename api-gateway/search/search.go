@@ -24,6 +24,8 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -69,7 +71,7 @@ var searchReplyTime = 30 * time.Second
 func New(p Params) Result {
 	return Result{
 		Handler: &searchHandler{
-			log:  p.Log.GetLogger("shares"),
+			log:  p.Log.GetLogger("search"),
 			nc:   p.Nc,
 			auth: p.Auth,
 		},
@@ -78,8 +80,23 @@ func New(p Params) Result {
 
 func (h *searchHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
 	apiGroup.GET("/search", func(ctx *gin.Context) {
+		// the query syntax is interpreted by the search providers, it is passed on unchanged
 		query := ctx.Query("q")
 
+		limit, err := intQuery(ctx, "limit", 0)
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		limit = (&events.SearchRequest{Limit: limit}).PageLimit()
+		offset, err := intQuery(ctx, "offset", 0)
+		if err != nil || offset < 0 {
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid offset: %s", ctx.Query("offset")))
+			return
+		}
+
+		types := searchTypes(ctx)
+
 		ctx.Header("Content-Type", "text/event-stream")
 		ctx.Header("Cache-Control", "no-cache")
 		ctx.Header("Connection", "keep-alive")
@@ -112,15 +129,31 @@ func (h *searchHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, public
 			RequestId: requestId,
 			UserId:    h.auth.GetUserId(ctx.Request.Context()),
 			Query:     query,
-			Types:     []string{events.SearchTypeFiles},
+			Types:     types,
+			Mode:      ctx.Query("mode"),
+			Limit:     limit,
+			Offset:    offset,
+			Cursor:    ctx.Query("cursor"),
+			Sort:      ctx.Query("sort"),
+			Order:     ctx.Query("order"),
 		}
 		data, _ := json.Marshal(searchRequest)
 		h.nc.Publish(events.SearchRequestTopic, data)
 
+		// participants stop working on the request if the client goes away or the request times out
+		completed := false
+		defer func() {
+			if !completed {
+				h.cancel(requestId)
+			}
+		}()
+
 		ctx.Status(200)
 		ctx.Writer.WriteHeaderNow()
 		ctx.Writer.Flush()
 		activeReplies := map[string]struct{}{}
+		// number of results forwarded per participant, participants must not send more than the limit
+		replyCounts := map[string]int{}
 		startTs := time.Now()
 		for {
 			select {
@@ -137,11 +170,23 @@ func (h *searchHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, public
 				json.Unmarshal(msg.Data, &reply)
 				if reply.Last {
 					delete(activeReplies, reply.ReplyId)
+					// the last reply carries the total and the cursor for paging
+					if err := writeSSE(ctx.Writer, msg.Data); err != nil {
+						h.log.Warn("failed to write search reply", "error", err)
+						return
+					}
 					if len(activeReplies) == 0 && time.Since(startTs) > searchAckTime {
+						completed = true
 						return
 					}
 				} else {
 					activeReplies[reply.ReplyId] = struct{}{}
+					if reply.Error == "" {
+						replyCounts[reply.ReplyId]++
+						if replyCounts[reply.ReplyId] > limit {
+							continue
+						}
+					}
 					if err := writeSSE(ctx.Writer, msg.Data); err != nil {
 						h.log.Warn("failed to write search reply", "error", err)
 						return
@@ -155,6 +200,44 @@ func (h *searchHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, public
 
 }
 
+func (h *searchHandler) cancel(requestId string) {
+	h.log.Debug("cancel search request", "requestId", requestId)
+	data, _ := json.Marshal(events.SearchCancel{RequestId: requestId})
+	if err := h.nc.Publish(fmt.Sprintf(events.SearchCancelTopicPattern, requestId), data); err != nil {
+		h.log.Warn("failed to cancel search request", "requestId", requestId, "error", err)
+	}
+}
+
+// searchTypes returns the requested result types.
+// Types can be given as repeated or comma-separated "types" parameters, the default is files.
+func searchTypes(ctx *gin.Context) []string {
+	types := make([]string, 0)
+	for _, param := range ctx.QueryArray("types") {
+		for _, typ := range strings.Split(param, ",") {
+			typ = strings.TrimSpace(typ)
+			if typ != "" && !slices.Contains(types, typ) {
+				types = append(types, typ)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return []string{events.SearchTypeFiles}
+	}
+	return types
+}
+
+func intQuery(ctx *gin.Context, key string, defaultValue int) (int, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return i, nil
+}
+
 func searchTimeout(numReplies int) <-chan time.Time {
 	if numReplies == 0 {
 		return time.After(searchAckTime)
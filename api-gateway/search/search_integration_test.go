@@ -27,6 +27,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -165,15 +166,18 @@ func TestSearchStreamsReplies(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
 
-	replies, err := readSSEReplies(resp.Body, 2)
+	replies, err := readSSEReplies(resp.Body, 3)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	assert.Equal(t, 2, len(replies))
-	if len(replies) == 2 {
+	assert.Equal(t, 3, len(replies))
+	if len(replies) == 3 {
 		assert.Equal(t, "file-1", replies[0].Reply["id"])
 		assert.Equal(t, "file-2", replies[1].Reply["id"])
+		// the last reply is forwarded without results as well
+		assert.True(t, replies[2].Last)
+		assert.Zero(t, replies[2].Total)
 	}
 
 	if handlerErr := <-errCh; handlerErr != nil {
@@ -283,6 +287,380 @@ func TestSearchProviderAckNoReply(t *testing.T) {
 	}
 }
 
+func TestSearchPassesQuerySyntax(t *testing.T) {
+	setSearchTimeouts(t, 20*time.Millisecond, 100*time.Millisecond)
+
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	requestChan := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.SearchRequestTopic, requestChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	query := `type:image size:>100M modified:<30d in:"photos/2023" -draft "exact phrase" type:banana`
+
+	errCh := respondToSearchRequest(requestChan, func(req events.SearchRequest) error {
+		if req.Query != query {
+			return fmt.Errorf("unexpected query %q", req.Query)
+		}
+
+		ack := events.SearchAck{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Ack:       true,
+			Types:     []string{events.SearchTypeFiles},
+		}
+		if err := publishJSON(nc, ackTopic(req.RequestId), &ack); err != nil {
+			return err
+		}
+
+		errReply := events.SearchReply{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Error:     "invalid search query at position 75: unknown type banana",
+		}
+		if err := publishJSON(nc, replyTopic(req.RequestId), &errReply); err != nil {
+			return err
+		}
+		lastReply := events.SearchReply{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Last:      true,
+		}
+		if err := publishJSON(nc, replyTopic(req.RequestId), &lastReply); err != nil {
+			return err
+		}
+
+		return nc.Flush()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/search?q="+url.QueryEscape(query), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	replies, err := readSSEReplies(resp.Body, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 1, len(replies)) {
+		assert.Contains(t, replies[0].Error, "unknown type banana")
+	}
+
+	if handlerErr := <-errCh; handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	setSearchTimeouts(t, 20*time.Millisecond, 100*time.Millisecond)
+
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	requestChan := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.SearchRequestTopic, requestChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	errCh := respondToSearchRequest(requestChan, func(req events.SearchRequest) error {
+		if req.Limit != 2 || req.Cursor != "abc" || req.Sort != events.SearchSortName || req.Order != events.SearchOrderDesc {
+			return fmt.Errorf("unexpected paging parameters %+v", req)
+		}
+
+		ack := events.SearchAck{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Ack:       true,
+			Types:     []string{events.SearchTypeFiles},
+		}
+		if err := publishJSON(nc, ackTopic(req.RequestId), &ack); err != nil {
+			return err
+		}
+
+		// a participant that ignores the limit is cut off
+		for i := 0; i < 3; i++ {
+			reply := events.SearchReply{
+				RequestId: req.RequestId,
+				ReplyId:   "provider-1",
+				Type:      events.SearchTypeFiles,
+				Reply: map[string]any{
+					"id": fmt.Sprintf("file-%d", i),
+				},
+			}
+			if err := publishJSON(nc, replyTopic(req.RequestId), &reply); err != nil {
+				return err
+			}
+		}
+
+		lastReply := events.SearchReply{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Type:      events.SearchTypeFiles,
+			Last:      true,
+			Total:     42,
+			Cursor:    "next",
+		}
+		if err := publishJSON(nc, replyTopic(req.RequestId), &lastReply); err != nil {
+			return err
+		}
+
+		return nc.Flush()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/search?q=jpg&limit=2&cursor=abc&sort=name&order=desc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	replies, err := readSSEReplies(resp.Body, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equal(t, 3, len(replies)) {
+		assert.Equal(t, "file-0", replies[0].Reply["id"])
+		assert.Equal(t, "file-1", replies[1].Reply["id"])
+		assert.True(t, replies[2].Last)
+		assert.Equal(t, int64(42), replies[2].Total)
+		assert.Equal(t, "next", replies[2].Cursor)
+	}
+
+	if handlerErr := <-errCh; handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+}
+
+func TestSearchInvalidLimit(t *testing.T) {
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/search?q=jpg&limit=many")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSearchTypes(t *testing.T) {
+	setSearchTimeouts(t, 20*time.Millisecond, 100*time.Millisecond)
+
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	requestChan := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.SearchRequestTopic, requestChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	errCh := respondToSearchRequest(requestChan, func(req events.SearchRequest) error {
+		expected := []string{events.SearchTypeSpaces, events.SearchTypeShares, events.SearchTypeFiles}
+		if !assert.ObjectsAreEqual(expected, req.Types) {
+			return fmt.Errorf("unexpected search types %v", req.Types)
+		}
+		if req.Mode != events.SearchModeFuzzy {
+			return fmt.Errorf("unexpected search mode %s", req.Mode)
+		}
+		return nil
+	})
+
+	resp, err := http.Get(server.URL + "/api/search?q=holiday&types=spaces,shares&types=files&types=spaces&mode=fuzzy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if handlerErr := <-errCh; handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+}
+
+func TestSearchCancelOnDisconnect(t *testing.T) {
+	setSearchTimeouts(t, 20*time.Millisecond, time.Second)
+
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	requestChan := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.SearchRequestTopic, requestChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	cancelChan := make(chan *nats.Msg, 1)
+	cancelSub, err := nc.ChanSubscribe(events.SearchCancelTopic, cancelChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelSub.Unsubscribe()
+
+	requestIdChan := make(chan string, 1)
+	errCh := respondToSearchRequest(requestChan, func(req events.SearchRequest) error {
+		requestIdChan <- req.RequestId
+
+		ack := events.SearchAck{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Ack:       true,
+			Types:     []string{events.SearchTypeFiles},
+		}
+		if err := publishJSON(nc, ackTopic(req.RequestId), &ack); err != nil {
+			return err
+		}
+		// the participant is still working when the client goes away
+		reply := events.SearchReply{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Type:      events.SearchTypeFiles,
+			Reply: map[string]any{
+				"id": "file-1",
+			},
+		}
+		if err := publishJSON(nc, replyTopic(req.RequestId), &reply); err != nil {
+			return err
+		}
+		return nc.Flush()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/search?q=hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies, err := readSSEReplies(resp.Body, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(replies))
+	cancel()
+	resp.Body.Close()
+
+	if handlerErr := <-errCh; handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+	requestId := <-requestIdChan
+
+	msg, err := waitForNatsMessage(cancelChan, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fmt.Sprintf(events.SearchCancelTopicPattern, requestId), msg.Subject)
+	cancelReq := events.SearchCancel{}
+	assert.NoError(t, json.Unmarshal(msg.Data, &cancelReq))
+	assert.Equal(t, requestId, cancelReq.RequestId)
+}
+
+func TestSearchNoCancelWhenCompleted(t *testing.T) {
+	setSearchTimeouts(t, 20*time.Millisecond, 100*time.Millisecond)
+
+	nc := connectNats(t)
+	defer nc.Close()
+
+	app := newSearchApp(t, nc)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	requestChan := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.SearchRequestTopic, requestChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	cancelChan := make(chan *nats.Msg, 1)
+	cancelSub, err := nc.ChanSubscribe(events.SearchCancelTopic, cancelChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelSub.Unsubscribe()
+
+	errCh := respondToSearchRequest(requestChan, func(req events.SearchRequest) error {
+		ack := events.SearchAck{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Ack:       true,
+			Types:     []string{events.SearchTypeFiles},
+		}
+		if err := publishJSON(nc, ackTopic(req.RequestId), &ack); err != nil {
+			return err
+		}
+		time.Sleep(searchAckTime + 5*time.Millisecond)
+		lastReply := events.SearchReply{
+			RequestId: req.RequestId,
+			ReplyId:   "provider-1",
+			Last:      true,
+		}
+		if err := publishJSON(nc, replyTopic(req.RequestId), &lastReply); err != nil {
+			return err
+		}
+		return nc.Flush()
+	})
+
+	resp, err := http.Get(server.URL + "/api/search?q=hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readSSEReplies(resp.Body, 0)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handlerErr := <-errCh; handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+	_, err = waitForNatsMessage(cancelChan, 50*time.Millisecond)
+	assert.Error(t, err)
+}
+
 func setSearchTimeouts(t *testing.T, ackTimeout time.Duration, replyTimeout time.Duration) {
 	oldAck := searchAckTime
 	oldReply := searchReplyTime
@@ -0,0 +1,236 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/api-gateway/webdav"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+)
+
+const expiryJobKey = "SERAPH_TRASH_EXPIRY"
+
+// the instance of the gateway that creates this key purges the expired items,
+// the key expires with its bucket after one interval
+const expiryLockKey = "expiry"
+
+var Module = fx.Module("trash",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log    *logging.Logger
+	Nc     *nats.Conn
+	Js     jetstream.JetStream
+	Viper  *viper.Viper
+	WebDav webdav.WebDavServer
+	Lc     fx.Lifecycle
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type trashHandler struct {
+	log    *slog.Logger
+	nc     *nats.Conn
+	trash  webdav.Trash
+	lock   jetstream.KeyValue
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+func New(p Params) (Result, error) {
+	p.Viper.SetDefault("trash.expiryInterval", "1h")
+	expiryInterval := p.Viper.GetDuration("trash.expiryInterval")
+
+	lock, err := p.Js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket: "SERAPH_TRASH_EXPIRY",
+		TTL:    expiryInterval,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	h := &trashHandler{
+		log:   p.Log.GetLogger("trash"),
+		nc:    p.Nc,
+		trash: p.WebDav.Trash(),
+		lock:  lock,
+		done:  make(chan struct{}),
+	}
+
+	p.Lc.Append(fx.StartHook(func() {
+		h.startExpiry(expiryInterval)
+	}))
+	p.Lc.Append(fx.StopHook(h.stopExpiry))
+
+	return Result{Handler: h}, nil
+}
+
+func (h *trashHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	apiGroup.GET("trash", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		spaceProviderId := ctx.Query("space")
+		if spaceProviderId == "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("space is required"))
+			return
+		}
+
+		items, err := h.trash.List(ctx.Request.Context(), spaceProviderId)
+		if err != nil {
+			h.abortWithError(ctx, "error while listing trash", err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, items)
+	})
+
+	apiGroup.POST("trash/:id/restore", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		err := h.trash.Restore(ctx.Request.Context(), ctx.Param("id"))
+		if err != nil {
+			h.abortWithError(ctx, "error while restoring trash item", err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	})
+
+	apiGroup.DELETE("trash/:id", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		err := h.trash.Purge(ctx.Request.Context(), ctx.Param("id"))
+		if err != nil {
+			h.abortWithError(ctx, "error while purging trash item", err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	})
+
+	apiGroup.DELETE("trash", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		spaceProviderId := ctx.Query("space")
+		if spaceProviderId == "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("space is required"))
+			return
+		}
+
+		err := h.trash.Empty(ctx.Request.Context(), spaceProviderId)
+		if err != nil {
+			h.abortWithError(ctx, "error while emptying trash", err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+func (h *trashHandler) abortWithError(ctx *gin.Context, msg string, err error) {
+	switch {
+	case errors.Is(err, webdav.ErrTrashItemNotFound), errors.Is(err, fs.ErrNotExist):
+		ctx.AbortWithError(http.StatusNotFound, err)
+	case errors.Is(err, fs.ErrExist):
+		ctx.AbortWithError(http.StatusConflict, err)
+	case errors.Is(err, fs.ErrPermission):
+		ctx.AbortWithError(http.StatusForbidden, err)
+	default:
+		h.log.Error(msg, "error", err)
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
+func (h *trashHandler) startExpiry(interval time.Duration) {
+	h.ticker = time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-h.ticker.C:
+				h.expire()
+			}
+		}
+	}()
+}
+
+func (h *trashHandler) stopExpiry() {
+	h.ticker.Stop()
+	close(h.done)
+}
+
+func (h *trashHandler) expire() {
+	ctx := context.Background()
+
+	// every instance of the gateway runs the ticker, but only one of them purges the expired items per interval
+	_, err := h.lock.Create(ctx, expiryLockKey, []byte(uuid.NewString()))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return
+	}
+	if err != nil {
+		h.log.Error("error while acquiring trash expiry lock", "error", err)
+		return
+	}
+
+	h.publishJob("Purging expired items from trash")
+
+	count, err := h.trash.Expire(ctx)
+	if err != nil {
+		h.log.Error("error while purging expired trash items", "error", err)
+		h.publishJob("Purging expired items failed: " + err.Error())
+		return
+	}
+
+	if count > 0 {
+		h.log.Info("purged expired trash items", "count", count)
+	}
+	h.publishJob(fmt.Sprintf("Purged %d expired items.", count))
+}
+
+func (h *trashHandler) publishJob(statusMessage string) {
+	ev := events.JobEvent{
+		Event: events.Event{
+			ID:      uuid.NewString(),
+			Version: 1,
+		},
+		Key:           expiryJobKey,
+		Description:   "Emptying trash",
+		StatusMessage: statusMessage,
+	}
+	data, _ := ev.Marshal()
+	topic := fmt.Sprintf(events.JobsTopicPattern, expiryJobKey)
+	h.nc.Publish(topic, data)
+}
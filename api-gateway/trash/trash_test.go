@@ -0,0 +1,86 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package trash
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/api-gateway/webdav"
+)
+
+// countingTrash counts how often expired items are purged
+type countingTrash struct {
+	webdav.Trash
+	expired atomic.Int32
+}
+
+func (t *countingTrash) Expire(ctx context.Context) (int, error) {
+	t.expired.Add(1)
+	return 0, nil
+}
+
+func TestExpiryRunsInOneInstance(t *testing.T) {
+	natsServer, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket: "SERAPH_TRASH_EXPIRY",
+		TTL:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trash := &countingTrash{}
+	for range 3 {
+		h := &trashHandler{log: slog.New(slog.DiscardHandler), nc: nc, trash: trash, lock: lock}
+		h.expire()
+	}
+	assert.Equal(t, int32(1), trash.expired.Load())
+
+	// the next instance purges the items when the lock expired
+	assert.NoError(t, lock.Delete(context.Background(), expiryLockKey))
+	h := &trashHandler{log: slog.New(slog.DiscardHandler), nc: nc, trash: trash, lock: lock}
+	h.expire()
+	assert.Equal(t, int32(2), trash.expired.Load())
+}
@@ -0,0 +1,150 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package versions
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/api-gateway/webdav"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/util"
+)
+
+var Module = fx.Module("versions",
+	fx.Provide(
+		New,
+	),
+)
+
+type Params struct {
+	fx.In
+
+	Log    *logging.Logger
+	WebDav webdav.WebDavServer
+}
+
+type Result struct {
+	fx.Out
+
+	Handler gateway.GatewayHandler `group:"gatewayhandlers"`
+}
+
+type versionsHandler struct {
+	log    *slog.Logger
+	webdav webdav.WebDavServer
+}
+
+func New(p Params) Result {
+	return Result{
+		Handler: &versionsHandler{
+			log:    p.Log.GetLogger("versions"),
+			webdav: p.WebDav,
+		},
+	}
+}
+
+func (h *versionsHandler) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
+	// list versions of a file, or download a version if the "version" parameter is given
+	apiGroup.GET("versions/*path", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		requestPath := ctx.Param("path")
+		versionId := ctx.Query("version")
+
+		versions, err := h.webdav.Versions(ctx.Request.Context(), requestPath)
+		if err != nil {
+			h.abortWithError(ctx, "error while retrieving versions", requestPath, err)
+			return
+		}
+
+		if versionId == "" {
+			list, err := versions.List(ctx.Request.Context())
+			if err != nil {
+				h.abortWithError(ctx, "error while listing versions", requestPath, err)
+				return
+			}
+			ctx.JSON(http.StatusOK, list)
+			return
+		}
+
+		file, err := versions.Open(ctx.Request.Context(), versionId)
+		if err != nil {
+			h.abortWithError(ctx, "error while opening version", requestPath, err)
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil {
+			h.abortWithError(ctx, "error while opening version", requestPath, err)
+			return
+		}
+
+		fastReader := &util.FastReader{
+			Reader: file,
+		}
+		ctx.DataFromReader(http.StatusOK, stat.Size(), "application/octet-stream", fastReader, map[string]string{
+			"Content-Disposition": "attachment; filename=\"" + path.Base(requestPath) + "\"",
+		})
+	})
+
+	// restore a version, the current content of the file is kept as a new version
+	apiGroup.POST("versions/*path", webdav.CacheMiddleware(), func(ctx *gin.Context) {
+		requestPath := ctx.Param("path")
+		versionId := ctx.Query("version")
+
+		if versionId == "" {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("version is required"))
+			return
+		}
+
+		versions, err := h.webdav.Versions(ctx.Request.Context(), requestPath)
+		if err != nil {
+			h.abortWithError(ctx, "error while retrieving versions", requestPath, err)
+			return
+		}
+
+		err = versions.Restore(ctx.Request.Context(), versionId)
+		if err != nil {
+			h.abortWithError(ctx, "error while restoring version", requestPath, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+func (h *versionsHandler) abortWithError(ctx *gin.Context, msg string, requestPath string, err error) {
+	switch {
+	case errors.Is(err, webdav.ErrVersioningDisabled):
+		ctx.AbortWithError(http.StatusBadRequest, err)
+	case errors.Is(err, webdav.ErrVersionNotFound), errors.Is(err, fs.ErrNotExist):
+		ctx.AbortWithError(http.StatusNotFound, err)
+	case errors.Is(err, fs.ErrPermission):
+		ctx.AbortWithError(http.StatusForbidden, err)
+	default:
+		h.log.Error(msg, "path", requestPath, "error", err)
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package versions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	xwebdav "golang.org/x/net/webdav"
+	"umbasa.net/seraph/api-gateway/webdav"
+)
+
+const versionId = "20250101T120000.000000000Z"
+
+// fakeWebDav returns the same versions for every path, or err if set
+type fakeWebDav struct {
+	webdav.WebDavServer
+	versions *fakeVersions
+	err      error
+}
+
+func (w *fakeWebDav) Versions(ctx context.Context, name string) (webdav.FileVersions, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.versions, nil
+}
+
+// fakeVersions keeps a single version with the content "old" in memory
+type fakeVersions struct {
+	fs       xwebdav.FileSystem
+	restored string
+	err      error
+}
+
+func newFakeVersions(t *testing.T) *fakeVersions {
+	memFs := xwebdav.NewMemFS()
+	file, err := memFs.OpenFile(context.Background(), "/"+versionId, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("old"))
+	file.Close()
+	return &fakeVersions{fs: memFs}
+}
+
+func (v *fakeVersions) List(ctx context.Context) ([]webdav.FileVersion, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+	created, _ := time.Parse("20060102T150405.000000000Z", versionId)
+	return []webdav.FileVersion{{Id: versionId, Size: 3, Created: created}}, nil
+}
+
+func (v *fakeVersions) Open(ctx context.Context, id string) (xwebdav.File, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+	if id != versionId {
+		return nil, webdav.ErrVersionNotFound
+	}
+	return v.fs.OpenFile(ctx, "/"+id, os.O_RDONLY, 0)
+}
+
+func (v *fakeVersions) Restore(ctx context.Context, id string) error {
+	if v.err != nil {
+		return v.err
+	}
+	if id != versionId {
+		return webdav.ErrVersionNotFound
+	}
+	v.restored = id
+	return nil
+}
+
+func newVersionsApp(server webdav.WebDavServer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	h := &versionsHandler{log: slog.New(slog.DiscardHandler), webdav: server}
+	h.Setup(app, app.Group("/api"), app.Group("/public"))
+	return app
+}
+
+func serve(app *gin.Engine, method string, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestListVersions(t *testing.T) {
+	app := newVersionsApp(&fakeWebDav{versions: newFakeVersions(t)})
+
+	rec := serve(app, http.MethodGet, "/api/versions/p/space/file.txt")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	list := []webdav.FileVersion{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, versionId, list[0].Id)
+		assert.Equal(t, int64(3), list[0].Size)
+	}
+}
+
+func TestDownloadVersion(t *testing.T) {
+	app := newVersionsApp(&fakeWebDav{versions: newFakeVersions(t)})
+
+	rec := serve(app, http.MethodGet, "/api/versions/p/space/file.txt?version="+versionId)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "old", rec.Body.String())
+	assert.Equal(t, "attachment; filename=\"file.txt\"", rec.Header().Get("Content-Disposition"))
+
+	rec = serve(app, http.MethodGet, "/api/versions/p/space/file.txt?version=unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRestoreVersion(t *testing.T) {
+	versions := newFakeVersions(t)
+	app := newVersionsApp(&fakeWebDav{versions: versions})
+
+	rec := serve(app, http.MethodPost, "/api/versions/p/space/file.txt")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, versions.restored)
+
+	rec = serve(app, http.MethodPost, "/api/versions/p/space/file.txt?version=unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, versions.restored)
+
+	rec = serve(app, http.MethodPost, "/api/versions/p/space/file.txt?version="+versionId)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, versionId, versions.restored)
+}
+
+func TestVersionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *fakeWebDav
+		status int
+	}{
+		{"versioning disabled", &fakeWebDav{err: webdav.ErrVersioningDisabled}, http.StatusBadRequest},
+		{"file not found", &fakeWebDav{err: fs.ErrNotExist}, http.StatusNotFound},
+		{"read only", &fakeWebDav{versions: &fakeVersions{err: fs.ErrPermission}}, http.StatusForbidden},
+		{"other error", &fakeWebDav{versions: &fakeVersions{err: errors.New("unavailable")}}, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := newVersionsApp(test.server)
+
+			rec := serve(app, http.MethodGet, "/api/versions/p/space/file.txt")
+			assert.Equal(t, test.status, rec.Code)
+
+			rec = serve(app, http.MethodPost, "/api/versions/p/space/file.txt?version="+versionId)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package webdav
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -10,7 +11,9 @@ import (
 	"path"
 	"strings"
 
+	"github.com/google/uuid"
 	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/events"
 	"umbasa.net/seraph/file-provider/fileprovider"
 	"umbasa.net/seraph/messaging"
 	"umbasa.net/seraph/shares/shares"
@@ -51,15 +54,47 @@ func (f *delegatingFs) RemoveAll(ctx context.Context, name string) error {
 func (f *delegatingFs) Rename(ctx context.Context, oldName, newName string) error {
 	//TODO: rename across providers!?
 	oldMode, oldProvider, _ := getModeAndProviderAndPath(oldName)
-	newMode, newProvider, newPath := getModeAndProviderAndPath(newName)
+	newMode, newProvider, _ := getModeAndProviderAndPath(newName)
 	if oldMode != newMode || oldProvider != newProvider {
 		return fs.ErrInvalid
 	}
-	fs, path, err := f.getFsAndPath(ctx, "Rename", oldName)
+	fs, oldPath, err := f.getFsAndPath(ctx, "Rename", oldName)
 	if err != nil {
 		return err
 	}
-	return fs.Rename(ctx, path, newPath)
+	_, newPath, err := f.getFsAndPath(ctx, "Rename", newName)
+	if err != nil {
+		return err
+	}
+	// located before the rename, the file can't be found at its old location afterwards
+	fromProvider, fromPath, locateErr := f.locate(ctx, oldName)
+	err = fs.Rename(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	if locateErr == nil {
+		f.publishMove(ctx, fromProvider, fromPath, newName)
+	}
+	return nil
+}
+
+// publishMove tells the file indexer that a file was moved, so that it can keep the indexed file
+func (f *delegatingFs) publishMove(ctx context.Context, providerId string, oldPath string, newName string) {
+	toProvider, newPath, err := f.locate(ctx, newName)
+	if err != nil || toProvider != providerId {
+		// moves between union members are picked up from the file providers
+		return
+	}
+	ev := events.FileMoveEvent{
+		ProviderId: providerId,
+		OldPath:    oldPath,
+		NewPath:    newPath,
+	}
+	data, _ := json.Marshal(ev)
+	err = f.server.outbox.Publish(ctx, events.FileMoveTopic, uuid.NewString(), data)
+	if err != nil {
+		f.log.Error("unable to publish move event", "providerId", providerId, "path", newPath, "error", err)
+	}
 }
 
 func (f *delegatingFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
@@ -71,8 +106,57 @@ func (f *delegatingFs) Stat(ctx context.Context, name string) (os.FileInfo, erro
 }
 
 func (f *delegatingFs) getFsAndPath(ctx context.Context, op string, name string) (webdav.FileSystem, string, error) {
-	mode, providerId, path := getModeAndProviderAndPath(name)
-	f.log.Debug("delegating "+op, "mode", mode, "providerId", providerId, "path", path)
+	r, err := f.resolve(ctx, op, name)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.fs, r.path, nil
+}
+
+// locate returns the provider and the path on that provider that the given name resolves to
+func (f *delegatingFs) locate(ctx context.Context, name string) (string, string, error) {
+	r, err := f.resolve(ctx, "Locate", name)
+	if err != nil {
+		return "", "", err
+	}
+	fileSystem := r.fs
+	if versioning, ok := fileSystem.(*versioningFs); ok {
+		fileSystem = versioning.fs
+	}
+	if union, ok := fileSystem.(*unionFs); ok {
+		return union.Locate(ctx, r.path)
+	}
+	if r.providerId == "" {
+		return "", "", fs.ErrNotExist
+	}
+	return r.providerId, r.path, nil
+}
+
+// fileVersions returns the versions of the file with the given name
+func (f *delegatingFs) fileVersions(ctx context.Context, name string) (FileVersions, error) {
+	r, err := f.resolve(ctx, "Versions", name)
+	if err != nil {
+		return nil, err
+	}
+	versioning, ok := r.fs.(*versioningFs)
+	if !ok {
+		return nil, ErrVersioningDisabled
+	}
+	return versioning.fileVersions(r.path), nil
+}
+
+type resolved struct {
+	fs   webdav.FileSystem
+	path string
+	// empty if fs is not backed by a single provider
+	providerId string
+	// set if the provider is not reachable and fs is a read-only copy from the index
+	offline bool
+}
+
+func (f *delegatingFs) resolve(ctx context.Context, op string, name string) (*resolved, error) {
+	mode, providerId, filePath := getModeAndProviderAndPath(name)
+	f.log.Debug("delegating "+op, "mode", mode, "providerId", providerId, "path", filePath)
 
 	switch mode {
 
@@ -80,51 +164,104 @@ func (f *delegatingFs) getFsAndPath(ctx context.Context, op string, name string)
 	case "p":
 		if providerId == "" {
 			fs, err := f.getSpacesFs(ctx)
-			return fs, path, err
+			return &resolved{fs: fs, path: filePath}, err
 		}
 
-		resolvedProviderId, resolvedPath, readOnly, err := f.resolveSpace(ctx, providerId, path)
+		res, err := f.resolveSpace(ctx, providerId)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
-		if resolvedProviderId == "" {
-			return nil, "", fs.ErrNotExist
+		if !res.Found() {
+			return nil, fs.ErrNotExist
 		}
 
-		f.log.Debug(fmt.Sprintf("resolved %s:%s to %s:%s", providerId, path, resolvedProviderId, resolvedPath), "providerId", providerId, "path", path, "resolvedProviderId", resolvedProviderId, "resolvedPath", resolvedPath)
-
-		fs := &fileprovider.LimitedFs{
-			FileSystem: f.server.getClient(resolvedProviderId),
-			ReadOnly:   readOnly,
+		if len(res.Union) > 0 {
+			f.log.Debug(fmt.Sprintf("resolved %s:%s to union", providerId, filePath), "providerId", providerId, "path", filePath)
+			fs := f.withVersioning(f.getUnionFs(ctx, res.Union, res.Precedence, res.ReadOnly), res.Versioning, res.ReadOnly, "")
+			return &resolved{fs: fs, path: "/" + filePath}, nil
 		}
-		return fs, resolvedPath, nil
+
+		resolvedPath := path.Join(res.Path, filePath)
+
+		f.log.Debug(fmt.Sprintf("resolved %s:%s to %s:%s", providerId, filePath, res.ProviderId, resolvedPath), "providerId", providerId, "path", filePath, "resolvedProviderId", res.ProviderId, "resolvedPath", resolvedPath)
+
+		r := f.getProviderFs(ctx, res.ProviderId, resolvedPath, res.ReadOnly)
+		r.fs = f.withTrash(r.fs, res.Trash, res.ReadOnly || r.offline, res.ProviderId)
+		r.fs = f.withVersioning(r.fs, res.Versioning, res.ReadOnly || r.offline, res.ProviderId)
+		return r, nil
 
 	// "share mode"
 	case "s":
 
-		resolvedProviderId, resolvedPath, readOnly, err := f.resolveShare(ctx, providerId, path)
+		res, err := f.resolveShare(ctx, providerId, filePath)
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
-		if resolvedProviderId == "" {
-			return nil, "", fs.ErrNotExist
+		if !res.Found() {
+			return nil, fs.ErrNotExist
 		}
 
-		f.log.Debug(fmt.Sprintf("resolved %s:%s to %s:%s", providerId, path, resolvedProviderId, resolvedPath), "providerId", providerId, "path", path, "resolvedProviderId", resolvedProviderId, "resolvedPath", resolvedPath)
-
-		fs := &fileprovider.LimitedFs{
-			FileSystem: f.server.getClient(resolvedProviderId),
-			ReadOnly:   readOnly,
+		if len(res.Union) > 0 {
+			// the paths of the union members point to the requested file,
+			// so resolve the root of the share to be able to address files relative to it
+			root, err := f.resolveShare(ctx, providerId, "")
+			if err != nil {
+				return nil, err
+			}
+			if len(root.Union) == 0 {
+				return nil, fs.ErrNotExist
+			}
+			f.log.Debug(fmt.Sprintf("resolved share %s:%s to union", providerId, filePath), "providerId", providerId, "path", filePath)
+			fs := f.withVersioning(f.getUnionFs(ctx, root.Union, root.Precedence, root.ReadOnly), root.Versioning, root.ReadOnly, "")
+			return &resolved{fs: fs, path: "/" + filePath}, nil
 		}
-		return fs, resolvedPath, nil
+
+		f.log.Debug(fmt.Sprintf("resolved %s:%s to %s:%s", providerId, filePath, res.ProviderId, res.Path), "providerId", providerId, "path", filePath, "resolvedProviderId", res.ProviderId, "resolvedPath", res.Path)
+
+		r := f.getProviderFs(ctx, res.ProviderId, res.Path, res.ReadOnly)
+		r.fs = f.withTrash(r.fs, res.Trash, res.ReadOnly || r.offline, res.ProviderId)
+		r.fs = f.withVersioning(r.fs, res.Versioning, res.ReadOnly || r.offline, res.ProviderId)
+		return r, nil
 
 	// invalid mode
 	default:
-		return nil, "", fs.ErrNotExist
+		return nil, fs.ErrNotExist
 	}
 
 }
 
+func (f *delegatingFs) getProviderFs(ctx context.Context, providerId string, path string, readOnly bool) *resolved {
+	providerFs, offline := f.server.providerFs(ctx, providerId)
+	fs := &fileprovider.LimitedFs{
+		FileSystem: providerFs,
+		ReadOnly:   readOnly || offline,
+	}
+	return &resolved{fs: fs, path: path, providerId: providerId, offline: offline}
+}
+
+func (f *delegatingFs) getUnionFs(ctx context.Context, union []spaces.UnionMember, precedence string, readOnly bool) *unionFs {
+	members := make([]unionFsMember, len(union))
+	for i, member := range union {
+		providerFs, offline := f.server.providerFs(ctx, member.ProviderId)
+		memberReadOnly := readOnly || member.ReadOnly || offline
+		members[i] = unionFsMember{
+			providerId: member.ProviderId,
+			fs: &fileprovider.LimitedFs{
+				FileSystem: providerFs,
+				ReadOnly:   memberReadOnly,
+			},
+			root:     member.Path,
+			readOnly: memberReadOnly,
+			write:    member.Write,
+		}
+	}
+	return &unionFs{
+		log:        &f.log,
+		members:    members,
+		precedence: precedence,
+	}
+}
+
 func getModeAndProviderAndPath(p string) (string, string, string) {
 	split := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
 
@@ -158,8 +295,44 @@ func (f *delegatingFs) getSpacesFs(ctx context.Context) (webdav.FileSystem, erro
 	return &spacesFileSystem{f.server, spaces}, nil
 }
 
-func (f *delegatingFs) resolveSpace(ctx context.Context, spaceProviderId string, filePath string) (string, string, bool, error) {
-	cache := ctx.Value(spaceResolveCacheKey{}).(map[string]spaces.SpaceResolveResponse)
+// withVersioning wraps fs to keep previous versions of files, if enabled.
+// providerId is the provider that backs fs, the versions area is hidden if it is located on the same provider.
+func (f *delegatingFs) withVersioning(fileSystem webdav.FileSystem, versioning *spaces.VersioningConfig, readOnly bool, providerId string) webdav.FileSystem {
+	if versioning == nil || !versioning.Enabled || readOnly {
+		return fileSystem
+	}
+	wrapped := &versioningFs{
+		fs:       fileSystem,
+		log:      &f.log,
+		root:     versioning.Root,
+		versions: f.server.getClient(versioning.ProviderId),
+		path:     versioning.Path,
+		config:   *versioning,
+	}
+	_, wrapped.trashed = fileSystem.(*trashFs)
+	if versioning.ProviderId == providerId {
+		wrapped.hidden = path.Clean("/" + versioning.Path)
+	}
+	return wrapped
+}
+
+// withTrash wraps fs to move deleted files into the trash, if enabled.
+// providerId is the provider that backs fs.
+func (f *delegatingFs) withTrash(fileSystem webdav.FileSystem, trash *spaces.TrashConfig, readOnly bool, providerId string) webdav.FileSystem {
+	if trash == nil || !trash.Enabled || readOnly {
+		return fileSystem
+	}
+	return &trashFs{
+		fs:         fileSystem,
+		trash:      f.server.trash,
+		providerId: providerId,
+		config:     *trash,
+		path:       path.Join("/", trash.Root, spaces.TrashDir),
+	}
+}
+
+func (f *delegatingFs) resolveSpace(ctx context.Context, spaceProviderId string) (*spaces.SpaceResolveResponse, error) {
+	cache, _ := ctx.Value(spaceResolveCacheKey{}).(map[string]spaces.SpaceResolveResponse)
 	var res spaces.SpaceResolveResponse
 	if fromCache, ok := cache[spaceProviderId]; ok {
 		res = fromCache
@@ -171,19 +344,21 @@ func (f *delegatingFs) resolveSpace(ctx context.Context, spaceProviderId string,
 		}
 		err := messaging.Request(ctx, f.server.nc, spaces.SpaceResolveTopic, messaging.Json(&req), messaging.Json(&res))
 		if err != nil {
-			return "", "", false, fmt.Errorf("unable to resolve space %s for user %s: %w", spaceProviderId, userId, err)
+			return nil, fmt.Errorf("unable to resolve space %s for user %s: %w", spaceProviderId, userId, err)
 		}
 		if res.Error != "" {
-			return "", "", false, fmt.Errorf("unable to resolve space %s for user %s: %w", spaceProviderId, userId, errors.New(res.Error))
+			return nil, fmt.Errorf("unable to resolve space %s for user %s: %w", spaceProviderId, userId, errors.New(res.Error))
+		}
+		if cache != nil {
+			cache[spaceProviderId] = res
 		}
-		cache[spaceProviderId] = res
 	}
 
-	return res.ProviderId, path.Join(res.Path, filePath), res.ReadOnly, nil
+	return &res, nil
 }
 
-func (f *delegatingFs) resolveShare(ctx context.Context, shareId string, filePath string) (string, string, bool, error) {
-	cache := ctx.Value(shareResolveCacheKey{}).(map[string]shares.ShareResolveResponse)
+func (f *delegatingFs) resolveShare(ctx context.Context, shareId string, filePath string) (*shares.ShareResolveResponse, error) {
+	cache, _ := ctx.Value(shareResolveCacheKey{}).(map[string]shares.ShareResolveResponse)
 	var resolveRes shares.ShareResolveResponse
 	if fromCache, ok := cache[shareId+filePath]; ok {
 		resolveRes = fromCache
@@ -194,12 +369,14 @@ func (f *delegatingFs) resolveShare(ctx context.Context, shareId string, filePat
 		}
 		err := messaging.Request(ctx, f.server.nc, shares.ShareResolveTopic, messaging.Json(&resolveReq), messaging.Json(&resolveRes))
 		if err != nil {
-			return "", "", false, err
+			return nil, err
 		}
 		if resolveRes.Error != "" {
-			return "", "", false, errors.New(resolveRes.Error)
+			return nil, errors.New(resolveRes.Error)
+		}
+		if cache != nil {
+			cache[shareId+filePath] = resolveRes
 		}
-		cache[shareId+filePath] = resolveRes
 	}
-	return resolveRes.ProviderId, resolveRes.Path, resolveRes.ReadOnly, nil
+	return &resolveRes, nil
 }
@@ -0,0 +1,292 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+// OfflineHeader is set on responses that contain files from the index, because a file provider was not reachable
+const OfflineHeader = "X-Seraph-Offline"
+
+// ErrProviderOffline is returned when reading the content of a file whose file provider is not reachable
+var ErrProviderOffline = errors.New("file provider is offline")
+
+// how long the result of checking whether a file provider is reachable is kept
+var providerCheckInterval = 30 * time.Second
+
+// a file provider that does not answer within this time is considered offline
+var providerProbeTimeout = 3 * time.Second
+
+// timeout for requests to the file indexer
+var indexTimeout = 5 * time.Second
+
+// key for request-scoped marker that is set when files are served from the index
+type offlineKey struct{}
+
+type offlineMarker struct {
+	once   sync.Once
+	header http.Header
+}
+
+func withOfflineMarker(r *http.Request, w http.ResponseWriter) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), offlineKey{}, &offlineMarker{header: w.Header()}))
+}
+
+// markOffline sets the OfflineHeader on the response of the request
+func markOffline(ctx context.Context) {
+	if marker, ok := ctx.Value(offlineKey{}).(*offlineMarker); ok {
+		marker.once.Do(func() {
+			marker.header.Set(OfflineHeader, "true")
+		})
+	}
+}
+
+type providerCheck struct {
+	online bool
+	time   time.Time
+}
+
+// providerStatus keeps track of which file providers are reachable
+type providerStatus struct {
+	mu     sync.Mutex
+	checks map[string]providerCheck
+	probe  func(ctx context.Context, providerId string) error
+}
+
+func newProviderStatus(probe func(ctx context.Context, providerId string) error) *providerStatus {
+	return &providerStatus{
+		checks: make(map[string]providerCheck),
+		probe:  probe,
+	}
+}
+
+// isOnline returns whether the file provider is reachable, the provider is probed if it wasn't checked recently
+func (s *providerStatus) isOnline(ctx context.Context, providerId string) bool {
+	s.mu.Lock()
+	check, ok := s.checks[providerId]
+	s.mu.Unlock()
+	if ok && time.Since(check.time) < providerCheckInterval {
+		return check.online
+	}
+
+	online := s.probe(ctx, providerId) == nil
+	s.set(providerId, online)
+	return online
+}
+
+func (s *providerStatus) set(providerId string, online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[providerId] = providerCheck{online: online, time: time.Now()}
+}
+
+// check marks the file provider offline if err indicates that it didn't answer
+func (s *providerStatus) check(providerId string, err error) error {
+	if errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders) {
+		s.set(providerId, false)
+	}
+	return err
+}
+
+// availabilityFs marks its file provider offline when a request to it is not answered
+type availabilityFs struct {
+	webdav.FileSystem
+
+	providerId string
+	status     *providerStatus
+}
+
+func (f *availabilityFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return f.status.check(f.providerId, f.FileSystem.Mkdir(ctx, name, perm))
+}
+
+func (f *availabilityFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := f.FileSystem.OpenFile(ctx, name, flag, perm)
+	return file, f.status.check(f.providerId, err)
+}
+
+func (f *availabilityFs) RemoveAll(ctx context.Context, name string) error {
+	return f.status.check(f.providerId, f.FileSystem.RemoveAll(ctx, name))
+}
+
+func (f *availabilityFs) Rename(ctx context.Context, oldName, newName string) error {
+	return f.status.check(f.providerId, f.FileSystem.Rename(ctx, oldName, newName))
+}
+
+func (f *availabilityFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := f.FileSystem.Stat(ctx, name)
+	return info, f.status.check(f.providerId, err)
+}
+
+// indexFs is a read-only file system that serves the files of a file provider from the index of the file indexer.
+// Files and directories can be listed, but the content of files can not be read.
+type indexFs struct {
+	nc         *nats.Conn
+	providerId string
+}
+
+var _ webdav.FileSystem = &indexFs{}
+
+func (f *indexFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.ErrPermission
+}
+
+func (f *indexFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, fs.ErrPermission
+	}
+	res, err := f.lookup(ctx, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &indexFile{ctx: ctx, fs: f, info: &indexFileInfo{*res.File}}, nil
+}
+
+func (f *indexFs) RemoveAll(ctx context.Context, name string) error {
+	return fs.ErrPermission
+}
+
+func (f *indexFs) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.ErrPermission
+}
+
+func (f *indexFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	res, err := f.lookup(ctx, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &indexFileInfo{*res.File}, nil
+}
+
+func (f *indexFs) lookup(ctx context.Context, name string, readdir bool) (*events.FileLookupResponse, error) {
+	req := events.FileLookupRequest{
+		ProviderId: f.providerId,
+		Path:       name,
+		Readdir:    readdir,
+	}
+	res := events.FileLookupResponse{}
+	err := messaging.RequestTimeout(ctx, f.nc, events.FileLookupTopic, indexTimeout, messaging.Json(&req), messaging.Json(&res))
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	if res.NotFound || res.File == nil {
+		return nil, fs.ErrNotExist
+	}
+	return &res, nil
+}
+
+type indexFile struct {
+	ctx  context.Context
+	fs   *indexFs
+	info *indexFileInfo
+
+	entries []fs.FileInfo
+	read    bool
+}
+
+func (f *indexFile) Close() error {
+	return nil
+}
+
+func (f *indexFile) Read(p []byte) (n int, err error) {
+	return 0, ErrProviderOffline
+}
+
+func (f *indexFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, ErrProviderOffline
+}
+
+func (f *indexFile) Write(p []byte) (n int, err error) {
+	return 0, fs.ErrPermission
+}
+
+func (f *indexFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, fs.ErrInvalid
+	}
+	if !f.read {
+		res, err := f.fs.lookup(f.ctx, f.info.entry.Path, true)
+		if err != nil {
+			return nil, err
+		}
+		f.entries = make([]fs.FileInfo, len(res.Children))
+		for i, child := range res.Children {
+			f.entries[i] = &indexFileInfo{child}
+		}
+		f.read = true
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.entries))
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *indexFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type indexFileInfo struct {
+	entry events.FileEntry
+}
+
+var _ webdav.ContentTyper = &indexFileInfo{}
+
+func (i *indexFileInfo) Name() string {
+	return path.Base(i.entry.Path)
+}
+
+func (i *indexFileInfo) Size() int64 {
+	return i.entry.Size
+}
+
+func (i *indexFileInfo) Mode() fs.FileMode {
+	if i.entry.IsDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *indexFileInfo) ModTime() time.Time {
+	return time.Unix(i.entry.ModTime, 0)
+}
+
+func (i *indexFileInfo) IsDir() bool {
+	return i.entry.IsDir
+}
+
+func (i *indexFileInfo) Sys() any {
+	return nil
+}
+
+// ContentType returns the mime type that was detected by the file indexer,
+// the content of the file can't be read to detect it
+func (i *indexFileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.entry.Mime == "" {
+		return "application/octet-stream", nil
+	}
+	return i.entry.Mime, nil
+}
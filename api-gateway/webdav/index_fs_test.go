@@ -0,0 +1,141 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/events"
+)
+
+func startIndex(t *testing.T, files map[string]events.FileEntry) *nats.Conn {
+	natsServer, err := server.NewServer(&server.Options{Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	_, err = nc.Subscribe(events.FileLookupTopic, func(msg *nats.Msg) {
+		req := events.FileLookupRequest{}
+		json.Unmarshal(msg.Data, &req)
+		res := events.FileLookupResponse{NotFound: true}
+		if file, ok := files[req.Path]; ok && req.ProviderId == "nas" {
+			res = events.FileLookupResponse{File: &file}
+			if req.Readdir {
+				for _, child := range files {
+					if child.Path != "/" && path.Dir(child.Path) == req.Path {
+						res.Children = append(res.Children, child)
+					}
+				}
+			}
+		}
+		data, _ := json.Marshal(res)
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nc
+}
+
+func TestIndexFs(t *testing.T) {
+	ctx := context.Background()
+	nc := startIndex(t, map[string]events.FileEntry{
+		"/":             {ProviderId: "nas", Path: "/", IsDir: true},
+		"/photos":       {ProviderId: "nas", Path: "/photos", IsDir: true, Size: 300, FileCount: 2},
+		"/photos/a.jpg": {ProviderId: "nas", Path: "/photos/a.jpg", Size: 100, ModTime: 1700000000, Mime: "image/jpeg"},
+		"/photos/b.jpg": {ProviderId: "nas", Path: "/photos/b.jpg", Size: 200, ModTime: 1700000000},
+	})
+	indexFs := &indexFs{nc: nc, providerId: "nas"}
+
+	info, err := indexFs.Stat(ctx, "/photos/a.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "a.jpg", info.Name())
+	assert.Equal(t, int64(100), info.Size())
+	assert.Equal(t, time.Unix(1700000000, 0), info.ModTime())
+	assert.False(t, info.IsDir())
+	contentType, err := info.(webdav.ContentTyper).ContentType(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+
+	_, err = indexFs.Stat(ctx, "/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	dir, err := indexFs.OpenFile(ctx, "/photos", os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	entries, err := dir.Readdir(1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = dir.Readdir(1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	_, err = dir.Readdir(1)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// content is not available and writes are rejected
+	file, err := indexFs.OpenFile(ctx, "/photos/a.jpg", os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	_, err = file.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, ErrProviderOffline)
+	_, err = indexFs.OpenFile(ctx, "/photos/c.jpg", os.O_CREATE|os.O_WRONLY, 0644)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, indexFs.Mkdir(ctx, "/new", 0755), fs.ErrPermission)
+	assert.ErrorIs(t, indexFs.RemoveAll(ctx, "/photos"), fs.ErrPermission)
+	assert.ErrorIs(t, indexFs.Rename(ctx, "/photos", "/pictures"), fs.ErrPermission)
+}
+
+func TestProviderStatus(t *testing.T) {
+	probes := 0
+	var probeErr error
+	status := newProviderStatus(func(ctx context.Context, providerId string) error {
+		probes++
+		return probeErr
+	})
+	ctx := context.Background()
+
+	assert.True(t, status.isOnline(ctx, "nas"))
+	assert.True(t, status.isOnline(ctx, "nas"))
+	assert.Equal(t, 1, probes)
+
+	// requests that are not answered mark the provider offline until the next probe
+	status.check("nas", fs.ErrNotExist)
+	assert.True(t, status.isOnline(ctx, "nas"))
+	status.check("nas", nats.ErrTimeout)
+	assert.False(t, status.isOnline(ctx, "nas"))
+	assert.Equal(t, 1, probes)
+
+	probeErr = errors.New("unreachable")
+	assert.False(t, status.isOnline(ctx, "other"))
+	assert.Equal(t, 2, probes)
+}
+
+func TestMarkOffline(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := withOfflineMarker(httptest.NewRequest(http.MethodGet, "/dav/p/space", nil), w)
+
+	markOffline(context.Background())
+	assert.Empty(t, w.Header().Get(OfflineHeader))
+	markOffline(r.Context())
+	assert.Equal(t, "true", w.Header().Get(OfflineHeader))
+}
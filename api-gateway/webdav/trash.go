@@ -0,0 +1,392 @@
+package webdav
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+var TrashBucketConfig = jetstream.KeyValueConfig{
+	Bucket: "SERAPH_TRASH",
+}
+
+var ErrTrashItemNotFound = errors.New("trash item not found")
+
+// trashKeyPrefix returns the prefix of the keys of the items in the trash of a space.
+// The space provider id is encoded, since it may contain characters that are not allowed in keys.
+func trashKeyPrefix(spaceProviderId string) string {
+	return hex.EncodeToString([]byte(spaceProviderId))
+}
+
+// TrashItem is a file or directory that was moved into the trash of a space
+type TrashItem struct {
+	// key of the item in the trash bucket, starts with the prefix of its space
+	Id              string `json:"id"`
+	SpaceProviderId string `json:"spaceProviderId"`
+	// original path of the item, relative to the space
+	Path string `json:"path"`
+	// provider that holds the item
+	ProviderId string `json:"providerId"`
+	// original path of the item on the provider
+	OriginalPath string `json:"originalPath"`
+	// path of the item inside of the trash on the provider
+	TrashPath string    `json:"trashPath"`
+	UserId    string    `json:"userId"`
+	Deleted   time.Time `json:"deleted"`
+	// zero if the item does not expire
+	ExpiresAt time.Time `json:"expiresAt"`
+	IsDir     bool      `json:"isDir"`
+	Size      int64     `json:"size"`
+}
+
+// Trash manages the items that were moved into the trash of spaces
+type Trash interface {
+	// List returns the items in the trash of a space, newest first
+	List(ctx context.Context, spaceProviderId string) ([]TrashItem, error)
+
+	// Restore moves an item back to its original location.
+	// Returns fs.ErrExist if the original location is occupied.
+	Restore(ctx context.Context, id string) error
+
+	// Purge deletes an item permanently
+	Purge(ctx context.Context, id string) error
+
+	// Empty deletes all items in the trash of a space permanently
+	Empty(ctx context.Context, spaceProviderId string) error
+
+	// Expire deletes all items whose retention time has passed, regardless of the user.
+	// Returns the number of deleted items.
+	Expire(ctx context.Context) (int, error)
+}
+
+type trash struct {
+	server *webDavServer
+	log    *slog.Logger
+	kv     jetstream.KeyValue
+}
+
+func (t *trash) List(ctx context.Context, spaceProviderId string) ([]TrashItem, error) {
+	err := t.checkAccess(ctx, spaceProviderId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.items(ctx, trashKeyPrefix(spaceProviderId)+".*")
+}
+
+func (t *trash) Restore(ctx context.Context, id string) error {
+	item, err := t.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = t.checkAccess(ctx, item.SpaceProviderId, true)
+	if err != nil {
+		return err
+	}
+
+	client := t.server.getClient(item.ProviderId)
+
+	_, err = client.Stat(ctx, item.OriginalPath)
+	if err == nil {
+		return fs.ErrExist
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = mkdirAll(ctx, client, "/", path.Dir(item.OriginalPath))
+	if err != nil {
+		return err
+	}
+	err = client.Rename(ctx, item.TrashPath, item.OriginalPath)
+	if err != nil {
+		return err
+	}
+	err = client.RemoveAll(ctx, path.Dir(item.TrashPath))
+	if err != nil {
+		t.log.Warn("unable to remove trash directory of restored item", "id", item.Id, "trashPath", item.TrashPath, "error", err)
+	}
+
+	err = t.kv.Delete(ctx, item.Id)
+	if err != nil {
+		return err
+	}
+	t.publish(ctx, events.FileTrashActionRestore, item)
+	return nil
+}
+
+func (t *trash) Purge(ctx context.Context, id string) error {
+	item, err := t.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = t.checkAccess(ctx, item.SpaceProviderId, true)
+	if err != nil {
+		return err
+	}
+	return t.purge(ctx, item)
+}
+
+func (t *trash) Empty(ctx context.Context, spaceProviderId string) error {
+	err := t.checkAccess(ctx, spaceProviderId, true)
+	if err != nil {
+		return err
+	}
+	items, err := t.items(ctx, trashKeyPrefix(spaceProviderId)+".*")
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		err := t.purge(ctx, &items[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *trash) Expire(ctx context.Context) (int, error) {
+	all, err := t.items(ctx, ">")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	count := 0
+	for i := range all {
+		item := &all[i]
+		if item.ExpiresAt.IsZero() || item.ExpiresAt.After(now) {
+			continue
+		}
+		err := t.purge(ctx, item)
+		if err != nil {
+			t.log.Error("unable to purge expired trash item", "id", item.Id, "trashPath", item.TrashPath, "error", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (t *trash) purge(ctx context.Context, item *TrashItem) error {
+	client := t.server.getClient(item.ProviderId)
+
+	err := client.RemoveAll(ctx, path.Dir(item.TrashPath))
+	if err != nil {
+		return err
+	}
+
+	err = t.kv.Delete(ctx, item.Id)
+	if err != nil {
+		return err
+	}
+	t.publish(ctx, events.FileTrashActionPurge, item)
+	return nil
+}
+
+// checkAccess makes sure that the current user can access the space,
+// changing the trash requires write access
+func (t *trash) checkAccess(ctx context.Context, spaceProviderId string, write bool) error {
+	res, err := t.server.fs.resolveSpace(ctx, spaceProviderId)
+	if err != nil {
+		return err
+	}
+	if !res.Found() {
+		return ErrTrashItemNotFound
+	}
+	if write && res.ReadOnly {
+		return fs.ErrPermission
+	}
+	return nil
+}
+
+func (t *trash) get(ctx context.Context, id string) (*TrashItem, error) {
+	entry, err := t.kv.Get(ctx, id)
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrInvalidKey) {
+		return nil, ErrTrashItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	item := TrashItem{}
+	err = json.Unmarshal(entry.Value(), &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// items returns the items with keys matching the filter, newest first
+func (t *trash) items(ctx context.Context, keys string) ([]TrashItem, error) {
+	watcher, err := t.kv.Watch(ctx, keys, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	items := make([]TrashItem, 0)
+	for v := range watcher.Updates() {
+		if v == nil {
+			break
+		}
+
+		item := TrashItem{}
+		err := json.Unmarshal(v.Value(), &item)
+		if err != nil {
+			t.log.Error("invalid trash item", "key", v.Key(), "error", err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	// newest first
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+func (t *trash) put(ctx context.Context, item *TrashItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = t.kv.Put(ctx, item.Id, data)
+	return err
+}
+
+func (t *trash) publish(ctx context.Context, action string, item *TrashItem) {
+	ev := events.FileTrashEvent{
+		Action:     action,
+		ProviderId: item.ProviderId,
+		Path:       item.OriginalPath,
+		TrashPath:  item.TrashPath,
+	}
+	data, _ := json.Marshal(ev)
+	err := t.server.outbox.Publish(ctx, events.FileTrashTopic, uuid.NewString(), data)
+	if err != nil {
+		t.log.Error("unable to publish trash event", "id", item.Id, "error", err)
+	}
+}
+
+// trashFs moves files into the trash instead of deleting them
+type trashFs struct {
+	fs    webdav.FileSystem
+	trash *trash
+
+	providerId string
+	config     spaces.TrashConfig
+	// trash area in fs, hidden from clients
+	path string
+}
+
+var _ webdav.FileSystem = &trashFs{}
+
+func (f *trashFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if f.isHidden(name) {
+		return fs.ErrPermission
+	}
+	return f.fs.Mkdir(ctx, name, perm)
+}
+
+func (f *trashFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if f.isHidden(name) {
+		return nil, fs.ErrNotExist
+	}
+	file, err := f.fs.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if path.Dir(f.path) == path.Clean(name) {
+		return &hidingDir{file, path.Base(f.path)}, nil
+	}
+	return file, nil
+}
+
+func (f *trashFs) RemoveAll(ctx context.Context, name string) error {
+	if f.isHidden(name) || strings.HasPrefix(f.path, path.Clean(name)+"/") {
+		return fs.ErrPermission
+	}
+
+	info, err := f.fs.Stat(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	id := uuid.NewString()
+	item := TrashItem{
+		Id:              trashKeyPrefix(f.config.SpaceProviderId) + "." + id,
+		SpaceProviderId: f.config.SpaceProviderId,
+		Path:            path.Join("/", strings.TrimPrefix(path.Clean(name), path.Clean("/"+f.config.Root))),
+		ProviderId:      f.providerId,
+		OriginalPath:    path.Clean(name),
+		UserId:          f.trash.server.auth.GetUserId(ctx),
+		Deleted:         now,
+		IsDir:           info.IsDir(),
+		Size:            info.Size(),
+	}
+	// keep the name of the item, so that it is recognizable when browsing the trash on the provider
+	item.TrashPath = path.Join(f.path, id, path.Base(item.OriginalPath))
+	if f.config.RetentionDays > 0 {
+		item.ExpiresAt = now.AddDate(0, 0, f.config.RetentionDays)
+	}
+
+	err = mkdirAll(ctx, f.fs, "/", path.Dir(item.TrashPath))
+	if err != nil {
+		return err
+	}
+	err = f.fs.Rename(ctx, item.OriginalPath, item.TrashPath)
+	if err != nil {
+		return err
+	}
+
+	err = f.trash.put(ctx, &item)
+	if err != nil {
+		f.trash.log.Error("unable to record trash item, moving it back", "path", item.OriginalPath, "error", err)
+		restoreErr := f.fs.Rename(ctx, item.TrashPath, item.OriginalPath)
+		if restoreErr != nil {
+			f.trash.log.Error("unable to move item back from trash", "path", item.OriginalPath, "trashPath", item.TrashPath, "error", restoreErr)
+		}
+		return err
+	}
+
+	f.trash.publish(ctx, events.FileTrashActionTrash, &item)
+	return nil
+}
+
+func (f *trashFs) Rename(ctx context.Context, oldName, newName string) error {
+	if f.isHidden(oldName) || f.isHidden(newName) {
+		return fs.ErrPermission
+	}
+	return f.fs.Rename(ctx, oldName, newName)
+}
+
+func (f *trashFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if f.isHidden(name) {
+		return nil, fs.ErrNotExist
+	}
+	return f.fs.Stat(ctx, name)
+}
+
+func (f *trashFs) isHidden(name string) bool {
+	name = path.Clean("/" + name)
+	return name == f.path || strings.HasPrefix(name, f.path+"/")
+}
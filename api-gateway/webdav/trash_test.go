@@ -0,0 +1,201 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/api-gateway/auth"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func newTrashFs(t *testing.T) (*trashFs, webdav.FileSystem, *nats.Conn) {
+	natsServer, err := server.NewServer(&server.Options{
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		Port:      -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.CreateOrUpdateKeyValue(context.Background(), TrashBucketConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createEventStreams(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logging.New(logging.Params{})
+	config := viper.New()
+	config.Set("auth.enabled", false)
+	authResult, err := auth.New(auth.Params{
+		Log:   logger,
+		Viper: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	davServer := &webDavServer{
+		logger:  logger,
+		nc:      nc,
+		auth:    authResult.Auth,
+		clients: &sync.Map{},
+		outbox:  messaging.NewOutbox(js, logger.GetLogger("webdav")),
+	}
+	t.Cleanup(func() { davServer.outbox.Close(context.Background()) })
+
+	memFs := webdav.NewMemFS()
+	assert.NoError(t, memFs.Mkdir(context.Background(), "/space", 0755))
+
+	return &trashFs{
+		fs:         memFs,
+		trash:      &trash{davServer, logger.GetLogger("trash"), kv},
+		providerId: "provider",
+		config: spaces.TrashConfig{
+			Enabled:         true,
+			RetentionDays:   7,
+			SpaceProviderId: "space",
+			Root:            "/space",
+		},
+		path: "/space/" + spaces.TrashDir,
+	}, memFs, nc
+}
+
+func TestTrashFsMovesToTrash(t *testing.T) {
+	ctx := context.Background()
+	trash, memFs, nc := newTrashFs(t)
+
+	trashEvents := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe(events.FileTrashTopic, trashEvents)
+	assert.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	writeFile(t, trash, "/space/file.txt", "content")
+
+	assert.NoError(t, trash.RemoveAll(ctx, "/space/file.txt"))
+
+	_, err = trash.Stat(ctx, "/space/file.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	items, err := trash.trash.items(ctx, ">")
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	item := items[0]
+	prefix := trashKeyPrefix("space") + "."
+	assert.True(t, strings.HasPrefix(item.Id, prefix))
+	assert.Equal(t, "space", item.SpaceProviderId)
+	assert.Equal(t, "/file.txt", item.Path)
+	assert.Equal(t, "/space/file.txt", item.OriginalPath)
+	assert.Equal(t, "/space/.trash/"+strings.TrimPrefix(item.Id, prefix)+"/file.txt", item.TrashPath)
+	assert.Equal(t, int64(len("content")), item.Size)
+	assert.WithinDuration(t, item.Deleted.AddDate(0, 0, 7), item.ExpiresAt, time.Second)
+
+	// the content is kept in the trash, which is hidden
+	assert.Equal(t, "content", readFile(t, memFs, item.TrashPath))
+	_, err = trash.Stat(ctx, "/space/.trash")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	dir, err := trash.OpenFile(ctx, "/space", os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	infos, err := dir.Readdir(-1)
+	assert.NoError(t, err)
+	dir.Close()
+	assert.Empty(t, infos)
+
+	select {
+	case msg := <-trashEvents:
+		ev := events.FileTrashEvent{}
+		assert.NoError(t, json.Unmarshal(msg.Data, &ev))
+		assert.Equal(t, events.FileTrashActionTrash, ev.Action)
+		assert.Equal(t, "/space/file.txt", ev.Path)
+		assert.Equal(t, item.TrashPath, ev.TrashPath)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no trash event received")
+	}
+}
+
+func TestTrashFsProtectsTrash(t *testing.T) {
+	ctx := context.Background()
+	trash, _, _ := newTrashFs(t)
+
+	assert.ErrorIs(t, trash.RemoveAll(ctx, "/space/.trash"), os.ErrPermission)
+	assert.ErrorIs(t, trash.RemoveAll(ctx, "/space"), os.ErrPermission)
+	assert.ErrorIs(t, trash.Mkdir(ctx, "/space/.trash/dir", 0755), os.ErrPermission)
+}
+
+func TestTrashItemsOfSpace(t *testing.T) {
+	ctx := context.Background()
+	trash, _, _ := newTrashFs(t)
+
+	for _, space := range []string{"space", "other space", "space.other"} {
+		item := TrashItem{Id: trashKeyPrefix(space) + ".item", SpaceProviderId: space}
+		assert.NoError(t, trash.trash.put(ctx, &item))
+	}
+
+	// only the items of the space are read
+	items, err := trash.trash.items(ctx, trashKeyPrefix("space")+".*")
+	assert.NoError(t, err)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "space", items[0].SpaceProviderId)
+	}
+
+	items, err = trash.trash.items(ctx, ">")
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+}
+
+func TestTrashReadOnlyAccess(t *testing.T) {
+	ctx := context.Background()
+	trash, _, nc := newTrashFs(t)
+	trash.trash.server.fs = &delegatingFs{server: trash.trash.server}
+
+	sub, err := nc.Subscribe(spaces.SpaceResolveTopic, func(msg *nats.Msg) {
+		data, _ := json.Marshal(spaces.SpaceResolveResponse{ProviderId: "provider", Path: "/space", ReadOnly: true})
+		msg.Respond(data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	item := TrashItem{Id: trashKeyPrefix("space") + ".item", SpaceProviderId: "space"}
+	assert.NoError(t, trash.trash.put(ctx, &item))
+
+	// read-only members can see the trash, but not change it
+	items, err := trash.trash.List(ctx, "space")
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.ErrorIs(t, trash.trash.Restore(ctx, item.Id), os.ErrPermission)
+	assert.ErrorIs(t, trash.trash.Purge(ctx, item.Id), os.ErrPermission)
+	assert.ErrorIs(t, trash.trash.Empty(ctx, "space"), os.ErrPermission)
+}
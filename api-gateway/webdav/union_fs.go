@@ -0,0 +1,344 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+// unionFs merges the paths of several providers into one tree.
+// Reads are served from the member selected by the precedence rule,
+// directory listings are merged from all members containing the directory.
+// New files and directories are created in the first member that is a write target.
+type unionFs struct {
+	log        *slog.Logger
+	members    []unionFsMember
+	precedence string
+}
+
+type unionFsMember struct {
+	providerId string
+	fs         webdav.FileSystem
+	root       string
+	readOnly   bool
+	write      bool
+}
+
+// a name that was found in a member of the union
+type unionHit struct {
+	member *unionFsMember
+	info   fs.FileInfo
+}
+
+var _ webdav.FileSystem = &unionFs{}
+
+func (f *unionFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	hits, err := f.lookup(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(hits) > 0 {
+		return fs.ErrExist
+	}
+
+	parent, err := f.Stat(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return fs.ErrInvalid
+	}
+
+	target := f.writeTarget()
+	if target == nil {
+		return fs.ErrPermission
+	}
+	err = f.mkdirParents(ctx, target, name)
+	if err != nil {
+		return err
+	}
+	return target.fs.Mkdir(ctx, target.path(name), perm)
+}
+
+func (f *unionFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	hits, err := f.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		if len(hits) == 0 {
+			return nil, fs.ErrNotExist
+		}
+		hit := f.pick(hits)
+		if !hit.info.IsDir() {
+			return hit.member.fs.OpenFile(ctx, hit.member.path(name), flag, perm)
+		}
+		return f.openDir(ctx, name, hits)
+	}
+
+	if len(hits) > 0 {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, fs.ErrExist
+		}
+		// existing files are modified in place
+		hit := f.pick(hits)
+		if hit.member.readOnly {
+			return nil, fs.ErrPermission
+		}
+		return hit.member.fs.OpenFile(ctx, hit.member.path(name), flag, perm)
+	}
+
+	if flag&os.O_CREATE == 0 {
+		return nil, fs.ErrNotExist
+	}
+
+	target := f.writeTarget()
+	if target == nil {
+		return nil, fs.ErrPermission
+	}
+	err = f.mkdirParents(ctx, target, name)
+	if err != nil {
+		return nil, err
+	}
+	return target.fs.OpenFile(ctx, target.path(name), flag, perm)
+}
+
+func (f *unionFs) RemoveAll(ctx context.Context, name string) error {
+	hits, err := f.lookup(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+
+	// do not remove the name partially
+	for _, hit := range hits {
+		if hit.member.readOnly {
+			return fs.ErrPermission
+		}
+	}
+
+	for _, hit := range hits {
+		err := hit.member.fs.RemoveAll(ctx, hit.member.path(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *unionFs) Rename(ctx context.Context, oldName, newName string) error {
+	hits, err := f.lookup(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if len(hits) == 0 {
+		return fs.ErrNotExist
+	}
+
+	for _, hit := range hits {
+		if hit.member.readOnly {
+			return fs.ErrPermission
+		}
+	}
+
+	for _, hit := range hits {
+		err := f.mkdirParents(ctx, hit.member, newName)
+		if err != nil {
+			return err
+		}
+		err = hit.member.fs.Rename(ctx, hit.member.path(oldName), hit.member.path(newName))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *unionFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	hits, err := f.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(hits) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return f.pick(hits).info, nil
+}
+
+// Locate returns the provider and the path on that provider that a name in the union resolves to.
+func (f *unionFs) Locate(ctx context.Context, name string) (string, string, error) {
+	hits, err := f.lookup(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+	if len(hits) == 0 {
+		return "", "", fs.ErrNotExist
+	}
+	hit := f.pick(hits)
+	return hit.member.providerId, hit.member.path(name), nil
+}
+
+// lookup returns all members that contain the given name, in the order of the members.
+// Members that fail with an error other than "not exist" are skipped,
+// unless the name was not found in any other member.
+func (f *unionFs) lookup(ctx context.Context, name string) ([]unionHit, error) {
+	hits := make([]unionHit, 0, len(f.members))
+	var lastErr error
+	for i := range f.members {
+		member := &f.members[i]
+		info, err := member.fs.Stat(ctx, member.path(name))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				f.log.Warn("error while accessing union member", "providerId", member.providerId, "path", member.path(name), "error", err)
+				lastErr = err
+			}
+			continue
+		}
+		hits = append(hits, unionHit{member, info})
+	}
+	if len(hits) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return hits, nil
+}
+
+// pick selects the hit that takes precedence over the others
+func (f *unionFs) pick(hits []unionHit) unionHit {
+	return hits[f.pickIndex(hits)]
+}
+
+func (f *unionFs) pickIndex(hits []unionHit) int {
+	picked := 0
+	if f.precedence == spaces.UnionPrecedenceNewest {
+		for i, hit := range hits {
+			if hit.info.ModTime().After(hits[picked].info.ModTime()) {
+				picked = i
+			}
+		}
+	}
+	return picked
+}
+
+func (f *unionFs) writeTarget() *unionFsMember {
+	for i := range f.members {
+		if f.members[i].write && !f.members[i].readOnly {
+			return &f.members[i]
+		}
+	}
+	return nil
+}
+
+// mkdirParents creates the parent directories of name in the given member,
+// so that a name that exists in the union can also be created in the member
+func (f *unionFs) mkdirParents(ctx context.Context, member *unionFsMember, name string) error {
+	return mkdirAll(ctx, member.fs, member.root, path.Dir(path.Clean("/"+name)))
+}
+
+func (f *unionFs) openDir(ctx context.Context, name string, hits []unionHit) (webdav.File, error) {
+	dir := &unionDir{
+		precedence: f.precedence,
+		files:      make([]webdav.File, 0, len(hits)),
+	}
+	// the picked directory comes first, it provides Stat() for the merged directory
+	picked := f.pickIndex(hits)
+	ordered := append([]unionHit{hits[picked]}, hits[:picked]...)
+	ordered = append(ordered, hits[picked+1:]...)
+	for _, hit := range ordered {
+		if !hit.info.IsDir() {
+			continue
+		}
+		file, err := hit.member.fs.OpenFile(ctx, hit.member.path(name), os.O_RDONLY, 0)
+		if err != nil {
+			dir.Close()
+			return nil, err
+		}
+		dir.files = append(dir.files, file)
+	}
+	dir.File = dir.files[0]
+	return dir, nil
+}
+
+func (m *unionFsMember) path(name string) string {
+	return path.Join(m.root, name)
+}
+
+// unionDir merges the listings of the directories of all members
+type unionDir struct {
+	webdav.File
+
+	precedence string
+	files      []webdav.File
+	entries    []fs.FileInfo
+	listed     bool
+}
+
+func (d *unionDir) Close() error {
+	var err error
+	for _, file := range d.files {
+		closeErr := file.Close()
+		if closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (d *unionDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.listed {
+		err := d.list()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *unionDir) list() error {
+	index := make(map[string]int)
+	entries := make([]fs.FileInfo, 0)
+	for _, file := range d.files {
+		infos, err := file.Readdir(-1)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			i, ok := index[info.Name()]
+			if !ok {
+				index[info.Name()] = len(entries)
+				entries = append(entries, info)
+				continue
+			}
+			if d.precedence == spaces.UnionPrecedenceNewest && info.ModTime().After(entries[i].ModTime()) {
+				entries[i] = info
+			}
+		}
+	}
+	d.entries = entries
+	d.listed = true
+	return nil
+}
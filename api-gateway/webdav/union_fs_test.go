@@ -0,0 +1,115 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func newUnionFs(t *testing.T, precedence string) (*unionFs, webdav.FileSystem, webdav.FileSystem) {
+	ctx := context.Background()
+	first := webdav.NewMemFS()
+	second := webdav.NewMemFS()
+	assert.NoError(t, first.Mkdir(ctx, "/a", 0755))
+	assert.NoError(t, second.Mkdir(ctx, "/b", 0755))
+
+	return &unionFs{
+		log: slog.Default(),
+		members: []unionFsMember{
+			{providerId: "first", fs: first, root: "/a", readOnly: true},
+			{providerId: "second", fs: second, root: "/b", write: true},
+		},
+		precedence: precedence,
+	}, first, second
+}
+
+func writeFile(t *testing.T, fileSystem webdav.FileSystem, name string, content string) {
+	file, err := fileSystem.OpenFile(context.Background(), name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+}
+
+func readFile(t *testing.T, fileSystem webdav.FileSystem, name string) string {
+	file, err := fileSystem.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestUnionFsMergesDirectories(t *testing.T) {
+	ctx := context.Background()
+	union, first, second := newUnionFs(t, spaces.UnionPrecedenceOrder)
+
+	writeFile(t, first, "/a/one.txt", "one")
+	writeFile(t, first, "/a/both.txt", "first")
+	writeFile(t, second, "/b/two.txt", "two")
+	writeFile(t, second, "/b/both.txt", "second")
+
+	dir, err := union.OpenFile(ctx, "/", os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	infos, err := dir.Readdir(-1)
+	assert.NoError(t, err)
+	assert.NoError(t, dir.Close())
+
+	names := make([]string, 0)
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"both.txt", "one.txt", "two.txt"}, names)
+
+	assert.Equal(t, "first", readFile(t, union, "/both.txt"))
+	assert.Equal(t, "two", readFile(t, union, "/two.txt"))
+
+	providerId, filePath, err := union.Locate(ctx, "/two.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", providerId)
+	assert.Equal(t, "/b/two.txt", filePath)
+}
+
+func TestUnionFsPrecedenceNewest(t *testing.T) {
+	union, first, second := newUnionFs(t, spaces.UnionPrecedenceNewest)
+
+	writeFile(t, first, "/a/both.txt", "first")
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, second, "/b/both.txt", "second")
+
+	assert.Equal(t, "second", readFile(t, union, "/both.txt"))
+}
+
+func TestUnionFsWrite(t *testing.T) {
+	ctx := context.Background()
+	union, first, second := newUnionFs(t, spaces.UnionPrecedenceOrder)
+
+	assert.NoError(t, first.Mkdir(ctx, "/a/dir", 0755))
+	writeFile(t, first, "/a/dir/old.txt", "old")
+
+	// new files go to the write target, parent directories are created as needed
+	writeFile(t, union, "/dir/new.txt", "new")
+	assert.Equal(t, "new", readFile(t, second, "/b/dir/new.txt"))
+
+	// files in a read-only member can not be modified or removed
+	_, err := union.OpenFile(ctx, "/dir/old.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.ErrorIs(t, union.RemoveAll(ctx, "/dir/old.txt"), fs.ErrPermission)
+
+	assert.NoError(t, union.Rename(ctx, "/dir/new.txt", "/other/renamed.txt"))
+	assert.Equal(t, "new", readFile(t, second, "/b/other/renamed.txt"))
+
+	assert.NoError(t, union.RemoveAll(ctx, "/other"))
+	_, err = union.Stat(ctx, "/other")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,377 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+// versions are named after the time they were created, so that they sort in chronological order
+const versionIdFormat = "20060102T150405.000000000Z"
+
+var ErrVersioningDisabled = errors.New("versioning is not enabled")
+var ErrVersionNotFound = errors.New("version not found")
+
+// FileVersion is a previous revision of a file
+type FileVersion struct {
+	Id      string    `json:"id"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// FileVersions gives access to the previous revisions of a single file
+type FileVersions interface {
+	// List returns the versions of the file, newest first
+	List(ctx context.Context) ([]FileVersion, error)
+
+	// Open opens a version for reading
+	Open(ctx context.Context, id string) (webdav.File, error)
+
+	// Restore replaces the content of the file with a version.
+	// The content that is replaced is kept as a new version.
+	Restore(ctx context.Context, id string) error
+}
+
+// versioningFs snapshots the previous content of a file before it is truncated, removed or replaced by a rename.
+// WebDAV MOVE and COPY with overwrite remove the destination before writing to it, so removing a file
+// has to keep its content as well, unless the removed file ends up in the trash.
+type versioningFs struct {
+	fs  webdav.FileSystem
+	log *slog.Logger
+
+	// path in fs that version paths are relative to
+	root string
+	// versions area, if it is located inside of fs it is hidden
+	hidden   string
+	versions webdav.FileSystem
+	path     string
+
+	config spaces.VersioningConfig
+
+	// removed files are moved to the trash by fs, so they are not snapshotted
+	trashed bool
+}
+
+var _ webdav.FileSystem = &versioningFs{}
+
+func (f *versioningFs) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if f.isHidden(name) {
+		return fs.ErrPermission
+	}
+	return f.fs.Mkdir(ctx, name, perm)
+}
+
+func (f *versioningFs) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if f.isHidden(name) {
+		return nil, fs.ErrNotExist
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		err := f.snapshot(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := f.fs.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if f.hidden != "" && path.Dir(f.hidden) == path.Clean(name) {
+		return &hidingDir{file, path.Base(f.hidden)}, nil
+	}
+	return file, nil
+}
+
+func (f *versioningFs) RemoveAll(ctx context.Context, name string) error {
+	if f.isHidden(name) {
+		return fs.ErrPermission
+	}
+	if f.hidden != "" && strings.HasPrefix(f.hidden, path.Clean(name)+"/") {
+		// would remove the versions area
+		return fs.ErrPermission
+	}
+
+	if !f.trashed {
+		err := f.snapshot(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.fs.RemoveAll(ctx, name)
+}
+
+func (f *versioningFs) Rename(ctx context.Context, oldName, newName string) error {
+	if f.isHidden(oldName) || f.isHidden(newName) {
+		return fs.ErrPermission
+	}
+
+	err := f.snapshot(ctx, newName)
+	if err != nil {
+		return err
+	}
+
+	err = f.fs.Rename(ctx, oldName, newName)
+	if err != nil {
+		return err
+	}
+
+	// versions follow the file to its new name
+	oldVersions := f.versionsDir(oldName)
+	newVersions := f.versionsDir(newName)
+	if _, err := f.versions.Stat(ctx, oldVersions); err != nil {
+		return nil
+	}
+	if _, err := f.versions.Stat(ctx, newVersions); err == nil {
+		// the replaced file has versions as well, or it was just snapshotted
+		err = f.mergeVersions(ctx, oldVersions, newVersions)
+	} else {
+		err = mkdirAll(ctx, f.versions, "/", path.Dir(newVersions))
+		if err == nil {
+			err = f.versions.Rename(ctx, oldVersions, newVersions)
+		}
+	}
+	if err != nil {
+		f.log.Warn("unable to move versions of renamed file", "oldName", oldName, "newName", newName, "error", err)
+	}
+	return nil
+}
+
+// mergeVersions moves the versions in from into the existing versions directory to and removes from
+func (f *versioningFs) mergeVersions(ctx context.Context, from string, to string) error {
+	versions, err := f.listVersions(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		err := f.versions.Rename(ctx, path.Join(from, version.Id), path.Join(to, version.Id))
+		if err != nil {
+			return err
+		}
+	}
+	f.applyRetention(ctx, to)
+	return f.versions.RemoveAll(ctx, from)
+}
+
+func (f *versioningFs) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if f.isHidden(name) {
+		return nil, fs.ErrNotExist
+	}
+	return f.fs.Stat(ctx, name)
+}
+
+func (f *versioningFs) fileVersions(name string) FileVersions {
+	return &fileVersions{f, name}
+}
+
+func (f *versioningFs) isHidden(name string) bool {
+	if f.hidden == "" {
+		return false
+	}
+	name = path.Clean("/" + name)
+	return name == f.hidden || strings.HasPrefix(name, f.hidden+"/")
+}
+
+func (f *versioningFs) versionsDir(name string) string {
+	rel := strings.TrimPrefix(path.Clean("/"+name), path.Clean("/"+f.root))
+	return path.Join(f.path, rel)
+}
+
+// snapshot copies the current content of the file into a new version.
+// Nothing is done if the file does not exist or is a directory.
+func (f *versioningFs) snapshot(ctx context.Context, name string) error {
+	info, err := f.fs.Stat(ctx, name)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+
+	dir := f.versionsDir(name)
+	err = mkdirAll(ctx, f.versions, "/", dir)
+	if err != nil {
+		f.log.Error("unable to create versions directory", "name", name, "versionsDir", dir, "error", err)
+		return err
+	}
+
+	src, err := f.fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	id := time.Now().UTC().Format(versionIdFormat)
+	dst, err := f.versions.OpenFile(ctx, path.Join(dir, id), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		f.log.Error("unable to create version", "name", name, "version", id, "error", err)
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		f.log.Error("unable to write version", "name", name, "version", id, "error", err)
+		f.versions.RemoveAll(ctx, path.Join(dir, id))
+		return err
+	}
+
+	f.applyRetention(ctx, dir)
+	return nil
+}
+
+// applyRetention removes the versions in dir that exceed the configured limits, oldest first
+func (f *versioningFs) applyRetention(ctx context.Context, dir string) {
+	versions, err := f.listVersions(ctx, dir)
+	if err != nil {
+		f.log.Error("unable to list versions for retention", "versionsDir", dir, "error", err)
+		return
+	}
+
+	var totalSize int64
+	var minCreated time.Time
+	if f.config.MaxAgeDays > 0 {
+		minCreated = time.Now().AddDate(0, 0, -f.config.MaxAgeDays)
+	}
+	for i, version := range versions {
+		totalSize += version.Size
+		keep := (f.config.MaxVersions <= 0 || i < f.config.MaxVersions) &&
+			(f.config.MaxAgeDays <= 0 || version.Created.After(minCreated)) &&
+			(f.config.MaxSize <= 0 || totalSize <= f.config.MaxSize)
+		if keep {
+			continue
+		}
+		err := f.versions.RemoveAll(ctx, path.Join(dir, version.Id))
+		if err != nil {
+			f.log.Error("unable to remove version", "versionsDir", dir, "version", version.Id, "error", err)
+		}
+	}
+}
+
+func (f *versioningFs) listVersions(ctx context.Context, dir string) ([]FileVersion, error) {
+	file, err := f.versions.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []FileVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]FileVersion, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		created, err := time.Parse(versionIdFormat, info.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, FileVersion{
+			Id:      info.Name(),
+			Size:    info.Size(),
+			Created: created,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Id > versions[j].Id
+	})
+	return versions, nil
+}
+
+type fileVersions struct {
+	fs   *versioningFs
+	name string
+}
+
+func (v *fileVersions) List(ctx context.Context) ([]FileVersion, error) {
+	return v.fs.listVersions(ctx, v.fs.versionsDir(v.name))
+}
+
+func (v *fileVersions) Open(ctx context.Context, id string) (webdav.File, error) {
+	if _, err := time.Parse(versionIdFormat, id); err != nil {
+		return nil, ErrVersionNotFound
+	}
+	file, err := v.fs.versions.OpenFile(ctx, path.Join(v.fs.versionsDir(v.name), id), os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrVersionNotFound
+	}
+	return file, err
+}
+
+func (v *fileVersions) Restore(ctx context.Context, id string) error {
+	src, err := v.Open(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := v.fs.OpenFile(ctx, v.name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// hidingDir removes an entry from the listing of a directory
+type hidingDir struct {
+	webdav.File
+
+	hidden string
+}
+
+func (d *hidingDir) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	for i, info := range infos {
+		if info.Name() == d.hidden {
+			infos = append(infos[:i], infos[i+1:]...)
+			break
+		}
+	}
+	return infos, err
+}
+
+// mkdirAll creates the directory dir below root, including any missing parents
+func mkdirAll(ctx context.Context, fileSystem webdav.FileSystem, root string, dir string) error {
+	current := root
+	for _, segment := range strings.Split(strings.Trim(path.Clean("/"+dir), "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+		_, err := fileSystem.Stat(ctx, current)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		err = fileSystem.Mkdir(ctx, current, 0755)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
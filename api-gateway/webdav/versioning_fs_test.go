@@ -0,0 +1,172 @@
+package webdav
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func newVersioningFs(t *testing.T, config spaces.VersioningConfig) (*versioningFs, webdav.FileSystem) {
+	memFs := webdav.NewMemFS()
+	assert.NoError(t, memFs.Mkdir(context.Background(), "/space", 0755))
+
+	return &versioningFs{
+		fs:       memFs,
+		log:      slog.Default(),
+		root:     "/space",
+		hidden:   "/space/" + spaces.VersionsDir,
+		versions: memFs,
+		path:     "/space/" + spaces.VersionsDir,
+		config:   config,
+	}, memFs
+}
+
+func TestVersioningFsKeepsPreviousContent(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true})
+
+	writeFile(t, versioning, "/space/file.txt", "one")
+	writeFile(t, versioning, "/space/file.txt", "two")
+	writeFile(t, versioning, "/space/file.txt", "three")
+
+	versions := versioning.fileVersions("/space/file.txt")
+	list, err := versions.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	file, err := versions.Open(ctx, list[0].Id)
+	assert.NoError(t, err)
+	file.Close()
+	assert.Equal(t, "two", readFile(t, versioning.versions, versioning.versionsDir("/space/file.txt")+"/"+list[0].Id))
+
+	assert.NoError(t, versions.Restore(ctx, list[1].Id))
+	assert.Equal(t, "one", readFile(t, versioning, "/space/file.txt"))
+
+	// restoring keeps the replaced content as a version
+	list, err = versions.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 3)
+}
+
+func TestVersioningFsRetention(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true, MaxVersions: 2})
+
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		writeFile(t, versioning, "/space/file.txt", content)
+	}
+
+	list, err := versioning.fileVersions("/space/file.txt").List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "4", readFile(t, versioning.versions, versioning.versionsDir("/space/file.txt")+"/"+list[0].Id))
+}
+
+func TestVersioningFsRename(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true})
+
+	writeFile(t, versioning, "/space/a.txt", "a1")
+	writeFile(t, versioning, "/space/a.txt", "a2")
+	writeFile(t, versioning, "/space/b.txt", "b")
+
+	// renaming over an existing file keeps its content and the history of the renamed file
+	assert.NoError(t, versioning.Rename(ctx, "/space/a.txt", "/space/b.txt"))
+	list, err := versioning.fileVersions("/space/b.txt").List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "b", readFile(t, versioning.versions, versioning.versionsDir("/space/b.txt")+"/"+list[0].Id))
+		assert.Equal(t, "a1", readFile(t, versioning.versions, versioning.versionsDir("/space/b.txt")+"/"+list[1].Id))
+	}
+	_, err = versioning.versions.Stat(ctx, versioning.versionsDir("/space/a.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// versions follow the file
+	assert.NoError(t, versioning.Rename(ctx, "/space/b.txt", "/space/c.txt"))
+	list, err = versioning.fileVersions("/space/c.txt").List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestVersioningFsRemoveWithTrash(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true})
+	versioning.trashed = true
+
+	writeFile(t, versioning, "/space/file.txt", "one")
+	assert.NoError(t, versioning.RemoveAll(ctx, "/space/file.txt"))
+
+	// the removed file is kept by the trash instead
+	list, err := versioning.fileVersions("/space/file.txt").List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestVersioningFsHidesVersions(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true})
+
+	writeFile(t, versioning, "/space/file.txt", "one")
+	writeFile(t, versioning, "/space/file.txt", "two")
+
+	dir, err := versioning.OpenFile(ctx, "/space", os.O_RDONLY, 0)
+	assert.NoError(t, err)
+	infos, err := dir.Readdir(-1)
+	assert.NoError(t, err)
+	dir.Close()
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "file.txt", infos[0].Name())
+
+	_, err = versioning.Stat(ctx, "/space/"+spaces.VersionsDir)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorIs(t, versioning.RemoveAll(ctx, "/space"), fs.ErrPermission)
+}
+
+func TestVersioningFsWebdavOverwrite(t *testing.T) {
+	ctx := context.Background()
+	versioning, _ := newVersioningFs(t, spaces.VersioningConfig{Enabled: true})
+	handler := &webdav.Handler{
+		FileSystem: versioning,
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	serve := func(method string, src string, dst string) int {
+		req := httptest.NewRequest(method, src, nil)
+		req.Header.Set("Destination", dst)
+		req.Header.Set("Overwrite", "T")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// MOVE removes the destination before renaming onto it
+	writeFile(t, versioning, "/space/a.txt", "a")
+	writeFile(t, versioning, "/space/b.txt", "b")
+	assert.Equal(t, http.StatusNoContent, serve("MOVE", "/space/a.txt", "/space/b.txt"))
+	assert.Equal(t, "a", readFile(t, versioning, "/space/b.txt"))
+
+	list, err := versioning.fileVersions("/space/b.txt").List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "b", readFile(t, versioning.versions, versioning.versionsDir("/space/b.txt")+"/"+list[0].Id))
+	}
+
+	// COPY does the same before copying the content
+	writeFile(t, versioning, "/space/c.txt", "c")
+	assert.Equal(t, http.StatusNoContent, serve("COPY", "/space/c.txt", "/space/b.txt"))
+	assert.Equal(t, "c", readFile(t, versioning, "/space/b.txt"))
+
+	list, err = versioning.fileVersions("/space/b.txt").List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "a", readFile(t, versioning.versions, versioning.versionsDir("/space/b.txt")+"/"+list[0].Id))
+	}
+}
@@ -24,21 +24,28 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"go.uber.org/fx"
 	"golang.org/x/net/webdav"
 	"umbasa.net/seraph/api-gateway/auth"
 	"umbasa.net/seraph/api-gateway/gateway-handler"
+	"umbasa.net/seraph/events"
 	"umbasa.net/seraph/file-provider/fileprovider"
 	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
 	"umbasa.net/seraph/shares/shares"
 	"umbasa.net/seraph/spaces/spaces"
 )
 
 const PathPrefix = "/dav"
 
+// time to publish the file events that are left in the outbox when the gateway is stopped
+const outboxCloseTimeout = 10 * time.Second
+
 var Module = fx.Module("webdav",
 	fx.Provide(
 		New,
@@ -50,7 +57,9 @@ type Params struct {
 
 	Log  *logging.Logger
 	Nc   *nats.Conn
+	Js   jetstream.JetStream
 	Auth auth.Auth
+	Lc   fx.Lifecycle
 }
 
 type Result struct {
@@ -62,6 +71,17 @@ type Result struct {
 
 type WebDavServer interface {
 	FileSystem() webdav.FileSystem
+
+	// Locate returns the provider and the path on that provider that a path of FileSystem() resolves to.
+	// For union space providers this is the member that actually holds the file.
+	Locate(ctx context.Context, name string) (string, string, error)
+
+	// Versions gives access to previous versions of a file of FileSystem().
+	// Returns ErrVersioningDisabled if versioning is not enabled for the space.
+	Versions(ctx context.Context, name string) (FileVersions, error)
+
+	// Trash gives access to files that were deleted in spaces with the trash enabled
+	Trash() Trash
 }
 
 type webDavServer struct {
@@ -70,7 +90,12 @@ type webDavServer struct {
 	auth       auth.Auth
 	clients    *sync.Map
 	fs         *delegatingFs
+	trash      *trash
 	lockSystem webdav.LockSystem
+	providers  *providerStatus
+
+	// publishes the events that the file indexer needs to keep the identity of files
+	outbox *messaging.Outbox
 }
 
 // key for request-scoped cache for delegatingFs.resolveSpace()
@@ -79,17 +104,57 @@ type spaceResolveCacheKey struct{}
 // key for request-scoped cache for delegatingFs.resolveShare()
 type shareResolveCacheKey struct{}
 
-func New(p Params) Result {
+func New(p Params) (Result, error) {
+	kv, err := p.Js.CreateOrUpdateKeyValue(context.Background(), TrashBucketConfig)
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = createEventStreams(p.Js)
+	if err != nil {
+		return Result{}, err
+	}
+
+	log := p.Log.GetLogger("webdav")
 	server := &webDavServer{
 		logger:     p.Log,
 		nc:         p.Nc,
 		auth:       p.Auth,
 		clients:    &sync.Map{},
+		outbox:     messaging.NewOutbox(p.Js, log),
 		lockSystem: webdav.NewMemLS(),
 	}
+	p.Lc.Append(fx.StopHook(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), outboxCloseTimeout)
+		defer cancel()
+		if err := server.outbox.Close(ctx); err != nil {
+			log.Error("error while publishing remaining file events", "error", err)
+		}
+	}))
+	server.providers = newProviderStatus(server.probeProvider)
 	fs := &delegatingFs{server, *server.logger.GetLogger("webdav.fs")}
 	server.fs = fs
-	return Result{Server: server, Handler: server}
+	server.trash = &trash{server, p.Log.GetLogger("webdav.trash"), kv}
+	return Result{Server: server, Handler: server}, nil
+}
+
+// createEventStreams creates the streams for the move and trash events that are published through the outbox
+func createEventStreams(js jetstream.JetStream) error {
+	for name, subject := range map[string]string{
+		events.FileMoveStream:  events.FileMoveTopic,
+		events.FileTrashStream: events.FileTrashTopic,
+	} {
+		_, err := js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+			Name:     name,
+			Subjects: []string{subject},
+			// events are removed once they were processed
+			Retention: jetstream.WorkQueuePolicy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (server *webDavServer) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, publicApiGroup *gin.RouterGroup) {
@@ -122,6 +187,7 @@ func (server *webDavServer) Setup(app *gin.Engine, apiGroup *gin.RouterGroup, pu
 			r := ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), spaceResolveCacheKey{}, spaceCache))
 			r = r.WithContext(context.WithValue(r.Context(), shareResolveCacheKey{}, shareCache))
 			w := &fastResponseWriter{ctx.Writer}
+			r = withOfflineMarker(r, w)
 
 			handler.ServeHTTP(w, r)
 			ctx.Abort()
@@ -135,6 +201,18 @@ func (server *webDavServer) FileSystem() webdav.FileSystem {
 	return server.fs
 }
 
+func (server *webDavServer) Locate(ctx context.Context, name string) (string, string, error) {
+	return server.fs.locate(ctx, name)
+}
+
+func (server *webDavServer) Versions(ctx context.Context, name string) (FileVersions, error) {
+	return server.fs.fileVersions(ctx, name)
+}
+
+func (server *webDavServer) Trash() Trash {
+	return server.trash
+}
+
 func (server *webDavServer) getClient(providerId string) fileprovider.Client {
 	client, ok := server.clients.Load(providerId)
 	if !ok {
@@ -150,6 +228,39 @@ func (server *webDavServer) getClient(providerId string) fileprovider.Client {
 	return client.(fileprovider.Client)
 }
 
+// providerFs returns the file system of a file provider.
+// If the provider is not reachable, a read-only copy from the index is returned instead and offline is true.
+func (server *webDavServer) providerFs(ctx context.Context, providerId string) (fileSystem webdav.FileSystem, offline bool) {
+	if !server.providers.isOnline(ctx, providerId) {
+		markOffline(ctx)
+		return &indexFs{nc: server.nc, providerId: providerId}, true
+	}
+	return &availabilityFs{
+		FileSystem: server.getClient(providerId),
+		providerId: providerId,
+		status:     server.providers,
+	}, false
+}
+
+// probeProvider checks if a file provider answers within providerProbeTimeout
+func (server *webDavServer) probeProvider(ctx context.Context, providerId string) error {
+	ctx, cancel := context.WithTimeout(ctx, providerProbeTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := server.getClient(providerId).Stat(context.WithoutCancel(ctx), "/")
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func CacheMiddleware() func(*gin.Context) {
 	return func(ctx *gin.Context) {
 		spaceCache := make(map[string]spaces.SpaceResolveResponse)
@@ -158,7 +269,7 @@ func CacheMiddleware() func(*gin.Context) {
 		r = r.WithContext(context.WithValue(r.Context(), shareResolveCacheKey{}, shareCache))
 		w := &fastResponseWriter{ctx.Writer}
 		ctx.Writer = w
-		ctx.Request = r
+		ctx.Request = withOfflineMarker(r, w)
 	}
 }
 
@@ -22,4 +22,11 @@ const (
 	FileChangedEventCreated = "created"
 	FileChangedEventChanged = "changed"
 	FileChangedEventDeleted = "deleted"
+
+	// the file was moved into the trash, the event carries its path in the trash
+	FileChangedEventTrashed = "trashed"
+	// the file was restored from the trash
+	FileChangedEventRestored = "restored"
+	// the file was moved or renamed, the event carries its previous path in OldPath
+	FileChangedEventMoved = "moved"
 )
@@ -0,0 +1,46 @@
+package events
+
+// headers of the messages in the FileIndexerDlqStream
+const (
+	// why the event could not be processed
+	DeadLetterReasonHeader = "Seraph-Dead-Letter-Reason"
+	// subject that the event was originally published to
+	DeadLetterSubjectHeader = "Seraph-Dead-Letter-Subject"
+	// sequence of the event in the FileInfoStream
+	DeadLetterSequenceHeader = "Seraph-Dead-Letter-Sequence"
+	// number of attempts to process the event
+	DeadLetterDeliveriesHeader = "Seraph-Dead-Letter-Deliveries"
+)
+
+const (
+	DeadLetterActionList    = "list"
+	DeadLetterActionRetry   = "retry"
+	DeadLetterActionDiscard = "discard"
+)
+
+// DeadLetterRequest lists, retries or discards file events that the file indexer failed to process
+type DeadLetterRequest struct {
+	Action string `json:"action"`
+	// id of the dead letter to retry or discard
+	Id uint64 `json:"id,omitempty"`
+	// maximum number of dead letters to list, starting after the id
+	Limit int `json:"limit,omitempty"`
+}
+
+type DeadLetter struct {
+	// sequence of the event in the FileIndexerDlqStream
+	Id         uint64 `json:"id"`
+	ProviderId string `json:"providerId"`
+	Path       string `json:"path"`
+	Reason     string `json:"reason"`
+	Subject    string `json:"subject"`
+	Deliveries uint64 `json:"deliveries"`
+	// unix timestamp of when the event was dead-lettered
+	Time int64 `json:"time"`
+}
+
+type DeadLetterResponse struct {
+	DeadLetters []DeadLetter `json:"deadLetters,omitempty"`
+	NotFound    bool         `json:"notFound,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
@@ -0,0 +1,50 @@
+package events
+
+// DuplicatesRequest asks the file indexer for files with identical content
+// in the spaces that the user can access
+type DuplicatesRequest struct {
+	UserId string `json:"userId"`
+	// confirm candidates by hashing the full content of the files.
+	// Verification runs as the job with the given key, the request is answered when the job
+	// has started and the verified groups are published to DuplicatesResultTopicPattern.
+	Verify bool   `json:"verify"`
+	Job    string `json:"job,omitempty"`
+	// only files with at least this size are considered
+	MinSize int64 `json:"minSize,omitempty"`
+	// maximum number of groups, 0 for the default
+	Limit int `json:"limit,omitempty"`
+	// only return the groups with these hashes
+	ImoHashes []string `json:"imoHashes,omitempty"`
+}
+
+type DuplicateFile struct {
+	// space provider that the file is visible in
+	ProviderId string `json:"providerId"`
+	// path of the file relative to the space provider
+	Path    string `json:"path"`
+	ModTime int64  `json:"modTime"`
+}
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	ImoHash string `json:"imoHash"`
+	Size    int64  `json:"size"`
+	// whether the content of the files was compared by a full content hash
+	Verified bool `json:"verified"`
+	// bytes that are freed by deleting all but one of the files
+	Reclaimable int64           `json:"reclaimable"`
+	Files       []DuplicateFile `json:"files"`
+}
+
+type DuplicatesResponse struct {
+	Groups []DuplicateGroup `json:"groups"`
+	// reclaimable bytes of the returned groups
+	Reclaimable int64 `json:"reclaimable"`
+	// number of candidate groups and their reclaimable bytes, including groups that were not returned.
+	// Candidates are not verified, so these are estimates.
+	TotalGroups      int64 `json:"totalGroups"`
+	TotalReclaimable int64 `json:"totalReclaimable"`
+	// key of the job that verified the groups
+	Job   string `json:"job,omitempty"`
+	Error string `json:"error,omitempty"`
+}
@@ -94,9 +94,10 @@ type FileChangedEvent struct {
 	ModTime    int64  `avro:"modTime" json:"modTime"`
 	IsDir      bool   `avro:"isDir" json:"isDir"`
 	Mime       string `avro:"mime" json:"mime"`
+	OldPath    string `avro:"oldPath" json:"oldPath"`
 }
 
-var schemaFileChangedEvent = avro.MustParse(`{"name":"seraph.events.FileChangedEvent","type":"record","fields":[{"name":"event","type":"seraph.events.Event"},{"name":"fileId","type":"string"},{"name":"providerId","type":"string"},{"name":"change","type":"string"},{"name":"path","type":"string"},{"name":"size","type":"long"},{"name":"mode","type":"long"},{"name":"modTime","type":"long"},{"name":"isDir","type":"boolean"},{"name":"mime","type":"string"}]}`)
+var schemaFileChangedEvent = avro.MustParse(`{"name":"seraph.events.FileChangedEvent","type":"record","fields":[{"name":"event","type":"seraph.events.Event"},{"name":"fileId","type":"string"},{"name":"providerId","type":"string"},{"name":"change","type":"string"},{"name":"path","type":"string"},{"name":"size","type":"long"},{"name":"mode","type":"long"},{"name":"modTime","type":"long"},{"name":"isDir","type":"boolean"},{"name":"mime","type":"string"},{"name":"oldPath","type":"string","default":""}]}`)
 
 // Schema returns the schema for FileChangedEvent.
 func (o *FileChangedEvent) Schema() avro.Schema {
@@ -0,0 +1,109 @@
+package events
+
+// ListFilesRequest asks the file indexer for the entries of a directory in a space,
+// with the metadata that was extracted from the files
+type ListFilesRequest struct {
+	UserId string `json:"userId"`
+	// space provider that contains the directory
+	ProviderId string `json:"providerId"`
+	// path of the directory relative to the space provider
+	Path string `json:"path"`
+	// maximum number of entries, 0 for the default
+	Limit int `json:"limit,omitempty"`
+	// continues after the page that returned the cursor
+	Cursor string `json:"cursor,omitempty"`
+	// one of the SearchSort constants except relevance, defaults to name
+	Sort string `json:"sort,omitempty"`
+	// SearchOrderAsc or SearchOrderDesc, the default depends on Sort
+	Order string `json:"order,omitempty"`
+}
+
+type FileEntry struct {
+	// space provider that the file is visible in
+	ProviderId string `json:"providerId"`
+	// path of the file relative to the space provider
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDir"`
+	// size of the file or of all files below the directory
+	Size int64 `json:"size"`
+	// number of files below the directory
+	FileCount int64  `json:"fileCount,omitempty"`
+	ModTime   int64  `json:"modTime"`
+	Mime      string `json:"mime,omitempty"`
+	// Unix timestamp of when a photo was taken or a video was recorded, if known
+	Taken int64          `json:"taken,omitempty"`
+	Video *VideoMetadata `json:"video,omitempty"`
+}
+
+type ListFilesResponse struct {
+	Files []FileEntry `json:"files"`
+	// number of entries in the directory
+	Total int64 `json:"total"`
+	// set if more entries are available: cursor for the next page
+	Cursor string `json:"cursor,omitempty"`
+	// set if the space provider or the directory does not exist
+	NotFound bool   `json:"notFound,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StorageRequest asks the file indexer for the disk usage of a space
+type StorageRequest struct {
+	UserId  string `json:"userId"`
+	SpaceId string `json:"spaceId"`
+	// maximum number of entries per space provider, 0 for the default
+	Limit int `json:"limit,omitempty"`
+}
+
+type ProviderStorage struct {
+	// space provider
+	ProviderId string `json:"providerId"`
+	Size       int64  `json:"size"`
+	FileCount  int64  `json:"fileCount"`
+	// largest files and directories in the root directory of the space provider
+	Entries []FileEntry `json:"entries"`
+}
+
+type StorageResponse struct {
+	SpaceId   string            `json:"spaceId"`
+	Size      int64             `json:"size"`
+	FileCount int64             `json:"fileCount"`
+	Providers []ProviderStorage `json:"providers"`
+	// set if the space does not exist
+	NotFound bool   `json:"notFound,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FileLookupRequest asks the file indexer for a file of a file provider as it was last indexed.
+// Used to browse a file provider while it is not reachable.
+type FileLookupRequest struct {
+	ProviderId string `json:"providerId"`
+	// absolute path of the file in the file provider
+	Path string `json:"path"`
+	// also return the entries of the directory
+	Readdir bool `json:"readdir,omitempty"`
+}
+
+type FileLookupResponse struct {
+	// Path of the entries is the absolute path in the file provider
+	File     *FileEntry  `json:"file,omitempty"`
+	Children []FileEntry `json:"children,omitempty"`
+	// set if the file is not in the index
+	NotFound bool   `json:"notFound,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VideoMetadata is extracted from the headers of video files by the file indexer
+type VideoMetadata struct {
+	// duration in milliseconds
+	Duration int64 `bson:"duration,omitempty" json:"duration,omitempty"`
+	// coded dimensions in pixels, without applying the rotation
+	Width  int `bson:"width,omitempty" json:"width,omitempty"`
+	Height int `bson:"height,omitempty" json:"height,omitempty"`
+	// codecs of the first video and audio track
+	VideoCodec string `bson:"videoCodec,omitempty" json:"videoCodec,omitempty"`
+	AudioCodec string `bson:"audioCodec,omitempty" json:"audioCodec,omitempty"`
+	// clockwise rotation in degrees (0, 90, 180 or 270) that is applied when displaying the video
+	Rotation int `bson:"rotation,omitempty" json:"rotation,omitempty"`
+	// Unix timestamp of when the video was recorded, if stored in the file
+	CreationTime int64 `bson:"creationTime,omitempty" json:"creationTime,omitempty"`
+}
@@ -0,0 +1,23 @@
+package events
+
+// ReindexRequest asks the file indexer to process files that are already in the index again
+type ReindexRequest struct {
+	// file provider to reindex, all indexed providers if empty
+	ProviderId string `json:"providerId,omitempty"`
+	// only reindex the file or directory at this path and the files below it, requires ProviderId
+	Path string `json:"path,omitempty"`
+	// crawl the file provider first and remove files from the index that no longer exist
+	Rebuild bool `json:"rebuild,omitempty"`
+}
+
+type ReindexResponse struct {
+	// key of the job that reports the progress of the reindex
+	Job   string `json:"job,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// values of the "status" property of the last JobEvent of a reindex
+const (
+	ReindexStatusComplete = "complete"
+	ReindexStatusFailed   = "failed"
+)
@@ -1,12 +1,66 @@
 package events
 
-const SearchTypeFiles = "files"
+// types of search results, each type is answered by a search participant
+const (
+	SearchTypeFiles  = "files"
+	SearchTypeShares = "shares"
+	SearchTypeSpaces = "spaces"
+)
+
+// search modes
+const (
+	// full text search on file paths and contents, supporting the query syntax
+	SearchModeText = "text"
+	// prefix and typo tolerant search on file names, e.g. for autocomplete
+	SearchModeFuzzy = "fuzzy"
+)
+
+// sort orders for search results
+const (
+	SearchSortRelevance = "relevance"
+	SearchSortName      = "name"
+	SearchSortModified  = "mtime"
+	SearchSortSize      = "size"
+	// time when a photo was taken or a video was recorded, the modification time for other files
+	SearchSortTaken = "taken"
+)
+
+const (
+	SearchOrderAsc  = "asc"
+	SearchOrderDesc = "desc"
+)
+
+// number of results per participant if the request does not set a limit
+const SearchDefaultLimit = 100
+
+// maximum number of results per participant and request
+const SearchMaxLimit = 1000
 
 type SearchRequest struct {
 	RequestId string   `json:"requestId"`
 	UserId    string   `json:"userId"`
 	Query     string   `json:"query"`
 	Types     []string `json:"types"`
+	// one of the SearchMode constants, defaults to SearchModeText
+	Mode string `json:"mode,omitempty"`
+	// maximum number of replies per participant, 0 for the participant's default
+	Limit int `json:"limit,omitempty"`
+	// number of results to skip
+	Offset int `json:"offset,omitempty"`
+	// continues after the page that returned the cursor, takes precedence over Offset
+	Cursor string `json:"cursor,omitempty"`
+	// one of the SearchSort constants, defaults to relevance
+	Sort string `json:"sort,omitempty"`
+	// SearchOrderAsc or SearchOrderDesc, the default depends on Sort
+	Order string `json:"order,omitempty"`
+}
+
+// PageLimit returns the number of results that a participant returns for the request
+func (r *SearchRequest) PageLimit() int {
+	if r.Limit <= 0 {
+		return SearchDefaultLimit
+	}
+	return min(r.Limit, SearchMaxLimit)
 }
 
 type SearchAck struct {
@@ -16,6 +70,12 @@ type SearchAck struct {
 	Types     []string `json:"types"`
 }
 
+// SearchCancel tells participants to stop working on a search request,
+// e.g. because the client has disconnected
+type SearchCancel struct {
+	RequestId string `json:"requestId"`
+}
+
 type SearchReply struct {
 	RequestId string         `json:"requestId"`
 	ReplyId   string         `json:"replyId"`
@@ -23,4 +83,8 @@ type SearchReply struct {
 	Reply     map[string]any `json:"reply"`
 	Error     string         `json:"error"`
 	Last      bool           `json:"last"`
+	// set in the last reply: estimated number of results in total
+	Total int64 `json:"total,omitempty"`
+	// set in the last reply if more results are available: cursor for the next page
+	Cursor string `json:"cursor,omitempty"`
 }
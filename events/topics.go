@@ -22,6 +22,9 @@ const FileInfoStream = "SERAPH_FILE_INFO"
 const FileProviderFileInfoTopic = "seraph.fileprovider.*.fileinfo"
 const FileProviderFileInfoTopicPattern = "seraph.fileprovider.%s.fileinfo"
 
+const FileIndexerDlqStream = "SERAPH_FILE_INDEXER_DLQ"
+const FileIndexerDlqTopic = "seraph.fileindexer.dlq"
+
 const FileChangedStream = "SERAPH_FILE_CHANGED"
 const FileChangedTopic = "seraph.file.*.changed"
 const FileChangedTopicPattern = "seraph.file.%s.changed"
@@ -33,3 +36,21 @@ const JobsTopicPattern = "seraph.jobs.%s"
 const SearchRequestTopic = "seraph.search"
 const SearchAckTopicPattern = "seraph.search.%s.ack"
 const SearchReplyTopicPattern = "seraph.search.%s.reply"
+const SearchCancelTopic = "seraph.search.*.cancel"
+const SearchCancelTopicPattern = "seraph.search.%s.cancel"
+
+const FileTrashStream = "SERAPH_FILE_TRASH"
+const FileTrashTopic = "seraph.trash"
+
+const DuplicatesTopic = "seraph.duplicates"
+const DuplicatesResultTopicPattern = "seraph.duplicates.%s.result"
+
+const ListFilesTopic = "seraph.files.list"
+const StorageTopic = "seraph.files.storage"
+const FileLookupTopic = "seraph.files.lookup"
+
+const ReindexTopic = "seraph.fileindexer.reindex"
+const DeadLetterTopic = "seraph.fileindexer.deadletters"
+
+const FileMoveStream = "SERAPH_FILE_MOVE"
+const FileMoveTopic = "seraph.move"
@@ -0,0 +1,23 @@
+package events
+
+const (
+	FileTrashActionTrash   = "trash"
+	FileTrashActionRestore = "restore"
+	FileTrashActionPurge   = "purge"
+)
+
+// Published when a file or directory is moved into the trash, restored from it or purged.
+// Path is the original location of the file, TrashPath its location inside of the trash.
+type FileTrashEvent struct {
+	Action     string `json:"action"`
+	ProviderId string `json:"providerId"`
+	Path       string `json:"path"`
+	TrashPath  string `json:"trashPath"`
+}
+
+// Published when a file or directory was moved or renamed within a file provider
+type FileMoveEvent struct {
+	ProviderId string `json:"providerId"`
+	OldPath    string `json:"oldPath"`
+	NewPath    string `json:"newPath"`
+}
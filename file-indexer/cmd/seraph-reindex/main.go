@@ -0,0 +1,110 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+// seraph-reindex asks the file indexer to process indexed files again
+// and prints the progress of the reindex job.
+//
+// Usage:
+//
+//	seraph-reindex [-nats url] [-provider id] [-path path] [-rebuild] [-detach]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/nats-io/nats.go"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+func main() {
+	natsUrl := flag.String("nats", nats.DefaultURL, "URL of the NATS server")
+	providerId := flag.String("provider", "", "file provider to reindex, all indexed providers if empty")
+	filePath := flag.String("path", "", "only reindex this file or directory and the files below it")
+	rebuild := flag.Bool("rebuild", false, "crawl the file provider and remove files that no longer exist from the index")
+	detach := flag.Bool("detach", false, "exit after the reindex was started instead of waiting for it to finish")
+	flag.Parse()
+
+	if err := run(*natsUrl, &events.ReindexRequest{
+		ProviderId: *providerId,
+		Path:       *filePath,
+		Rebuild:    *rebuild,
+	}, *detach); err != nil {
+		fmt.Fprintln(os.Stderr, "reindex failed:", err)
+		os.Exit(1)
+	}
+}
+
+func run(natsUrl string, req *events.ReindexRequest, detach bool) error {
+	nc, err := nats.Connect(natsUrl)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// subscribe before the request, so that no progress of the new job is missed
+	jobs := make(chan *nats.Msg, 64)
+	sub, err := nc.ChanSubscribe(fmt.Sprintf(events.JobsTopicPattern, "SERAPH_REINDEX_*"), jobs)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	res := events.ReindexResponse{}
+	err = messaging.Request(ctx, nc, events.ReindexTopic, messaging.Json(req), messaging.Json(&res))
+	if err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	fmt.Println("started job", res.Job)
+	if detach {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the reindex continues in the file indexer
+			return nil
+		case msg := <-jobs:
+			ev := events.JobEvent{}
+			if err := ev.Unmarshal(msg.Data); err != nil || ev.Key != res.Job {
+				continue
+			}
+			if ev.Properties["status"] == events.ReindexStatusFailed {
+				return errors.New(strings.TrimPrefix(ev.StatusMessage, "Reindex failed: "))
+			}
+			fmt.Println(ev.StatusMessage)
+			if ev.Properties["status"] == events.ReindexStatusComplete {
+				return nil
+			}
+		}
+	}
+}
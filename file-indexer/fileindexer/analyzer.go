@@ -0,0 +1,354 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/file-provider/fileprovider"
+)
+
+// reads outside of the declared ranges fetch at least this many bytes,
+// since parsers tend to read small headers one after another
+const readAheadSize = 64 * 1024
+
+// declared ranges that are closer than this are fetched with one read
+const mergeGapSize = 64 * 1024
+
+// Analyzer reads information from the content of a changed file.
+// All analyzers of a file share one open file, the ranges that they declare are fetched together before they run.
+type Analyzer interface {
+	// Name identifies the analyzer in logs and traces
+	Name() string
+	// Handles reports whether the analyzer reads files of the mime type,
+	// the name is for formats that are not reliably identified by their mime type
+	Handles(mimeType string, name string) bool
+	// Ranges returns the parts of the file that the analyzer reads.
+	// Reads outside of these ranges work, but need another round trip to the file provider.
+	Ranges(file *File) []ByteRange
+	// Analyze reads the file and sets its results in proto
+	Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error
+	// Reset clears the results of the analyzer, for files that it doesn't handle or could not read
+	Reset(ctx context.Context, file *File, proto *FilePrototype)
+}
+
+// ByteRange is a part of a file, a negative offset is counted from the end of the file
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Head returns the first n bytes of a file
+func Head(n int64) ByteRange {
+	return ByteRange{0, n}
+}
+
+// Tail returns the last n bytes of a file
+func Tail(n int64) ByteRange {
+	return ByteRange{-n, n}
+}
+
+// Section returns n bytes of a file starting at offset
+func Section(offset int64, n int64) ByteRange {
+	return ByteRange{offset, n}
+}
+
+// resolve returns the start and end of the range in a file of the given size
+func (b ByteRange) resolve(size int64) (int64, int64) {
+	start := b.Offset
+	if start < 0 {
+		start += size
+	}
+	start = min(max(start, 0), size)
+	return start, min(start+max(b.Length, 0), size)
+}
+
+// rangeReader serves all analyzers of a file from buffered ranged reads of one open file
+type rangeReader struct {
+	mu     sync.Mutex
+	file   io.ReadSeeker
+	size   int64
+	blocks []rangeBlock
+	// number of reads from the file, for tests
+	reads int
+}
+
+// rangeBlock is a buffered part of the file, blocks are sorted and don't touch each other
+type rangeBlock struct {
+	offset int64
+	data   []byte
+}
+
+func (b rangeBlock) end() int64 {
+	return b.offset + int64(len(b.data))
+}
+
+func newRangeReader(file io.ReadSeeker, size int64) *rangeReader {
+	return &rangeReader{file: file, size: size}
+}
+
+// implements io.ReaderAt
+var _ io.ReaderAt = &rangeReader{}
+
+// prefetch reads the parts of the ranges that are not buffered yet, ranges that are close to each other are read together
+func (r *rangeReader) prefetch(ranges []ByteRange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := make([][2]int64, 0, len(ranges))
+	for _, b := range ranges {
+		start, end := b.resolve(r.size)
+		if start < end {
+			spans = append(spans, [2]int64{start, end})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	merged := make([][2]int64, 0, len(spans))
+	for _, span := range spans {
+		if last := len(merged) - 1; last >= 0 && span[0] <= merged[last][1]+mergeGapSize {
+			merged[last][1] = max(merged[last][1], span[1])
+		} else {
+			merged = append(merged, span)
+		}
+	}
+	for _, span := range merged {
+		if err := r.fill(span[0], span[1], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("rangeReader.ReadAt: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), r.size)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.fill(off, end, readAheadSize); err != nil {
+		return 0, err
+	}
+
+	// the range is contained in one block after it was filled, unless the file is shorter than expected
+	i := sort.Search(len(r.blocks), func(i int) bool { return r.blocks[i].end() > off })
+	if i == len(r.blocks) || r.blocks[i].offset > off {
+		return 0, io.ErrUnexpectedEOF
+	}
+	block := r.blocks[i]
+	n := copy(p, block.data[off-block.offset:min(end, block.end())-block.offset])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fill reads the parts between start and end that are not buffered, gaps are read with at least readAhead bytes
+func (r *rangeReader) fill(start int64, end int64, readAhead int64) error {
+	gaps := make([][2]int64, 0)
+	pos := start
+	for _, block := range r.blocks {
+		if block.end() <= pos {
+			continue
+		}
+		if block.offset >= end {
+			break
+		}
+		if block.offset > pos {
+			gaps = append(gaps, [2]int64{pos, block.offset})
+		}
+		pos = max(pos, block.end())
+	}
+	if pos < end {
+		gaps = append(gaps, [2]int64{pos, end})
+	}
+
+	for _, gap := range gaps {
+		if err := r.read(gap[0], min(max(gap[1], gap[0]+readAhead), r.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read buffers the bytes between start and end
+func (r *rangeReader) read(start int64, end int64) error {
+	if _, err := r.file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	data := make([]byte, end-start)
+	n, err := io.ReadFull(r.file, data)
+	r.reads++
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n > 0 {
+		r.insert(rangeBlock{start, data[:n]})
+	}
+	return nil
+}
+
+// insert adds a block and merges it with the blocks that it overlaps or touches
+func (r *rangeReader) insert(block rangeBlock) {
+	blocks := make([]rangeBlock, 0, len(r.blocks)+1)
+	for _, b := range r.blocks {
+		if b.end() < block.offset || b.offset > block.end() {
+			blocks = append(blocks, b)
+			continue
+		}
+		start := min(b.offset, block.offset)
+		data := make([]byte, max(b.end(), block.end())-start)
+		copy(data[b.offset-start:], b.data)
+		copy(data[block.offset-start:], block.data)
+		block = rangeBlock{start, data}
+	}
+	blocks = append(blocks, block)
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].offset < blocks[j].offset })
+	r.blocks = blocks
+}
+
+// analysis runs analyzers on one file and collects their results,
+// the file is opened when the first analyzer needs it
+type analysis struct {
+	c     *consumer
+	file  *File
+	proto FilePrototype
+
+	// opens the file, replaced in tests
+	open    func(ctx context.Context) (io.ReadSeekCloser, error)
+	in      io.ReadSeekCloser
+	reader  *rangeReader
+	openErr error
+}
+
+func (c *consumer) newAnalysis(file *File) *analysis {
+	file.indexedMime = file.Mime
+	a := &analysis{c: c, file: file}
+	a.open = a.openFile
+	return a
+}
+
+func (a *analysis) openFile(ctx context.Context) (io.ReadSeekCloser, error) {
+	client := fileprovider.NewFileProviderClient(a.file.ProviderId, a.c.nc, a.c.logger)
+	inFile, err := client.OpenFile(ctx, a.file.Path, os.O_RDONLY, 0)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &clientFile{File: inFile, client: client}, nil
+}
+
+// run runs the analyzers that handle the file and resets the results of the others.
+// The results are applied to the file, so that later analyzers see them.
+func (a *analysis) run(ctx context.Context, analyzers []Analyzer) {
+	handling := make([]Analyzer, 0, len(analyzers))
+	ranges := make([]ByteRange, 0)
+	for _, analyzer := range analyzers {
+		if a.file.IsDir || !analyzer.Handles(a.file.Mime, a.file.Path) {
+			analyzer.Reset(ctx, a.file, &a.proto)
+			continue
+		}
+		handling = append(handling, analyzer)
+		ranges = append(ranges, analyzer.Ranges(a.file)...)
+	}
+
+	if len(handling) > 0 {
+		err := a.prefetch(ctx, ranges)
+		for _, analyzer := range handling {
+			if err != nil {
+				analyzer.Reset(ctx, a.file, &a.proto)
+				continue
+			}
+			a.analyze(ctx, analyzer)
+			a.apply()
+		}
+	}
+	a.apply()
+}
+
+// prefetch opens the file if necessary and reads the ranges of the analyzers
+func (a *analysis) prefetch(ctx context.Context, ranges []ByteRange) error {
+	if a.reader == nil && a.openErr == nil {
+		a.in, a.openErr = a.open(ctx)
+		if a.openErr != nil {
+			a.c.log.Error("Error while opening file for analysis", "path", a.file.Path, "error", a.openErr)
+			return a.openErr
+		}
+		a.reader = newRangeReader(a.in, a.file.Size)
+	}
+	if a.openErr != nil {
+		return a.openErr
+	}
+
+	err := a.reader.prefetch(ranges)
+	if err != nil {
+		a.c.log.Error("Error while reading file for analysis", "path", a.file.Path, "error", err)
+	}
+	return err
+}
+
+func (a *analysis) analyze(ctx context.Context, analyzer Analyzer) {
+	ctx, span := a.c.tracer.Start(ctx, "analyze "+analyzer.Name())
+	defer span.End()
+
+	err := analyzer.Analyze(ctx, a.file, a.reader, &a.proto)
+	if err != nil {
+		a.c.log.Warn("Error while analyzing file", "analyzer", analyzer.Name(), "path", a.file.Path, "error", err)
+		analyzer.Reset(ctx, a.file, &a.proto)
+	}
+}
+
+// apply sets the results that were collected so far on the file
+func (a *analysis) apply() {
+	if err := applyPrototype(a.file, &a.proto); err != nil {
+		a.c.log.Error("Error while applying analysis results", "path", a.file.Path, "error", err)
+	}
+}
+
+func (a *analysis) close() {
+	if a.in != nil {
+		a.in.Close()
+	}
+}
+
+// clientFile is a file that closes its file provider client when it is closed
+type clientFile struct {
+	webdav.File
+	client fileprovider.Client
+}
+
+func (f *clientFile) Close() error {
+	defer f.client.Close()
+	return f.File.Close()
+}
+
+// sectionFile passes a part of the file to readers that need a webdav.File, it can only be read
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (f sectionFile) Close() error {
+	return nil
+}
+
+func (f sectionFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+func (f sectionFile) Stat() (fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+func (f sectionFile) Write(p []byte) (int, error) {
+	return 0, fs.ErrPermission
+}
@@ -0,0 +1,263 @@
+package fileindexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/kalafut/imohash"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// countingFile counts how often it is read, to check that reads are shared
+type countingFile struct {
+	*bytes.Reader
+	reads int
+}
+
+func (f *countingFile) Read(p []byte) (int, error) {
+	f.reads++
+	return f.Reader.Read(p)
+}
+
+func (f *countingFile) Close() error {
+	return nil
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestByteRangeResolve(t *testing.T) {
+	for _, test := range []struct {
+		r          ByteRange
+		start, end int64
+	}{
+		{Head(100), 0, 100},
+		{Head(2000), 0, 1000},
+		{Tail(100), 900, 1000},
+		{Tail(2000), 0, 1000},
+		{Section(500, 100), 500, 600},
+		{Section(950, 100), 950, 1000},
+		{Section(2000, 100), 1000, 1000},
+	} {
+		start, end := test.r.resolve(1000)
+		assert.Equal(t, test.start, start, "%v", test.r)
+		assert.Equal(t, test.end, end, "%v", test.r)
+	}
+}
+
+func TestRangeReaderPrefetch(t *testing.T) {
+	data := testData(1024 * 1024)
+	r := newRangeReader(bytes.NewReader(data), int64(len(data)))
+
+	err := r.prefetch([]ByteRange{Head(100), Head(1000), Section(2000, 100), Section(512*1024, 100), Tail(100)})
+	assert.NoError(t, err)
+	// ranges that are close to each other are read together
+	assert.Equal(t, 3, r.reads)
+
+	buf := make([]byte, 100)
+	for _, off := range []int64{0, 2000, 512 * 1024, int64(len(data)) - 100} {
+		n, err := r.ReadAt(buf, off)
+		assert.NoError(t, err)
+		assert.Equal(t, 100, n)
+		assert.Equal(t, data[off:off+100], buf)
+	}
+	assert.Equal(t, 3, r.reads)
+
+	// ranges that are already buffered are not read again
+	err = r.prefetch([]ByteRange{Head(1000), Tail(50)})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.reads)
+}
+
+func TestRangeReaderReadAhead(t *testing.T) {
+	data := testData(1024 * 1024)
+	r := newRangeReader(bytes.NewReader(data), int64(len(data)))
+
+	buf := make([]byte, 16)
+	for off := int64(300000); off < 300000+readAheadSize-16; off += 1000 {
+		n, err := r.ReadAt(buf, off)
+		assert.NoError(t, err)
+		assert.Equal(t, 16, n)
+		assert.Equal(t, data[off:off+16], buf)
+	}
+	assert.Equal(t, 1, r.reads)
+
+	// a read that overlaps the buffered block only reads the missing part
+	off := int64(300000 + readAheadSize - 8)
+	n, err := r.ReadAt(buf, off)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, n)
+	assert.Equal(t, data[off:off+16], buf)
+	assert.Equal(t, 2, r.reads)
+	assert.Len(t, r.blocks, 1)
+}
+
+func TestRangeReaderEOF(t *testing.T) {
+	data := testData(1000)
+	r := newRangeReader(bytes.NewReader(data), int64(len(data)))
+
+	buf := make([]byte, 100)
+	n, err := r.ReadAt(buf, 950)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, data[950:], buf[:n])
+
+	_, err = r.ReadAt(buf, 1000)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// the file is shorter than its size in the index
+	r = newRangeReader(bytes.NewReader(data), 2000)
+	_, err = r.ReadAt(buf, 1500)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestHashAnalyzerRanges(t *testing.T) {
+	for _, size := range []int{0, 1000, imohash.SampleThreshold, 1024 * 1024} {
+		data := testData(size)
+		file := &File{Size: int64(size)}
+		r := newRangeReader(bytes.NewReader(data), file.Size)
+		err := r.prefetch(hashAnalyzer{}.Ranges(file))
+		assert.NoError(t, err)
+		reads := r.reads
+
+		proto := FilePrototype{}
+		err = hashAnalyzer{&consumer{log: slog.New(slog.DiscardHandler)}}.Analyze(context.Background(), file, r, &proto)
+		assert.NoError(t, err)
+
+		expected := imohash.Sum(data)
+		assert.Equal(t, hex.EncodeToString(expected[:]), proto.ImoHash.Get(), "size %d", size)
+		// the hash is calculated from the prefetched ranges only
+		assert.Equal(t, reads, r.reads, "size %d", size)
+	}
+}
+
+// testAnalyzer records how it was called
+type testAnalyzer struct {
+	name    string
+	handles string
+	ranges  []ByteRange
+	err     error
+
+	analyzed bool
+	reset    bool
+	mime     string
+}
+
+func (a *testAnalyzer) Name() string {
+	return a.name
+}
+
+func (a *testAnalyzer) Handles(mimeType string, name string) bool {
+	return mimeType == a.handles
+}
+
+func (a *testAnalyzer) Ranges(file *File) []ByteRange {
+	return a.ranges
+}
+
+func (a *testAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	a.analyzed = true
+	a.mime = file.Mime
+	if a.err != nil {
+		return a.err
+	}
+	proto.Photo.Set(&PhotoMetadata{Camera: a.name})
+	return nil
+}
+
+func (a *testAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	a.reset = true
+}
+
+func testConsumer() *consumer {
+	return &consumer{
+		log:          slog.New(slog.DiscardHandler),
+		tracer:       noop.NewTracerProvider().Tracer("test"),
+		videoEnabled: true,
+	}
+}
+
+func TestAnalysis(t *testing.T) {
+	data := testMp4(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	in := &countingFile{Reader: bytes.NewReader(data)}
+	opened := 0
+
+	c := testConsumer()
+	file := &File{Path: "/movie.mp4", Size: int64(len(data))}
+	analysis := c.newAnalysis(file)
+	analysis.open = func(ctx context.Context) (io.ReadSeekCloser, error) {
+		opened++
+		return in, nil
+	}
+	defer analysis.close()
+
+	analysis.run(context.Background(), []Analyzer{mimeAnalyzer{c}, hashAnalyzer{c}})
+	assert.Equal(t, "video/mp4", file.Mime)
+	assert.NotEmpty(t, file.ImoHash)
+
+	mp4 := &testAnalyzer{name: "mp4", handles: "video/mp4", ranges: []ByteRange{Head(100)}}
+	jpeg := &testAnalyzer{name: "jpeg", handles: "image/jpeg"}
+	broken := &testAnalyzer{name: "broken", handles: "video/mp4", err: errors.New("broken")}
+	analysis.run(context.Background(), []Analyzer{videoAnalyzer{c}, mp4, jpeg, broken})
+
+	assert.Equal(t, 1, opened)
+	// the samples of the hash, and the parts of the video headers that were not sampled
+	assert.Equal(t, 5, in.reads)
+	assert.True(t, mp4.analyzed)
+	assert.Equal(t, "video/mp4", mp4.mime)
+	assert.False(t, jpeg.analyzed)
+	assert.True(t, jpeg.reset)
+	assert.True(t, broken.analyzed)
+	assert.True(t, broken.reset)
+
+	// the results of all analyzers are merged and applied to the file
+	assert.Equal(t, "mp4", file.Photo.Camera)
+	assert.Equal(t, file.ImoHash, analysis.proto.ImoHash.Get())
+	if assert.NotNil(t, file.Video) {
+		assert.Equal(t, 3840, file.Video.Width)
+	}
+}
+
+func TestAnalysisDirectory(t *testing.T) {
+	c := testConsumer()
+	dir := &File{Path: "/photos", IsDir: true, Mime: "image/jpeg"}
+	analysis := c.newAnalysis(dir)
+	analysis.open = func(ctx context.Context) (io.ReadSeekCloser, error) {
+		t.Fatal("directories are not opened")
+		return nil, nil
+	}
+
+	jpeg := &testAnalyzer{name: "jpeg", handles: "image/jpeg"}
+	analysis.run(context.Background(), []Analyzer{mimeAnalyzer{c}, jpeg})
+
+	assert.False(t, jpeg.analyzed)
+	assert.True(t, jpeg.reset)
+	assert.Equal(t, "", dir.Mime)
+}
+
+func TestAnalysisOpenError(t *testing.T) {
+	c := testConsumer()
+	file := &File{Path: "/a.jpg", Size: 100, Mime: "image/jpeg", ImoHash: "abc"}
+	analysis := c.newAnalysis(file)
+	analysis.open = func(ctx context.Context) (io.ReadSeekCloser, error) {
+		return nil, errors.New("offline")
+	}
+
+	jpeg := &testAnalyzer{name: "jpeg", handles: "image/jpeg"}
+	analysis.run(context.Background(), []Analyzer{hashAnalyzer{c}, jpeg})
+
+	assert.False(t, jpeg.analyzed)
+	assert.True(t, jpeg.reset)
+	assert.Equal(t, "", file.ImoHash)
+}
@@ -0,0 +1,86 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// audio formats supported by taglib that are not always identified by their mime type
+var audioExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".ogg": true, ".oga": true, ".opus": true, ".spx": true,
+	".m4a": true, ".m4b": true, ".aac": true, ".alac": true, ".wav": true, ".aif": true,
+	".aiff": true, ".wma": true, ".asf": true, ".ape": true, ".wv": true, ".mpc": true,
+	".dsf": true, ".dff": true, ".tta": true, ".mod": true, ".s3m": true, ".it": true, ".xm": true,
+}
+
+var errNoAudioMetadata = errors.New("no audio metadata found")
+
+func isAudio(mimeType string, name string) bool {
+	return strings.HasPrefix(mimeType, "audio/") || audioExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// tags are stored at the start (ID3v2, Vorbis comments, MP4 atoms) or at the end (ID3v1, APE) of audio files
+const audioTagSearchSize = 256 * 1024
+
+// audioAnalyzer reads the tags and audio properties of an audio file.
+// The metadata is cleared if the file is not an audio file, has no tags or the indexer was built without taglib.
+type audioAnalyzer struct {
+	c *consumer
+}
+
+func (a audioAnalyzer) Name() string {
+	return "audio"
+}
+
+func (a audioAnalyzer) Handles(mimeType string, name string) bool {
+	return audioSupported && a.c.audioEnabled && isAudio(mimeType, name)
+}
+
+func (a audioAnalyzer) Ranges(file *File) []ByteRange {
+	return []ByteRange{Head(audioTagSearchSize), Tail(audioTagSearchSize)}
+}
+
+func (a audioAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	audio, err := readAudioMetadata(path.Base(file.Path), sectionFile{io.NewSectionReader(r, 0, file.Size)})
+	if errors.Is(err, errNoAudioMetadata) {
+		a.c.log.Debug("No audio metadata found", "path", file.Path)
+		proto.Audio.Set(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	a.c.log.Debug("Extracted audio metadata", "path", file.Path, "artist", audio.Artist, "title", audio.Title)
+	proto.Audio.Set(audio)
+	return nil
+}
+
+func (a audioAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	proto.Audio.Set(nil)
+}
+
+// parseNumber parses numbers in the form "3" or "3/12" as used for track and disc numbers
+func parseNumber(value string) (number int, total int) {
+	n, t, _ := strings.Cut(strings.TrimSpace(value), "/")
+	number, _ = strconv.Atoi(strings.TrimSpace(n))
+	total, _ = strconv.Atoi(strings.TrimSpace(t))
+	return max(number, 0), max(total, 0)
+}
+
+// parseYear returns the year of a date tag (YYYY, YYYY-MM-DD or a full timestamp)
+func parseYear(value string) int {
+	value = strings.TrimSpace(value)
+	if len(value) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(value[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
@@ -0,0 +1,15 @@
+//go:build !taglib
+
+package fileindexer
+
+import (
+	"golang.org/x/net/webdav"
+)
+
+// audio metadata extraction requires building with the taglib tag, since it needs cgo and libtag
+const audioSupported = false
+
+func readAudioMetadata(name string, file webdav.File) (*AudioMetadata, error) {
+	file.Close()
+	return nil, errNoAudioMetadata
+}
@@ -0,0 +1,82 @@
+//go:build taglib
+
+package fileindexer
+
+import (
+	"strings"
+
+	"golang.org/x/net/webdav"
+	"umbasa.net/seraph/taglib"
+)
+
+// audio metadata extraction requires building with the taglib tag, since it needs cgo and libtag
+const audioSupported = true
+
+// separator for tags with multiple values
+const audioValueSeparator = "; "
+
+// readAudioMetadata reads the tags of the file with taglib. The file is closed afterwards.
+func readAudioMetadata(name string, file webdav.File) (*AudioMetadata, error) {
+	stream := taglib.NewWebdavFileStream(name, file)
+	defer stream.Delete()
+
+	ref := taglib.NewFileRef(stream)
+	defer taglib.DeleteFileRef(ref)
+
+	if ref.IsNull() {
+		return nil, errNoAudioMetadata
+	}
+
+	props := ref.Properties()
+	defer taglib.DeletePropertyMap(props)
+
+	value := func(key string) string {
+		k := taglib.NewString(key)
+		defer taglib.DeleteString(k)
+		if !props.Contains(k) {
+			return ""
+		}
+		values := props.Value(k)
+		defer taglib.DeleteStringList(values)
+		return stringListValue(values)
+	}
+
+	audio := &AudioMetadata{
+		Title:       value("TITLE"),
+		Artist:      value("ARTIST"),
+		Album:       value("ALBUM"),
+		AlbumArtist: value("ALBUMARTIST"),
+		Genre:       value("GENRE"),
+		Year:        parseYear(value("DATE")),
+	}
+	audio.Track, audio.TrackTotal = parseNumber(value("TRACKNUMBER"))
+	audio.Disc, audio.DiscTotal = parseNumber(value("DISCNUMBER"))
+
+	if properties := ref.AudioProperties(); properties != nil && properties.Swigcptr() != 0 {
+		audio.Duration = int64(properties.LengthInMilliseconds())
+		audio.Bitrate = properties.Bitrate()
+		audio.SampleRate = properties.SampleRate()
+		audio.Channels = properties.Channels()
+	}
+
+	keys := ref.ComplexPropertyKeys()
+	defer taglib.DeleteStringList(keys)
+	for _, key := range strings.Split(stringListValue(keys), audioValueSeparator) {
+		if key == "PICTURE" {
+			audio.HasArt = true
+		}
+	}
+
+	if *audio == (AudioMetadata{}) {
+		return nil, errNoAudioMetadata
+	}
+	return audio, nil
+}
+
+func stringListValue(values taglib.StringList) string {
+	separator := taglib.NewString(audioValueSeparator)
+	defer taglib.DeleteString(separator)
+	value := values.ToString(separator)
+	defer taglib.DeleteString(value)
+	return strings.TrimSpace(value.ToCString(true))
+}
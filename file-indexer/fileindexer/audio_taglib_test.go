@@ -0,0 +1,28 @@
+//go:build taglib
+
+package fileindexer
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+func TestReadAudioMetadata(t *testing.T) {
+	dir := webdav.Dir("../../taglib")
+	file, err := dir.OpenFile(context.Background(), "test.mp3", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio, err := readAudioMetadata("test.mp3", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "The Title", audio.Title)
+	assert.Equal(t, "The Artist", audio.Artist)
+	assert.Equal(t, "The Album", audio.Album)
+	assert.Equal(t, "Booty Bass", audio.Genre)
+	assert.Equal(t, 42, audio.Track)
+}
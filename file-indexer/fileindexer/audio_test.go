@@ -0,0 +1,39 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAudio(t *testing.T) {
+	assert.True(t, isAudio("audio/mpeg", "/music/a.mp3"))
+	assert.True(t, isAudio("", "/music/a.FLAC"))
+	assert.True(t, isAudio("application/octet-stream", "/music/a.opus"))
+	assert.False(t, isAudio("video/mp4", "/videos/a.mp4"))
+	assert.False(t, isAudio("image/jpeg", "/music/cover.jpg"))
+}
+
+func TestParseNumber(t *testing.T) {
+	cases := map[string][2]int{
+		"3":      {3, 0},
+		"3/12":   {3, 12},
+		" 7 / 9": {7, 9},
+		"/5":     {0, 5},
+		"":       {0, 0},
+		"A1":     {0, 0},
+		"-2":     {0, 0},
+	}
+	for value, expected := range cases {
+		number, total := parseNumber(value)
+		assert.Equal(t, expected, [2]int{number, total}, value)
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	assert.Equal(t, 1999, parseYear("1999"))
+	assert.Equal(t, 2004, parseYear("2004-05-03"))
+	assert.Equal(t, 2011, parseYear("2011-01-01T10:00:00Z"))
+	assert.Equal(t, 0, parseYear("99"))
+	assert.Equal(t, 0, parseYear("unknown"))
+}
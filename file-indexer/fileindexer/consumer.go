@@ -2,17 +2,17 @@ package fileindexer
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"mime"
-	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boz/go-throttle"
@@ -29,15 +29,17 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 	"umbasa.net/seraph/events"
-	"umbasa.net/seraph/file-provider/fileprovider"
 	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
 	"umbasa.net/seraph/tracing"
-	"umbasa.net/seraph/util"
 )
 
 const filesCollection = "files"
 const readdirCollection = "readdir"
 
+// time to publish the file changed events that are left in the outbox when the consumer is stopped
+const outboxCloseTimeout = 10 * time.Second
+
 type Consumer interface {
 	Start() error
 	Stop()
@@ -48,18 +50,47 @@ type consumer struct {
 	log            *slog.Logger
 	nc             *nats.Conn
 	js             jetstream.JetStream
+	outbox         *messaging.Outbox
 	fileInfoStream jetstream.Stream
-	consumer       jetstream.Consumer
-	kv             jetstream.KeyValue
-
-	limiter          util.Limiter
+	dlqStream      jetstream.Stream
+	moveStream     jetstream.Stream
+	trashStream    jetstream.Stream
+	moveCons       jetstream.ConsumeContext
+	trashCons      jetstream.ConsumeContext
+	reindexSub     *nats.Subscription
+	deadLetterSub  *nats.Subscription
+
+	// lanes by provider id
+	lanes       map[string]*lane
+	lanesMu     sync.Mutex
+	laneConfigs map[string]laneConfig
+
+	scheduler        *fairScheduler
 	progressThrottle throttle.Throttle
+	batchSize        int
+
+	dirs *dirCache
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	files   *mongo.Collection
-	readdir *mongo.Collection
+	files    *mongo.Collection
+	readdir  *mongo.Collection
+	contents *mongo.Collection
+
+	contentConfig contentConfig
+	photoEnabled  bool
+	audioEnabled  bool
+	videoEnabled  bool
+	reindexLimit  int
+	maxDeliver    int
+	retryDelay    time.Duration
+	readdirExpiry time.Duration
+
+	// analyzers that identify a file, they run before moved files are detected
+	identifiers []Analyzer
+	// analyzers that read the content of a file
+	analyzers []Analyzer
 
 	tracer trace.Tracer
 }
@@ -74,6 +105,9 @@ type ConsumerParams struct {
 	Viper   *viper.Viper
 	Tracing *tracing.Tracing
 	Mig     Migrations
+
+	// additional analyzers, they run after the built-in analyzers
+	Analyzers []Analyzer `group:"analyzers"`
 }
 
 var searchWordsRegex = regexp.MustCompile(`\W|_`)
@@ -103,70 +137,131 @@ func NewConsumer(p ConsumerParams) (Consumer, error) {
 		return nil, err
 	}
 
-	kv, err := p.Js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
-		Bucket: "SERAPH_FILE_INDEXER",
+	// create stream for file events that could not be processed
+
+	log.Debug("create " + events.FileIndexerDlqStream)
+	dlqStream, err := p.Js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:     events.FileIndexerDlqStream,
+		Subjects: []string{events.FileIndexerDlqTopic},
 	})
-	kv.Create(context.Background(), "lastSeq", make([]byte, 8))
+	if err != nil {
+		return nil, err
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// create stream for FileMoveEvent - we consume these
 
-	c, _ := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
-		Durable: "SERAPH_FILE_INDEXER",
+	log.Debug("create " + events.FileMoveStream)
+	moveStream, err := p.Js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:     events.FileMoveStream,
+		Subjects: []string{events.FileMoveTopic},
+		// events are removed once they were processed
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// create stream for FileTrashEvent - we consume these
+
+	log.Debug("create " + events.FileTrashStream)
+	trashStream, err := p.Js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:     events.FileTrashStream,
+		Subjects: []string{events.FileTrashTopic},
+		// events are removed once they were processed
+		Retention: jetstream.WorkQueuePolicy,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	laneConfigs, err := loadLaneConfigs(p.Viper)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 
 	files := p.Db.Collection(filesCollection)
 	readdir := p.Db.Collection(readdirCollection)
+	contents := p.Db.Collection(contentsCollection)
 
-	limiter := util.NewLimiter(p.Viper.GetInt("fileindexer.parallel"))
+	scheduler := newFairScheduler(p.Viper.GetInt("fileindexer.parallel"))
 	progressThrottle := throttle.NewThrottle(2*time.Second, true)
 
 	tracer := p.Tracing.TracerProvider.Tracer("fileindexer")
 
+	if p.Viper.GetBool("fileindexer.audio.enabled") && !audioSupported {
+		log.Info("audio metadata extraction is not available, the file indexer was built without taglib")
+	}
+
 	cons := consumer{
 		logger:         p.Logger,
 		log:            log,
 		nc:             p.Nc,
 		js:             p.Js,
+		outbox:         messaging.NewOutbox(p.Js, log),
 		fileInfoStream: stream,
-		consumer:       c,
-		kv:             kv,
+		dlqStream:      dlqStream,
+		moveStream:     moveStream,
+		trashStream:    trashStream,
+		lanes:          make(map[string]*lane),
+		laneConfigs:    laneConfigs,
 
-		limiter:          limiter,
+		scheduler:        scheduler,
 		progressThrottle: progressThrottle,
+		batchSize:        max(p.Viper.GetInt("fileindexer.batchSize"), 1),
+
+		dirs: newDirCache(),
 
-		files:   files,
-		readdir: readdir,
-		ctx:     ctx,
-		cancel:  cancel,
+		files:    files,
+		readdir:  readdir,
+		contents: contents,
+		ctx:      ctx,
+		cancel:   cancel,
+
+		contentConfig: newContentConfig(p.Viper),
+		photoEnabled:  p.Viper.GetBool("fileindexer.photo.enabled"),
+		audioEnabled:  p.Viper.GetBool("fileindexer.audio.enabled"),
+		videoEnabled:  p.Viper.GetBool("fileindexer.video.enabled"),
+		reindexLimit:  p.Viper.GetInt("fileindexer.reindex.parallel"),
+		maxDeliver:    max(p.Viper.GetInt("fileindexer.retry.maxDeliver"), 1),
+		retryDelay:    p.Viper.GetDuration("fileindexer.retry.delay"),
+		readdirExpiry: p.Viper.GetDuration("fileindexer.readdir.expiry"),
 
 		tracer: tracer,
 	}
+	cons.identifiers = []Analyzer{mimeAnalyzer{&cons}, hashAnalyzer{&cons}}
+	cons.analyzers = append([]Analyzer{contentAnalyzer{&cons}, photoAnalyzer{&cons}, audioAnalyzer{&cons}, videoAnalyzer{&cons}}, p.Analyzers...)
 
 	return &cons, nil
 }
 
 func (c *consumer) Start() error {
-	iter, err := c.consumer.Messages()
+	var err error
+	c.trashCons, err = c.consumeEvents(c.trashStream, "trash event", c.handleTrashEvent)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		for {
-			msg, err := iter.Next()
-			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
-				return
-			}
-			if err != nil {
-				c.log.Error("consumer error", "error", err)
-				return
-			}
-			if !c.limiter.Begin(c.ctx) {
-				return
-			}
-			go c.handleMessage(msg)
-		}
-	}()
+	c.moveCons, err = c.consumeEvents(c.moveStream, "move event", c.handleMoveEvent)
+	if err != nil {
+		return err
+	}
+
+	c.reindexSub, err = c.nc.QueueSubscribe(events.ReindexTopic, "SERAPH_FILE_INDEXER", c.handleReindexMessage)
+	if err != nil {
+		return err
+	}
+
+	c.deadLetterSub, err = c.nc.QueueSubscribe(events.DeadLetterTopic, "SERAPH_FILE_INDEXER", c.handleDeadLetterRequest)
+	if err != nil {
+		return err
+	}
+
+	err = c.startLanes()
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		for c.progressThrottle.Next() {
@@ -174,72 +269,195 @@ func (c *consumer) Start() error {
 		}
 	}()
 
+	go c.initDirSizes()
+
+	go c.initNameGrams()
+
+	go c.sweepReaddirs()
+
 	return nil
 }
 
 func (c *consumer) Stop() {
+	if c.trashCons != nil {
+		c.trashCons.Drain()
+	}
+	if c.moveCons != nil {
+		c.moveCons.Drain()
+	}
+	if c.reindexSub != nil {
+		c.reindexSub.Unsubscribe()
+	}
+	if c.deadLetterSub != nil {
+		c.deadLetterSub.Unsubscribe()
+	}
 	c.cancel()
-	c.limiter.Join()
+	c.scheduler.Join()
 	c.progressThrottle.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboxCloseTimeout)
+	defer cancel()
+	if err := c.outbox.Close(ctx); err != nil {
+		c.log.Error("error while publishing remaining file changed events", "error", err)
+	}
+}
+
+// consumeEvents processes the events of a stream one after another with a durable consumer that is shared by all file indexers.
+// Events that fail are delivered again, up to the configured number of attempts.
+func (c *consumer) consumeEvents(stream jetstream.Stream, name string, handle func(ctx context.Context, data []byte) error) (jetstream.ConsumeContext, error) {
+	cons, err := stream.CreateOrUpdateConsumer(c.ctx, jetstream.ConsumerConfig{
+		Durable: "SERAPH_FILE_INDEXER",
+		// the number of attempts is limited below, so that giving up on an event is logged
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cons.Consume(func(msg jetstream.Msg) {
+		ctx := messaging.ExtractTraceContextHeader(c.ctx, msg.Headers())
+		err := handle(ctx, msg.Data())
+		if err == nil {
+			msg.Ack()
+			return
+		}
+
+		metadata, metadataErr := msg.Metadata()
+		if metadataErr != nil || metadata.NumDelivered >= uint64(c.maxDeliver) {
+			c.log.Error("giving up processing "+name, "error", err, "subject", msg.Subject())
+			msg.TermWithReason(err.Error())
+			return
+		}
+		msg.NakWithDelay(retryDelay(c.retryDelay, metadata.NumDelivered))
+	})
 }
 
-func (c *consumer) handleMessage(msg jetstream.Msg) {
-	defer c.limiter.End()
-	ctx, span := c.tracer.Start(c.ctx, "handleMessage")
+// fileInfoMsg is a file event that was read from the stream
+type fileInfoMsg struct {
+	msg      jetstream.Msg
+	metadata *jetstream.MsgMetadata
+	event    events.FileInfoEvent
+	// the span that published the event
+	link trace.Link
+}
+
+// indexBatch writes the files of a batch of file events to the index,
+// and then processes each file when the scheduler has a free slot for the lane
+func (c *consumer) indexBatch(l *lane, msgs []jetstream.Msg) bool {
+	ctx, span := c.tracer.Start(c.ctx, "indexBatch")
 	defer span.End()
 
-	metadata, err := msg.Metadata()
+	// the file events that wait for a slot are reported as in progress, so that they aren't redelivered
+	var dispatched atomic.Int64
+	keepAlive, stopKeepAlive := context.WithCancel(ctx)
+	defer stopKeepAlive()
+	go func() {
+		ticker := time.NewTicker(inProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-keepAlive.Done():
+				return
+			case <-ticker.C:
+				for _, msg := range msgs[dispatched.Load():] {
+					msg.InProgress()
+				}
+			}
+		}
+	}()
+
+	items := make([]*fileInfoMsg, 0, len(msgs))
+	files := make([]*FilePrototype, 0, len(msgs))
+	for _, msg := range msgs {
+		item := c.readMessage(ctx, msg)
+		if item == nil {
+			continue
+		}
+		span.AddLink(item.link)
+		file := newFilePrototype(&item.event)
+		items = append(items, item)
+		files = append(files, &file)
+	}
+
+	results, err := c.upsertFiles(ctx, files)
 	if err != nil {
-		c.log.Error("failed to read message metadata", "error", err)
-		return
+		c.log.Error("error processing FileInfoEvents", "error", err, "providerId", l.providerId, "count", len(files))
+		results = make([]upsertResult, len(files))
+		for i := range results {
+			results[i].err = err
+		}
 	}
 
-	fileInfoEvent := events.FileInfoEvent{}
+	for i, item := range items {
+		if !c.scheduler.Begin(c.ctx, l.providerId) {
+			for _, item := range items[i:] {
+				item.msg.Nak()
+			}
+			return false
+		}
+		dispatched.Store(int64(i + 1))
+		go c.handleMessage(l.providerId, item, results[i])
+	}
+	return true
+}
 
-	err = fileInfoEvent.Unmarshal(msg.Data())
+// readMessage decodes a file event, events that can't be processed are moved to the dead letter queue
+func (c *consumer) readMessage(ctx context.Context, msg jetstream.Msg) *fileInfoMsg {
+	metadata, err := msg.Metadata()
 	if err != nil {
-		c.log.Error("failed to deserialize message", "error", err)
-		return
+		c.log.Error("failed to read message metadata", "error", err)
+		return nil
 	}
 
-	if !strings.HasPrefix(fileInfoEvent.Path, "/") {
-		c.log.Error("error processing FileInfoEvent: path is not absolute", "event", fileInfoEvent)
-		msg.TermWithReason("path is not absolute")
-		return
+	if metadata.NumDelivered > uint64(c.maxDeliver) {
+		// the last attempt timed out, or the message could not be moved to the dead letter queue
+		c.deadLetter(ctx, msg, metadata, "exceeded maximum number of deliveries")
+		return nil
 	}
 
-	cleanPath := path.Clean(fileInfoEvent.Path)
-	file := FilePrototype{}
-	file.ProviderId.Set(fileInfoEvent.ProviderID)
-	file.Path.Set(cleanPath)
-	file.SearchWords.Set(strings.TrimSpace(searchWordsRegex.ReplaceAllString(cleanPath, " ")))
-	file.IsDir.Set(fileInfoEvent.IsDir)
-	if fileInfoEvent.ModTime != 0 || fileInfoEvent.Mode != 0 || fileInfoEvent.Size != 0 {
-		file.ModTime.Set(fileInfoEvent.ModTime)
-		file.Mode.Set(fileInfoEvent.Mode)
-		file.Size.Set(fileInfoEvent.Size)
+	item := &fileInfoMsg{msg: msg, metadata: metadata, link: messaging.EventLink(msg.Headers())}
+	err = item.event.Unmarshal(msg.Data())
+	if err != nil {
+		c.log.Error("failed to deserialize message", "error", err)
+		c.deadLetter(ctx, msg, metadata, "failed to deserialize message: "+err.Error())
+		return nil
 	}
-	if !fileInfoEvent.IsDir {
-		file.Pending.Set(true)
+
+	if !strings.HasPrefix(item.event.Path, "/") {
+		c.log.Error("error processing FileInfoEvent: path is not absolute", "event", item.event)
+		c.deadLetter(ctx, msg, metadata, "path is not absolute")
+		return nil
 	}
+	return item
+}
 
-	newFile, change, err := c.upsertFile(ctx, &file)
+func (c *consumer) handleMessage(providerId string, item *fileInfoMsg, result upsertResult) {
+	defer c.scheduler.End(providerId)
+	// the file is processed in the trace of the operation that published the event
+	ctx, span := c.tracer.Start(trace.ContextWithRemoteSpanContext(c.ctx, item.link.SpanContext), "handleMessage")
+	defer span.End()
 
-	if err != nil {
-		c.log.Error("error processing FileInfoEvent", "error", err, "event", fileInfoEvent)
+	msg, metadata, fileInfoEvent := item.msg, item.metadata, item.event
+	if result.err != nil {
+		c.log.Error("error processing FileInfoEvent", "error", result.err, "event", fileInfoEvent)
+		c.retry(ctx, msg, metadata, result.err)
 		return
 	}
 
+	newFile, change := result.file, result.change
+	var err error
 	if change != "" {
 		err = c.handleChangedFile(ctx, newFile, change)
 		if err != nil {
 			c.log.Error("error processing changed file", "error", err, "event", fileInfoEvent)
+			c.retry(ctx, msg, metadata, err)
 			return
 		}
-	} else {
+	} else if newFile.Pending {
 		err = c.handleUnchangedFile(ctx, newFile)
 		if err != nil {
 			c.log.Error("error processing unchanged file", "error", err, "event", fileInfoEvent)
+			c.retry(ctx, msg, metadata, err)
 			return
 		}
 	}
@@ -251,110 +469,56 @@ func (c *consumer) handleMessage(msg jetstream.Msg) {
 	c.log.Debug("successfully processed file event", "event", fileInfoEvent)
 	msg.Ack()
 
-	c.updateLastSeq(ctx, metadata.Sequence.Stream)
 	c.progressThrottle.Trigger()
 }
 
-func (c *consumer) updateLastSeq(ctx context.Context, newValue uint64) {
-	ctx, span := c.tracer.Start(ctx, "updateLastSeq")
-	defer span.End()
-
-	for {
-		current, err := c.kv.Get(ctx, "lastSeq")
-		if err != nil {
-			c.log.Error("error while updating progress", "error", err)
-			return
-		}
-		currentValue := binary.LittleEndian.Uint64(current.Value())
-		if newValue <= currentValue {
-			return
-		}
-		newVal := make([]byte, 8)
-		binary.LittleEndian.PutUint64(newVal, newValue)
-		_, err = c.kv.Update(ctx, "lastSeq", newVal, current.Revision())
-		if err == nil {
-			return
-		}
+// newFilePrototype returns the file that is stored in the index for a FileInfoEvent
+func newFilePrototype(fileInfoEvent *events.FileInfoEvent) FilePrototype {
+	cleanPath := path.Clean(fileInfoEvent.Path)
+	file := FilePrototype{}
+	file.ProviderId.Set(fileInfoEvent.ProviderID)
+	file.Path.Set(cleanPath)
+	file.SearchWords.Set(strings.TrimSpace(searchWordsRegex.ReplaceAllString(cleanPath, " ")))
+	file.NameGrams.Set(nameGrams(cleanPath))
+	file.IsDir.Set(fileInfoEvent.IsDir)
+	if fileInfoEvent.ModTime != 0 || fileInfoEvent.Mode != 0 || fileInfoEvent.Size != 0 {
+		file.ModTime.Set(fileInfoEvent.ModTime)
+		file.Mode.Set(fileInfoEvent.Mode)
+		file.Size.Set(fileInfoEvent.Size)
 	}
+	if !fileInfoEvent.IsDir {
+		file.Pending.Set(true)
+	}
+	return file
 }
 
-func (c *consumer) upsertFile(ctx context.Context, file *FilePrototype) (newFile *File, change string, err error) {
-	ctx, span := c.tracer.Start(ctx, "upsertFile")
+func (c *consumer) handleChangedFile(ctx context.Context, file *File, change string) error {
+	ctx, span := c.tracer.Start(ctx, "handleChangedFile")
 	defer span.End()
 
-	if file.Path.Get() != "/" { // if it's not the root dir
-		dirName := path.Dir(file.Path.Get())
-		parentDir := FilePrototype{}
-		parentDir.ProviderId.Set(file.ProviderId.Get())
-		parentDir.Path.Set(dirName)
-		parentDir.IsDir.Set(true)
-		var parent *File
-		parent, _, err = c.upsertFile(ctx, &parentDir)
-		if err != nil {
-			return
-		}
-		file.ParentDir.Set(parent.Id)
-	}
-
-	filter := FilePrototype{}
-	filter.ProviderId.Set(file.ProviderId.Get())
-	filter.Path.Set(file.Path.Get())
-
-	update := bson.M{
-		"$set": file,
-	}
-	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
-	result := c.files.FindOneAndUpdate(ctx, filter, update, opts)
-	resultNew := c.files.FindOne(ctx, filter)
-
-	newFile = &File{}
-	err = resultNew.Decode(newFile)
-
-	if err != nil {
-		return
-	}
+	analysis := c.newAnalysis(file)
+	defer analysis.close()
 
-	if result.Err() == mongo.ErrNoDocuments {
-		// file was newly created
-		change = events.FileChangedEventCreated
-	} else {
-		var oldFile File
-		err = result.Decode(&oldFile)
+	analysis.run(ctx, c.identifiers)
+	if change == events.FileChangedEventCreated && file.ImoHash != "" {
+		moved, err := c.detectMove(ctx, file, file.ImoHash)
 		if err != nil {
-			return
-		}
-
-		if oldFile.Pending {
-			// consumer was previously interrupted while processing changes for the file
-			change = events.FileChangedEventChanged
-		} else if oldFile.ModTime != newFile.ModTime ||
-			oldFile.Size != newFile.Size ||
-			oldFile.Mode != newFile.Mode {
-			// file was modified
-			change = events.FileChangedEventChanged
+			c.log.Error("error while detecting moved file", "error", err, "file", file.Path)
+		} else if moved {
+			return nil
 		}
 	}
-	return
-}
-
-func (c *consumer) handleChangedFile(ctx context.Context, file *File, change string) error {
-	ctx, span := c.tracer.Start(ctx, "handleChangedFile")
-	defer span.End()
-
-	mimeTyp := c.detectAndUpdateMime(ctx, file)
-	imoHash := c.calculateAndUpdateImoHash(ctx, file)
+	analysis.run(ctx, c.analyzers)
 
 	filter := FilePrototype{}
 	filter.Id.Set(file.Id)
 
-	proto := FilePrototype{}
-	proto.Mime.Set(mimeTyp)
-	proto.ImoHash.Set(imoHash)
+	proto := analysis.proto
 	proto.Pending.Set(false)
 
 	_, err := c.files.UpdateOne(ctx, filter, bson.M{"$set": proto})
 	if err != nil {
-		c.log.Error("error persisting mimeType and hash", "error", err, "file", file)
+		c.log.Error("error persisting analysis results", "error", err, "file", file)
 		return err
 	}
 
@@ -367,75 +531,88 @@ func (c *consumer) handleChangedFile(ctx context.Context, file *File, change str
 	return nil
 }
 
-func (c *consumer) detectAndUpdateMime(ctx context.Context, file *File) string {
-	ctx, span := c.tracer.Start(ctx, "detectAndUpdateMime")
-	defer span.End()
+// number of bytes that are read to detect the mime type from magic numbers, the default limit of mimetype
+const mimeHeadSize = 3072
+
+// mimeAnalyzer identifies the mime type of a file
+type mimeAnalyzer struct {
+	c *consumer
+}
+
+func (a mimeAnalyzer) Name() string {
+	return "mime"
+}
 
-	if file.IsDir {
-		return ""
+func (a mimeAnalyzer) Handles(mimeType string, name string) bool {
+	return true
+}
+
+func (a mimeAnalyzer) Ranges(file *File) []ByteRange {
+	if mime.TypeByExtension(path.Ext(file.Path)) != "" {
+		return nil
 	}
+	return []ByteRange{Head(mimeHeadSize)}
+}
 
-	ext := path.Ext(file.Path)
-	typ := mime.TypeByExtension(ext)
+func (a mimeAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	typ := mime.TypeByExtension(path.Ext(file.Path))
 
 	if typ == "" {
 		// use magic numbers for mimetype detection
 		// slow, so we do it only when it can't be done from the file extension
-		client := fileprovider.NewFileProviderClient(file.ProviderId, c.nc, c.logger)
-		defer client.Close()
-		inFile, err := client.OpenFile(ctx, file.Path, os.O_RDONLY, 0)
+		mimeType, err := mimetype.DetectReader(io.NewSectionReader(r, 0, file.Size))
 		if err != nil {
-			c.log.Error("Error while opening file for mime type detection", "path", file.Path, "error", err)
-			return ""
-		}
-		defer inFile.Close()
-		mimeType, err := mimetype.DetectReader(inFile)
-		if err != nil {
-			c.log.Error("Error while detecting mime type", "path", file.Path, "error", err)
-			return ""
+			return err
 		}
 		typ = mimeType.String()
 	}
 
-	c.log.Debug("Identified mime type", "path", file.Path, "mime", typ)
+	a.c.log.Debug("Identified mime type", "path", file.Path, "mime", typ)
 
-	file.Mime = typ
+	proto.Mime.Set(typ)
+	return nil
+}
 
-	return typ
+func (a mimeAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	proto.Mime.Set("")
 }
 
-func (c *consumer) calculateAndUpdateImoHash(ctx context.Context, file *File) string {
-	ctx, span := c.tracer.Start(ctx, "calculateAndUpdateImoHash")
-	defer span.End()
+// hashAnalyzer calculates the imo hash of a file, which samples the start, middle and end of large files
+type hashAnalyzer struct {
+	c *consumer
+}
 
-	if file.IsDir {
-		return ""
-	}
+func (a hashAnalyzer) Name() string {
+	return "imohash"
+}
 
-	client := fileprovider.NewFileProviderClient(file.ProviderId, c.nc, c.logger)
-	defer client.Close()
-	inFile, err := client.OpenFile(ctx, file.Path, os.O_RDONLY, 0)
-	if err != nil {
-		c.log.Error("Error while opening file for imo hash calculation", "path", file.Path, "error", err)
-		return ""
-	}
-	defer inFile.Close()
+func (a hashAnalyzer) Handles(mimeType string, name string) bool {
+	return true
+}
 
-	readerAt := &util.ReaderAt{ReadSeeker: inFile}
+func (a hashAnalyzer) Ranges(file *File) []ByteRange {
+	if file.Size < imohash.SampleThreshold || file.Size < 4*imohash.SampleSize {
+		return []ByteRange{Head(file.Size)}
+	}
+	return []ByteRange{Head(imohash.SampleSize), Section(file.Size/2, imohash.SampleSize), Tail(imohash.SampleSize)}
+}
 
-	hash, err := imohash.SumSectionReader(io.NewSectionReader(readerAt, 0, file.Size))
+func (a hashAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	hash, err := imohash.SumSectionReader(io.NewSectionReader(r, 0, file.Size))
 	if err != nil {
-		c.log.Error("Error while calculating imo hash", "path", file.Path, "error", err)
-		return ""
+		return err
 	}
 
 	hashStr := hex.EncodeToString(hash[:])
 
-	c.log.Debug("Calculated imo hash", "path", file.Path, "hash", hashStr)
+	a.c.log.Debug("Calculated imo hash", "path", file.Path, "hash", hashStr)
 
-	file.ImoHash = hashStr
+	proto.ImoHash.Set(hashStr)
+	return nil
+}
 
-	return hashStr
+func (a hashAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	proto.ImoHash.Set("")
 }
 
 func (c *consumer) handleUnchangedFile(ctx context.Context, file *File) error {
@@ -453,6 +630,11 @@ func (c *consumer) handleUnchangedFile(ctx context.Context, file *File) error {
 }
 
 func (c *consumer) publishChange(ctx context.Context, file *File, change string) error {
+	return c.publishChangeFrom(ctx, file, change, "")
+}
+
+// publishChangeFrom publishes a change of a file that was previously located at oldPath
+func (c *consumer) publishChangeFrom(ctx context.Context, file *File, change string, oldPath string) error {
 	ctx, span := c.tracer.Start(ctx, "publishChange")
 	defer span.End()
 
@@ -470,6 +652,7 @@ func (c *consumer) publishChange(ctx context.Context, file *File, change string)
 		ModTime:    file.ModTime,
 		IsDir:      file.IsDir,
 		Mime:       file.Mime,
+		OldPath:    oldPath,
 	}
 
 	data, err := ev.Marshal()
@@ -480,9 +663,8 @@ func (c *consumer) publishChange(ctx context.Context, file *File, change string)
 
 	topic := fmt.Sprintf(events.FileChangedTopicPattern, file.Id.Hex())
 
-	c.nc.Publish(topic, data)
-
-	return nil
+	// consumers receive each event once, even if it has to be published again
+	return c.outbox.Publish(ctx, topic, ev.Event.ID, data)
 }
 
 func (c *consumer) handleReaddir(ctx context.Context, file *File, readDir *events.ReadDir) error {
@@ -499,6 +681,7 @@ func (c *consumer) handleReaddir(ctx context.Context, file *File, readDir *event
 	proto.Total.Set(readDir.Total)
 	proto.File.Set(file.Id)
 	proto.ParentDir.Set(file.ParentDir)
+	proto.Time.Set(time.Now().Unix())
 
 	_, err := c.readdir.UpdateOne(ctx, filter, bson.M{"$set": proto}, opts)
 	if err != nil {
@@ -553,16 +736,26 @@ func (c *consumer) handleReaddirComplete(ctx context.Context, file *File, readDi
 		return err
 	}
 
+	deletedIds := make([]primitive.ObjectID, 0)
 	for cur.Next(ctx) {
 		var f File
 		cur.Decode(&f)
 		c.publishChange(ctx, &f, events.FileChangedEventDeleted)
+		if f.IsDir {
+			c.dirs.invalidate(f.ProviderId, f.Path)
+		}
+		deletedIds = append(deletedIds, f.Id)
+		size, count := f.sizeAndCount()
+		if err := c.updateDirSizes(ctx, f.ParentDir, -size, -count); err != nil {
+			c.log.Error("error while updating directory sizes", "error", err, "file", f.Path)
+		}
 	}
 
 	res, err := c.files.DeleteMany(ctx, deleteFileFilter)
 	if err != nil {
 		return err
 	}
+	c.removeContents(ctx, deletedIds)
 
 	c.log.Debug("readdir "+readDir.Readdir+" complete", "total", readDir.Total, "deleted", res.DeletedCount)
 
@@ -574,24 +767,29 @@ func (c *consumer) handleReaddirComplete(ctx context.Context, file *File, readDi
 	return nil
 }
 
+// updateProgress publishes the number of file events that are not processed yet,
+// the properties of the job contain the number for each file provider
 func (c *consumer) updateProgress() {
-	info, err := c.fileInfoStream.Info(c.ctx)
-	if err != nil {
-		c.log.Error("error updating index progress", "error", err)
-		return
-	}
-
-	lastSeq, err := c.kv.Get(c.ctx, "lastSeq")
-	if err != nil {
-		c.log.Error("error updating index progress", "error", err)
-		return
+	remaining := uint64(0)
+	properties := make(map[string]string)
+	busy := make([]string, 0)
+	for _, l := range c.sortedLanes() {
+		info, err := l.consumer.Info(c.ctx)
+		if err != nil {
+			c.log.Error("error updating index progress", "error", err, "providerId", l.providerId)
+			return
+		}
+		pending := info.NumPending + uint64(info.NumAckPending)
+		remaining += pending
+		properties[l.providerId] = strconv.FormatUint(pending, 10)
+		if pending > 0 {
+			busy = append(busy, fmt.Sprintf("%s: %d", l.providerId, pending))
+		}
 	}
 
-	remaining := info.State.LastSeq - binary.LittleEndian.Uint64(lastSeq.Value())
-
 	var statusMessage string
 	if remaining > 0 {
-		statusMessage = fmt.Sprintf("Index progress: %d remaining", remaining)
+		statusMessage = fmt.Sprintf("Index progress: %d remaining (%s)", remaining, strings.Join(busy, ", "))
 	} else {
 		statusMessage = "Indexing complete."
 	}
@@ -605,6 +803,7 @@ func (c *consumer) updateProgress() {
 		Key:           "SERAPH_FILE_INDEXER",
 		Description:   "Indexing files",
 		StatusMessage: statusMessage,
+		Properties:    properties,
 	}
 	data, _ := ev.Marshal()
 	topic := fmt.Sprintf(events.JobsTopicPattern, "SERAPH_FILE_INDEXER")
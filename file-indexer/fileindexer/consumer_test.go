@@ -0,0 +1,86 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStream(t *testing.T) (jetstream.JetStream, jetstream.Stream) {
+	natsServer, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:      "TEST",
+		Subjects:  []string{"test.>"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js, stream
+}
+
+func TestConsumeEvents(t *testing.T) {
+	ctx := context.Background()
+	js, stream := newTestStream(t)
+	c := testConsumer()
+	c.ctx = ctx
+	c.maxDeliver = 3
+	c.retryDelay = 10 * time.Millisecond
+
+	var calls atomic.Int32
+	consumeCtx, err := c.consumeEvents(stream, "test event", func(ctx context.Context, data []byte) error {
+		calls.Add(1)
+		if string(data) == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumeCtx.Stop()
+
+	// events are processed once
+	_, err = js.Publish(ctx, "test.a", []byte("ok"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// failed events are delivered again up to the maximum number of attempts
+	_, err = js.Publish(ctx, "test.a", []byte("fail"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return calls.Load() == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	// and removed from the stream afterwards
+	assert.Eventually(t, func() bool {
+		info, err := stream.Info(ctx)
+		return err == nil && info.State.Msgs == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(4), calls.Load())
+}
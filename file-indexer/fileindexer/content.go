@@ -0,0 +1,113 @@
+package fileindexer
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const contentsCollection = "contents"
+
+type contentConfig struct {
+	enabled bool
+	// files larger than this are not read
+	maxSize int64
+	// maximum length of the extracted text in bytes
+	maxTextLength int
+	// maximum time for reading and extracting a file
+	timeout time.Duration
+}
+
+func newContentConfig(v *viper.Viper) contentConfig {
+	return contentConfig{
+		enabled:       v.GetBool("fileindexer.content.enabled"),
+		maxSize:       v.GetInt64("fileindexer.content.maxSize"),
+		maxTextLength: v.GetInt("fileindexer.content.maxTextLength"),
+		timeout:       v.GetDuration("fileindexer.content.timeout"),
+	}
+}
+
+// contentAnalyzer extracts the text of a file and stores it in the contents collection.
+// Content that can no longer be extracted from the file is removed.
+type contentAnalyzer struct {
+	c *consumer
+}
+
+func (a contentAnalyzer) Name() string {
+	return "content"
+}
+
+func (a contentAnalyzer) Handles(mimeType string, name string) bool {
+	return a.c.contentConfig.enabled && contentExtractor(mimeType, name) != nil
+}
+
+func (a contentAnalyzer) Ranges(file *File) []ByteRange {
+	if file.Size > a.c.contentConfig.maxSize {
+		return nil
+	}
+	return []ByteRange{Head(file.Size)}
+}
+
+func (a contentAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	if file.Size > a.c.contentConfig.maxSize {
+		a.c.removeContents(ctx, []primitive.ObjectID{file.Id})
+		return nil
+	}
+
+	// the timeout only applies to the extraction, not to storing its result
+	extractCtx := ctx
+	if a.c.contentConfig.timeout > 0 {
+		var cancel context.CancelFunc
+		extractCtx, cancel = context.WithTimeout(ctx, a.c.contentConfig.timeout)
+		defer cancel()
+	}
+
+	data := readAt(r, 0, file.Size)
+	text, truncated, err := extractContent(extractCtx, contentExtractor(file.Mime, file.Path), data, a.c.contentConfig.maxTextLength)
+	if err != nil {
+		return err
+	}
+
+	filter := ContentPrototype{}
+	filter.Id.Set(file.Id)
+
+	content := ContentPrototype{}
+	content.Text.Set(text)
+	content.Truncated.Set(truncated)
+
+	_, err = a.c.contents.UpdateOne(ctx, filter, bson.M{"$set": content}, options.Update().SetUpsert(true))
+	if err != nil {
+		a.c.log.Error("error persisting content", "error", err, "path", file.Path)
+		return nil
+	}
+
+	a.c.log.Debug("Extracted content", "path", file.Path, "length", len(text), "truncated", truncated)
+	return nil
+}
+
+func (a contentAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	if file.IsDir || !a.c.contentConfig.enabled {
+		return
+	}
+	// there is only content to remove if it was extracted for the previous mime type
+	if contentExtractor(file.indexedMime, file.Path) == nil {
+		return
+	}
+	a.c.removeContents(ctx, []primitive.ObjectID{file.Id})
+}
+
+// removeContents deletes the extracted content of the given files
+func (c *consumer) removeContents(ctx context.Context, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	_, err := c.contents.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		c.log.Error("error removing content", "error", err)
+	}
+}
@@ -0,0 +1,157 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/trace"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+// the delay between attempts to process a file event is doubled up to this maximum
+const maxRetryDelay = 10 * time.Minute
+
+// number of dead letters that are listed if the request has no limit
+const defaultDeadLetterLimit = 100
+
+// retry schedules another attempt to process a message that failed,
+// the message is moved to the dead letter queue after the last attempt
+func (c *consumer) retry(ctx context.Context, msg jetstream.Msg, metadata *jetstream.MsgMetadata, err error) {
+	if metadata.NumDelivered >= uint64(c.maxDeliver) {
+		c.deadLetter(ctx, msg, metadata, err.Error())
+		return
+	}
+	msg.NakWithDelay(retryDelay(c.retryDelay, metadata.NumDelivered))
+}
+
+// retryDelay returns the delay before the next attempt after the given number of deliveries
+func retryDelay(delay time.Duration, delivered uint64) time.Duration {
+	for i := uint64(1); i < delivered && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// deadLetter moves a message that can't be processed to the dead letter queue
+func (c *consumer) deadLetter(ctx context.Context, msg jetstream.Msg, metadata *jetstream.MsgMetadata, reason string) {
+	ctx, span := c.tracer.Start(ctx, "deadLetter", trace.WithLinks(messaging.EventLink(msg.Headers())))
+	defer span.End()
+
+	dlqMsg := nats.NewMsg(events.FileIndexerDlqTopic)
+	dlqMsg.Data = msg.Data()
+	// the trace context of the event is kept, so that the event continues its trace when it is retried
+	messaging.InjectTraceContext(messaging.ExtractTraceContextHeader(ctx, msg.Headers()), dlqMsg.Header)
+	dlqMsg.Header.Set(events.DeadLetterReasonHeader, reason)
+	dlqMsg.Header.Set(events.DeadLetterSubjectHeader, msg.Subject())
+	dlqMsg.Header.Set(events.DeadLetterSequenceHeader, strconv.FormatUint(metadata.Sequence.Stream, 10))
+	dlqMsg.Header.Set(events.DeadLetterDeliveriesHeader, strconv.FormatUint(metadata.NumDelivered, 10))
+
+	_, err := c.js.PublishMsg(ctx, dlqMsg)
+	if err != nil {
+		c.log.Error("unable to move message to dead letter queue", "error", err, "sequence", metadata.Sequence.Stream)
+		msg.NakWithDelay(maxRetryDelay)
+		return
+	}
+	c.log.Warn("moved message to dead letter queue", "reason", reason, "sequence", metadata.Sequence.Stream)
+	msg.TermWithReason(reason)
+
+	c.progressThrottle.Trigger()
+}
+
+func (c *consumer) handleDeadLetterRequest(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(c.ctx, msg)
+	ctx, span := c.tracer.Start(ctx, "handleDeadLetterRequest")
+	defer span.End()
+
+	req := events.DeadLetterRequest{}
+	res := events.DeadLetterResponse{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil {
+		switch req.Action {
+		case events.DeadLetterActionList:
+			res.DeadLetters, err = c.listDeadLetters(ctx, req.Id, req.Limit)
+		case events.DeadLetterActionRetry:
+			err = c.retryDeadLetter(ctx, req.Id)
+		case events.DeadLetterActionDiscard:
+			err = c.dlqStream.DeleteMsg(ctx, req.Id)
+		default:
+			err = fmt.Errorf("unknown action: %s", req.Action)
+		}
+	}
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		res.NotFound = true
+	} else if err != nil {
+		c.log.Error("error processing dead letter request", "error", err, "request", req)
+		res.Error = err.Error()
+	}
+
+	data, _ := json.Marshal(&res)
+	msg.Respond(data)
+}
+
+// listDeadLetters returns the dead letters after the given id
+func (c *consumer) listDeadLetters(ctx context.Context, after uint64, limit int) ([]events.DeadLetter, error) {
+	if limit <= 0 {
+		limit = defaultDeadLetterLimit
+	}
+
+	deadLetters := make([]events.DeadLetter, 0)
+	for seq := after + 1; len(deadLetters) < limit; {
+		// the next message at or after seq, skipping messages that were retried or discarded
+		raw, err := c.dlqStream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(events.FileIndexerDlqTopic))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, newDeadLetter(raw))
+		seq = raw.Sequence + 1
+	}
+	return deadLetters, nil
+}
+
+// retryDeadLetter publishes a dead-lettered event again to be processed by the file indexer
+func (c *consumer) retryDeadLetter(ctx context.Context, id uint64) error {
+	raw, err := c.dlqStream.GetMsg(ctx, id)
+	if err != nil {
+		return err
+	}
+	subject := raw.Header.Get(events.DeadLetterSubjectHeader)
+	if subject == "" {
+		return errors.New("dead letter has no subject")
+	}
+	retryMsg := nats.NewMsg(subject)
+	retryMsg.Data = raw.Data
+	messaging.InjectTraceContext(messaging.ExtractTraceContextHeader(ctx, raw.Header), retryMsg.Header)
+	_, err = c.js.PublishMsg(ctx, retryMsg)
+	if err != nil {
+		return err
+	}
+	return c.dlqStream.DeleteMsg(ctx, id)
+}
+
+func newDeadLetter(raw *jetstream.RawStreamMsg) events.DeadLetter {
+	deliveries, _ := strconv.ParseUint(raw.Header.Get(events.DeadLetterDeliveriesHeader), 10, 64)
+	deadLetter := events.DeadLetter{
+		Id:         raw.Sequence,
+		Reason:     raw.Header.Get(events.DeadLetterReasonHeader),
+		Subject:    raw.Header.Get(events.DeadLetterSubjectHeader),
+		Deliveries: deliveries,
+		Time:       raw.Time.Unix(),
+	}
+	// the event may not be readable, which is why it was dead-lettered
+	fileInfoEvent := events.FileInfoEvent{}
+	if err := fileInfoEvent.Unmarshal(raw.Data); err == nil {
+		deadLetter.ProviderId = fileInfoEvent.ProviderID
+		deadLetter.Path = fileInfoEvent.Path
+	}
+	return deadLetter
+}
@@ -0,0 +1,126 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boz/go-throttle"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/events"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 10*time.Second, retryDelay(10*time.Second, 1))
+	assert.Equal(t, 20*time.Second, retryDelay(10*time.Second, 2))
+	assert.Equal(t, 80*time.Second, retryDelay(10*time.Second, 4))
+	assert.Equal(t, maxRetryDelay, retryDelay(10*time.Second, 20))
+	assert.Equal(t, maxRetryDelay, retryDelay(10*time.Second, 1000))
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	ev := events.FileInfoEvent{
+		ProviderID: "provider",
+		Path:       "/photos/broken.jpg",
+	}
+	data, err := ev.Marshal()
+	assert.NoError(t, err)
+
+	header := nats.Header{}
+	header.Set(events.DeadLetterReasonHeader, "failed")
+	header.Set(events.DeadLetterSubjectHeader, "seraph.fileprovider.provider.fileinfo")
+	header.Set(events.DeadLetterDeliveriesHeader, "5")
+	now := time.Now()
+
+	deadLetter := newDeadLetter(&jetstream.RawStreamMsg{
+		Subject:  events.FileIndexerDlqTopic,
+		Sequence: 42,
+		Header:   header,
+		Data:     data,
+		Time:     now,
+	})
+	assert.Equal(t, events.DeadLetter{
+		Id:         42,
+		ProviderId: "provider",
+		Path:       "/photos/broken.jpg",
+		Reason:     "failed",
+		Subject:    "seraph.fileprovider.provider.fileinfo",
+		Deliveries: 5,
+		Time:       now.Unix(),
+	}, deadLetter)
+
+	// events that can't be deserialized are listed without provider and path
+	deadLetter = newDeadLetter(&jetstream.RawStreamMsg{Sequence: 43, Header: header, Data: []byte("garbage"), Time: now})
+	assert.Equal(t, uint64(43), deadLetter.Id)
+	assert.Empty(t, deadLetter.Path)
+}
+
+// testMsg is a file event that records how it was acknowledged
+type testMsg struct {
+	jetstream.Msg
+
+	metadata jetstream.MsgMetadata
+	nakDelay time.Duration
+	termed   bool
+}
+
+func (m *testMsg) Data() []byte                              { return []byte("{}") }
+func (m *testMsg) Headers() nats.Header                      { return nats.Header{} }
+func (m *testMsg) Subject() string                           { return "seraph.fileprovider.provider.fileinfo" }
+func (m *testMsg) Metadata() (*jetstream.MsgMetadata, error) { return &m.metadata, nil }
+
+func (m *testMsg) NakWithDelay(delay time.Duration) error {
+	m.nakDelay = delay
+	return nil
+}
+
+func (m *testMsg) TermWithReason(reason string) error {
+	m.termed = true
+	return nil
+}
+
+// testJetStream records published messages, or fails to publish them
+type testJetStream struct {
+	jetstream.JetStream
+
+	err       error
+	published []*nats.Msg
+}
+
+func (js *testJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if js.err != nil {
+		return nil, js.err
+	}
+	js.published = append(js.published, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+func TestDeadLetterPublishFailureOnLastAttempt(t *testing.T) {
+	ctx := context.Background()
+	js := &testJetStream{err: errors.New("no responders")}
+	c := testConsumer()
+	c.js = js
+	c.maxDeliver = 3
+	c.progressThrottle = throttle.NewThrottle(time.Hour, false)
+	defer c.progressThrottle.Stop()
+
+	msg := &testMsg{metadata: jetstream.MsgMetadata{NumDelivered: 3}}
+	c.retry(ctx, msg, &msg.metadata, errors.New("failed"))
+
+	// the message is delivered again, because it couldn't be moved to the dead letter queue
+	assert.False(t, msg.termed)
+	assert.Equal(t, maxRetryDelay, msg.nakDelay)
+
+	// the redelivered message is moved to the dead letter queue without processing it again
+	js.err = nil
+	msg.metadata.NumDelivered++
+	assert.Nil(t, c.readMessage(ctx, msg))
+	assert.True(t, msg.termed)
+	if assert.Len(t, js.published, 1) {
+		assert.Equal(t, events.FileIndexerDlqTopic, js.published[0].Subject)
+		assert.Equal(t, "4", js.published[0].Header.Get(events.DeadLetterDeliveriesHeader))
+	}
+}
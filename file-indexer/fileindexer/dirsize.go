@@ -0,0 +1,169 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maximum number of directories that are updated with one bulk write
+const dirSizeBatchSize = 1000
+
+type dirSize struct {
+	size  int64
+	count int64
+}
+
+// dirSizeEntry is the part of a file that is needed to calculate directory sizes
+type dirSizeEntry struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	ParentDir primitive.ObjectID `bson:"parentDir"`
+	IsDir     bool               `bson:"isDir"`
+	Size      int64              `bson:"size"`
+}
+
+// updateDirSizes adds size and count to the directory and all of its parent directories
+func (c *consumer) updateDirSizes(ctx context.Context, dirId primitive.ObjectID, size int64, count int64) error {
+	if size == 0 && count == 0 {
+		return nil
+	}
+
+	ctx, span := c.tracer.Start(ctx, "updateDirSizes")
+	defer span.End()
+
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"parentDir": 1})
+	update := bson.M{"$inc": bson.M{"totalSize": size, "fileCount": count}}
+	for !dirId.IsZero() {
+		dir := File{}
+		err := c.files.FindOneAndUpdate(ctx, bson.M{"_id": dirId}, update, opts).Decode(&dir)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// the directory was removed from the index
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dirId = dir.ParentDir
+	}
+	return nil
+}
+
+// removeDirSize subtracts a file or directory from its parent directories before it is removed from the index
+func (c *consumer) removeDirSize(ctx context.Context, providerId string, filePath string) error {
+	filter := FilePrototype{}
+	filter.ProviderId.Set(providerId)
+	filter.Path.Set(filePath)
+
+	file := File{}
+	err := c.files.FindOne(ctx, filter).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	size, count := file.sizeAndCount()
+	return c.updateDirSizes(ctx, file.ParentDir, -size, -count)
+}
+
+// recomputeDirSizes calculates the sizes of all directories of a provider from scratch
+func (c *consumer) recomputeDirSizes(ctx context.Context, providerId string) error {
+	ctx, span := c.tracer.Start(ctx, "recomputeDirSizes")
+	defer span.End()
+
+	filter := FilePrototype{}
+	filter.ProviderId.Set(providerId)
+	opts := options.Find().SetProjection(bson.M{"parentDir": 1, "isDir": 1, "size": 1})
+
+	cur, err := c.files.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	entries := make([]dirSizeEntry, 0)
+	if err := cur.All(ctx, &entries); err != nil {
+		return err
+	}
+
+	sizes := aggregateDirSizes(entries)
+	models := make([]mongo.WriteModel, 0, dirSizeBatchSize)
+	for id, sum := range sizes {
+		proto := FilePrototype{}
+		proto.TotalSize.Set(sum.size)
+		proto.FileCount.Set(sum.count)
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id}).SetUpdate(bson.M{"$set": proto}))
+		if len(models) == dirSizeBatchSize {
+			if _, err := c.files.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+				return err
+			}
+			models = models[:0]
+		}
+	}
+	if len(models) > 0 {
+		if _, err := c.files.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	c.log.Debug("recomputed directory sizes", "providerId", providerId, "directories", len(sizes))
+	return nil
+}
+
+// initDirSizes calculates the directory sizes for files that were indexed
+// before directory sizes were introduced
+func (c *consumer) initDirSizes() {
+	missing, err := c.files.CountDocuments(c.ctx, bson.M{"isDir": true, "fileCount": bson.M{"$exists": false}}, options.Count().SetLimit(1))
+	if err != nil {
+		c.log.Error("error while checking directory sizes", "error", err)
+		return
+	}
+	if missing == 0 {
+		return
+	}
+
+	providers, err := c.indexedProviders(c.ctx)
+	if err != nil {
+		c.log.Error("error while calculating directory sizes", "error", err)
+		return
+	}
+	c.log.Info("calculating directory sizes", "providers", len(providers))
+	for _, providerId := range providers {
+		if err := c.recomputeDirSizes(c.ctx, providerId); err != nil {
+			c.log.Error("error while calculating directory sizes", "providerId", providerId, "error", err)
+		}
+	}
+}
+
+// aggregateDirSizes returns the size and number of all files below each directory
+func aggregateDirSizes(entries []dirSizeEntry) map[primitive.ObjectID]dirSize {
+	parents := make(map[primitive.ObjectID]primitive.ObjectID, len(entries))
+	sizes := make(map[primitive.ObjectID]dirSize)
+	for _, entry := range entries {
+		parents[entry.Id] = entry.ParentDir
+		if entry.IsDir {
+			sizes[entry.Id] = dirSize{}
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		for dirId := entry.ParentDir; !dirId.IsZero(); dirId = parents[dirId] {
+			sum, ok := sizes[dirId]
+			if !ok {
+				// parent is missing from the index
+				break
+			}
+			sum.size += entry.Size
+			sum.count++
+			sizes[dirId] = sum
+		}
+	}
+	return sizes
+}
@@ -0,0 +1,48 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAggregateDirSizes(t *testing.T) {
+	root := primitive.NewObjectID()
+	photos := primitive.NewObjectID()
+	trip := primitive.NewObjectID()
+	empty := primitive.NewObjectID()
+	orphanParent := primitive.NewObjectID()
+
+	sizes := aggregateDirSizes([]dirSizeEntry{
+		{Id: root, IsDir: true},
+		{Id: photos, ParentDir: root, IsDir: true},
+		{Id: trip, ParentDir: photos, IsDir: true},
+		{Id: empty, ParentDir: root, IsDir: true},
+		{Id: primitive.NewObjectID(), ParentDir: root, Size: 10},
+		{Id: primitive.NewObjectID(), ParentDir: photos, Size: 100},
+		{Id: primitive.NewObjectID(), ParentDir: trip, Size: 1000},
+		{Id: primitive.NewObjectID(), ParentDir: trip, Size: 2000},
+		// parent directory is not in the index
+		{Id: primitive.NewObjectID(), ParentDir: orphanParent, Size: 5},
+	})
+
+	assert.Equal(t, map[primitive.ObjectID]dirSize{
+		root:   {size: 3110, count: 4},
+		photos: {size: 3100, count: 3},
+		trip:   {size: 3000, count: 2},
+		empty:  {},
+	}, sizes)
+}
+
+func TestSizeAndCount(t *testing.T) {
+	file := File{Size: 100}
+	size, count := file.sizeAndCount()
+	assert.Equal(t, int64(100), size)
+	assert.Equal(t, int64(1), count)
+
+	dir := File{IsDir: true, Size: 4096, TotalSize: 3000, FileCount: 2}
+	size, count = dir.sizeAndCount()
+	assert.Equal(t, int64(3000), size)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,379 @@
+package fileindexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/file-provider/fileprovider"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+	"umbasa.net/seraph/tracing"
+)
+
+type DuplicatesParams struct {
+	fx.In
+
+	Nc      *nats.Conn
+	Db      *mongo.Database
+	Logger  *logging.Logger
+	Tracing *tracing.Tracing
+	Mig     Migrations
+	Lc      fx.Lifecycle
+}
+
+// number of groups that are returned if the request does not set a limit
+const defaultDuplicatesLimit = 100
+
+// maximum number of groups that are returned per request
+const maxDuplicatesLimit = 1000
+
+type Duplicates interface{}
+
+type duplicates struct {
+	logger *logging.Logger
+	log    *slog.Logger
+	nc     *nats.Conn
+	files  *mongo.Collection
+	tracer trace.Tracer
+
+	sub *nats.Subscription
+}
+
+// candidateGroup is a set of files with the same ImoHash and size
+type candidateGroup struct {
+	Id struct {
+		ImoHash string `bson:"imoHash"`
+		Size    int64  `bson:"size"`
+	} `bson:"_id"`
+	Files []File `bson:"files"`
+}
+
+func NewDuplicates(p DuplicatesParams) (Duplicates, error) {
+	d := &duplicates{
+		logger: p.Logger,
+		log:    p.Logger.GetLogger("duplicates"),
+		nc:     p.Nc,
+		files:  p.Db.Collection(filesCollection),
+		tracer: p.Tracing.TracerProvider.Tracer("duplicates"),
+	}
+
+	p.Lc.Append(fx.StartHook(d.start))
+	p.Lc.Append(fx.StopHook(d.stop))
+
+	return d, nil
+}
+
+func (d *duplicates) start() error {
+	sub, err := d.nc.QueueSubscribe(events.DuplicatesTopic, events.DuplicatesTopic, func(msg *nats.Msg) {
+		go d.handleRequest(msg)
+	})
+	if err != nil {
+		return err
+	}
+	d.sub = sub
+	return nil
+}
+
+func (d *duplicates) stop() {
+	if d.sub != nil {
+		d.sub.Unsubscribe()
+		d.sub = nil
+	}
+}
+
+func (d *duplicates) handleRequest(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(context.Background(), msg)
+	ctx, span := d.tracer.Start(ctx, "findDuplicates")
+	defer span.End()
+
+	req := events.DuplicatesRequest{}
+	var candidates *duplicateCandidates
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil && req.Verify && req.Job == "" {
+		err = errors.New("verification requires a job")
+	}
+	if err == nil {
+		candidates, err = d.find(ctx, &req)
+	}
+	if err != nil {
+		d.log.Error("error while finding duplicates", "error", err)
+		data, _ := json.Marshal(events.DuplicatesResponse{Error: err.Error()})
+		msg.Respond(data)
+		return
+	}
+
+	var res *events.DuplicatesResponse
+	if req.Verify {
+		// hashing the files takes long, the result is published when the job is done
+		res = &events.DuplicatesResponse{
+			TotalGroups:      candidates.totalGroups,
+			TotalReclaimable: candidates.totalReclaimable,
+			Job:              req.Job,
+		}
+		go d.verify(trace.LinkFromContext(ctx), req.Job, candidates)
+	} else {
+		res = candidates.response(nil)
+	}
+
+	data, _ := json.Marshal(res)
+	msg.Respond(data)
+}
+
+// duplicateCandidates are the groups of files with the same ImoHash and size in the spaces of a user
+type duplicateCandidates struct {
+	userSpaces []spaces.Space
+	groups     []candidateGroup
+	// number of all candidate groups and their reclaimable bytes, including groups that were not loaded
+	totalGroups      int64
+	totalReclaimable int64
+}
+
+// find returns the candidate groups of duplicate files in the spaces of the user,
+// ordered by the space that can be reclaimed
+func (d *duplicates) find(ctx context.Context, req *events.DuplicatesRequest) (*duplicateCandidates, error) {
+	userSpaces, err := spaces.GetSpacesForUser(ctx, d.nc, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(userSpaces) == 0 {
+		return nil, errors.New("no spaces found")
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultDuplicatesLimit
+	}
+	limit = min(limit, maxDuplicatesLimit)
+
+	filter := append(spaceFilter(userSpaces, nil, nil),
+		bson.M{"isDir": false},
+		bson.M{"imoHash": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{"size": bson.M{"$gte": max(req.MinSize, 1)}},
+	)
+	if len(req.ImoHashes) > 0 {
+		filter = append(filter, bson.M{"imoHash": bson.M{"$in": req.ImoHashes}})
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"$and": filter}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"imoHash": "$imoHash", "size": "$size"},
+			"count": bson.M{"$sum": 1},
+			"files": bson.M{"$push": bson.M{"_id": "$_id", "providerId": "$providerId", "path": "$path", "modTime": "$modTime"}},
+		}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+		bson.M{"$addFields": bson.M{
+			"reclaimable": bson.M{"$multiply": bson.A{"$_id.size", bson.M{"$subtract": bson.A{"$count", 1}}}},
+		}},
+		bson.M{"$facet": bson.M{
+			"groups": bson.A{
+				bson.M{"$sort": bson.D{{Key: "reclaimable", Value: -1}, {Key: "_id.imoHash", Value: 1}}},
+				bson.M{"$limit": limit},
+			},
+			"summary": bson.A{
+				bson.M{"$group": bson.M{"_id": nil, "groups": bson.M{"$sum": 1}, "reclaimable": bson.M{"$sum": "$reclaimable"}}},
+			},
+		}},
+	}
+
+	d.log.Debug("duplicates query", "query", logging.JsonValue(pipeline))
+
+	cur, err := d.files.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := struct {
+		Groups  []candidateGroup `bson:"groups"`
+		Summary []struct {
+			Groups      int64 `bson:"groups"`
+			Reclaimable int64 `bson:"reclaimable"`
+		} `bson:"summary"`
+	}{}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	candidates := &duplicateCandidates{
+		userSpaces: userSpaces,
+		groups:     result.Groups,
+	}
+	if len(result.Summary) > 0 {
+		candidates.totalGroups = result.Summary[0].Groups
+		candidates.totalReclaimable = result.Summary[0].Reclaimable
+	}
+	return candidates, nil
+}
+
+// response returns the groups of duplicates.
+// If split is set, the files of each candidate group are split into the sets with identical content.
+func (c *duplicateCandidates) response(split func(files []File) [][]File) *events.DuplicatesResponse {
+	res := &events.DuplicatesResponse{
+		Groups:           make([]events.DuplicateGroup, 0, len(c.groups)),
+		TotalGroups:      c.totalGroups,
+		TotalReclaimable: c.totalReclaimable,
+	}
+	for _, candidate := range c.groups {
+		sets := [][]File{candidate.Files}
+		if split != nil {
+			sets = split(candidate.Files)
+		}
+		for _, files := range sets {
+			group := newDuplicateGroup(c.userSpaces, candidate.Id.ImoHash, candidate.Id.Size, files)
+			group.Verified = split != nil
+			res.Groups = append(res.Groups, group)
+			res.Reclaimable += group.Reclaimable
+		}
+	}
+	return res
+}
+
+// verify hashes the full content of the candidates as a job and publishes the verified groups when it is done
+func (d *duplicates) verify(link trace.Link, jobKey string, candidates *duplicateCandidates) {
+	ctx, span := d.tracer.Start(context.Background(), "verifyDuplicates", trace.WithLinks(link))
+	defer span.End()
+
+	total := 0
+	for _, candidate := range candidates.groups {
+		total += len(candidate.Files)
+	}
+	hashed := 0
+	d.publishJob(ctx, jobKey, fmt.Sprintf("Verified %d of %d files", hashed, total), nil)
+
+	res := candidates.response(func(files []File) [][]File {
+		return groupByContent(files, func(file *File) (string, error) {
+			hash, err := d.contentHash(ctx, file)
+			if err != nil {
+				d.log.Warn("error while hashing file", "providerId", file.ProviderId, "path", file.Path, "error", err)
+			}
+			hashed++
+			d.publishJob(ctx, jobKey, fmt.Sprintf("Verified %d of %d files", hashed, total), nil)
+			return hash, err
+		})
+	})
+	res.Job = jobKey
+
+	data, _ := json.Marshal(res)
+	err := messaging.PublishEvent(ctx, d.nc, fmt.Sprintf(events.DuplicatesResultTopicPattern, jobKey), data)
+	if err != nil {
+		d.log.Error("error while publishing duplicates", "job", jobKey, "error", err)
+	}
+
+	d.publishJob(ctx, jobKey, fmt.Sprintf("Verified %d files, found %d groups of duplicates.", total, len(res.Groups)), map[string]string{
+		"groups":      strconv.Itoa(len(res.Groups)),
+		"reclaimable": strconv.FormatInt(res.Reclaimable, 10),
+	})
+}
+
+func (d *duplicates) publishJob(ctx context.Context, jobKey string, statusMessage string, properties map[string]string) {
+	ev := events.JobEvent{
+		Event: events.Event{
+			ID:      uuid.NewString(),
+			Version: 1,
+		},
+		Key:           jobKey,
+		Description:   "Verifying duplicates",
+		StatusMessage: statusMessage,
+		Properties:    properties,
+	}
+	data, _ := ev.Marshal()
+	topic := fmt.Sprintf(events.JobsTopicPattern, jobKey)
+	messaging.PublishEvent(ctx, d.nc, topic, data)
+}
+
+// contentHash returns the sha256 hash of the full content of the file
+func (d *duplicates) contentHash(ctx context.Context, file *File) (string, error) {
+	client := fileprovider.NewFileProviderClient(file.ProviderId, d.nc, d.logger)
+	defer client.Close()
+
+	inFile, err := client.OpenFile(ctx, file.Path, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer inFile.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, inFile)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// groupByContent splits the files by their content hash.
+// Only sets of at least two files are returned, files that can not be hashed are left out.
+func groupByContent(files []File, hash func(*File) (string, error)) [][]File {
+	byHash := make(map[string][]File)
+	hashes := make([]string, 0)
+	for i := range files {
+		h, err := hash(&files[i])
+		if err != nil {
+			continue
+		}
+		if _, ok := byHash[h]; !ok {
+			hashes = append(hashes, h)
+		}
+		byHash[h] = append(byHash[h], files[i])
+	}
+
+	sets := make([][]File, 0)
+	for _, h := range hashes {
+		if len(byHash[h]) > 1 {
+			sets = append(sets, byHash[h])
+		}
+	}
+	return sets
+}
+
+// newDuplicateGroup returns the group with the files mapped to the spaces of the user, oldest file first
+func newDuplicateGroup(userSpaces []spaces.Space, imoHash string, size int64, files []File) events.DuplicateGroup {
+	group := events.DuplicateGroup{
+		ImoHash:     imoHash,
+		Size:        size,
+		Reclaimable: size * int64(len(files)-1),
+		Files:       make([]events.DuplicateFile, 0, len(files)),
+	}
+	for _, file := range files {
+		mapSpace(userSpaces, &file)
+		group.Files = append(group.Files, events.DuplicateFile{
+			ProviderId: file.ProviderId,
+			Path:       file.Path,
+			ModTime:    file.ModTime,
+		})
+	}
+	sort.SliceStable(group.Files, func(i, j int) bool {
+		a, b := group.Files[i], group.Files[j]
+		if a.ModTime != b.ModTime {
+			return a.ModTime < b.ModTime
+		}
+		if a.ProviderId != b.ProviderId {
+			return a.ProviderId < b.ProviderId
+		}
+		return a.Path < b.Path
+	})
+	return group
+}
@@ -0,0 +1,82 @@
+package fileindexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func TestGroupByContent(t *testing.T) {
+	files := []File{
+		{ProviderId: "p", Path: "/a"},
+		{ProviderId: "p", Path: "/b"},
+		{ProviderId: "p", Path: "/c"},
+		{ProviderId: "p", Path: "/d"},
+		{ProviderId: "p", Path: "/unreadable"},
+	}
+	contents := map[string]string{"/a": "x", "/b": "y", "/c": "x", "/d": "z"}
+
+	sets := groupByContent(files, func(file *File) (string, error) {
+		content, ok := contents[file.Path]
+		if !ok {
+			return "", errors.New("unreadable")
+		}
+		return content, nil
+	})
+
+	assert.Equal(t, [][]File{{files[0], files[2]}}, sets)
+}
+
+func TestNewDuplicateGroup(t *testing.T) {
+	userSpaces := []spaces.Space{{
+		FileProviders: []spaces.SpaceFileProvider{{
+			SpaceProviderId: "space",
+			ProviderId:      "provider",
+			Path:            "/home",
+		}},
+	}}
+	files := []File{
+		{ProviderId: "provider", Path: "/home/photos/copy.jpg", ModTime: 200},
+		{ProviderId: "provider", Path: "/home/photos/original.jpg", ModTime: 100},
+		{ProviderId: "provider", Path: "/home/backup/original.jpg", ModTime: 200},
+	}
+
+	group := newDuplicateGroup(userSpaces, "hash", 1000, files)
+
+	assert.Equal(t, "hash", group.ImoHash)
+	assert.Equal(t, int64(2000), group.Reclaimable)
+	assert.Equal(t, []events.DuplicateFile{
+		{ProviderId: "space", Path: "photos/original.jpg", ModTime: 100},
+		{ProviderId: "space", Path: "backup/original.jpg", ModTime: 200},
+		{ProviderId: "space", Path: "photos/copy.jpg", ModTime: 200},
+	}, group.Files)
+}
+
+func TestDuplicateCandidatesResponse(t *testing.T) {
+	candidates := &duplicateCandidates{
+		groups:           []candidateGroup{{Files: []File{{Path: "/a"}, {Path: "/b"}, {Path: "/c"}}}},
+		totalGroups:      5,
+		totalReclaimable: 500,
+	}
+	candidates.groups[0].Id.ImoHash = "hash"
+	candidates.groups[0].Id.Size = 10
+
+	res := candidates.response(nil)
+	assert.Len(t, res.Groups, 1)
+	assert.False(t, res.Groups[0].Verified)
+	assert.Equal(t, int64(20), res.Reclaimable)
+	assert.Equal(t, int64(5), res.TotalGroups)
+	assert.Equal(t, int64(500), res.TotalReclaimable)
+
+	// verification splits the groups by content
+	res = candidates.response(func(files []File) [][]File {
+		return [][]File{files[:2]}
+	})
+	assert.Len(t, res.Groups, 1)
+	assert.True(t, res.Groups[0].Verified)
+	assert.Len(t, res.Groups[0].Files, 2)
+	assert.Equal(t, int64(10), res.Reclaimable)
+}
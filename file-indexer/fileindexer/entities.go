@@ -3,23 +3,31 @@ package fileindexer
 import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"umbasa.net/seraph/entities"
+	"umbasa.net/seraph/events"
 )
 
 type FilePrototype struct {
 	entities.Prototype
 
-	Id          entities.Definable[primitive.ObjectID] `bson:"_id"`
-	ParentDir   entities.Definable[primitive.ObjectID] `bson:"parentDir"`
-	ProviderId  entities.Definable[string]             `bson:"providerId"`
-	Path        entities.Definable[string]             `bson:"path"`
-	SearchWords entities.Definable[string]             `bson:"searchWords"`
-	Size        entities.Definable[int64]              `bson:"size"`
-	Mode        entities.Definable[int64]              `bson:"mode"`
-	ModTime     entities.Definable[int64]              `bson:"modTime"`
-	IsDir       entities.Definable[bool]               `bson:"isDir"`
-	Mime        entities.Definable[string]             `bson:"mime"`
-	ImoHash     entities.Definable[string]             `bson:"imoHash"`
-	Pending     entities.Definable[bool]               `bson:"pending"`
+	Id          entities.Definable[primitive.ObjectID]    `bson:"_id"`
+	ParentDir   entities.Definable[primitive.ObjectID]    `bson:"parentDir"`
+	ProviderId  entities.Definable[string]                `bson:"providerId"`
+	Path        entities.Definable[string]                `bson:"path"`
+	SearchWords entities.Definable[string]                `bson:"searchWords"`
+	NameGrams   entities.Definable[[]string]              `bson:"nameGrams"`
+	Size        entities.Definable[int64]                 `bson:"size"`
+	Mode        entities.Definable[int64]                 `bson:"mode"`
+	ModTime     entities.Definable[int64]                 `bson:"modTime"`
+	IsDir       entities.Definable[bool]                  `bson:"isDir"`
+	Mime        entities.Definable[string]                `bson:"mime"`
+	ImoHash     entities.Definable[string]                `bson:"imoHash"`
+	Photo       entities.Definable[*PhotoMetadata]        `bson:"photo"`
+	Audio       entities.Definable[*AudioMetadata]        `bson:"audio"`
+	Video       entities.Definable[*events.VideoMetadata] `bson:"video"`
+	Pending     entities.Definable[bool]                  `bson:"pending"`
+	Trashed     entities.Definable[bool]                  `bson:"trashed"`
+	TotalSize   entities.Definable[int64]                 `bson:"totalSize"`
+	FileCount   entities.Definable[int64]                 `bson:"fileCount"`
 }
 
 type File struct {
@@ -31,6 +39,8 @@ type File struct {
 	Path string `bson:"path"`
 	// Path of the file with all non-word characters replaced by space for text indexing purposes
 	SearchWords string `bson:"searchWords"`
+	// trigrams of the words in the file name for prefix and fuzzy search
+	NameGrams []string `bson:"nameGrams"`
 	// File size in bytes
 	Size int64 `bson:"size"`
 	// File mode bits
@@ -44,9 +54,89 @@ type File struct {
 	// imoHash of the file - calculated from 16kb blocks at the beginning, middle and end of file.
 	// Can be used to find duplicates.
 	ImoHash string `bson:"imoHash"`
+	// EXIF metadata if the file is a photo
+	Photo *PhotoMetadata `bson:"photo,omitempty"`
+	// tags and audio properties if the file is an audio file
+	Audio *AudioMetadata `bson:"audio,omitempty"`
+	// duration, dimensions and codecs if the file is a video
+	Video *events.VideoMetadata `bson:"video,omitempty"`
 	// set to true while calculating and updating file metadata.
 	// Used to resume if interrupted during metadata calculation.
 	Pending bool `bson:"pending"`
+	// set to true while the file is in the trash of a space
+	Trashed bool `bson:"trashed"`
+	// for directories: size in bytes and number of all files below the directory
+	TotalSize int64 `bson:"totalSize"`
+	FileCount int64 `bson:"fileCount"`
+
+	// mime type of the file before it was analyzed again, not stored
+	indexedMime string
+}
+
+// sizeAndCount returns the size and number of files that the file adds to its parent directories
+func (f *File) sizeAndCount() (int64, int64) {
+	if f.IsDir {
+		return f.TotalSize, f.FileCount
+	}
+	return f.Size, 1
+}
+
+// takenTime returns when a photo was taken or a video was recorded, or the modification time if not known
+func (f *File) takenTime() int64 {
+	switch {
+	case f.Photo != nil && f.Photo.DateTaken != 0:
+		return f.Photo.DateTaken
+	case f.Video != nil && f.Video.CreationTime != 0:
+		return f.Video.CreationTime
+	}
+	return f.ModTime
+}
+
+type PhotoMetadata struct {
+	// Unix timestamp of when the photo was taken
+	DateTaken int64 `bson:"dateTaken,omitempty"`
+	// camera manufacturer
+	Make string `bson:"make,omitempty"`
+	// camera model
+	Model string `bson:"model,omitempty"`
+	// manufacturer and model of the camera, for searching
+	Camera string `bson:"camera,omitempty"`
+	Lens   string `bson:"lens,omitempty"`
+	// dimensions of the image in pixels, without applying the orientation
+	Width  int `bson:"width,omitempty"`
+	Height int `bson:"height,omitempty"`
+	// EXIF orientation (1-8), values from 5 to 8 mean that width and height are swapped when displayed
+	Orientation int `bson:"orientation,omitempty"`
+	// GPS position where the photo was taken
+	Location *GeoPoint `bson:"location,omitempty"`
+}
+
+// GeoPoint is a GeoJSON point, coordinates are longitude and latitude
+type GeoPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"`
+}
+
+type AudioMetadata struct {
+	Title       string `bson:"title,omitempty"`
+	Artist      string `bson:"artist,omitempty"`
+	Album       string `bson:"album,omitempty"`
+	AlbumArtist string `bson:"albumArtist,omitempty"`
+	Genre       string `bson:"genre,omitempty"`
+	Year        int    `bson:"year,omitempty"`
+	// track and disc number, with the total number of tracks and discs if known
+	Track      int `bson:"track,omitempty"`
+	TrackTotal int `bson:"trackTotal,omitempty"`
+	Disc       int `bson:"disc,omitempty"`
+	DiscTotal  int `bson:"discTotal,omitempty"`
+	// duration in milliseconds
+	Duration int64 `bson:"duration,omitempty"`
+	// bitrate in kbit/s
+	Bitrate    int `bson:"bitrate,omitempty"`
+	SampleRate int `bson:"sampleRate,omitempty"`
+	Channels   int `bson:"channels,omitempty"`
+	// whether the file contains embedded cover art
+	HasArt bool `bson:"hasArt,omitempty"`
 }
 
 type ReaddirPrototype struct {
@@ -57,6 +147,7 @@ type ReaddirPrototype struct {
 	Total     entities.Definable[int64]              `bson:"total"`
 	File      entities.Definable[primitive.ObjectID] `bson:"file"`
 	ParentDir entities.Definable[primitive.ObjectID] `bson:"parentDir"`
+	Time      entities.Definable[int64]              `bson:"time"`
 }
 
 type Readdir struct {
@@ -66,4 +157,23 @@ type Readdir struct {
 	Total     int64              `bson:"total"`
 	File      primitive.ObjectID `bson:"file"`
 	ParentDir primitive.ObjectID `bson:"parentDir"`
+	// when the entry was received, as unix timestamp
+	Time int64 `bson:"time"`
+}
+
+type ContentPrototype struct {
+	entities.Prototype
+
+	Id        entities.Definable[primitive.ObjectID] `bson:"_id"`
+	Text      entities.Definable[string]             `bson:"text"`
+	Truncated entities.Definable[bool]               `bson:"truncated"`
+}
+
+type Content struct {
+	// Id of the file that the content was extracted from
+	Id primitive.ObjectID `bson:"_id"`
+	// plain text content of the file for text indexing purposes
+	Text string `bson:"text"`
+	// set to true if the text was cut because the file contains more text than allowed
+	Truncated bool `bson:"truncated"`
 }
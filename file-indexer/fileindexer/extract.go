@@ -0,0 +1,409 @@
+package fileindexer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+// extractor extracts the plain text content of a document, it stops when ctx is done
+type extractor func(ctx context.Context, data []byte) (string, error)
+
+// maximum number of bytes that are inflated from the entries of a document archive,
+// so that a zip bomb can't exhaust the memory of the indexer
+var maxArchiveContentSize int64 = 64 * 1024 * 1024
+
+var errArchiveTooLarge = errors.New("archive content exceeds size limit")
+
+var whitespaceRegex = regexp.MustCompile(`\s+`)
+
+var markdownRegexes = []struct {
+	regex   *regexp.Regexp
+	replace string
+}{
+	// images and links - keep the text
+	{regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`), "$1"},
+	// headings, quotes and list markers
+	{regexp.MustCompile(`(?m)^\s*(#+|>+|[-*+]|\d+\.)\s+`), ""},
+	// emphasis, code and fences
+	{regexp.MustCompile("[*_~`]+"), ""},
+	// html tags
+	{regexp.MustCompile(`<[^>]+>`), " "},
+}
+
+// contentExtractor returns the extractor for a file, or nil if the content of the file can not be indexed
+func contentExtractor(mimeType string, name string) extractor {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = mimeType
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".text", ".log", ".csv":
+		return extractText
+	case ".md", ".markdown":
+		return extractMarkdown
+	case ".html", ".htm", ".xhtml":
+		return extractHtml
+	case ".pdf":
+		return extractPdf
+	case ".docx":
+		return extractDocx
+	case ".odt":
+		return extractOdt
+	case ".epub":
+		return extractEpub
+	}
+
+	switch mediaType {
+	case "text/markdown":
+		return extractMarkdown
+	case "text/html", "application/xhtml+xml":
+		return extractHtml
+	case "application/pdf":
+		return extractPdf
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return extractDocx
+	case "application/vnd.oasis.opendocument.text":
+		return extractOdt
+	case "application/epub+zip":
+		return extractEpub
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return extractText
+	}
+	return nil
+}
+
+// extractContent runs the extractor, giving up when ctx is done.
+// The extractor is stopped when ctx is done, but extractContent doesn't wait for it.
+// The text is normalized and cut to maxLength bytes, truncated reports whether it was cut.
+func extractContent(ctx context.Context, extract extractor, data []byte, maxLength int) (text string, truncated bool, err error) {
+	type result struct {
+		text string
+		err  error
+	}
+	resultChan := make(chan result, 1)
+
+	go func() {
+		defer func() {
+			// the parsers may panic on malformed documents
+			if r := recover(); r != nil {
+				resultChan <- result{err: fmt.Errorf("failed to extract content: %v", r)}
+			}
+		}()
+		text, err := extract(ctx, data)
+		resultChan <- result{text, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", false, ctx.Err()
+	case r := <-resultChan:
+		if r.err != nil {
+			return "", false, r.err
+		}
+		text, truncated = normalizeText(r.text, maxLength)
+		return text, truncated, nil
+	}
+}
+
+func normalizeText(text string, maxLength int) (string, bool) {
+	text = strings.ToValidUTF8(text, " ")
+	text = strings.TrimSpace(whitespaceRegex.ReplaceAllString(text, " "))
+	if maxLength <= 0 || len(text) <= maxLength {
+		return text, false
+	}
+	// do not cut in the middle of a character
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut], true
+}
+
+func extractText(ctx context.Context, data []byte) (string, error) {
+	return string(data), nil
+}
+
+func extractMarkdown(ctx context.Context, data []byte) (string, error) {
+	text := string(data)
+	for _, r := range markdownRegexes {
+		text = r.regex.ReplaceAllString(text, r.replace)
+	}
+	return text, nil
+}
+
+func extractHtml(ctx context.Context, data []byte) (string, error) {
+	return htmlText(ctx, bytes.NewReader(data))
+}
+
+func htmlText(ctx context.Context, r io.Reader) (string, error) {
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(r)
+	skip := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return sb.String(), nil
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if isInvisibleHtml(string(name)) {
+				skip++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if isInvisibleHtml(string(name)) && skip > 0 {
+				skip--
+			}
+			sb.WriteString(" ")
+		case html.TextToken:
+			if skip == 0 {
+				sb.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func isInvisibleHtml(tag string) bool {
+	return tag == "script" || tag == "style" || tag == "head"
+}
+
+func extractPdf(ctx context.Context, data []byte) (string, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	// like reader.GetPlainText(), but page by page so that it can be stopped
+	var sb strings.Builder
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= reader.NumPage(); i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		page := reader.Page(i)
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(text)
+	}
+	return sb.String(), nil
+}
+
+func extractDocx(ctx context.Context, data []byte) (string, error) {
+	archive, err := openArchive(data)
+	if err != nil {
+		return "", err
+	}
+	return archive.xmlText(ctx, "word/document.xml", "p", "br", "tab")
+}
+
+func extractOdt(ctx context.Context, data []byte) (string, error) {
+	archive, err := openArchive(data)
+	if err != nil {
+		return "", err
+	}
+	return archive.xmlText(ctx, "content.xml", "p", "h", "s", "tab", "line-break")
+}
+
+func extractEpub(ctx context.Context, data []byte) (string, error) {
+	archive, err := openArchive(data)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, name := range archive.epubDocuments() {
+		file, err := archive.open(name)
+		if errors.Is(err, errArchiveTooLarge) {
+			return "", err
+		}
+		if err != nil {
+			continue
+		}
+		text, err := htmlText(ctx, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(text)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// epubDocuments returns the content documents of an epub in reading order
+func (archive *zipArchive) epubDocuments() []string {
+	container := struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}{}
+	err := archive.xml("META-INF/container.xml", &container)
+	if err == nil && len(container.Rootfiles) > 0 {
+		opfPath := container.Rootfiles[0].FullPath
+		opf := struct {
+			Items []struct {
+				Id   string `xml:"id,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"manifest>item"`
+			Spine []struct {
+				IdRef string `xml:"idref,attr"`
+			} `xml:"spine>itemref"`
+		}{}
+		err = archive.xml(opfPath, &opf)
+		if err == nil && len(opf.Spine) > 0 {
+			hrefs := make(map[string]string)
+			for _, item := range opf.Items {
+				hrefs[item.Id] = item.Href
+			}
+			documents := make([]string, 0, len(opf.Spine))
+			for _, itemRef := range opf.Spine {
+				if href, ok := hrefs[itemRef.IdRef]; ok {
+					documents = append(documents, path.Join(path.Dir(opfPath), href))
+				}
+			}
+			return documents
+		}
+	}
+
+	// no usable package document - fall back to all html documents
+	documents := make([]string, 0)
+	for _, file := range archive.File {
+		switch strings.ToLower(path.Ext(file.Name)) {
+		case ".xhtml", ".html", ".htm":
+			documents = append(documents, file.Name)
+		}
+	}
+	sort.Strings(documents)
+	return documents
+}
+
+// zipArchive is the zip archive of a document, its entries share a limit of inflated bytes
+type zipArchive struct {
+	*zip.Reader
+	remaining int64
+}
+
+func openArchive(data []byte) (*zipArchive, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return &zipArchive{reader, maxArchiveContentSize}, nil
+}
+
+// open opens an entry of the archive, reading it fails with errArchiveTooLarge when the limit is reached
+func (archive *zipArchive) open(name string) (io.ReadCloser, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		// the declared size is checked first, but it may be wrong
+		if file.UncompressedSize64 > uint64(archive.remaining) {
+			return nil, errArchiveTooLarge
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEntry{r, archive}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func (archive *zipArchive) xml(name string, v any) error {
+	file, err := archive.open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return xml.NewDecoder(file).Decode(v)
+}
+
+// xmlText returns the character data of an xml document in the archive.
+// The given elements are separated from the surrounding text.
+func (archive *zipArchive) xmlText(ctx context.Context, name string, separators ...string) (string, error) {
+	file, err := archive.open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	decoder := xml.NewDecoder(file)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.StartElement:
+			for _, separator := range separators {
+				if t.Name.Local == separator {
+					sb.WriteString(" ")
+				}
+			}
+		}
+	}
+}
+
+// archiveEntry counts the inflated bytes of an entry against the limit of its archive
+type archiveEntry struct {
+	io.ReadCloser
+	archive *zipArchive
+}
+
+func (e *archiveEntry) Read(p []byte) (int, error) {
+	if e.archive.remaining <= 0 {
+		// the limit is only exceeded if the entry has more bytes
+		var b [1]byte
+		if n, err := e.ReadCloser.Read(b[:]); n == 0 && errors.Is(err, io.EOF) {
+			return 0, io.EOF
+		}
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > e.archive.remaining {
+		p = p[:e.archive.remaining]
+	}
+	n, err := e.ReadCloser.Read(p)
+	e.archive.remaining -= int64(n)
+	return n, err
+}
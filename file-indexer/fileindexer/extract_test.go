@@ -0,0 +1,124 @@
+package fileindexer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func zipFile(t *testing.T, files map[string]string) []byte {
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func extract(t *testing.T, mimeType string, name string, data []byte) string {
+	extractor := contentExtractor(mimeType, name)
+	if !assert.NotNil(t, extractor) {
+		return ""
+	}
+	text, _, err := extractContent(context.Background(), extractor, data, 0)
+	assert.NoError(t, err)
+	return text
+}
+
+func TestExtractText(t *testing.T) {
+	assert.Equal(t, "hello world", extract(t, "text/plain; charset=utf-8", "/a/notes", []byte("hello\n\n  world\n")))
+	assert.Equal(t, "Title some bold text and a link", extract(t, "", "/README.md", []byte("# Title\n\nsome **bold** text\n\n- and a [link](http://example.com)\n")))
+	assert.Equal(t, "Heading Paragraph", extract(t, "text/html", "/index.html", []byte("<html><head><title>x</title><style>p {}</style></head><body><h1>Heading</h1><p>Paragraph</p><script>var a;</script></body></html>")))
+	assert.Nil(t, contentExtractor("image/jpeg", "/photo.jpg"))
+}
+
+func TestExtractDocuments(t *testing.T) {
+	docx := zipFile(t, map[string]string{
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:r><w:t>First</w:t></w:r></w:p><w:p><w:r><w:t>Second</w:t></w:r></w:p></w:body></w:document>`,
+	})
+	assert.Equal(t, "First Second", extract(t, "", "/doc.docx", docx))
+
+	odt := zipFile(t, map[string]string{
+		"content.xml": `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">` +
+			`<office:body><office:text><text:h>Title</text:h><text:p>Body</text:p></office:text></office:body></office:document-content>`,
+	})
+	assert.Equal(t, "Title Body", extract(t, "", "/doc.odt", odt))
+
+	epub := zipFile(t, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package><manifest><item id="c1" href="one.xhtml"/><item id="c2" href="two.xhtml"/></manifest>` +
+			`<spine><itemref idref="c2"/><itemref idref="c1"/></spine></package>`,
+		"OEBPS/one.xhtml": `<html><body><p>Chapter one</p></body></html>`,
+		"OEBPS/two.xhtml": `<html><body><p>Chapter two</p></body></html>`,
+	})
+	assert.Equal(t, "Chapter two Chapter one", extract(t, "application/epub+zip", "/book.epub", epub))
+}
+
+func TestExtractLimits(t *testing.T) {
+	text, truncated, err := extractContent(context.Background(), extractText, []byte("äöü"), 5)
+	assert.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "äö", text)
+
+	_, _, err = extractContent(context.Background(), extractPdf, []byte("not a pdf"), 0)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stopped := make(chan error, 1)
+	slow := func(ctx context.Context, data []byte) (string, error) {
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return "", ctx.Err()
+	}
+	_, _, err = extractContent(ctx, slow, nil, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// the extractor is stopped as well
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("extractor was not stopped")
+	}
+
+	// the parsers stop when the context is done
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = extractHtml(canceled, []byte("<p>text</p>"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestExtractArchiveLimit(t *testing.T) {
+	defer func(size int64) { maxArchiveContentSize = size }(maxArchiveContentSize)
+	maxArchiveContentSize = 1000
+
+	document := `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>` +
+		strings.Repeat("a", 2000) + `</w:t></w:r></w:p></w:body></w:document>`
+	docx := zipFile(t, map[string]string{"word/document.xml": document})
+	_, _, err := extractContent(context.Background(), extractDocx, docx, 0)
+	assert.ErrorIs(t, err, errArchiveTooLarge)
+
+	// the entries of an archive share the limit
+	epub := zipFile(t, map[string]string{
+		"OEBPS/one.xhtml": "<p>" + strings.Repeat("a", 600) + "</p>",
+		"OEBPS/two.xhtml": "<p>" + strings.Repeat("b", 600) + "</p>",
+	})
+	_, _, err = extractContent(context.Background(), extractEpub, epub, 0)
+	assert.ErrorIs(t, err, errArchiveTooLarge)
+
+	// documents within the limit are extracted
+	maxArchiveContentSize = int64(len(document))
+	text, _, err := extractContent(context.Background(), extractDocx, docx, 0)
+	assert.NoError(t, err)
+	assert.Len(t, text, 2000)
+}
@@ -0,0 +1,416 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/spf13/viper"
+	"umbasa.net/seraph/events"
+)
+
+// durable consumer of all file events, replaced by the consumers of the lanes
+const legacyDurable = "SERAPH_FILE_INDEXER"
+
+// how often the file info stream is checked for new file providers
+var laneDiscoveryInterval = 10 * time.Second
+
+// how long a lane waits for a new file event before checking whether it was stopped
+var laneFetchWait = 5 * time.Second
+
+// file events that wait for a free slot are reported as in progress in this interval,
+// so that they aren't redelivered in the meantime
+var inProgressInterval = 10 * time.Second
+
+var nonDurableChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// laneConfig configures how a file provider is scheduled, see fileindexer.lanes
+type laneConfig struct {
+	Id string
+	// share of the free slots that the provider gets when other providers are busy, too
+	Weight int
+	// maximum number of files of the provider that are processed at the same time
+	Parallel int
+}
+
+// loadLaneConfigs reads the "fileindexer.lanes" list
+func loadLaneConfigs(v *viper.Viper) (map[string]laneConfig, error) {
+	configs := make([]laneConfig, 0)
+	err := v.UnmarshalKey("fileindexer.lanes", &configs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fileindexer.lanes: %w", err)
+	}
+	lanes := make(map[string]laneConfig, len(configs))
+	for _, config := range configs {
+		if config.Id == "" {
+			return nil, errors.New("invalid fileindexer.lanes: id is required")
+		}
+		lanes[config.Id] = config
+	}
+	return lanes, nil
+}
+
+// lane processes the file events of one file provider with its own durable consumer
+type lane struct {
+	providerId string
+	consumer   jetstream.Consumer
+}
+
+// laneDurable returns the name of the durable consumer of a file provider's lane
+func laneDurable(providerId string) string {
+	name := nonDurableChars.ReplaceAllString(providerId, "_")
+	if name != providerId {
+		// keep providers apart whose ids only differ in the replaced characters
+		h := fnv.New32a()
+		h.Write([]byte(providerId))
+		name = fmt.Sprintf("%s_%08x", name, h.Sum32())
+	}
+	return legacyDurable + "_" + name
+}
+
+// laneProvider returns the id of the file provider that a file info subject belongs to
+func laneProvider(subject string) string {
+	prefix, suffix, _ := strings.Cut(events.FileProviderFileInfoTopicPattern, "%s")
+	if !strings.HasPrefix(subject, prefix) || !strings.HasSuffix(subject, suffix) || len(subject) <= len(prefix)+len(suffix) {
+		return ""
+	}
+	return subject[len(prefix) : len(subject)-len(suffix)]
+}
+
+// startLanes creates a lane for each file provider that has file events in the stream,
+// and keeps checking for new file providers until the consumer is stopped
+func (c *consumer) startLanes() error {
+	startSeq, err := c.legacyStartSeq()
+	if err != nil {
+		return err
+	}
+	if err := c.discoverLanes(startSeq); err != nil {
+		return err
+	}
+	if startSeq > 0 {
+		// the lanes continue where the previous consumer stopped
+		if err := c.fileInfoStream.DeleteConsumer(c.ctx, legacyDurable); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return err
+		}
+		c.log.Info("replaced file indexer consumer with lanes per file provider", "startSeq", startSeq)
+	}
+
+	go func() {
+		ticker := time.NewTicker(laneDiscoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.discoverLanes(0); err != nil {
+					c.log.Error("error while checking for new file providers", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// legacyStartSeq returns the first file event that the durable consumer of previous versions did not process,
+// or 0 if there is no such consumer
+func (c *consumer) legacyStartSeq() (uint64, error) {
+	legacy, err := c.fileInfoStream.Consumer(c.ctx, legacyDurable)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return legacy.CachedInfo().AckFloor.Stream + 1, nil
+}
+
+// discoverLanes starts lanes for file providers that don't have one, new consumers start at startSeq if it is set
+func (c *consumer) discoverLanes(startSeq uint64) error {
+	info, err := c.fileInfoStream.Info(c.ctx, jetstream.WithSubjectFilter(events.FileProviderFileInfoTopic))
+	if err != nil {
+		return err
+	}
+	for subject := range info.State.Subjects {
+		providerId := laneProvider(subject)
+		if providerId == "" {
+			continue
+		}
+		c.lanesMu.Lock()
+		_, ok := c.lanes[providerId]
+		c.lanesMu.Unlock()
+		if ok {
+			continue
+		}
+
+		l, err := c.createLane(providerId, startSeq)
+		if err != nil {
+			return fmt.Errorf("creating lane for %s failed: %w", providerId, err)
+		}
+		c.lanesMu.Lock()
+		c.lanes[providerId] = l
+		c.lanesMu.Unlock()
+
+		config := c.laneConfig(providerId)
+		c.scheduler.configure(providerId, config.Weight, config.Parallel)
+		c.log.Debug("started lane", "providerId", providerId, "weight", config.Weight, "parallel", config.Parallel)
+		go c.runLane(l)
+	}
+	return nil
+}
+
+func (c *consumer) createLane(providerId string, startSeq uint64) (*lane, error) {
+	name := laneDurable(providerId)
+	cons, err := c.fileInfoStream.Consumer(c.ctx, name)
+	if err == nil {
+		config := cons.CachedInfo().Config
+		if config.MaxDeliver != -1 {
+			config.MaxDeliver = -1
+			cons, err = c.fileInfoStream.UpdateConsumer(c.ctx, config)
+		}
+	} else if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		config := jetstream.ConsumerConfig{
+			Durable:       name,
+			FilterSubject: fmt.Sprintf(events.FileProviderFileInfoTopicPattern, providerId),
+			// the number of attempts is limited by the file indexer, the server keeps redelivering a message
+			// until it has been moved to the dead letter queue
+			MaxDeliver: -1,
+		}
+		if startSeq > 0 {
+			config.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+			config.OptStartSeq = startSeq
+		}
+		cons, err = c.fileInfoStream.CreateConsumer(c.ctx, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lane{providerId: providerId, consumer: cons}, nil
+}
+
+// laneConfig returns the scheduling configuration of a file provider
+func (c *consumer) laneConfig(providerId string) laneConfig {
+	config := c.laneConfigs[providerId]
+	if config.Weight <= 0 {
+		config.Weight = 1
+	}
+	if config.Parallel <= 0 {
+		config.Parallel = c.scheduler.limit
+	}
+	return config
+}
+
+// runLane fetches the file events of a lane in batches and indexes them
+func (c *consumer) runLane(l *lane) {
+	for c.ctx.Err() == nil {
+		msgs, err := c.fetchBatch(l)
+		if err != nil {
+			c.log.Error("consumer error", "error", err, "providerId", l.providerId)
+			select {
+			case <-c.ctx.Done():
+			case <-time.After(laneFetchWait):
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if !c.indexBatch(l, msgs) {
+			return
+		}
+	}
+}
+
+// fetchBatch returns the file events that are available for the lane, up to the batch size.
+// If there are none, it waits for the next file event.
+func (c *consumer) fetchBatch(l *lane) ([]jetstream.Msg, error) {
+	batch, err := l.consumer.FetchNoWait(c.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]jetstream.Msg, 0, c.batchSize)
+	for msg := range batch.Messages() {
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) > 0 {
+		return msgs, nil
+	}
+	if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) && !errors.Is(err, nats.ErrTimeout) {
+		return nil, err
+	}
+
+	msg, err := l.consumer.Next(jetstream.FetchMaxWait(laneFetchWait))
+	if errors.Is(err, nats.ErrTimeout) || errors.Is(err, jetstream.ErrNoMessages) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []jetstream.Msg{msg}, nil
+}
+
+// sortedLanes returns the lanes ordered by provider id
+func (c *consumer) sortedLanes() []*lane {
+	c.lanesMu.Lock()
+	defer c.lanesMu.Unlock()
+	lanes := make([]*lane, 0, len(c.lanes))
+	for _, l := range c.lanes {
+		lanes = append(lanes, l)
+	}
+	sort.Slice(lanes, func(i, j int) bool {
+		return lanes[i].providerId < lanes[j].providerId
+	})
+	return lanes
+}
+
+// fairScheduler hands out a limited number of slots for processing files to the lanes of the file providers.
+// A free slot goes to the waiting lane with the fewest running files relative to its weight,
+// so that a provider with a large backlog can't starve the others.
+// A lane that has reached its own limit waits for one of its files to finish.
+type fairScheduler struct {
+	mu      sync.Mutex
+	done    *sync.Cond
+	limit   int
+	running int
+	turn    uint64
+	lanes   map[string]*laneState
+}
+
+type laneState struct {
+	weight  int
+	limit   int
+	running int
+	waiting []chan struct{}
+	// turn of the last slot that the lane got, to take turns between lanes with the same share
+	lastTurn uint64
+}
+
+func newFairScheduler(limit int) *fairScheduler {
+	s := &fairScheduler{
+		limit: max(limit, 1),
+		lanes: make(map[string]*laneState),
+	}
+	s.done = sync.NewCond(&s.mu)
+	return s
+}
+
+// configure sets the weight and the limit of a lane
+func (s *fairScheduler) configure(lane string, weight int, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.lane(lane)
+	state.weight = max(weight, 1)
+	state.limit = max(limit, 1)
+	s.dispatch()
+}
+
+// Begin blocks until the lane gets a slot, it returns false if ctx is cancelled before
+func (s *fairScheduler) Begin(ctx context.Context, lane string) bool {
+	granted := make(chan struct{})
+	s.mu.Lock()
+	state := s.lane(lane)
+	state.waiting = append(state.waiting, granted)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-granted:
+		return true
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-granted:
+		// the slot was granted in the meantime
+		s.release(state)
+	default:
+		for i, waiting := range state.waiting {
+			if waiting == granted {
+				state.waiting = append(state.waiting[:i], state.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	return false
+}
+
+// End frees the slot of the lane
+func (s *fairScheduler) End(lane string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(s.lane(lane))
+}
+
+// Join waits until all slots are free
+func (s *fairScheduler) Join() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.running > 0 {
+		s.done.Wait()
+	}
+}
+
+func (s *fairScheduler) lane(lane string) *laneState {
+	state, ok := s.lanes[lane]
+	if !ok {
+		state = &laneState{weight: 1, limit: s.limit}
+		s.lanes[lane] = state
+	}
+	return state
+}
+
+func (s *fairScheduler) release(state *laneState) {
+	state.running--
+	s.running--
+	s.dispatch()
+	if s.running == 0 {
+		s.done.Broadcast()
+	}
+}
+
+// dispatch hands out free slots to waiting lanes, s.mu must be held
+func (s *fairScheduler) dispatch() {
+	for s.running < s.limit {
+		var next *laneState
+		for _, state := range s.lanes {
+			if len(state.waiting) == 0 || state.running >= state.limit {
+				continue
+			}
+			if next == nil || state.before(next) {
+				next = state
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		granted := next.waiting[0]
+		next.waiting = next.waiting[1:]
+		next.running++
+		s.running++
+		s.turn++
+		next.lastTurn = s.turn
+		close(granted)
+	}
+}
+
+// before returns whether the lane should get a slot before the other lane
+func (state *laneState) before(other *laneState) bool {
+	// compare running/weight without dividing
+	share := state.running * other.weight
+	otherShare := other.running * state.weight
+	if share != otherShare {
+		return share < otherShare
+	}
+	return state.lastTurn < other.lastTurn
+}
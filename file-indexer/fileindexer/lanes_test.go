@@ -0,0 +1,132 @@
+package fileindexer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// enqueue adds n waiting files to a lane and returns their channels
+func enqueue(s *fairScheduler, lane string, n int) []chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	waiting := make([]chan struct{}, n)
+	for i := range waiting {
+		waiting[i] = make(chan struct{})
+		s.lane(lane).waiting = append(s.lane(lane).waiting, waiting[i])
+	}
+	s.dispatch()
+	return waiting
+}
+
+func granted(waiting []chan struct{}) int {
+	count := 0
+	for _, ch := range waiting {
+		select {
+		case <-ch:
+			count++
+		default:
+		}
+	}
+	return count
+}
+
+func TestFairSchedulerTakesTurns(t *testing.T) {
+	s := newFairScheduler(1)
+	assert.True(t, s.Begin(context.Background(), "big"))
+
+	big := enqueue(s, "big", 3)
+	small := enqueue(s, "small", 1)
+	assert.Equal(t, 0, granted(big))
+	assert.Equal(t, 0, granted(small))
+
+	// the small provider gets the next slot although the big one was waiting first
+	s.End("big")
+	assert.Equal(t, 0, granted(big))
+	assert.Equal(t, 1, granted(small))
+
+	s.End("small")
+	assert.Equal(t, 1, granted(big))
+}
+
+func TestFairSchedulerWeights(t *testing.T) {
+	s := newFairScheduler(6)
+	s.configure("photos", 2, 6)
+	s.configure("nas", 1, 6)
+
+	// another lane holds all slots until both lanes are waiting
+	blocker := enqueue(s, "blocker", 6)
+	assert.Equal(t, 6, granted(blocker))
+	photos := enqueue(s, "photos", 10)
+	nas := enqueue(s, "nas", 10)
+
+	for range 6 {
+		s.End("blocker")
+	}
+	assert.Equal(t, 4, granted(photos))
+	assert.Equal(t, 2, granted(nas))
+}
+
+func TestFairSchedulerLaneLimit(t *testing.T) {
+	s := newFairScheduler(4)
+	s.configure("nas", 1, 1)
+
+	nas := enqueue(s, "nas", 3)
+	photos := enqueue(s, "photos", 5)
+	assert.Equal(t, 1, granted(nas))
+	assert.Equal(t, 3, granted(photos))
+}
+
+func TestFairSchedulerCancel(t *testing.T) {
+	s := newFairScheduler(1)
+	assert.True(t, s.Begin(context.Background(), "nas"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, s.Begin(ctx, "photos"))
+	assert.Empty(t, s.lane("photos").waiting)
+
+	s.End("nas")
+	s.Join()
+	assert.Equal(t, 0, s.running)
+}
+
+func TestLaneDurable(t *testing.T) {
+	assert.Equal(t, "SERAPH_FILE_INDEXER_photos", laneDurable("photos"))
+	assert.Equal(t, "SERAPH_FILE_INDEXER_my-nas_2", laneDurable("my-nas_2"))
+
+	dotted := laneDurable("nas.local")
+	assert.True(t, strings.HasPrefix(dotted, "SERAPH_FILE_INDEXER_nas_local_"))
+	assert.NotEqual(t, dotted, laneDurable("nas local"))
+}
+
+func TestLaneProvider(t *testing.T) {
+	assert.Equal(t, "photos", laneProvider("seraph.fileprovider.photos.fileinfo"))
+	assert.Equal(t, "", laneProvider("seraph.fileprovider..fileinfo"))
+	assert.Equal(t, "", laneProvider("seraph.file.photos.changed"))
+}
+
+func TestLoadLaneConfigs(t *testing.T) {
+	v := viper.New()
+	v.Set("fileindexer.lanes", []map[string]any{
+		{"id": "photos", "weight": 4},
+		{"id": "nas", "parallel": 2},
+	})
+	configs, err := loadLaneConfigs(v)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]laneConfig{
+		"photos": {Id: "photos", Weight: 4},
+		"nas":    {Id: "nas", Parallel: 2},
+	}, configs)
+
+	configs, err = loadLaneConfigs(viper.New())
+	assert.NoError(t, err)
+	assert.Empty(t, configs)
+
+	v.Set("fileindexer.lanes", []map[string]any{{"weight": 4}})
+	_, err = loadLaneConfigs(v)
+	assert.Error(t, err)
+}
@@ -0,0 +1,281 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+	"umbasa.net/seraph/tracing"
+)
+
+type ListingParams struct {
+	fx.In
+
+	Nc      *nats.Conn
+	Db      *mongo.Database
+	Logger  *logging.Logger
+	Tracing *tracing.Tracing
+	Mig     Migrations
+	Lc      fx.Lifecycle
+}
+
+type Listing interface{}
+
+type listing struct {
+	log    *slog.Logger
+	nc     *nats.Conn
+	files  *mongo.Collection
+	tracer trace.Tracer
+
+	subs []*nats.Subscription
+}
+
+func NewListing(p ListingParams) (Listing, error) {
+	l := &listing{
+		log:    p.Logger.GetLogger("listing"),
+		nc:     p.Nc,
+		files:  p.Db.Collection(filesCollection),
+		tracer: p.Tracing.TracerProvider.Tracer("listing"),
+	}
+
+	p.Lc.Append(fx.StartHook(l.start))
+	p.Lc.Append(fx.StopHook(l.stop))
+
+	return l, nil
+}
+
+func (l *listing) start() error {
+	handlers := map[string]nats.MsgHandler{
+		events.ListFilesTopic:  l.handleRequest,
+		events.StorageTopic:    l.handleStorageRequest,
+		events.FileLookupTopic: l.handleLookupRequest,
+	}
+	for topic, handler := range handlers {
+		sub, err := l.nc.QueueSubscribe(topic, topic, func(msg *nats.Msg) {
+			go handler(msg)
+		})
+		if err != nil {
+			return err
+		}
+		l.subs = append(l.subs, sub)
+	}
+	return nil
+}
+
+func (l *listing) stop() {
+	for _, sub := range l.subs {
+		sub.Unsubscribe()
+	}
+	l.subs = nil
+}
+
+func (l *listing) handleRequest(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(context.Background(), msg)
+	ctx, span := l.tracer.Start(ctx, "listFiles")
+	defer span.End()
+
+	req := events.ListFilesRequest{}
+	res := &events.ListFilesResponse{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil {
+		res, err = l.list(ctx, &req)
+	}
+	if err != nil {
+		l.log.Error("error while listing files", "error", err)
+		res = &events.ListFilesResponse{Error: err.Error()}
+	}
+
+	data, _ := json.Marshal(res)
+	msg.Respond(data)
+}
+
+// list returns the indexed entries of a directory in a space of the user
+func (l *listing) list(ctx context.Context, req *events.ListFilesRequest) (*events.ListFilesResponse, error) {
+	sortBy := req.Sort
+	if sortBy == "" {
+		sortBy = events.SearchSortName
+	}
+	if sortBy == events.SearchSortRelevance {
+		return nil, fmt.Errorf("invalid sort %s", sortBy)
+	}
+	page, err := newSearchPage(&events.SearchRequest{Limit: req.Limit, Cursor: req.Cursor, Sort: sortBy, Order: req.Order})
+	if err != nil {
+		return nil, err
+	}
+
+	userSpaces, err := spaces.GetSpacesForUser(ctx, l.nc, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	members := spaceProviderMembers(userSpaces, req.ProviderId)
+	if len(members) == 0 {
+		return &events.ListFilesResponse{NotFound: true, Error: "space provider not found"}, nil
+	}
+
+	dir := path.Clean("/" + req.Path)
+	entries, err := l.readDir(ctx, userSpaces, members, dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && dir != "/" {
+		// tell an empty directory apart from a missing one
+		exists, err := l.dirExists(ctx, userSpaces, members, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return &events.ListFilesResponse{NotFound: true, Error: "directory not found"}, nil
+		}
+	}
+
+	res := &events.ListFilesResponse{
+		Files:  make([]events.FileEntry, 0),
+		Total:  int64(len(entries)),
+		Cursor: page.nextCursor(int64(len(entries))),
+	}
+	for _, entry := range page.apply(entries) {
+		res.Files = append(res.Files, newFileEntry(req.ProviderId, dir, &entry.File))
+	}
+	return res, nil
+}
+
+// readDir returns the indexed entries of a directory in all members of a space provider
+func (l *listing) readDir(ctx context.Context, userSpaces []spaces.Space, members []spaces.UnionMember, dir string) ([]scoredFile, error) {
+	// previous versions and the trash are hidden like in search results
+	filter := bson.M{"$and": append(spaceFilter(userSpaces, nil, nil), listingFilter(members, dir))}
+	l.log.Debug("listing query", "query", logging.JsonValue(filter))
+
+	cur, err := l.files.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	// entries of union members are merged, the first member wins on name collisions
+	byName := make(map[string]int)
+	entries := make([]scoredFile, 0)
+	for cur.Next(ctx) {
+		file := File{}
+		if err := cur.Decode(&file); err != nil {
+			return nil, err
+		}
+		if file.IsDir {
+			// directories are listed and sorted with the size of all files below them
+			file.Size = file.TotalSize
+		}
+		name := path.Base(file.Path)
+		if i, ok := byName[name]; ok {
+			kept := &entries[i].File
+			if file.IsDir && kept.IsDir {
+				// the contents of the directories are merged as well
+				kept.Size += file.Size
+				kept.FileCount += file.FileCount
+			} else if memberRank(members, &file) < memberRank(members, kept) {
+				entries[i] = scoredFile{File: file}
+			}
+			continue
+		}
+		byName[name] = len(entries)
+		entries = append(entries, scoredFile{File: file})
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// dirExists returns whether the directory is indexed in one of the members
+func (l *listing) dirExists(ctx context.Context, userSpaces []spaces.Space, members []spaces.UnionMember, dir string) (bool, error) {
+	conds := bson.A{}
+	for _, member := range members {
+		conds = append(conds, bson.M{
+			"providerId": member.ProviderId,
+			"path":       path.Join("/", member.Path, dir),
+			"isDir":      true,
+		})
+	}
+	filter := bson.M{"$and": append(spaceFilter(userSpaces, nil, nil), bson.M{"$or": conds})}
+	count, err := l.files.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func newFileEntry(providerId string, dir string, file *File) events.FileEntry {
+	entry := events.FileEntry{
+		ProviderId: providerId,
+		Path:       path.Join(dir, path.Base(file.Path))[1:],
+		IsDir:      file.IsDir,
+		Size:       file.Size,
+		ModTime:    file.ModTime,
+		Mime:       file.Mime,
+		Video:      file.Video,
+	}
+	if file.IsDir {
+		entry.FileCount = file.FileCount
+	}
+	if taken := file.takenTime(); taken != file.ModTime {
+		entry.Taken = taken
+	}
+	return entry
+}
+
+// spaceProviderMembers returns the locations that make up the space provider, in order of precedence
+func spaceProviderMembers(userSpaces []spaces.Space, spaceProviderId string) []spaces.UnionMember {
+	for _, space := range userSpaces {
+		for _, provider := range space.FileProviders {
+			if provider.SpaceProviderId == spaceProviderId {
+				return provider.Members()
+			}
+		}
+	}
+	return nil
+}
+
+// listingFilter matches the direct children of the directory in all members
+func listingFilter(members []spaces.UnionMember, dir string) bson.M {
+	conds := bson.A{}
+	for _, member := range members {
+		memberDir := path.Join("/", member.Path, dir)
+		if memberDir != "/" {
+			memberDir += "/"
+		}
+		conds = append(conds, bson.M{
+			"providerId": member.ProviderId,
+			"path":       bson.M{"$regex": fmt.Sprintf("^%s[^/]+$", regexp.QuoteMeta(memberDir))},
+		})
+	}
+	return bson.M{"$or": conds}
+}
+
+// memberRank returns the index of the member that contains the file
+func memberRank(members []spaces.UnionMember, file *File) int {
+	return slices.IndexFunc(members, func(member spaces.UnionMember) bool {
+		return memberContains(member, file)
+	})
+}
+
+// memberContains reports whether the file is the directory of the member or below it
+func memberContains(member spaces.UnionMember, file *File) bool {
+	if member.ProviderId != file.ProviderId {
+		return false
+	}
+	memberPath := path.Join("/", member.Path)
+	return memberPath == "/" || file.Path == memberPath || strings.HasPrefix(file.Path, memberPath+"/")
+}
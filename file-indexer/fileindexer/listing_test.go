@@ -0,0 +1,40 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func TestListingFilter(t *testing.T) {
+	members := []spaces.UnionMember{
+		{ProviderId: "p1", Path: ""},
+		{ProviderId: "p2", Path: "/media/photos"},
+	}
+
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"providerId": "p1", "path": bson.M{"$regex": `^/2023/trip\.1/[^/]+$`}},
+		bson.M{"providerId": "p2", "path": bson.M{"$regex": `^/media/photos/2023/trip\.1/[^/]+$`}},
+	}}, listingFilter(members, "/2023/trip.1"))
+
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"providerId": "p1", "path": bson.M{"$regex": `^/[^/]+$`}},
+		bson.M{"providerId": "p2", "path": bson.M{"$regex": `^/media/photos/[^/]+$`}},
+	}}, listingFilter(members, "/"))
+}
+
+func TestMemberRank(t *testing.T) {
+	members := []spaces.UnionMember{
+		{ProviderId: "p1", Path: "/a"},
+		{ProviderId: "p1", Path: "/b"},
+		{ProviderId: "p2", Path: ""},
+	}
+	assert.Equal(t, 0, memberRank(members, &File{ProviderId: "p1", Path: "/a/x.mp4"}))
+	assert.Equal(t, 1, memberRank(members, &File{ProviderId: "p1", Path: "/b/x.mp4"}))
+	assert.Equal(t, 2, memberRank(members, &File{ProviderId: "p2", Path: "/a/x.mp4"}))
+	assert.Equal(t, -1, memberRank(members, &File{ProviderId: "p3", Path: "/a/x.mp4"}))
+	// paths that only share a prefix with the member are not in the member
+	assert.Equal(t, -1, memberRank(members, &File{ProviderId: "p1", Path: "/ab/x.mp4"}))
+}
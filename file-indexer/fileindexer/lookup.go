@@ -0,0 +1,82 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func (l *listing) handleLookupRequest(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(context.Background(), msg)
+	ctx, span := l.tracer.Start(ctx, "lookup")
+	defer span.End()
+
+	req := events.FileLookupRequest{}
+	res := &events.FileLookupResponse{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil {
+		res, err = l.lookup(ctx, &req)
+	}
+	if err != nil {
+		l.log.Error("error while looking up file", "error", err)
+		res = &events.FileLookupResponse{Error: err.Error()}
+	}
+
+	data, _ := json.Marshal(res)
+	msg.Respond(data)
+}
+
+// lookup returns a file of a file provider and the entries of the directory, as they were last indexed
+func (l *listing) lookup(ctx context.Context, req *events.FileLookupRequest) (*events.FileLookupResponse, error) {
+	filePath := path.Clean("/" + req.Path)
+
+	filter := FilePrototype{}
+	filter.ProviderId.Set(req.ProviderId)
+	filter.Path.Set(filePath)
+
+	file := File{}
+	err := l.files.FindOne(ctx, filter).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &events.FileLookupResponse{NotFound: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := lookupEntry(&file)
+	res := &events.FileLookupResponse{File: &entry}
+	if !req.Readdir || !file.IsDir {
+		return res, nil
+	}
+
+	members := []spaces.UnionMember{{ProviderId: req.ProviderId}}
+	cur, err := l.files.Find(ctx, listingFilter(members, filePath))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	res.Children = make([]events.FileEntry, 0)
+	for cur.Next(ctx) {
+		child := File{}
+		if err := cur.Decode(&child); err != nil {
+			return nil, err
+		}
+		res.Children = append(res.Children, lookupEntry(&child))
+	}
+	return res, cur.Err()
+}
+
+// lookupEntry returns the entry of a file with its absolute path in the file provider
+func lookupEntry(file *File) events.FileEntry {
+	entry := newFileEntry(file.ProviderId, path.Dir(file.Path), file)
+	entry.Path = file.Path
+	return entry
+}
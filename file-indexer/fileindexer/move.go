@@ -0,0 +1,126 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/file-provider/fileprovider"
+)
+
+// maximum number of indexed files with the same hash that are checked when detecting a move
+const moveCandidateLimit = 10
+
+// handleMoveEvent moves the indexed files, so that they keep their id.
+// Events that can't be read are dropped, an error is returned if the event should be processed again.
+func (c *consumer) handleMoveEvent(ctx context.Context, data []byte) error {
+	ctx, span := c.tracer.Start(ctx, "handleMove")
+	defer span.End()
+
+	ev := events.FileMoveEvent{}
+	err := json.Unmarshal(data, &ev)
+	if err != nil {
+		c.log.Error("failed to deserialize move event", "error", err)
+		return nil
+	}
+
+	err = c.moveFiles(ctx, ev.ProviderId, ev.OldPath, ev.NewPath, false, events.FileChangedEventMoved)
+	if err != nil {
+		c.log.Error("error processing move event", "error", err, "event", ev)
+	}
+	return err
+}
+
+// detectMove checks whether a new file is an indexed file that was moved outside of seraph.
+// An indexed file with the same size and hash that no longer exists at its path is assumed to be moved
+// to the path of the new file. It is moved in the index and replaces the new file, which takes over its id.
+func (c *consumer) detectMove(ctx context.Context, file *File, imoHash string) (bool, error) {
+	ctx, span := c.tracer.Start(ctx, "detectMove")
+	defer span.End()
+
+	cur, err := c.files.Find(ctx, moveCandidateFilter(file, imoHash), options.Find().SetLimit(moveCandidateLimit))
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(ctx)
+
+	candidates := make([]File, 0)
+	if err := cur.All(ctx, &candidates); err != nil {
+		return false, err
+	}
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	client := fileprovider.NewFileProviderClient(file.ProviderId, c.nc, c.logger)
+	defer client.Close()
+
+	for _, candidate := range candidates {
+		_, err := client.Stat(ctx, candidate.Path)
+		if err == nil {
+			// the file was copied
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+
+		c.log.Debug("detected moved file", "providerId", file.ProviderId, "from", candidate.Path, "to", file.Path)
+		err = c.removeMoveTarget(ctx, file)
+		if err != nil {
+			return false, err
+		}
+
+		// the moved file takes over the stat of the new file, the size is the same
+		stat := FilePrototype{}
+		stat.ModTime.Set(file.ModTime)
+		stat.Mode.Set(file.Mode)
+		filter := FilePrototype{}
+		filter.Id.Set(candidate.Id)
+		_, err = c.files.UpdateOne(ctx, filter, bson.M{"$set": stat})
+		if err != nil {
+			return false, err
+		}
+
+		err = c.moveFiles(ctx, file.ProviderId, candidate.Path, file.Path, false, events.FileChangedEventMoved)
+		if err != nil {
+			return false, err
+		}
+		file.Id = candidate.Id
+		return true, nil
+	}
+	return false, nil
+}
+
+// removeMoveTarget removes the new file that a moved file replaces.
+// Its creation was never published, so unlike deleteFiles no event is published for it.
+func (c *consumer) removeMoveTarget(ctx context.Context, file *File) error {
+	filter := FilePrototype{}
+	filter.Id.Set(file.Id)
+	res, err := c.files.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return nil
+	}
+	size, count := file.sizeAndCount()
+	return c.updateDirSizes(ctx, file.ParentDir, -size, -count)
+}
+
+// moveCandidateFilter matches the indexed files that the file may have been moved from
+func moveCandidateFilter(file *File, imoHash string) bson.M {
+	return bson.M{
+		"_id":        bson.M{"$ne": file.Id},
+		"providerId": file.ProviderId,
+		"path":       bson.M{"$ne": file.Path},
+		"isDir":      false,
+		"size":       file.Size,
+		"imoHash":    imoHash,
+		"trashed":    bson.M{"$ne": true},
+	}
+}
@@ -0,0 +1,29 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMoveCandidateFilter(t *testing.T) {
+	file := File{
+		Id:         primitive.NewObjectID(),
+		ProviderId: "provider",
+		Path:       "/photos/new.jpg",
+		Size:       1234,
+	}
+
+	filter := moveCandidateFilter(&file, "abcd")
+
+	assert.Equal(t, bson.M{"$ne": file.Id}, filter["_id"])
+	assert.Equal(t, "provider", filter["providerId"])
+	assert.Equal(t, bson.M{"$ne": "/photos/new.jpg"}, filter["path"])
+	assert.Equal(t, false, filter["isDir"])
+	assert.Equal(t, int64(1234), filter["size"])
+	assert.Equal(t, "abcd", filter["imoHash"])
+	// files in the trash are moved back with restore events
+	assert.Equal(t, bson.M{"$ne": true}, filter["trashed"])
+}
@@ -0,0 +1,204 @@
+package fileindexer
+
+import (
+	"context"
+	"math"
+	"path"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"umbasa.net/seraph/logging"
+)
+
+// maximum number of candidates that are loaded for a fuzzy search, only these can be paged through
+const fuzzySearchLimit = 1000
+
+// number of files that are updated at once when calculating missing name trigrams
+const nameGramsBatchSize = 1000
+
+// fraction of the trigrams of the query that a name must contain to match
+const fuzzyMinSimilarity = 0.5
+
+// nameGrams returns the trigrams of the words in the file name.
+// Words are padded with two spaces at the start so that prefixes of
+// one or two characters can be matched as well.
+func nameGrams(filePath string) []string {
+	grams := make([]string, 0)
+	for _, word := range nameWords(path.Base(filePath)) {
+		grams = appendGrams(grams, "  "+word+" ")
+	}
+	return grams
+}
+
+// queryGrams returns the trigrams of the query words.
+// The words are not padded at the end, so that a word matches all names starting with it.
+func queryGrams(words []string) []string {
+	grams := make([]string, 0)
+	for _, word := range words {
+		for _, w := range nameWords(word) {
+			grams = appendGrams(grams, "  "+w)
+		}
+	}
+	return grams
+}
+
+// candidateGrams returns the query trigrams that a name must contain at least one of to be a candidate.
+// The single character prefix grams ("  v") are left out, as nearly every name contains one of them,
+// unless a word consists of only one character. All query trigrams are still used for scoring.
+func candidateGrams(words []string) []string {
+	grams := make([]string, 0)
+	for _, word := range words {
+		for _, w := range nameWords(word) {
+			wordGrams := appendGrams(nil, "  "+w)
+			if len(wordGrams) > 1 {
+				wordGrams = wordGrams[1:]
+			}
+			for _, gram := range wordGrams {
+				if !slices.Contains(grams, gram) {
+					grams = append(grams, gram)
+				}
+			}
+		}
+	}
+	return grams
+}
+
+func nameWords(name string) []string {
+	return strings.Fields(strings.ToLower(searchWordsRegex.ReplaceAllString(name, " ")))
+}
+
+func appendGrams(grams []string, padded string) []string {
+	runes := []rune(padded)
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if !slices.Contains(grams, gram) {
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// initNameGrams calculates the name trigrams for files that were indexed
+// before fuzzy search was introduced
+func (c *consumer) initNameGrams() {
+	filter := bson.M{"nameGrams": bson.M{"$exists": false}}
+	cur, err := c.files.Find(c.ctx, filter, options.Find().SetProjection(bson.M{"path": 1}))
+	if err != nil {
+		c.log.Error("error while calculating name trigrams", "error", err)
+		return
+	}
+	defer cur.Close(c.ctx)
+
+	updated := 0
+	models := make([]mongo.WriteModel, 0, nameGramsBatchSize)
+	write := func() bool {
+		if _, err := c.files.BulkWrite(c.ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			c.log.Error("error while calculating name trigrams", "error", err)
+			return false
+		}
+		updated += len(models)
+		models = models[:0]
+		return true
+	}
+	for cur.Next(c.ctx) {
+		file := File{}
+		if err := cur.Decode(&file); err != nil {
+			c.log.Error("error while calculating name trigrams", "error", err)
+			return
+		}
+		proto := FilePrototype{}
+		proto.NameGrams.Set(nameGrams(file.Path))
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": file.Id}).SetUpdate(bson.M{"$set": proto}))
+		if len(models) == nameGramsBatchSize && !write() {
+			return
+		}
+	}
+	if err := cur.Err(); err != nil {
+		c.log.Error("error while calculating name trigrams", "error", err)
+		return
+	}
+	if len(models) > 0 && !write() {
+		return
+	}
+	if updated > 0 {
+		c.log.Info("calculated name trigrams", "files", updated)
+	}
+}
+
+// findFuzzy returns the best fuzzySearchLimit files whose name is similar to the query words,
+// and the number of similar files, which is capped at fuzzySearchLimit.
+// Names that match the query exactly or start with it are ranked first,
+// other matches are ranked by the fraction of the query trigrams they contain.
+func (s *search) findFuzzy(ctx context.Context, fileFilter bson.A, words []string) ([]scoredFile, int64, error) {
+	grams := queryGrams(words)
+	if len(grams) == 0 {
+		return []scoredFile{}, 0, nil
+	}
+	minMatches := max(1, int(math.Ceil(float64(len(grams))*fuzzyMinSimilarity)))
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"$and": append(slices.Clone(fileFilter), bson.M{"nameGrams": bson.M{"$in": candidateGrams(words)}}),
+		}},
+		bson.M{"$addFields": bson.M{
+			"gramMatches": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$nameGrams", grams}}},
+		}},
+		bson.M{"$match": bson.M{"gramMatches": bson.M{"$gte": minMatches}}},
+		bson.M{"$sort": bson.D{{Key: "gramMatches", Value: -1}, {Key: "path", Value: 1}}},
+		bson.M{"$limit": fuzzySearchLimit},
+	}
+
+	s.log.Debug("fuzzy search query", "query", logging.JsonValue(pipeline))
+
+	cur, err := s.files.Aggregate(ctx, pipeline, options.Aggregate())
+	if err != nil {
+		s.log.Error("error while executing fuzzy search query", "error", err)
+		return nil, 0, err
+	}
+	defer cur.Close(ctx)
+
+	query := strings.Join(nameWords(strings.Join(words, " ")), " ")
+	results := make([]scoredFile, 0)
+	for cur.Next(ctx) {
+		result := struct {
+			File        `bson:",inline"`
+			GramMatches int `bson:"gramMatches"`
+		}{}
+		err := cur.Decode(&result)
+		if err != nil {
+			s.log.Error("error while decoding fuzzy search results", "error", err)
+			return nil, 0, err
+		}
+		score := float64(result.GramMatches)/float64(len(grams)) + nameScore(query, result.Path)
+		results = append(results, scoredFile{File: result.File, Score: score})
+	}
+	if err := cur.Err(); err != nil {
+		s.log.Error("error while retrieving fuzzy search results", "error", err)
+		return nil, 0, err
+	}
+	return results, int64(len(results)), nil
+}
+
+// nameScore ranks exact name hits and prefix hits above similar names
+func nameScore(query string, filePath string) float64 {
+	name := path.Base(filePath)
+	words := nameWords(name)
+	normalized := strings.Join(words, " ")
+	withoutExt := strings.Join(nameWords(strings.TrimSuffix(name, path.Ext(name))), " ")
+
+	switch {
+	case normalized == query || withoutExt == query:
+		return 3
+	case strings.HasPrefix(normalized, query):
+		return 2
+	}
+	for _, queryWord := range strings.Fields(query) {
+		if !slices.ContainsFunc(words, func(word string) bool { return strings.HasPrefix(word, queryWord) }) {
+			return 0
+		}
+	}
+	return 1
+}
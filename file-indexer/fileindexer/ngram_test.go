@@ -0,0 +1,59 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gramSimilarity(query []string, filePath string) float64 {
+	grams := queryGrams(query)
+	names := nameGrams(filePath)
+	matches := 0
+	for _, gram := range grams {
+		for _, name := range names {
+			if gram == name {
+				matches++
+				break
+			}
+		}
+	}
+	return float64(matches) / float64(len(grams))
+}
+
+func TestNameGrams(t *testing.T) {
+	assert.Equal(t, []string{"  a", " ab", "ab ", "  x", " x "}, nameGrams("/dir/AB-x"))
+	assert.Empty(t, nameGrams("/"))
+
+	// the directory is not part of the name
+	assert.NotContains(t, nameGrams("/photos/beach.jpg"), "pho")
+}
+
+func TestQueryGramsMatchPrefixes(t *testing.T) {
+	assert.Equal(t, []string{"  v", " va", "vac", "aca"}, queryGrams([]string{"Vaca"}))
+	assert.Equal(t, 1.0, gramSimilarity([]string{"vaca"}, "/photos/vacation.jpg"))
+	assert.Equal(t, 1.0, gramSimilarity([]string{"v"}, "/photos/vacation.jpg"))
+	assert.Equal(t, 1.0, gramSimilarity([]string{"beach", "2023"}, "/photos/2023_beach-trip.jpg"))
+}
+
+func TestQueryGramsTolerateTypos(t *testing.T) {
+	assert.GreaterOrEqual(t, gramSimilarity([]string{"vacaton"}, "/photos/vacation.jpg"), fuzzyMinSimilarity)
+	assert.GreaterOrEqual(t, gramSimilarity([]string{"vcation"}, "/photos/vacation.jpg"), fuzzyMinSimilarity)
+	assert.Less(t, gramSimilarity([]string{"invoice"}, "/photos/vacation.jpg"), fuzzyMinSimilarity)
+}
+
+func TestNameScore(t *testing.T) {
+	assert.Equal(t, 3.0, nameScore("vacation", "/photos/vacation.jpg"))
+	assert.Equal(t, 3.0, nameScore("vacation jpg", "/photos/vacation.jpg"))
+	assert.Equal(t, 2.0, nameScore("vaca", "/photos/vacation.jpg"))
+	assert.Equal(t, 1.0, nameScore("trip vaca", "/photos/vacation-trip.jpg"))
+	assert.Equal(t, 0.0, nameScore("vacaton", "/photos/vacation.jpg"))
+}
+
+func TestCandidateGrams(t *testing.T) {
+	assert.Equal(t, []string{" va", "vac", "aca"}, candidateGrams([]string{"vaca"}))
+	assert.Equal(t, []string{" va"}, candidateGrams([]string{"va"}))
+
+	// a single character has no other gram
+	assert.Equal(t, []string{"  v", " be", "bea"}, candidateGrams([]string{"v", "bea"}))
+}
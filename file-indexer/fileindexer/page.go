@@ -0,0 +1,152 @@
+package fileindexer
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+// searchPage selects the part of the search results that is returned for a request
+type searchPage struct {
+	limit  int
+	offset int
+	sort   string
+	desc   bool
+}
+
+func newSearchPage(req *events.SearchRequest) (searchPage, error) {
+	page := searchPage{
+		limit: req.PageLimit(),
+		sort:  req.Sort,
+	}
+
+	offset, err := messaging.SearchOffset(req)
+	if err != nil {
+		return page, err
+	}
+	page.offset = offset
+
+	switch page.sort {
+	case "":
+		page.sort = events.SearchSortRelevance
+		page.desc = true
+	case events.SearchSortRelevance, events.SearchSortModified, events.SearchSortSize, events.SearchSortTaken:
+		page.desc = true
+	case events.SearchSortName:
+		page.desc = false
+	default:
+		return page, fmt.Errorf("invalid sort %s", page.sort)
+	}
+
+	switch req.Order {
+	case "":
+	case events.SearchOrderAsc:
+		page.desc = false
+	case events.SearchOrderDesc:
+		page.desc = true
+	default:
+		return page, fmt.Errorf("invalid order %s", req.Order)
+	}
+
+	return page, nil
+}
+
+// stages returns the aggregation stages that sort the matches and select the requested page.
+// score is the expression for the relevance of a match, or nil if the query has no text,
+// in which case the newest files are considered the most relevant.
+func (p searchPage) stages(score any) bson.A {
+	fields := bson.M{}
+	if score != nil {
+		fields["score"] = score
+	}
+	sortKey := "sortKey"
+	switch p.sort {
+	case events.SearchSortName:
+		fields[sortKey] = bson.M{"$toLower": bson.M{"$arrayElemAt": bson.A{bson.M{"$split": bson.A{"$path", "/"}}, -1}}}
+	case events.SearchSortModified:
+		sortKey = "modTime"
+	case events.SearchSortSize:
+		sortKey = "size"
+	case events.SearchSortTaken:
+		// same as File.takenTime()
+		fields[sortKey] = bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{"case": bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$photo.dateTaken", 0}}, 0}}, "then": "$photo.dateTaken"},
+				bson.M{"case": bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$video.creationTime", 0}}, 0}}, "then": "$video.creationTime"},
+			},
+			"default": "$modTime",
+		}}
+	default:
+		if score != nil {
+			sortKey = "score"
+		} else {
+			sortKey = "modTime"
+		}
+	}
+
+	direction := 1
+	if p.desc {
+		direction = -1
+	}
+	stages := bson.A{}
+	if len(fields) > 0 {
+		stages = append(stages, bson.M{"$addFields": fields})
+	}
+	return append(stages,
+		// stable order for results that compare equal, regardless of the direction
+		bson.M{"$sort": bson.D{{Key: sortKey, Value: direction}, {Key: "path", Value: 1}, {Key: "_id", Value: 1}}},
+		bson.M{"$skip": p.offset},
+		bson.M{"$limit": p.limit},
+	)
+}
+
+// apply sorts results that were loaded completely and returns the requested page
+func (p searchPage) apply(results []scoredFile) []scoredFile {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := &results[i], &results[j]
+		if p.desc {
+			a, b = b, a
+		}
+		switch p.sort {
+		case events.SearchSortName:
+			nameA, nameB := strings.ToLower(path.Base(a.Path)), strings.ToLower(path.Base(b.Path))
+			if nameA != nameB {
+				return nameA < nameB
+			}
+		case events.SearchSortModified:
+			if a.ModTime != b.ModTime {
+				return a.ModTime < b.ModTime
+			}
+		case events.SearchSortSize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case events.SearchSortTaken:
+			if takenA, takenB := a.takenTime(), b.takenTime(); takenA != takenB {
+				return takenA < takenB
+			}
+		default:
+			if a.Score != b.Score {
+				return a.Score < b.Score
+			}
+		}
+		// stable order for results that compare equal, regardless of the direction
+		return results[i].Path < results[j].Path
+	})
+
+	if p.offset >= len(results) {
+		return []scoredFile{}
+	}
+	end := min(p.offset+p.limit, len(results))
+	return results[p.offset:end]
+}
+
+// nextCursor returns the cursor for the page after this one, or "" if there are no more results
+func (p searchPage) nextCursor(total int64) string {
+	return messaging.NextSearchCursor(p.offset, p.limit, total)
+}
@@ -0,0 +1,115 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+func pageResults() []scoredFile {
+	return []scoredFile{
+		{File: File{Path: "/b/Zebra.jpg", Size: 10, ModTime: 300}, Score: 1},
+		{File: File{Path: "/a/apple.jpg", Size: 30, ModTime: 100}, Score: 3},
+		{File: File{Path: "/c/mango.jpg", Size: 20, ModTime: 200}, Score: 2},
+	}
+}
+
+func paths(results []scoredFile) []string {
+	paths := make([]string, 0, len(results))
+	for _, result := range results {
+		paths = append(paths, result.Path)
+	}
+	return paths
+}
+
+func TestSearchPageSort(t *testing.T) {
+	cases := []struct {
+		sort     string
+		order    string
+		expected []string
+	}{
+		{"", "", []string{"/a/apple.jpg", "/c/mango.jpg", "/b/Zebra.jpg"}},
+		{events.SearchSortName, "", []string{"/a/apple.jpg", "/c/mango.jpg", "/b/Zebra.jpg"}},
+		{events.SearchSortName, events.SearchOrderDesc, []string{"/b/Zebra.jpg", "/c/mango.jpg", "/a/apple.jpg"}},
+		{events.SearchSortModified, "", []string{"/b/Zebra.jpg", "/c/mango.jpg", "/a/apple.jpg"}},
+		{events.SearchSortSize, events.SearchOrderAsc, []string{"/b/Zebra.jpg", "/c/mango.jpg", "/a/apple.jpg"}},
+	}
+	for _, c := range cases {
+		page, err := newSearchPage(&events.SearchRequest{Sort: c.sort, Order: c.order})
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, paths(page.apply(pageResults())), c.sort+" "+c.order)
+	}
+}
+
+func TestSearchPageSortTaken(t *testing.T) {
+	results := pageResults()
+	// photos and videos are sorted by the capture time, other files by the modification time
+	results[0].Photo = &PhotoMetadata{DateTaken: 50}
+	results[2].Video = &events.VideoMetadata{CreationTime: 150}
+
+	page, err := newSearchPage(&events.SearchRequest{Sort: events.SearchSortTaken})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/c/mango.jpg", "/a/apple.jpg", "/b/Zebra.jpg"}, paths(page.apply(results)))
+}
+
+func TestSearchPageLimit(t *testing.T) {
+	page, err := newSearchPage(&events.SearchRequest{Limit: 2, Sort: events.SearchSortName})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a/apple.jpg", "/c/mango.jpg"}, paths(page.apply(pageResults())))
+
+	cursor := page.nextCursor(3)
+	assert.NotEmpty(t, cursor)
+
+	page, err = newSearchPage(&events.SearchRequest{Limit: 2, Sort: events.SearchSortName, Cursor: cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/b/Zebra.jpg"}, paths(page.apply(pageResults())))
+	assert.Empty(t, page.nextCursor(3))
+
+	page, err = newSearchPage(&events.SearchRequest{Offset: 5})
+	assert.NoError(t, err)
+	assert.Empty(t, page.apply(pageResults()))
+
+	page, err = newSearchPage(&events.SearchRequest{Limit: 100000})
+	assert.NoError(t, err)
+	assert.Equal(t, events.SearchMaxLimit, page.limit)
+}
+
+func TestSearchPageInvalid(t *testing.T) {
+	_, err := newSearchPage(&events.SearchRequest{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, messaging.ErrInvalidCursor)
+	_, err = newSearchPage(&events.SearchRequest{Sort: "color"})
+	assert.Error(t, err)
+	_, err = newSearchPage(&events.SearchRequest{Order: "up"})
+	assert.Error(t, err)
+	_, err = newSearchPage(&events.SearchRequest{Offset: -1})
+	assert.Error(t, err)
+}
+
+func TestSearchPageStages(t *testing.T) {
+	page, err := newSearchPage(&events.SearchRequest{Limit: 2, Offset: 4, Sort: events.SearchSortSize, Order: events.SearchOrderAsc})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.A{
+		bson.M{"$sort": bson.D{{Key: "size", Value: 1}, {Key: "path", Value: 1}, {Key: "_id", Value: 1}}},
+		bson.M{"$skip": 4},
+		bson.M{"$limit": 2},
+	}, page.stages(nil))
+
+	// relevance uses the score if the query has text, otherwise the newest files come first
+	page, err = newSearchPage(&events.SearchRequest{})
+	assert.NoError(t, err)
+	score := bson.M{"$meta": "textScore"}
+	stages := page.stages(score)
+	assert.Equal(t, bson.M{"$addFields": bson.M{"score": score}}, stages[0])
+	assert.Equal(t, bson.M{"$sort": bson.D{{Key: "score", Value: -1}, {Key: "path", Value: 1}, {Key: "_id", Value: 1}}}, stages[1])
+	assert.Equal(t, bson.M{"$sort": bson.D{{Key: "modTime", Value: -1}, {Key: "path", Value: 1}, {Key: "_id", Value: 1}}}, page.stages(nil)[0])
+
+	// the name is compared in lower case
+	page, err = newSearchPage(&events.SearchRequest{Sort: events.SearchSortName})
+	assert.NoError(t, err)
+	stages = page.stages(nil)
+	assert.Contains(t, stages[0].(bson.M)["$addFields"], "sortKey")
+	assert.Equal(t, bson.M{"$sort": bson.D{{Key: "sortKey", Value: 1}, {Key: "path", Value: 1}, {Key: "_id", Value: 1}}}, stages[1])
+}
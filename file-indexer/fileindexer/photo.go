@@ -0,0 +1,635 @@
+package fileindexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// maximum size of the metadata block that is read from a file
+const maxPhotoMetadataSize = 4 * 1024 * 1024
+
+// size of the file head that is searched for embedded XMP
+const xmpSearchSize = 256 * 1024
+
+// raw formats that are not identified as images by their mime type
+var photoExtensions = map[string]bool{
+	".heic": true, ".heif": true, ".avif": true,
+	".dng": true, ".cr2": true, ".cr3": true, ".crw": true, ".nef": true, ".nrw": true,
+	".arw": true, ".srf": true, ".sr2": true, ".orf": true, ".rw2": true, ".raf": true,
+	".pef": true, ".srw": true, ".x3f": true,
+}
+
+// uuid of the box in Canon CR3 files that holds the metadata
+const cr3MetadataUuid = "85c0b687820f11e08111f4ce462b6a48"
+
+// tags of the exif sub-IFD that Canon CR3 files store as a separate TIFF structure
+var cr3ExifFields = map[uint16]exif.FieldName{
+	0x9003: exif.DateTimeOriginal,
+	0x9011: exif.FieldName("OffsetTimeOriginal"),
+	0xA002: exif.PixelXDimension,
+	0xA003: exif.PixelYDimension,
+	0xA434: exif.LensModel,
+}
+
+var errNoPhotoMetadata = errors.New("no photo metadata found")
+
+// photoBlocks are the raw metadata blocks found in a file
+type photoBlocks struct {
+	// TIFF structures with EXIF tags
+	exif [][]byte
+	// XMP packet
+	xmp []byte
+	// dimensions of the image, if they could be read from the image header
+	config *image.Config
+}
+
+func isPhoto(mimeType string, name string) bool {
+	return strings.HasPrefix(mimeType, "image/") || photoExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// photoAnalyzer reads the photo metadata of an image file.
+// The metadata is cleared if the file is not a photo or has no metadata.
+type photoAnalyzer struct {
+	c *consumer
+}
+
+func (a photoAnalyzer) Name() string {
+	return "photo"
+}
+
+func (a photoAnalyzer) Handles(mimeType string, name string) bool {
+	return a.c.photoEnabled && isPhoto(mimeType, name)
+}
+
+func (a photoAnalyzer) Ranges(file *File) []ByteRange {
+	return []ByteRange{Head(xmpSearchSize)}
+}
+
+func (a photoAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	photo, err := readPhotoMetadata(r, file.Size)
+	if errors.Is(err, errNoPhotoMetadata) {
+		a.c.log.Debug("No photo metadata found", "path", file.Path)
+		proto.Photo.Set(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	a.c.log.Debug("Extracted photo metadata", "path", file.Path, "camera", photo.Camera, "dateTaken", photo.DateTaken)
+	proto.Photo.Set(photo)
+	return nil
+}
+
+func (a photoAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	proto.Photo.Set(nil)
+}
+
+// readPhotoMetadata reads EXIF and XMP metadata from JPEG, PNG, TIFF, HEIF and common raw formats
+func readPhotoMetadata(r io.ReaderAt, size int64) (*PhotoMetadata, error) {
+	head := readAt(r, 0, min(size, xmpSearchSize))
+	if len(head) < 16 {
+		return nil, errNoPhotoMetadata
+	}
+
+	blocks := &photoBlocks{}
+	var err error
+	switch {
+	case head[0] == 0xFF && head[1] == 0xD8:
+		err = jpegBlocks(r, 0, size, blocks)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		err = pngBlocks(r, size, blocks)
+	case bytes.HasPrefix(head, []byte("FUJIFILMCCD-RAW")) && len(head) >= 92:
+		// Fuji raw files contain a JPEG preview with the metadata
+		offset := int64(binary.BigEndian.Uint32(head[84:88]))
+		length := int64(binary.BigEndian.Uint32(head[88:92]))
+		err = jpegBlocks(r, offset, min(offset+length, size), blocks)
+	case string(head[4:8]) == "ftyp":
+		err = bmffBlocks(r, size, blocks)
+	case isTiffHeader(head):
+		// TIFF and raw formats based on TIFF, some of them use a different marker
+		data := readAt(r, 0, min(size, maxPhotoMetadataSize))
+		if data[0] == 'I' {
+			copy(data, "II*\x00")
+		} else {
+			copy(data, "MM\x00*")
+		}
+		blocks.exif = append(blocks.exif, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if blocks.xmp == nil {
+		blocks.xmp = findXmp(head)
+	}
+
+	photo := &PhotoMetadata{}
+	for i, data := range blocks.exif {
+		x, err := exif.Decode(bytes.NewReader(data))
+		if x == nil || (err != nil && exif.IsCriticalError(err)) {
+			continue
+		}
+		if i > 0 {
+			// the exif sub-IFD of CR3 files is a separate TIFF structure
+			if tif, err := tiff.Decode(bytes.NewReader(data)); err == nil && len(tif.Dirs) > 0 {
+				x.LoadTags(tif.Dirs[0], cr3ExifFields, false)
+			}
+		}
+		applyExif(photo, x)
+	}
+	if blocks.xmp != nil {
+		applyXmp(photo, blocks.xmp)
+	}
+	if photo.Width == 0 && blocks.config != nil {
+		photo.Width = blocks.config.Width
+		photo.Height = blocks.config.Height
+	}
+	photo.Camera = strings.TrimSpace(photo.Make + " " + strings.TrimPrefix(photo.Model, photo.Make+" "))
+
+	if *photo == (PhotoMetadata{}) {
+		return nil, errNoPhotoMetadata
+	}
+	return photo, nil
+}
+
+func isTiffHeader(head []byte) bool {
+	switch string(head[:4]) {
+	// TIFF, DNG, CR2, NEF, ARW, PEF, ...
+	case "II*\x00", "MM\x00*",
+		// Olympus ORF
+		"IIRO", "IIRS", "MMOR",
+		// Panasonic RW2
+		"IIU\x00":
+		return true
+	}
+	return false
+}
+
+func applyExif(photo *PhotoMetadata, x *exif.Exif) {
+	if t, err := x.DateTime(); err == nil && photo.DateTaken == 0 {
+		photo.DateTaken = t.Unix()
+	}
+	setString(&photo.Make, exifString(x, exif.Make))
+	setString(&photo.Model, exifString(x, exif.Model))
+	setString(&photo.Lens, exifString(x, exif.LensModel))
+	setInt(&photo.Orientation, exifInt(x, exif.Orientation))
+	if width, height := exifInt(x, exif.PixelXDimension), exifInt(x, exif.PixelYDimension); width > 0 && height > 0 {
+		setInt(&photo.Width, width)
+		setInt(&photo.Height, height)
+	} else if width, height := exifInt(x, exif.ImageWidth), exifInt(x, exif.ImageLength); width > 0 && height > 0 {
+		setInt(&photo.Width, width)
+		setInt(&photo.Height, height)
+	}
+	if lat, long, err := x.LatLong(); err == nil && photo.Location == nil && validLocation(lat, long) {
+		photo.Location = newGeoPoint(lat, long)
+	}
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	i, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+var xmpRegex = regexp.MustCompile(`(?s)<x:xmpmeta.*?</x:xmpmeta>`)
+
+// xmpProperties are the XMP properties that are read, by namespace prefix and name
+var xmpProperties = []string{
+	"exif:DateTimeOriginal", "xmp:CreateDate", "photoshop:DateCreated",
+	"tiff:Make", "tiff:Model", "aux:Lens", "exifEX:LensModel",
+	"tiff:Orientation", "exif:PixelXDimension", "exif:PixelYDimension",
+	"exif:GPSLatitude", "exif:GPSLongitude",
+}
+
+var xmpPropertyRegexes = func() map[string]*regexp.Regexp {
+	regexes := make(map[string]*regexp.Regexp)
+	for _, property := range xmpProperties {
+		name := regexp.QuoteMeta(property)
+		// properties can be attributes or elements
+		regexes[property] = regexp.MustCompile(name + `="([^"]*)"|<` + name + `>([^<]*)</` + name + `>`)
+	}
+	return regexes
+}()
+
+var xmpCoordinateRegex = regexp.MustCompile(`^(\d+),(\d+(?:\.\d+)?)(?:,(\d+(?:\.\d+)?))?([NSEW])$`)
+
+func findXmp(data []byte) []byte {
+	return xmpRegex.Find(data)
+}
+
+// applyXmp sets the fields that were not found in the EXIF data from the XMP packet
+func applyXmp(photo *PhotoMetadata, xmp []byte) {
+	get := func(property string) string {
+		match := xmpPropertyRegexes[property].FindSubmatch(xmp)
+		if match == nil {
+			return ""
+		}
+		return strings.TrimSpace(string(match[1]) + string(match[2]))
+	}
+
+	if photo.DateTaken == 0 {
+		for _, property := range []string{"exif:DateTimeOriginal", "xmp:CreateDate", "photoshop:DateCreated"} {
+			if t, ok := parseXmpDate(get(property)); ok {
+				photo.DateTaken = t.Unix()
+				break
+			}
+		}
+	}
+	setString(&photo.Make, get("tiff:Make"))
+	setString(&photo.Model, get("tiff:Model"))
+	setString(&photo.Lens, get("exifEX:LensModel"))
+	setString(&photo.Lens, get("aux:Lens"))
+	orientation, _ := strconv.Atoi(get("tiff:Orientation"))
+	setInt(&photo.Orientation, orientation)
+	width, _ := strconv.Atoi(get("exif:PixelXDimension"))
+	height, _ := strconv.Atoi(get("exif:PixelYDimension"))
+	if width > 0 && height > 0 {
+		setInt(&photo.Width, width)
+		setInt(&photo.Height, height)
+	}
+	if photo.Location == nil {
+		lat, latOk := parseXmpCoordinate(get("exif:GPSLatitude"))
+		long, longOk := parseXmpCoordinate(get("exif:GPSLongitude"))
+		if latOk && longOk && validLocation(lat, long) {
+			photo.Location = newGeoPoint(lat, long)
+		}
+	}
+}
+
+func parseXmpDate(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseXmpCoordinate parses GPS coordinates in the XMP format "DDD,MM.mmk" or "DDD,MM,SSk"
+func parseXmpCoordinate(value string) (float64, bool) {
+	match := xmpCoordinateRegex.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	degrees, _ := strconv.ParseFloat(match[1], 64)
+	minutes, _ := strconv.ParseFloat(match[2], 64)
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	coordinate := degrees + minutes/60 + seconds/3600
+	if match[4] == "S" || match[4] == "W" {
+		coordinate = -coordinate
+	}
+	return coordinate, true
+}
+
+func validLocation(lat float64, long float64) bool {
+	return !math.IsNaN(lat) && !math.IsNaN(long) &&
+		lat >= -90 && lat <= 90 && long >= -180 && long <= 180 &&
+		(lat != 0 || long != 0)
+}
+
+func newGeoPoint(lat float64, long float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{long, lat}}
+}
+
+func setString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func setInt(field *int, value int) {
+	if *field == 0 {
+		*field = value
+	}
+}
+
+// jpegBlocks reads the APP1 segments of a JPEG file between start and end
+func jpegBlocks(r io.ReaderAt, start int64, end int64, blocks *photoBlocks) error {
+	config, _, err := image.DecodeConfig(io.NewSectionReader(r, start, end-start))
+	if err == nil {
+		blocks.config = &config
+	}
+
+	offset := start + 2
+	for offset+4 <= end {
+		header := readAt(r, offset, 4)
+		if len(header) < 4 || header[0] != 0xFF {
+			return nil
+		}
+		marker := header[1]
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// markers without payload
+			offset += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// start of the image data
+			return nil
+		}
+		length := int64(binary.BigEndian.Uint16(header[2:4]))
+		if marker == 0xE1 {
+			payload := readAt(r, offset+4, length-2)
+			switch {
+			case bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+				blocks.exif = append(blocks.exif, payload[6:])
+			case bytes.HasPrefix(payload, []byte("http://ns.adobe.com/xap/1.0/\x00")):
+				blocks.xmp = payload
+			}
+		}
+		offset += 2 + length
+	}
+	return nil
+}
+
+// pngBlocks reads the eXIf chunk and the XMP text chunk of a PNG file
+func pngBlocks(r io.ReaderAt, size int64, blocks *photoBlocks) error {
+	config, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+	if err == nil {
+		blocks.config = &config
+	}
+
+	offset := int64(8)
+	for offset+8 <= size {
+		header := readAt(r, offset, 8)
+		if len(header) < 8 {
+			return nil
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		switch string(header[4:8]) {
+		case "eXIf":
+			blocks.exif = append(blocks.exif, readAt(r, offset+8, min(length, maxPhotoMetadataSize)))
+		case "iTXt":
+			data := readAt(r, offset+8, min(length, maxPhotoMetadataSize))
+			if bytes.HasPrefix(data, []byte("XML:com.adobe.xmp\x00")) {
+				blocks.xmp = findXmp(data)
+			}
+		case "IEND":
+			return nil
+		}
+		// chunk data is followed by a crc
+		offset += 12 + length
+	}
+	return nil
+}
+
+type bmffBox struct {
+	typ string
+	// offset and size of the payload
+	offset int64
+	size   int64
+}
+
+// bmffBlocks reads the metadata of files based on the ISO base media file format (HEIF, AVIF, CR3)
+func bmffBlocks(r io.ReaderAt, size int64, blocks *photoBlocks) error {
+	boxes := bmffBoxes(r, 0, size)
+
+	if meta := findBox(boxes, "meta"); meta != nil {
+		// HEIF: the metadata is stored as an item that is located with the iloc box
+		children := bmffBoxes(r, meta.offset+4, meta.offset+meta.size)
+		iinf := findBox(children, "iinf")
+		iloc := findBox(children, "iloc")
+		if iinf != nil && iloc != nil {
+			exifItem, xmpItem := heifItems(r, iinf)
+			locations := heifLocations(readAt(r, iloc.offset, min(iloc.size, maxPhotoMetadataSize)))
+			if location, ok := locations[exifItem]; ok && exifItem != 0 {
+				data := readAt(r, location[0], min(location[1], maxPhotoMetadataSize))
+				if len(data) > 4 {
+					tiffOffset := int64(binary.BigEndian.Uint32(data[0:4]))
+					if 4+tiffOffset < int64(len(data)) {
+						data = bytes.TrimPrefix(data[4+tiffOffset:], []byte("Exif\x00\x00"))
+						blocks.exif = append(blocks.exif, data)
+					}
+				}
+			}
+			if location, ok := locations[xmpItem]; ok && xmpItem != 0 {
+				blocks.xmp = findXmp(readAt(r, location[0], min(location[1], maxPhotoMetadataSize)))
+			}
+		}
+	}
+
+	if moov := findBox(boxes, "moov"); moov != nil {
+		// CR3: the metadata is stored in TIFF structures in a uuid box
+		for _, box := range bmffBoxes(r, moov.offset, moov.offset+moov.size) {
+			if box.typ != "uuid" || hex.EncodeToString(readAt(r, box.offset, 16)) != cr3MetadataUuid {
+				continue
+			}
+			for _, child := range bmffBoxes(r, box.offset+16, box.offset+box.size) {
+				if child.typ == "CMT1" || child.typ == "CMT2" || child.typ == "CMT4" {
+					blocks.exif = append(blocks.exif, readAt(r, child.offset, min(child.size, maxPhotoMetadataSize)))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func bmffBoxes(r io.ReaderAt, start int64, end int64) []bmffBox {
+	boxes := make([]bmffBox, 0)
+	offset := start
+	for offset+8 <= end {
+		header := readAt(r, offset, 16)
+		if len(header) < 8 {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// box extends to the end
+			size = end - offset
+		case 1:
+			if len(header) < 16 {
+				return boxes
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			break
+		}
+		boxes = append(boxes, bmffBox{
+			typ:    string(header[4:8]),
+			offset: offset + headerSize,
+			size:   size - headerSize,
+		})
+		offset += size
+	}
+	return boxes
+}
+
+func findBox(boxes []bmffBox, typ string) *bmffBox {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// heifItems returns the ids of the Exif and XMP items from the item info box
+func heifItems(r io.ReaderAt, iinf *bmffBox) (exifItem uint32, xmpItem uint32) {
+	header := readAt(r, iinf.offset, 6)
+	if len(header) < 6 {
+		return
+	}
+	entriesOffset := iinf.offset + 6
+	if header[0] != 0 {
+		entriesOffset += 2
+	}
+	for _, infe := range bmffBoxes(r, entriesOffset, iinf.offset+iinf.size) {
+		if infe.typ != "infe" {
+			continue
+		}
+		data := readAt(r, infe.offset, min(infe.size, 64))
+		if len(data) < 12 || data[0] < 2 {
+			continue
+		}
+		var id uint32
+		var itemType string
+		if data[0] == 2 {
+			id = uint32(binary.BigEndian.Uint16(data[4:6]))
+			itemType = string(data[8:12])
+		} else if len(data) >= 14 {
+			id = binary.BigEndian.Uint32(data[4:8])
+			itemType = string(data[10:14])
+		}
+		switch itemType {
+		case "Exif":
+			exifItem = id
+		case "mime":
+			// XMP is stored as a mime item with the content type application/rdf+xml
+			if bytes.Contains(data, []byte("application/rdf+xml")) {
+				xmpItem = id
+			}
+		}
+	}
+	return
+}
+
+// heifLocations parses the item location box, returning offset and length of the first extent of each item
+func heifLocations(data []byte) map[uint32][2]int64 {
+	locations := make(map[uint32][2]int64)
+	pos := 0
+	readN := func(n int) (uint64, bool) {
+		if n == 0 {
+			return 0, true
+		}
+		if pos+n > len(data) {
+			return 0, false
+		}
+		var v uint64
+		for _, b := range data[pos : pos+n] {
+			v = v<<8 | uint64(b)
+		}
+		pos += n
+		return v, true
+	}
+
+	version, ok := readN(1)
+	if !ok {
+		return locations
+	}
+	pos += 3
+	sizes, ok := readN(2)
+	if !ok {
+		return locations
+	}
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+	itemCount, ok := readN(map[bool]int{true: 4, false: 2}[version == 2])
+	if !ok {
+		return locations
+	}
+
+	for i := uint64(0); i < itemCount; i++ {
+		id, ok := readN(map[bool]int{true: 4, false: 2}[version == 2])
+		if !ok {
+			return locations
+		}
+		constructionMethod := uint64(0)
+		if version > 0 {
+			if constructionMethod, ok = readN(2); !ok {
+				return locations
+			}
+		}
+		if _, ok = readN(2); !ok {
+			return locations
+		}
+		baseOffset, ok := readN(baseOffsetSize)
+		if !ok {
+			return locations
+		}
+		extentCount, ok := readN(2)
+		if !ok {
+			return locations
+		}
+		for e := uint64(0); e < extentCount; e++ {
+			if _, ok = readN(indexSize); !ok {
+				return locations
+			}
+			extentOffset, ok := readN(offsetSize)
+			if !ok {
+				return locations
+			}
+			extentLength, ok := readN(lengthSize)
+			if !ok {
+				return locations
+			}
+			// only items stored in the file itself are supported
+			if e == 0 && constructionMethod&0xF == 0 {
+				locations[uint32(id)] = [2]int64{int64(baseOffset + extentOffset), int64(extentLength)}
+			}
+		}
+	}
+	return locations
+}
+
+// readAt reads up to n bytes at the offset, returning fewer bytes at the end of the file
+func readAt(r io.ReaderAt, offset int64, n int64) []byte {
+	if n <= 0 || offset < 0 {
+		return []byte{}
+	}
+	buf := make([]byte, n)
+	read, _ := r.ReadAt(buf, offset)
+	return buf[:read]
+}
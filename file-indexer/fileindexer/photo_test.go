@@ -0,0 +1,276 @@
+package fileindexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tiffEntry is a tag of a test IFD
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiEntry(tag uint16, value string) tiffEntry {
+	return tiffEntry{tag, 2, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func shortEntry(tag uint16, value uint16) tiffEntry {
+	return tiffEntry{tag, 3, 1, binary.LittleEndian.AppendUint16(nil, value)}
+}
+
+func longEntry(tag uint16, value uint32) tiffEntry {
+	return tiffEntry{tag, 4, 1, binary.LittleEndian.AppendUint32(nil, value)}
+}
+
+func rationalEntry(tag uint16, values ...float64) tiffEntry {
+	data := make([]byte, 0)
+	for _, value := range values {
+		data = binary.LittleEndian.AppendUint32(data, uint32(math.Round(value*1000)))
+		data = binary.LittleEndian.AppendUint32(data, 1000)
+	}
+	return tiffEntry{tag, 5, uint32(len(values)), data}
+}
+
+func ifdSize(entries []tiffEntry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, entry := range entries {
+		if len(entry.value) > 4 {
+			size += len(entry.value) + len(entry.value)%2
+		}
+	}
+	return size
+}
+
+// buildTiff returns a little endian TIFF structure with IFD0 and optional Exif and GPS IFDs
+func buildTiff(ifd0 []tiffEntry, exifIfd []tiffEntry, gpsIfd []tiffEntry) []byte {
+	// the pointers to the sub IFDs are part of IFD0
+	if exifIfd != nil {
+		ifd0 = append(ifd0, longEntry(0x8769, 0))
+	}
+	if gpsIfd != nil {
+		ifd0 = append(ifd0, longEntry(0x8825, 0))
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exifIfd)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case 0x8769:
+			ifd0[i] = longEntry(0x8769, uint32(exifOffset))
+		case 0x8825:
+			ifd0[i] = longEntry(0x8825, uint32(gpsOffset))
+		}
+	}
+
+	data := []byte("II*\x00\x08\x00\x00\x00")
+	for _, ifd := range [][]tiffEntry{ifd0, exifIfd, gpsIfd} {
+		if ifd == nil {
+			continue
+		}
+		valueOffset := len(data) + 2 + 12*len(ifd) + 4
+		values := make([]byte, 0)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(ifd)))
+		for _, entry := range ifd {
+			data = binary.LittleEndian.AppendUint16(data, entry.tag)
+			data = binary.LittleEndian.AppendUint16(data, entry.typ)
+			data = binary.LittleEndian.AppendUint32(data, entry.count)
+			if len(entry.value) <= 4 {
+				value := make([]byte, 4)
+				copy(value, entry.value)
+				data = append(data, value...)
+				continue
+			}
+			data = binary.LittleEndian.AppendUint32(data, uint32(valueOffset+len(values)))
+			values = append(values, entry.value...)
+			if len(entry.value)%2 == 1 {
+				values = append(values, 0)
+			}
+		}
+		data = binary.LittleEndian.AppendUint32(data, 0)
+		data = append(data, values...)
+	}
+	return data
+}
+
+func testExif() []byte {
+	return buildTiff(
+		[]tiffEntry{
+			asciiEntry(0x010F, "Canon"),
+			asciiEntry(0x0110, "Canon EOS R6"),
+			shortEntry(0x0112, 6),
+		},
+		[]tiffEntry{
+			asciiEntry(0x9003, "2023:07:14 18:30:05"),
+			longEntry(0xA002, 6000),
+			longEntry(0xA003, 4000),
+			asciiEntry(0xA434, "RF24-105mm F4 L IS USM"),
+		},
+		[]tiffEntry{
+			asciiEntry(0x0001, "N"),
+			rationalEntry(0x0002, 52, 31, 12),
+			asciiEntry(0x0003, "E"),
+			rationalEntry(0x0004, 13, 24, 36),
+		},
+	)
+}
+
+func assertTestExif(t *testing.T, photo *PhotoMetadata) {
+	assert.Equal(t, time.Date(2023, 7, 14, 18, 30, 5, 0, time.Local).Unix(), photo.DateTaken)
+	assert.Equal(t, "Canon", photo.Make)
+	assert.Equal(t, "Canon EOS R6", photo.Model)
+	assert.Equal(t, "Canon EOS R6", photo.Camera)
+	assert.Equal(t, "RF24-105mm F4 L IS USM", photo.Lens)
+	assert.Equal(t, 6000, photo.Width)
+	assert.Equal(t, 4000, photo.Height)
+	assert.Equal(t, 6, photo.Orientation)
+	if assert.NotNil(t, photo.Location) {
+		assert.Equal(t, "Point", photo.Location.Type)
+		assert.InDelta(t, 13.41, photo.Location.Coordinates[0], 0.0001)
+		assert.InDelta(t, 52.52, photo.Location.Coordinates[1], 0.0001)
+	}
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func pngChunk(typ string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(append([]byte(typ), payload...)))
+}
+
+func bmffBoxData(typ string, payload ...[]byte) []byte {
+	content := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(len(content)+8))
+	box = append(box, typ...)
+	return append(box, content...)
+}
+
+func readTestPhoto(data []byte) (*PhotoMetadata, error) {
+	return readPhotoMetadata(bytes.NewReader(data), int64(len(data)))
+}
+
+func TestIsPhoto(t *testing.T) {
+	assert.True(t, isPhoto("image/jpeg", "/a/b.jpg"))
+	assert.True(t, isPhoto("", "/a/IMG_0001.CR3"))
+	assert.True(t, isPhoto("application/octet-stream", "/a/b.heic"))
+	assert.False(t, isPhoto("video/mp4", "/a/b.mp4"))
+	assert.False(t, isPhoto("text/plain", "/a/b.txt"))
+}
+
+func TestReadPhotoMetadataTiff(t *testing.T) {
+	photo, err := readTestPhoto(testExif())
+	assert.NoError(t, err)
+	assertTestExif(t, photo)
+
+	// Olympus raw files use a different marker
+	orf := testExif()
+	copy(orf, "IIRO")
+	photo, err = readTestPhoto(orf)
+	assert.NoError(t, err)
+	assertTestExif(t, photo)
+}
+
+func TestReadPhotoMetadataJpeg(t *testing.T) {
+	data := []byte{0xFF, 0xD8}
+	data = append(data, jpegSegment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00"))...)
+	data = append(data, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), testExif()...))...)
+	data = append(data, jpegSegment(0xDA, make([]byte, 10))...)
+	data = append(data, 0xFF, 0xD9)
+
+	photo, err := readTestPhoto(data)
+	assert.NoError(t, err)
+	assertTestExif(t, photo)
+}
+
+func TestReadPhotoMetadataPng(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, 30, 20))))
+	encoded := buf.Bytes()
+
+	// insert the eXIf chunk after the IHDR chunk
+	ihdrEnd := 8 + 8 + 13 + 4
+	data := append([]byte{}, encoded[:ihdrEnd]...)
+	data = append(data, pngChunk("eXIf", buildTiff([]tiffEntry{asciiEntry(0x010F, "Apple"), asciiEntry(0x0110, "iPhone 14")}, nil, nil))...)
+	data = append(data, encoded[ihdrEnd:]...)
+
+	photo, err := readTestPhoto(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple iPhone 14", photo.Camera)
+	// dimensions are taken from the image header
+	assert.Equal(t, 30, photo.Width)
+	assert.Equal(t, 20, photo.Height)
+}
+
+func TestReadPhotoMetadataHeif(t *testing.T) {
+	ftyp := bmffBoxData("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	payload := append([]byte("\x00\x00\x00\x06Exif\x00\x00"), testExif()...)
+
+	infe := bmffBoxData("infe", []byte{2, 0, 0, 0}, []byte{0, 1, 0, 0}, []byte("hvc1\x00"))
+	infeExif := bmffBoxData("infe", []byte{2, 0, 0, 0}, []byte{0, 2, 0, 0}, []byte("Exif\x00"))
+	iinf := bmffBoxData("iinf", []byte{0, 0, 0, 0, 0, 2}, infe, infeExif)
+
+	ilocSize := 8 + 4 + 2 + 2 + 2*(2+2+2+4+4)
+	metaSize := 8 + 4 + len(iinf) + ilocSize
+	mdatOffset := len(ftyp) + metaSize + 8
+
+	iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 2}
+	iloc = append(iloc, 0, 1, 0, 0, 0, 1)
+	iloc = binary.BigEndian.AppendUint32(iloc, uint32(mdatOffset+len(payload)))
+	iloc = binary.BigEndian.AppendUint32(iloc, 16)
+	iloc = append(iloc, 0, 2, 0, 0, 0, 1)
+	iloc = binary.BigEndian.AppendUint32(iloc, uint32(mdatOffset))
+	iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(payload)))
+
+	meta := bmffBoxData("meta", []byte{0, 0, 0, 0}, iinf, bmffBoxData("iloc", iloc))
+	mdat := bmffBoxData("mdat", payload, make([]byte, 16))
+	data := bytes.Join([][]byte{ftyp, meta, mdat}, nil)
+
+	photo, err := readTestPhoto(data)
+	assert.NoError(t, err)
+	assertTestExif(t, photo)
+}
+
+func TestReadPhotoMetadataXmp(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description
+		xmp:CreateDate="2021-05-01T10:20:30"
+		tiff:Make="NIKON CORPORATION" exif:GPSLatitude="51,30.5N" exif:GPSLongitude="0,7.5W">
+		<tiff:Model>NIKON Z 6</tiff:Model>
+		<aux:Lens>NIKKOR Z 50mm f/1.8 S</aux:Lens>
+		</rdf:Description></rdf:RDF></x:xmpmeta>`
+	data := []byte{0xFF, 0xD8}
+	data = append(data, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"+xmp))...)
+	data = append(data, 0xFF, 0xD9)
+
+	photo, err := readTestPhoto(data)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 5, 1, 10, 20, 30, 0, time.Local).Unix(), photo.DateTaken)
+	assert.Equal(t, "NIKON CORPORATION NIKON Z 6", photo.Camera)
+	assert.Equal(t, "NIKKOR Z 50mm f/1.8 S", photo.Lens)
+	if assert.NotNil(t, photo.Location) {
+		assert.InDelta(t, -0.125, photo.Location.Coordinates[0], 0.0001)
+		assert.InDelta(t, 51.5083, photo.Location.Coordinates[1], 0.0001)
+	}
+}
+
+func TestReadPhotoMetadataMissing(t *testing.T) {
+	_, err := readTestPhoto([]byte("just some text that is not an image"))
+	assert.ErrorIs(t, err, errNoPhotoMetadata)
+
+	_, err = readTestPhoto([]byte{0xFF, 0xD8, 0xFF, 0xD9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	assert.ErrorIs(t, err, errNoPhotoMetadata)
+}
@@ -0,0 +1,535 @@
+package fileindexer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// QuerySyntaxError is returned for search queries that can not be parsed
+type QuerySyntaxError struct {
+	// position in the query (in characters, starting at 0)
+	Pos int
+	Msg string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("invalid search query at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// searchQuery is a parsed search query.
+//
+// The syntax is a list of terms separated by whitespace:
+//
+//	word                   file path or content contains the word
+//	"exact phrase"         file path or content contains the phrase
+//	type:image             mime type category (image, video, audio, text, document, pdf, archive)
+//	size:>100M             file size, with comparison operator (>, >=, <, <=, =) and unit (K, M, G, T)
+//	modified:<30d          modified less than 30 days ago (units h, d, w, m, y)
+//	modified:>2023         modified after 2023 (dates as YYYY, YYYY-MM or YYYY-MM-DD)
+//	in:photos/2023         located in the directory, relative to the space
+//	in:home/photos         located in the directory of the space entry "home" only
+//	ext:pdf                file extension
+//	is:dir                 only directories (is:file for only files)
+//	taken:2023             photo taken or video recorded in 2023 (same values as modified:)
+//	camera:canon           camera manufacturer or model of a photo contains the value
+//	lens:50mm              lens of a photo contains the value
+//	orientation:landscape  photo orientation (landscape, portrait, square)
+//	has:location           photo has a GPS location
+//	near:52.5,13.4,5       photo taken within 5 km of latitude and longitude (default radius 10 km)
+//	duration:>10m          duration of a video or audio file, with comparison operator and unit (s, m, h)
+//	artist:beatles         artist or album artist of an audio file contains the value
+//	album:abbey            album of an audio file contains the value
+//	genre:jazz             genre of an audio file contains the value
+//
+// Any term can be negated with a leading "-". Values containing spaces can be quoted (in:"my photos").
+// Multiple filters with the same key match any of the values, all other terms must match.
+type searchQuery struct {
+	words    []string
+	phrases  []string
+	excluded []string
+
+	// directories relative to the space, or to a single space entry if the first element names one
+	in    []string
+	notIn []string
+
+	filters []bson.M
+}
+
+type queryToken struct {
+	pos     int
+	negated bool
+	key     string
+	value   string
+	quoted  bool
+}
+
+var filterKeys = map[string]func(value string, now time.Time) (bson.M, error){
+	"type":     typeFilter,
+	"size":     sizeFilter,
+	"modified": modifiedFilter,
+	"ext":      extFilter,
+	"is":       isFilter,
+
+	"taken":       takenFilter,
+	"camera":      cameraFilter,
+	"lens":        lensFilter,
+	"orientation": orientationFilter,
+	"has":         hasFilter,
+	"near":        nearFilter,
+	"duration":    durationFilter,
+
+	"artist": artistFilter,
+	"album":  albumFilter,
+	"genre":  genreFilter,
+}
+
+var typeRegexes = map[string]string{
+	"image":    "^image/",
+	"video":    "^video/",
+	"audio":    "^audio/",
+	"text":     "^text/",
+	"pdf":      "^application/pdf$",
+	"document": `^(text/|application/(pdf|msword|rtf|epub\+zip|vnd\.openxmlformats-officedocument\.|vnd\.oasis\.opendocument\.|vnd\.ms-))`,
+	"archive":  `^application/(zip|gzip|x-gzip|x-tar|x-7z-compressed|x-rar-compressed|vnd\.rar|x-bzip2|x-xz|zstd)$`,
+}
+
+var sizeRegex = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d+(?:\.\d+)?)([kmgt]?)(?:i?b)?$`)
+var relativeTimeRegex = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d+)([hdwmy])$`)
+var absoluteTimeRegex = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d{4})(?:-(\d{2})(?:-(\d{2}))?)?$`)
+
+var durationRegex = regexp.MustCompile(`^(>=|<=|>|<|=)?(\d+(?:\.\d+)?)([smh])$`)
+var nearRegex = regexp.MustCompile(`^(-?\d+(?:\.\d+)?),(-?\d+(?:\.\d+)?)(?:,(\d+(?:\.\d+)?)(?:km)?)?$`)
+
+// radius for near: if the query does not contain one, in kilometers
+const defaultNearRadius = 10
+
+// equatorial radius of the earth in kilometers, for converting distances to radians
+const earthRadius = 6378.1
+
+var comparisonOperators = map[string]string{
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+	"=":  "$eq",
+	"":   "$eq",
+}
+
+// parseQuery parses the query syntax, relative times are resolved against now
+func parseQuery(query string, now time.Time) (*searchQuery, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &QuerySyntaxError{0, "empty query"}
+	}
+
+	q := &searchQuery{}
+	// filters with the same key and negation are combined with "or"
+	type group struct {
+		negated bool
+		conds   bson.A
+	}
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+
+	for _, token := range tokens {
+		if token.key == "in" {
+			if token.value == "" {
+				return nil, &QuerySyntaxError{token.pos, "missing directory for in:"}
+			}
+			dir := path.Clean("/" + token.value)
+			if token.negated {
+				q.notIn = append(q.notIn, dir)
+			} else {
+				q.in = append(q.in, dir)
+			}
+			continue
+		}
+
+		if filter, ok := filterKeys[token.key]; ok {
+			if token.value == "" {
+				return nil, &QuerySyntaxError{token.pos, fmt.Sprintf("missing value for %s:", token.key)}
+			}
+			cond, err := filter(strings.ToLower(token.value), now)
+			if err != nil {
+				return nil, &QuerySyntaxError{token.pos, err.Error()}
+			}
+			groupKey := token.key
+			if token.negated {
+				groupKey = "-" + groupKey
+			}
+			g, ok := groups[groupKey]
+			if !ok {
+				g = &group{negated: token.negated}
+				groups[groupKey] = g
+				order = append(order, groupKey)
+			}
+			g.conds = append(g.conds, cond)
+			continue
+		}
+
+		switch {
+		case token.negated:
+			q.excluded = append(q.excluded, token.value)
+		case token.quoted:
+			q.phrases = append(q.phrases, token.value)
+		default:
+			q.words = append(q.words, token.value)
+		}
+	}
+
+	for _, groupKey := range order {
+		g := groups[groupKey]
+		switch {
+		case g.negated:
+			q.filters = append(q.filters, bson.M{"$nor": g.conds})
+		case len(g.conds) == 1:
+			q.filters = append(q.filters, g.conds[0].(bson.M))
+		default:
+			q.filters = append(q.filters, bson.M{"$or": g.conds})
+		}
+	}
+
+	return q, nil
+}
+
+// text returns the search string for the text index, or "" if the query has no positive terms
+func (q *searchQuery) text() string {
+	if len(q.words) == 0 && len(q.phrases) == 0 {
+		return ""
+	}
+	terms := make([]string, 0, len(q.words)+len(q.phrases)+len(q.excluded))
+	terms = append(terms, q.words...)
+	for _, phrase := range q.phrases {
+		terms = append(terms, strconv.Quote(phrase))
+	}
+	for _, excluded := range q.excluded {
+		if strings.ContainsFunc(excluded, unicode.IsSpace) {
+			excluded = strconv.Quote(excluded)
+		}
+		terms = append(terms, "-"+excluded)
+	}
+	return strings.Join(terms, " ")
+}
+
+// fileFilters returns the conditions on files, excluded terms are applied to the path
+// if the query can not be run against the text index
+func (q *searchQuery) fileFilters() []bson.M {
+	filters := append([]bson.M{}, q.filters...)
+	if q.text() == "" {
+		filters = append(filters, q.excludedFilters()...)
+	}
+	return filters
+}
+
+// excludedFilters returns conditions that exclude files with the excluded terms in their path
+func (q *searchQuery) excludedFilters() []bson.M {
+	filters := make([]bson.M, 0, len(q.excluded))
+	for _, excluded := range q.excluded {
+		filters = append(filters, bson.M{"searchWords": bson.M{"$not": bson.M{"$regex": regexp.QuoteMeta(excluded), "$options": "i"}}})
+	}
+	return filters
+}
+
+// terms returns the positive words and phrases of the query
+func (q *searchQuery) terms() []string {
+	return append(slices.Clone(q.words), q.phrases...)
+}
+
+func tokenizeQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	tokens := make([]queryToken, 0)
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		token := queryToken{pos: i}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.negated = true
+			i++
+		}
+
+		var sb strings.Builder
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			if runes[i] != '"' {
+				if runes[i] == ':' && token.key == "" && !token.quoted {
+					if _, ok := filterKeys[strings.ToLower(sb.String())]; ok || strings.EqualFold(sb.String(), "in") {
+						token.key = strings.ToLower(sb.String())
+						sb.Reset()
+						i++
+						continue
+					}
+				}
+				sb.WriteRune(runes[i])
+				i++
+				continue
+			}
+
+			// quoted phrase or value
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &QuerySyntaxError{i, "missing closing quote"}
+			}
+			sb.WriteString(string(runes[i+1 : end]))
+			token.quoted = true
+			i = end + 1
+		}
+
+		token.value = sb.String()
+		if token.key == "" && token.value == "" {
+			if token.quoted {
+				return nil, &QuerySyntaxError{token.pos, "empty phrase"}
+			}
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func typeFilter(value string, now time.Time) (bson.M, error) {
+	regex, ok := typeRegexes[value]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s", value)
+	}
+	return bson.M{"mime": bson.M{"$regex": regex}}, nil
+}
+
+func sizeFilter(value string, now time.Time) (bson.M, error) {
+	match := sizeRegex.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("invalid size %s", value)
+	}
+	size, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size %s", value)
+	}
+	switch match[3] {
+	case "k":
+		size *= 1 << 10
+	case "m":
+		size *= 1 << 20
+	case "g":
+		size *= 1 << 30
+	case "t":
+		size *= 1 << 40
+	}
+	return bson.M{
+		"isDir": false,
+		"size":  bson.M{comparisonOperators[match[1]]: int64(size)},
+	}, nil
+}
+
+func modifiedFilter(value string, now time.Time) (bson.M, error) {
+	return timeFilter("modTime", value, now)
+}
+
+// takenFilter compares the time when a photo was taken or a video was recorded
+func takenFilter(value string, now time.Time) (bson.M, error) {
+	photo, err := timeFilter("photo.dateTaken", value, now)
+	if err != nil {
+		return nil, err
+	}
+	video, _ := timeFilter("video.creationTime", value, now)
+	return bson.M{"$or": bson.A{photo, video}}, nil
+}
+
+// timeFilter compares the unix timestamp in the field with a relative time or a date
+func timeFilter(field string, value string, now time.Time) (bson.M, error) {
+	if match := relativeTimeRegex.FindStringSubmatch(value); match != nil {
+		amount, err := strconv.Atoi(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %s", value)
+		}
+		var point time.Time
+		switch match[3] {
+		case "h":
+			point = now.Add(-time.Duration(amount) * time.Hour)
+		case "d":
+			point = now.AddDate(0, 0, -amount)
+		case "w":
+			point = now.AddDate(0, 0, -7*amount)
+		case "m":
+			point = now.AddDate(0, -amount, 0)
+		case "y":
+			point = now.AddDate(-amount, 0, 0)
+		}
+		// the operator compares the age, so it is reversed for the timestamp
+		var op string
+		switch match[1] {
+		case ">":
+			op = "$lt"
+		case ">=":
+			op = "$lte"
+		case "<=":
+			op = "$gte"
+		default:
+			op = "$gt"
+		}
+		return bson.M{field: bson.M{op: point.Unix()}}, nil
+	}
+
+	if match := absoluteTimeRegex.FindStringSubmatch(value); match != nil {
+		year, _ := strconv.Atoi(match[2])
+		month, day := 1, 1
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, now.Location())
+		end := start.AddDate(1, 0, 0)
+		if match[3] != "" {
+			month, _ = strconv.Atoi(match[3])
+			start = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, now.Location())
+			end = start.AddDate(0, 1, 0)
+		}
+		if match[4] != "" {
+			day, _ = strconv.Atoi(match[4])
+			start = time.Date(year, time.Month(month), day, 0, 0, 0, 0, now.Location())
+			end = start.AddDate(0, 0, 1)
+		}
+		if month < 1 || month > 12 || day < 1 || day > 31 {
+			return nil, fmt.Errorf("invalid date %s", value)
+		}
+		switch match[1] {
+		case ">":
+			return bson.M{field: bson.M{"$gte": end.Unix()}}, nil
+		case ">=":
+			return bson.M{field: bson.M{"$gte": start.Unix()}}, nil
+		case "<":
+			return bson.M{field: bson.M{"$lt": start.Unix()}}, nil
+		case "<=":
+			return bson.M{field: bson.M{"$lt": end.Unix()}}, nil
+		default:
+			return bson.M{field: bson.M{"$gte": start.Unix(), "$lt": end.Unix()}}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid time %s", value)
+}
+
+func extFilter(value string, now time.Time) (bson.M, error) {
+	ext := strings.TrimPrefix(value, ".")
+	if ext == "" || strings.ContainsAny(ext, "/") {
+		return nil, fmt.Errorf("invalid extension %s", value)
+	}
+	return bson.M{
+		"isDir": false,
+		"path":  bson.M{"$regex": `\.` + regexp.QuoteMeta(ext) + "$", "$options": "i"},
+	}, nil
+}
+
+func isFilter(value string, now time.Time) (bson.M, error) {
+	switch value {
+	case "dir", "directory", "folder":
+		return bson.M{"isDir": true}, nil
+	case "file":
+		return bson.M{"isDir": false}, nil
+	}
+	return nil, fmt.Errorf("unknown value for is: %s", value)
+}
+
+func cameraFilter(value string, now time.Time) (bson.M, error) {
+	return bson.M{"photo.camera": bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}}, nil
+}
+
+func lensFilter(value string, now time.Time) (bson.M, error) {
+	return bson.M{"photo.lens": bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}}, nil
+}
+
+// orientationFilter compares the dimensions of the photo as displayed,
+// with the EXIF orientations 5 to 8 swapping width and height
+func orientationFilter(value string, now time.Time) (bson.M, error) {
+	rotated := bson.M{"$gte": bson.A{"$photo.orientation", 5}}
+	width := bson.M{"$cond": bson.A{rotated, "$photo.height", "$photo.width"}}
+	height := bson.M{"$cond": bson.A{rotated, "$photo.width", "$photo.height"}}
+
+	var expr bson.M
+	switch value {
+	case "landscape":
+		expr = bson.M{"$gt": bson.A{width, height}}
+	case "portrait":
+		expr = bson.M{"$lt": bson.A{width, height}}
+	case "square":
+		expr = bson.M{"$eq": bson.A{width, height}}
+	default:
+		return nil, fmt.Errorf("unknown orientation %s", value)
+	}
+	return bson.M{"photo.width": bson.M{"$gt": 0}, "$expr": expr}, nil
+}
+
+func hasFilter(value string, now time.Time) (bson.M, error) {
+	switch value {
+	case "location", "gps":
+		return bson.M{"photo.location": bson.M{"$exists": true}}, nil
+	}
+	return nil, fmt.Errorf("unknown value for has: %s", value)
+}
+
+func nearFilter(value string, now time.Time) (bson.M, error) {
+	match := nearRegex.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("invalid location %s", value)
+	}
+	lat, _ := strconv.ParseFloat(match[1], 64)
+	long, _ := strconv.ParseFloat(match[2], 64)
+	radius := float64(defaultNearRadius)
+	if match[3] != "" {
+		radius, _ = strconv.ParseFloat(match[3], 64)
+	}
+	if lat < -90 || lat > 90 || long < -180 || long > 180 || radius <= 0 {
+		return nil, fmt.Errorf("invalid location %s", value)
+	}
+	return bson.M{"photo.location": bson.M{
+		"$geoWithin": bson.M{"$centerSphere": bson.A{bson.A{long, lat}, radius / earthRadius}},
+	}}, nil
+}
+
+func artistFilter(value string, now time.Time) (bson.M, error) {
+	regex := bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}
+	return bson.M{"$or": bson.A{bson.M{"audio.artist": regex}, bson.M{"audio.albumArtist": regex}}}, nil
+}
+
+func albumFilter(value string, now time.Time) (bson.M, error) {
+	return bson.M{"audio.album": bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}}, nil
+}
+
+func genreFilter(value string, now time.Time) (bson.M, error) {
+	return bson.M{"audio.genre": bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}}, nil
+}
+
+// durationFilter compares the duration of videos and audio files, both are stored in milliseconds
+func durationFilter(value string, now time.Time) (bson.M, error) {
+	match := durationRegex.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("invalid duration %s", value)
+	}
+	amount, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %s", value)
+	}
+	var unit time.Duration
+	switch match[3] {
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	}
+	cond := bson.M{comparisonOperators[match[1]]: int64(amount * float64(unit/time.Millisecond))}
+	return bson.M{"$or": bson.A{bson.M{"video.duration": cond}, bson.M{"audio.duration": cond}}}, nil
+}
@@ -0,0 +1,166 @@
+package fileindexer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var queryNow = time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func TestParseQueryText(t *testing.T) {
+	q, err := parseQuery(`holiday "beach house" -draft`, queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"holiday"}, q.words)
+	assert.Equal(t, []string{"beach house"}, q.phrases)
+	assert.Equal(t, []string{"draft"}, q.excluded)
+	assert.Equal(t, `holiday "beach house" -draft`, q.text())
+	assert.Empty(t, q.fileFilters())
+
+	// words that look like filters with an unknown key are searched as text
+	q, err = parseQuery("meeting 10:30", queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"meeting", "10:30"}, q.words)
+}
+
+func TestParseQueryFilters(t *testing.T) {
+	q, err := parseQuery(`type:image type:video size:>100M modified:<30d in:photos/2023 -in:"photos/2023/private stuff" ext:PDF is:dir -type:audio`, queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "", q.text())
+	assert.Equal(t, []string{"/photos/2023"}, q.in)
+	assert.Equal(t, []string{"/photos/2023/private stuff"}, q.notIn)
+	assert.Equal(t, []bson.M{
+		{"$or": bson.A{
+			bson.M{"mime": bson.M{"$regex": "^image/"}},
+			bson.M{"mime": bson.M{"$regex": "^video/"}},
+		}},
+		{"isDir": false, "size": bson.M{"$gt": int64(100 * 1024 * 1024)}},
+		{"modTime": bson.M{"$gt": queryNow.AddDate(0, 0, -30).Unix()}},
+		{"isDir": false, "path": bson.M{"$regex": `\.pdf$`, "$options": "i"}},
+		{"isDir": true},
+		{"$nor": bson.A{bson.M{"mime": bson.M{"$regex": "^audio/"}}}},
+	}, q.filters)
+}
+
+func TestParseQueryModified(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	end := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+	cases := map[string]bson.M{
+		"modified:2023":      {"modTime": bson.M{"$gte": start, "$lt": end}},
+		"modified:>2023":     {"modTime": bson.M{"$gte": end}},
+		"modified:<2023":     {"modTime": bson.M{"$lt": start}},
+		"modified:>1y":       {"modTime": bson.M{"$lt": queryNow.AddDate(-1, 0, 0).Unix()}},
+		"modified:2w":        {"modTime": bson.M{"$gt": queryNow.AddDate(0, 0, -14).Unix()}},
+		"modified:<=2023-02": {"modTime": bson.M{"$lt": time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC).Unix()}},
+	}
+	for query, expected := range cases {
+		q, err := parseQuery(query, queryNow)
+		assert.NoError(t, err, query)
+		assert.Equal(t, []bson.M{expected}, q.filters, query)
+	}
+}
+
+func TestParseQueryPhoto(t *testing.T) {
+	rotated := bson.M{"$gte": bson.A{"$photo.orientation", 5}}
+	width := bson.M{"$cond": bson.A{rotated, "$photo.height", "$photo.width"}}
+	height := bson.M{"$cond": bson.A{rotated, "$photo.width", "$photo.height"}}
+	radians := func(km float64) float64 { return km / earthRadius }
+	year2023 := bson.M{
+		"$gte": time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		"$lt":  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+	}
+	lastWeek := bson.M{"$gt": queryNow.AddDate(0, 0, -7).Unix()}
+
+	cases := map[string]bson.M{
+		"taken:2023": {"$or": bson.A{
+			bson.M{"photo.dateTaken": year2023},
+			bson.M{"video.creationTime": year2023},
+		}},
+		"taken:1w": {"$or": bson.A{
+			bson.M{"photo.dateTaken": lastWeek},
+			bson.M{"video.creationTime": lastWeek},
+		}},
+		"camera:EOS":            {"photo.camera": bson.M{"$regex": "eos", "$options": "i"}},
+		`lens:"f/1.8"`:          {"photo.lens": bson.M{"$regex": `f/1\.8`, "$options": "i"}},
+		"orientation:portrait":  {"photo.width": bson.M{"$gt": 0}, "$expr": bson.M{"$lt": bson.A{width, height}}},
+		"orientation:landscape": {"photo.width": bson.M{"$gt": 0}, "$expr": bson.M{"$gt": bson.A{width, height}}},
+		"has:location":          {"photo.location": bson.M{"$exists": true}},
+		"near:52.52,13.4": {"photo.location": bson.M{
+			"$geoWithin": bson.M{"$centerSphere": bson.A{bson.A{13.4, 52.52}, radians(10)}},
+		}},
+		"near:-33.9,18.4,2.5km": {"photo.location": bson.M{
+			"$geoWithin": bson.M{"$centerSphere": bson.A{bson.A{18.4, -33.9}, radians(2.5)}},
+		}},
+	}
+	for query, expected := range cases {
+		q, err := parseQuery(query, queryNow)
+		assert.NoError(t, err, query)
+		assert.Equal(t, []bson.M{expected}, q.filters, query)
+	}
+
+	for _, query := range []string{"orientation:sideways", "has:wings", "near:100,10", "near:berlin"} {
+		_, err := parseQuery(query, queryNow)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestParseQueryDuration(t *testing.T) {
+	cases := map[string]bson.M{
+		"duration:>10m":  {"$gt": int64(10 * 60 * 1000)},
+		"duration:<=90s": {"$lte": int64(90 * 1000)},
+		"duration:1.5h":  {"$eq": int64(90 * 60 * 1000)},
+	}
+	for query, cond := range cases {
+		q, err := parseQuery(query, queryNow)
+		assert.NoError(t, err, query)
+		assert.Equal(t, []bson.M{{"$or": bson.A{bson.M{"video.duration": cond}, bson.M{"audio.duration": cond}}}}, q.filters, query)
+	}
+
+	_, err := parseQuery("duration:long", queryNow)
+	assert.Error(t, err)
+}
+
+func TestParseQueryAudio(t *testing.T) {
+	q, err := parseQuery(`artist:"Miles Davis" album:kind genre:jazz`, queryNow)
+	assert.NoError(t, err)
+	artist := bson.M{"$regex": "miles davis", "$options": "i"}
+	assert.Equal(t, []bson.M{
+		{"$or": bson.A{bson.M{"audio.artist": artist}, bson.M{"audio.albumArtist": artist}}},
+		{"audio.album": bson.M{"$regex": "kind", "$options": "i"}},
+		{"audio.genre": bson.M{"$regex": "jazz", "$options": "i"}},
+	}, q.filters)
+}
+
+func TestParseQueryExcludedWithoutText(t *testing.T) {
+	q, err := parseQuery("is:file -tmp", queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, "", q.text())
+	assert.Equal(t, []bson.M{
+		{"isDir": false},
+		{"searchWords": bson.M{"$not": bson.M{"$regex": "tmp", "$options": "i"}}},
+	}, q.fileFilters())
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	cases := map[string]int{
+		`"unterminated`:        0,
+		`foo type:banana`:      4,
+		`size:>lots`:           0,
+		`a modified:yesterday`: 2,
+		`is:maybe`:             0,
+		`in:`:                  0,
+		`   `:                  0,
+		`foo ""`:               4,
+	}
+	for query, pos := range cases {
+		_, err := parseQuery(query, queryNow)
+		syntaxErr := &QuerySyntaxError{}
+		if assert.True(t, errors.As(err, &syntaxErr), query) {
+			assert.Equal(t, pos, syntaxErr.Pos, query)
+		}
+	}
+}
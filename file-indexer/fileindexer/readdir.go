@@ -0,0 +1,178 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"umbasa.net/seraph/file-provider/fileprovider"
+)
+
+// how often the readdir collection is checked for expired listings
+var readdirSweepInterval = time.Minute
+
+// readdirSession is a directory listing of which entries were received
+type readdirSession struct {
+	Readdir string             `bson:"_id"`
+	Dir     primitive.ObjectID `bson:"dir"`
+	Count   int64              `bson:"count"`
+	Total   int64              `bson:"total"`
+	// when the last entry was received, 0 for entries of previous versions
+	Last int64 `bson:"last"`
+}
+
+// sweepReaddirs periodically removes listings that didn't receive all entries until they expired,
+// for example because the file provider crashed or messages were lost, and lists their directories again
+func (c *consumer) sweepReaddirs() {
+	ticker := time.NewTicker(readdirSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.sweepExpiredReaddirs(c.ctx); err != nil {
+				c.log.Error("error while removing expired readdir entries", "error", err)
+			}
+		}
+	}
+}
+
+func (c *consumer) sweepExpiredReaddirs(ctx context.Context) error {
+	ctx, span := c.tracer.Start(ctx, "sweepExpiredReaddirs")
+	defer span.End()
+
+	cutoff := time.Now().Add(-c.readdirExpiry).Unix()
+
+	// only listings with entries from before the cutoff can be expired
+	sessions, err := c.readdirSessions(ctx, bson.M{"time": bson.M{"$not": bson.M{"$gte": cutoff}}})
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+
+	// listings of the same directories that are still receiving entries
+	dirs := make([]primitive.ObjectID, 0, len(sessions))
+	for _, session := range sessions {
+		if !slices.Contains(dirs, session.Dir) {
+			dirs = append(dirs, session.Dir)
+		}
+	}
+	active, err := c.readdirSessions(ctx, bson.M{"parentDir": bson.M{"$in": dirs}, "time": bson.M{"$gte": cutoff}})
+	if err != nil {
+		return err
+	}
+
+	expired, relist := expiredReaddirs(append(sessions, active...), cutoff)
+	claimed := make(map[primitive.ObjectID]bool)
+	for _, session := range expired {
+		// entries that arrived in the meantime are kept, so that the listing can still complete
+		res, err := c.readdir.DeleteMany(ctx, bson.M{"readdir": session.Readdir, "time": bson.M{"$not": bson.M{"$gte": session.Last + 1}}})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount > 0 {
+			// only the instance of the file indexer that removed the entries lists the directory again
+			claimed[session.Dir] = true
+			c.log.Warn("readdir expired incomplete", "readdir", session.Readdir, "count", session.Count, "total", session.Total)
+		}
+	}
+
+	for dirId := range relist {
+		if !claimed[dirId] {
+			continue
+		}
+		if err := c.relistDir(ctx, dirId); err != nil {
+			c.log.Error("error while listing directory of expired readdir", "error", err, "dir", dirId)
+		}
+	}
+	return nil
+}
+
+// readdirSessions groups the readdir entries that match filter by listing
+func (c *consumer) readdirSessions(ctx context.Context, filter bson.M) ([]readdirSession, error) {
+	cur, err := c.readdir.Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{
+			"_id":   "$readdir",
+			"dir":   bson.M{"$first": "$parentDir"},
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$first": "$total"},
+			"last":  bson.M{"$max": bson.M{"$ifNull": bson.A{"$time", 0}}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]readdirSession, 0)
+	err = cur.All(ctx, &sessions)
+	return sessions, err
+}
+
+// expiredReaddirs returns the listings that received no entries since the cutoff time,
+// and the directories that need to be listed again since no other listing of them is in progress.
+// A listing may be contained more than once, it is active if any of its entries is.
+func expiredReaddirs(sessions []readdirSession, cutoff int64) ([]readdirSession, map[primitive.ObjectID]bool) {
+	expired := make([]readdirSession, 0)
+	relist := make(map[primitive.ObjectID]bool)
+	active := make(map[primitive.ObjectID]bool)
+	activeReaddirs := make(map[string]bool)
+	for _, session := range sessions {
+		if session.Last >= cutoff {
+			active[session.Dir] = true
+			activeReaddirs[session.Readdir] = true
+		}
+	}
+	for _, session := range sessions {
+		if session.Last >= cutoff || activeReaddirs[session.Readdir] {
+			continue
+		}
+		expired = append(expired, session)
+		relist[session.Dir] = true
+	}
+	for dirId := range active {
+		delete(relist, dirId)
+	}
+	return expired, relist
+}
+
+// relistDir lists a directory from its file provider, the file provider publishes its entries as a new readdir
+func (c *consumer) relistDir(ctx context.Context, dirId primitive.ObjectID) error {
+	ctx, span := c.tracer.Start(ctx, "relistDir")
+	defer span.End()
+
+	filter := FilePrototype{}
+	filter.Id.Set(dirId)
+
+	dir := File{}
+	err := c.files.FindOne(ctx, filter).Decode(&dir)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the directory was removed from the index in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !dir.IsDir || dir.Trashed {
+		return nil
+	}
+
+	client := fileprovider.NewFileProviderClient(dir.ProviderId, c.nc, c.logger)
+	defer client.Close()
+	inFile, err := client.OpenFile(ctx, dir.Path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	_, err = inFile.Readdir(0)
+	if err != nil {
+		return err
+	}
+	c.log.Debug("listed directory of expired readdir again", "providerId", dir.ProviderId, "path", dir.Path)
+	return nil
+}
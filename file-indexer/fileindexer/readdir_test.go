@@ -0,0 +1,35 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExpiredReaddirs(t *testing.T) {
+	photos := primitive.NewObjectID()
+	music := primitive.NewObjectID()
+	docs := primitive.NewObjectID()
+	videos := primitive.NewObjectID()
+
+	expired, relist := expiredReaddirs([]readdirSession{
+		{Readdir: "a", Dir: photos, Count: 3, Total: 10, Last: 100},
+		// listed again while the previous listing is incomplete
+		{Readdir: "b", Dir: music, Count: 1, Total: 10, Last: 50},
+		{Readdir: "c", Dir: music, Count: 2, Total: 10, Last: 900},
+		// entry of a previous version without time
+		{Readdir: "d", Dir: docs, Count: 1, Total: 2},
+		{Readdir: "e", Dir: docs, Count: 1, Total: 2, Last: 200},
+		// entries from before and after the cutoff of the same listing
+		{Readdir: "f", Dir: videos, Count: 1, Total: 5, Last: 300},
+		{Readdir: "f", Dir: videos, Count: 1, Total: 5, Last: 700},
+	}, 500)
+
+	readdirs := make([]string, 0)
+	for _, session := range expired {
+		readdirs = append(readdirs, session.Readdir)
+	}
+	assert.Equal(t, []string{"a", "b", "d", "e"}, readdirs)
+	assert.Equal(t, map[primitive.ObjectID]bool{photos: true, docs: true}, relist)
+}
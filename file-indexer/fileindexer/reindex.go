@@ -0,0 +1,414 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/boz/go-throttle"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/file-provider/fileprovider"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+	"umbasa.net/seraph/util"
+)
+
+// reindexJob tracks the progress of a reindex
+type reindexJob struct {
+	key string
+
+	crawled   atomic.Int64
+	total     atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+	removed   atomic.Int64
+
+	progress throttle.Throttle
+	done     chan struct{}
+}
+
+func (c *consumer) handleReindexMessage(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(c.ctx, msg)
+
+	req := events.ReindexRequest{}
+	res := events.ReindexResponse{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil && req.Path != "" && req.ProviderId == "" {
+		err = errors.New("reindexing a path requires a provider")
+	}
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Job = "SERAPH_REINDEX_" + uuid.NewString()
+		go c.reindex(ctx, res.Job, &req)
+	}
+
+	data, _ := json.Marshal(&res)
+	msg.Respond(data)
+}
+
+// reindex marks the files in scope as pending and processes them again.
+// Files that are still pending when the indexer is interrupted are processed
+// with the next FileInfoEvent for them.
+func (c *consumer) reindex(ctx context.Context, jobKey string, req *events.ReindexRequest) {
+	ctx, span := c.tracer.Start(ctx, "reindex")
+	defer span.End()
+
+	job := &reindexJob{
+		key:      jobKey,
+		progress: throttle.NewThrottle(2*time.Second, true),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(job.done)
+		for job.progress.Next() {
+			c.publishReindexJob(ctx, job, reindexStatus(job), nil)
+		}
+	}()
+
+	err := c.runReindex(ctx, job, req)
+
+	job.progress.Stop()
+	<-job.done
+
+	properties := map[string]string{
+		"processed": strconv.FormatInt(job.processed.Load(), 10),
+		"failed":    strconv.FormatInt(job.failed.Load(), 10),
+		"removed":   strconv.FormatInt(job.removed.Load(), 10),
+	}
+	if err != nil {
+		c.log.Error("error while reindexing", "error", err, "job", jobKey)
+		properties["status"] = events.ReindexStatusFailed
+		c.publishReindexJob(ctx, job, "Reindex failed: "+err.Error(), properties)
+		return
+	}
+	properties["status"] = events.ReindexStatusComplete
+	c.publishReindexJob(ctx, job, fmt.Sprintf("Reindexed %d files, %d failed, %d removed.", job.processed.Load(), job.failed.Load(), job.removed.Load()), properties)
+}
+
+func (c *consumer) runReindex(ctx context.Context, job *reindexJob, req *events.ReindexRequest) error {
+	root := path.Clean("/" + req.Path)
+	c.log.Info("reindex started", "job", job.key, "providerId", req.ProviderId, "path", root, "rebuild", req.Rebuild)
+
+	// changes that were found while crawling, so that new files are published as created
+	changes := make(map[primitive.ObjectID]string)
+	if req.Rebuild {
+		providers := []string{req.ProviderId}
+		if req.ProviderId == "" {
+			var err error
+			providers, err = c.indexedProviders(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		for _, providerId := range providers {
+			seen := make(map[primitive.ObjectID]string)
+			if err := c.crawl(ctx, job, providerId, root, seen); err != nil {
+				return fmt.Errorf("crawling %s failed: %w", providerId, err)
+			}
+			removed, err := c.removeOrphans(ctx, providerId, root, seen)
+			if err != nil {
+				return err
+			}
+			job.removed.Add(removed)
+			// orphans are removed without updating the sizes of their parent directories
+			if err := c.recomputeDirSizes(ctx, providerId); err != nil {
+				return err
+			}
+			for id, change := range seen {
+				if change == events.FileChangedEventCreated {
+					changes[id] = change
+				}
+			}
+		}
+	}
+
+	scope := reindexScope(req.ProviderId, root)
+	_, err := c.files.UpdateMany(ctx, bson.M{"$and": bson.A{scope, bson.M{"isDir": false}}}, bson.M{"$set": bson.M{"pending": true}})
+	if err != nil {
+		return err
+	}
+
+	// files that the consumer picked up in the meantime are no longer pending
+	filter := bson.M{"$and": bson.A{scope, bson.M{"isDir": false, "pending": true}}}
+	total, err := c.files.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	job.total.Store(total)
+	job.progress.Trigger()
+
+	cur, err := c.files.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	limiter := util.NewLimiter(max(1, c.reindexLimit))
+	for cur.Next(ctx) {
+		file := File{}
+		if err := cur.Decode(&file); err != nil {
+			limiter.Join()
+			return err
+		}
+		if !limiter.Begin(ctx) {
+			break
+		}
+		go func() {
+			defer limiter.End()
+			change := events.FileChangedEventChanged
+			if changes[file.Id] == events.FileChangedEventCreated {
+				change = events.FileChangedEventCreated
+			}
+			if err := c.handleChangedFile(ctx, &file, change); err != nil {
+				job.failed.Add(1)
+			}
+			job.processed.Add(1)
+			job.progress.Trigger()
+		}()
+	}
+	limiter.Join()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return cur.Err()
+}
+
+// crawl adds the file at root and all files below it to the index and records their changes.
+// The trash and versions areas are not crawled, their files are kept as they are.
+func (c *consumer) crawl(ctx context.Context, job *reindexJob, providerId string, root string, seen map[primitive.ObjectID]string) error {
+	ctx, span := c.tracer.Start(ctx, "crawl")
+	defer span.End()
+
+	client := fileprovider.NewFileProviderClient(providerId, c.nc, c.logger)
+	defer client.Close()
+
+	info, err := client.Stat(ctx, root)
+	if err != nil {
+		return err
+	}
+	rootFile := crawledFile(providerId, root, info)
+	indexed, change, err := c.upsertFile(ctx, &rootFile)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		seen[indexed.Id] = change
+		job.crawled.Add(1)
+		job.progress.Trigger()
+		return nil
+	}
+
+	dirs := []crawledDir{{indexed, change}}
+	for len(dirs) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		seen[dir.file.Id] = dir.change
+		job.crawled.Add(1)
+		job.progress.Trigger()
+		if dir.change != "" {
+			// only files are processed after the crawl
+			if err := c.handleChangedFile(ctx, dir.file, dir.change); err != nil {
+				return err
+			}
+		}
+
+		subdirs, err := c.crawlDir(ctx, job, client, providerId, dir.file.Path, seen)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, subdirs...)
+	}
+	return nil
+}
+
+// crawledDir is a directory that was added to the index by the crawl and whose entries are not crawled yet
+type crawledDir struct {
+	file   *File
+	change string
+}
+
+// crawlDir adds the entries of a directory to the index in batches and returns its subdirectories
+func (c *consumer) crawlDir(ctx context.Context, job *reindexJob, client fileprovider.Client, providerId string, name string, seen map[primitive.ObjectID]string) ([]crawledDir, error) {
+	dir, err := client.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dir.Readdir(0)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*FilePrototype, 0, len(entries))
+	for _, entry := range entries {
+		entryPath := path.Join(name, entry.Name())
+		if entry.IsDir() && (entry.Name() == spaces.TrashDir || entry.Name() == spaces.VersionsDir) {
+			if err := c.keepSubtree(ctx, providerId, entryPath, seen); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file := crawledFile(providerId, entryPath, entry)
+		files = append(files, &file)
+	}
+
+	subdirs := make([]crawledDir, 0)
+	for start := 0; start < len(files); start += c.batchSize {
+		results, err := c.upsertFiles(ctx, files[start:min(start+c.batchSize, len(files))])
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if result.err != nil {
+				return nil, result.err
+			}
+			if result.file.IsDir {
+				subdirs = append(subdirs, crawledDir{result.file, result.change})
+				continue
+			}
+			seen[result.file.Id] = result.change
+			job.crawled.Add(1)
+		}
+		job.progress.Trigger()
+	}
+	return subdirs, nil
+}
+
+// keepSubtree marks the indexed files at and below dir as seen, so that they are not removed as orphans
+func (c *consumer) keepSubtree(ctx context.Context, providerId string, dir string, seen map[primitive.ObjectID]string) error {
+	cur, err := c.files.Find(ctx, subtreeFilter(providerId, dir), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var f File
+		if err := cur.Decode(&f); err != nil {
+			return err
+		}
+		if _, ok := seen[f.Id]; !ok {
+			seen[f.Id] = ""
+		}
+	}
+	return cur.Err()
+}
+
+func crawledFile(providerId string, name string, info fs.FileInfo) FilePrototype {
+	return newFilePrototype(&events.FileInfoEvent{
+		ProviderID: providerId,
+		Path:       name,
+		IsDir:      info.IsDir(),
+		Size:       info.Size(),
+		Mode:       int64(info.Mode()),
+		ModTime:    info.ModTime().Unix(),
+	})
+}
+
+// removeOrphans removes the files below root from the index that were not found by the crawl
+func (c *consumer) removeOrphans(ctx context.Context, providerId string, root string, seen map[primitive.ObjectID]string) (int64, error) {
+	ctx, span := c.tracer.Start(ctx, "removeOrphans")
+	defer span.End()
+
+	cur, err := c.files.Find(ctx, reindexScope(providerId, root))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	orphans := make([]primitive.ObjectID, 0)
+	for cur.Next(ctx) {
+		var f File
+		if err := cur.Decode(&f); err != nil {
+			return 0, err
+		}
+		if _, ok := seen[f.Id]; ok {
+			continue
+		}
+		c.publishChange(ctx, &f, events.FileChangedEventDeleted)
+		if f.IsDir {
+			c.dirs.invalidate(f.ProviderId, f.Path)
+		}
+		orphans = append(orphans, f.Id)
+	}
+	if err := cur.Err(); err != nil {
+		return 0, err
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	res, err := c.files.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": orphans}})
+	if err != nil {
+		return 0, err
+	}
+	c.removeContents(ctx, orphans)
+	c.log.Debug("removed orphaned files", "providerId", providerId, "path", root, "count", res.DeletedCount)
+	return res.DeletedCount, nil
+}
+
+func (c *consumer) indexedProviders(ctx context.Context) ([]string, error) {
+	values, err := c.files.Distinct(ctx, "providerId", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]string, 0, len(values))
+	for _, value := range values {
+		if providerId, ok := value.(string); ok {
+			providers = append(providers, providerId)
+		}
+	}
+	return providers, nil
+}
+
+func (c *consumer) publishReindexJob(ctx context.Context, job *reindexJob, statusMessage string, properties map[string]string) {
+	ev := events.JobEvent{
+		Event: events.Event{
+			ID:      uuid.NewString(),
+			Version: 1,
+		},
+		Key:           job.key,
+		Description:   "Reindexing files",
+		StatusMessage: statusMessage,
+		Properties:    properties,
+	}
+	data, _ := ev.Marshal()
+	topic := fmt.Sprintf(events.JobsTopicPattern, job.key)
+	messaging.PublishEvent(ctx, c.nc, topic, data)
+}
+
+func reindexStatus(job *reindexJob) string {
+	if total := job.total.Load(); total > 0 {
+		return fmt.Sprintf("Reindexed %d of %d files", job.processed.Load(), total)
+	}
+	return fmt.Sprintf("Crawling files: %d found", job.crawled.Load())
+}
+
+// reindexScope matches the indexed files at root and below it, in all providers if providerId is empty
+func reindexScope(providerId string, root string) bson.M {
+	if providerId == "" {
+		return bson.M{}
+	}
+	if root == "/" {
+		return bson.M{"providerId": providerId}
+	}
+	return subtreeFilter(providerId, root)
+}
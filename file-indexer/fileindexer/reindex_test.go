@@ -0,0 +1,20 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReindexScope(t *testing.T) {
+	assert.Equal(t, bson.M{}, reindexScope("", "/"))
+	assert.Equal(t, bson.M{"providerId": "p1"}, reindexScope("p1", "/"))
+	assert.Equal(t, bson.M{
+		"providerId": "p1",
+		"$or": bson.A{
+			bson.M{"path": "/a/b.c"},
+			bson.M{"path": bson.M{"$regex": `^/a/b\.c/`}},
+		},
+	}, reindexScope("p1", "/a/b.c"))
+}
@@ -2,16 +2,18 @@ package fileindexer
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"path"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.opentelemetry.io/otel/trace"
@@ -34,16 +36,24 @@ type SearchParams struct {
 	Lc      fx.Lifecycle
 }
 
+// maximum number of content matches that are considered for a search
+const contentSearchLimit = 1000
+
 type Search interface{}
 
 type search struct {
-	log    *slog.Logger
-	nc     *nats.Conn
-	files  *mongo.Collection
-	tracer trace.Tracer
+	log      *slog.Logger
+	nc       *nats.Conn
+	files    *mongo.Collection
+	contents *mongo.Collection
+	tracer   trace.Tracer
+
+	participant *messaging.SearchParticipant
+}
 
-	requestChan chan *nats.Msg
-	requestSub  *nats.Subscription
+type scoredFile struct {
+	File  `bson:",inline"`
+	Score float64 `bson:"score"`
 }
 
 func NewSearch(p SearchParams) (Search, error) {
@@ -52,10 +62,11 @@ func NewSearch(p SearchParams) (Search, error) {
 	tracer := p.Tracing.TracerProvider.Tracer("file-search")
 
 	search := &search{
-		log:    log,
-		nc:     p.Nc,
-		files:  files,
-		tracer: tracer,
+		log:      log,
+		nc:       p.Nc,
+		files:    files,
+		contents: p.Db.Collection(contentsCollection),
+		tracer:   tracer,
 	}
 
 	p.Lc.Append(fx.StartHook(search.start))
@@ -65,170 +76,298 @@ func NewSearch(p SearchParams) (Search, error) {
 }
 
 func (s *search) start() error {
-	s.requestChan = make(chan *nats.Msg, nats.DefaultSubPendingMsgsLimit)
-	sub, err := s.nc.ChanSubscribe(events.SearchRequestTopic, s.requestChan)
+	s.participant = messaging.NewSearchParticipant(s.nc, s.log, "files", []string{events.SearchTypeFiles}, s.handleSearch)
+	return s.participant.Start()
+}
+
+func (s *search) stop() {
+	if s.participant != nil {
+		s.participant.Stop()
+	}
+}
+
+// handleSearch answers a search request. The context is passed to all queries,
+// so that the cursors are closed when the request is cancelled.
+func (s *search) handleSearch(ctx context.Context, req *events.SearchRequest, types []string, replies *messaging.SearchReplies) error {
+	query, err := parseQuery(req.Query, time.Now())
 	if err != nil {
+		s.log.Debug("invalid search query", "query", req.Query, "error", err)
 		return err
 	}
-	s.requestSub = sub
+	page, err := newSearchPage(req)
+	if err != nil {
+		s.log.Debug("invalid search page", "error", err)
+		return err
+	}
+	if req.Mode != "" && req.Mode != events.SearchModeText && req.Mode != events.SearchModeFuzzy {
+		return fmt.Errorf("invalid search mode %s", req.Mode)
+	}
 
-	go s.messageLoop()
+	userSpaces, err := spaces.GetSpacesForUser(ctx, s.nc, req.UserId)
+	if err != nil {
+		s.log.Error("error while retrieving spaces for user", "error", err)
+		return err
+	}
+	if len(userSpaces) == 0 {
+		s.log.Warn("no spaces found for user " + req.UserId)
+		return errors.New("no spaces found")
+	}
 
-	return nil
-}
+	fileFilter := spaceFilter(userSpaces, query.in, query.notIn)
+	for _, filter := range query.fileFilters() {
+		fileFilter = append(fileFilter, filter)
+	}
 
-func (s *search) stop() {
-	if s.requestSub != nil {
-		s.requestSub.Unsubscribe()
-		s.requestSub = nil
+	var results []scoredFile
+	var total int64
+	// number of results that can be paged through
+	var pageable int64
+	if req.Mode == events.SearchModeFuzzy && len(query.terms()) > 0 {
+		for _, filter := range query.excludedFilters() {
+			fileFilter = append(fileFilter, filter)
+		}
+		results, total, err = s.findFuzzy(ctx, fileFilter, query.terms())
+		pageable = int64(len(results))
+		results = page.apply(results)
+	} else {
+		results, total, err = s.find(ctx, fileFilter, query.text(), page)
+		pageable = total
 	}
-	if s.requestChan != nil {
-		close(s.requestChan)
+	if err != nil {
+		return err
 	}
-}
 
-func (s *search) messageLoop() {
-	for {
-		msg, ok := <-s.requestChan
-		if !ok {
-			return
+	for _, result := range results {
+		file := result.File
+		mapSpace(userSpaces, &file)
+		reply := map[string]any{
+			"providerId": file.ProviderId,
+			"path":       file.Path,
+		}
+		if file.Video != nil {
+			reply["video"] = file.Video
+		}
+		err := replies.Reply(events.SearchTypeFiles, reply)
+		if err != nil {
+			return err
 		}
-		go s.handleMessage(msg)
 	}
+	replies.Total = total
+	replies.Cursor = page.nextCursor(pageable)
+	return nil
 }
 
-func (s *search) handleMessage(msg *nats.Msg) {
-	ctx := messaging.ExtractTraceContext(context.Background(), msg)
+// find returns the requested page of the files matching the query by path or by content, and the number of matches.
+// The scores of files that match both by path and by content are added.
+// Without text the files matching fileFilter are returned.
+func (s *search) find(ctx context.Context, fileFilter bson.A, text string, page searchPage) ([]scoredFile, int64, error) {
+	filter := bson.M{"$and": fileFilter}
+	var score any
+	if text != "" {
+		contentScores, err := s.findContents(ctx, fileFilter, text)
+		if err != nil {
+			return nil, 0, err
+		}
 
-	req := events.SearchRequest{}
-	err := json.Unmarshal(msg.Data, &req)
-	if err != nil {
-		s.log.Error("invalid request", "error", err)
-		return
-	}
-
-	replyId := uuid.NewString()
-	ackTopic := fmt.Sprintf(events.SearchAckTopicPattern, req.RequestId)
-	replyTopic := fmt.Sprintf(events.SearchReplyTopicPattern, req.RequestId)
-	if slices.Index(req.Types, events.SearchTypeFiles) < 0 {
-		s.log.Debug("nack search request", "requestId", req.RequestId, "replyId", replyId, "types", req.Types, "query", req.Query)
-		nack := events.SearchAck{
-			RequestId: req.RequestId,
-			ReplyId:   replyId,
-			Ack:       false,
+		match := bson.M{"$text": bson.M{"$search": text}}
+		// files that only match by content have no text score
+		score = bson.M{"$ifNull": bson.A{bson.M{"$meta": "textScore"}, 0}}
+		if len(contentScores) > 0 {
+			ids := make(bson.A, 0, len(contentScores))
+			scores := make(bson.A, 0, len(contentScores))
+			for id, contentScore := range contentScores {
+				ids = append(ids, id)
+				scores = append(scores, contentScore)
+			}
+			match = bson.M{"$or": bson.A{match, bson.M{"_id": bson.M{"$in": ids}}}}
+			score = bson.M{"$add": bson.A{score, bson.M{"$let": bson.M{
+				"vars": bson.M{"i": bson.M{"$indexOfArray": bson.A{ids, "$_id"}}},
+				"in":   bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$$i", 0}}, bson.M{"$arrayElemAt": bson.A{scores, "$$i"}}, 0}},
+			}}}}
 		}
-		data, _ := json.Marshal(nack)
-		s.nc.Publish(ackTopic, data)
-		return
+		filter = bson.M{"$and": append(slices.Clone(fileFilter), match)}
 	}
 
-	s.log.Debug("ack search request", "requestId", req.RequestId, "replyId", replyId, "types", req.Types, "query", req.Query)
-	ack := events.SearchAck{
-		RequestId: req.RequestId,
-		ReplyId:   replyId,
-		Ack:       true,
-		Types:     []string{events.SearchTypeFiles},
-	}
-	data, _ := json.Marshal(ack)
-	s.nc.Publish(ackTopic, data)
+	pipeline := append(bson.A{bson.M{"$match": filter}}, page.stages(score)...)
+	s.log.Debug("search query", "query", logging.JsonValue(pipeline))
 
-	userSpaces, err := spaces.GetSpacesForUser(ctx, s.nc, req.UserId)
+	cur, err := s.files.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
 	if err != nil {
-		s.log.Error("error while retrieving spaces for user", "error", err)
-		s.replyError(req.RequestId, replyId, err)
-		return
-	}
-	if len(userSpaces) == 0 {
-		s.log.Warn("no spaces found for user " + req.UserId)
-		s.replyError(req.RequestId, replyId, errors.New("no spaces found"))
-		return
+		s.log.Error("error while executing search query", "error", err)
+		return nil, 0, err
 	}
+	defer cur.Close(ctx)
 
-	providerFilterList := bson.A{}
-	for _, space := range userSpaces {
-		for _, provider := range space.FileProviders {
-			providerFilter := bson.M{
-				"providerId": provider.ProviderId,
-			}
-			if provider.Path != "" {
-				providerFilter["path"] = bson.M{"$regex": fmt.Sprintf("^%s", provider.Path)}
-			}
-			providerFilterList = append(providerFilterList, providerFilter)
+	results := make([]scoredFile, 0)
+	for cur.Next(ctx) {
+		file := scoredFile{}
+		if err := cur.Decode(&file); err != nil {
+			s.log.Error("error while decoding query results", "error", err)
+			return nil, 0, err
 		}
+		results = append(results, file)
+	}
+	if err := cur.Err(); err != nil {
+		s.log.Error("error while retrieving query results", "error", err)
+		return nil, 0, err
 	}
 
-	filter := bson.M{
-		"$and": bson.A{
-			bson.M{"$or": providerFilterList},
-			bson.M{"$text": bson.M{"$search": req.Query}},
-		},
+	total, err := s.files.CountDocuments(ctx, filter)
+	if err != nil {
+		s.log.Error("error while counting search results", "error", err)
+		return nil, 0, err
 	}
-	projection := bson.M{"score": bson.M{"$meta": "textScore"}}
+	return results, total, nil
+}
 
-	s.log.Debug("search query", "query", logging.JsonValue(filter))
+// findContents returns the ids of the files matching fileFilter whose content matches the query, with their score.
+// The files are joined in the query, so that the limit only applies to matches that are visible to the user.
+func (s *search) findContents(ctx context.Context, fileFilter bson.A, text string) (map[primitive.ObjectID]float64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$text": bson.M{"$search": text}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         filesCollection,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"pipeline":     bson.A{bson.M{"$match": bson.M{"$and": fileFilter}}, bson.M{"$project": bson.M{"_id": 1}}},
+			"as":           "file",
+		}}},
+		{{Key: "$match", Value: bson.M{"file": bson.M{"$ne": bson.A{}}}}},
+		{{Key: "$project", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+		{{Key: "$sort", Value: bson.M{"score": -1}}},
+		{{Key: "$limit", Value: contentSearchLimit}},
+	}
 
-	cur, err := s.files.Find(ctx, filter, options.Find().SetProjection(projection).SetSort(projection))
+	cur, err := s.contents.Aggregate(ctx, pipeline)
 	if err != nil {
-		s.log.Error("error while executing search query", "error", err)
-		s.replyError(req.RequestId, replyId, err)
-		return
+		s.log.Error("error while executing content search query", "error", err)
+		return nil, err
 	}
 	defer cur.Close(ctx)
 
+	scores := make(map[primitive.ObjectID]float64)
 	for cur.Next(ctx) {
-		err := cur.Err()
+		result := struct {
+			Id    primitive.ObjectID `bson:"_id"`
+			Score float64            `bson:"score"`
+		}{}
+		err := cur.Decode(&result)
 		if err != nil {
-			s.log.Error("error while retrieving query results", "error", err)
-			s.replyError(req.RequestId, replyId, err)
-			return
+			s.log.Error("error while decoding content search results", "error", err)
+			return nil, err
 		}
-		file := File{}
-		err = cur.Decode(&file)
-		if err != nil {
-			s.log.Error("error while decoding query results", "error", err)
-			s.replyError(req.RequestId, replyId, err)
-			return
+		scores[result.Id] = result.Score
+	}
+	if err := cur.Err(); err != nil {
+		s.log.Error("error while retrieving content search results", "error", err)
+		return nil, err
+	}
+	return scores, nil
+}
+
+// spaceFilter returns the conditions for files that are visible in the given spaces.
+// Files can optionally be restricted to or excluded from directories relative to the spaces.
+// A directory whose first element is the id of a space provider only applies to that space provider.
+func spaceFilter(userSpaces []spaces.Space, in []string, notIn []string) bson.A {
+	spaceProviderIds := make(map[string]bool)
+	for _, space := range userSpaces {
+		for _, provider := range space.FileProviders {
+			spaceProviderIds[provider.SpaceProviderId] = true
 		}
-		s.mapSpace(userSpaces, &file)
-		reply := events.SearchReply{
-			RequestId: req.RequestId,
-			ReplyId:   replyId,
-			Type:      events.SearchTypeFiles,
-			Reply: map[string]any{
-				"providerId": file.ProviderId,
-				"path":       file.Path,
-			},
+	}
+
+	providerFilterList := bson.A{}
+	hiddenFilterList := bson.A{
+		bson.M{"trashed": true},
+	}
+	for _, space := range userSpaces {
+		for _, provider := range space.FileProviders {
+			// previous versions of files and the trash are hidden
+			if versionsProviderId, versionsPath := provider.VersionsLocation(); versionsProviderId != "" {
+				hiddenFilterList = append(hiddenFilterList, bson.M{
+					"providerId": versionsProviderId,
+					"path":       bson.M{"$regex": fmt.Sprintf("^%s(/|$)", regexp.QuoteMeta(versionsPath))},
+				})
+			}
+			if trashProviderId, trashPath := provider.TrashLocation(); trashProviderId != "" {
+				hiddenFilterList = append(hiddenFilterList, bson.M{
+					"providerId": trashProviderId,
+					"path":       bson.M{"$regex": fmt.Sprintf("^%s(/|$)", regexp.QuoteMeta(trashPath))},
+				})
+			}
+			providerIn := scopeDirs(provider.SpaceProviderId, spaceProviderIds, in)
+			providerNotIn := scopeDirs(provider.SpaceProviderId, spaceProviderIds, notIn)
+			for _, member := range provider.Members() {
+				for _, dir := range providerNotIn {
+					hiddenFilterList = append(hiddenFilterList, dirFilter(member, dir))
+				}
+				if len(in) > 0 {
+					for _, dir := range providerIn {
+						providerFilterList = append(providerFilterList, dirFilter(member, dir))
+					}
+					continue
+				}
+				providerFilter := bson.M{
+					"providerId": member.ProviderId,
+				}
+				if memberPath := path.Join("/", member.Path); memberPath != "/" {
+					providerFilter["path"] = bson.M{"$regex": fmt.Sprintf("^%s(/|$)", regexp.QuoteMeta(memberPath))}
+				}
+				providerFilterList = append(providerFilterList, providerFilter)
+			}
 		}
-		data, _ := json.Marshal(reply)
-		s.nc.Publish(replyTopic, data)
 	}
-	reply := events.SearchReply{
-		RequestId: req.RequestId,
-		ReplyId:   replyId,
-		Last:      true,
+	if len(providerFilterList) == 0 {
+		// none of the directories is in a space, $or must not be empty
+		providerFilterList = append(providerFilterList, bson.M{"_id": bson.M{"$exists": false}})
+	}
+
+	return bson.A{
+		bson.M{"$or": providerFilterList},
+		bson.M{"$nor": hiddenFilterList},
+	}
+}
+
+// dirFilter returns the condition for files in the directory of the member
+func dirFilter(member spaces.UnionMember, dir string) bson.M {
+	dir = path.Join("/", member.Path, dir)
+	if dir == "/" {
+		return bson.M{"providerId": member.ProviderId}
+	}
+	return bson.M{
+		"providerId": member.ProviderId,
+		"path":       bson.M{"$regex": fmt.Sprintf("^%s(/|$)", regexp.QuoteMeta(dir)), "$options": "i"},
 	}
-	data, _ = json.Marshal(reply)
-	s.nc.Publish(replyTopic, data)
 }
 
-func (s *search) replyError(requestId string, replyId string, err error) {
-	replyTopic := fmt.Sprintf(events.SearchReplyTopicPattern, requestId)
-	errReply := events.SearchReply{
-		RequestId: requestId,
-		ReplyId:   replyId,
-		Error:     err.Error(),
+// scopeDirs returns the directories that apply to the space provider.
+// Directories starting with the id of a space provider are relative to that space provider and
+// are left out for all others, the remaining directories apply to every space provider.
+func scopeDirs(spaceProviderId string, spaceProviderIds map[string]bool, dirs []string) []string {
+	scoped := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		first, rest, _ := strings.Cut(strings.TrimPrefix(dir, "/"), "/")
+		switch {
+		case first == spaceProviderId:
+			scoped = append(scoped, "/"+rest)
+		case !spaceProviderIds[first]:
+			scoped = append(scoped, dir)
+		}
 	}
-	data, _ := json.Marshal(errReply)
-	s.nc.Publish(replyTopic, data)
+	return scoped
 }
 
-func (s *search) mapSpace(userSpaces []spaces.Space, file *File) {
+// mapSpace changes the provider and path of the file to the space provider that the file is visible in
+func mapSpace(userSpaces []spaces.Space, file *File) {
 	for _, space := range userSpaces {
 		for _, provider := range space.FileProviders {
-			if provider.ProviderId == file.ProviderId && strings.HasPrefix(file.Path, provider.Path) {
-				file.ProviderId = provider.SpaceProviderId
-				file.Path = file.Path[len(provider.Path):]
-				file.Path = strings.TrimLeft(file.Path, "/")
+			for _, member := range provider.Members() {
+				if memberContains(member, file) {
+					file.ProviderId = provider.SpaceProviderId
+					file.Path = strings.TrimPrefix(file.Path, path.Join("/", member.Path))
+					file.Path = strings.TrimLeft(file.Path, "/")
+					return
+				}
 			}
 		}
 	}
@@ -0,0 +1,71 @@
+package fileindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+func TestSpaceFilterScopesDirectories(t *testing.T) {
+	userSpaces := []spaces.Space{{
+		FileProviders: []spaces.SpaceFileProvider{
+			{SpaceProviderId: "home", ProviderId: "disk", Path: "/home"},
+			{SpaceProviderId: "media", ProviderId: "nas"},
+		},
+	}}
+
+	// directories without a space provider apply to every space provider
+	filter := spaceFilter(userSpaces, []string{"/photos"}, nil)
+	assert.Equal(t, bson.A{
+		bson.M{"providerId": "disk", "path": bson.M{"$regex": "^/home/photos(/|$)", "$options": "i"}},
+		bson.M{"providerId": "nas", "path": bson.M{"$regex": "^/photos(/|$)", "$options": "i"}},
+	}, filter[0].(bson.M)["$or"])
+
+	// directories starting with a space provider only apply to that space provider
+	filter = spaceFilter(userSpaces, []string{"/media/photos"}, []string{"/home/private"})
+	assert.Equal(t, bson.A{
+		bson.M{"providerId": "nas", "path": bson.M{"$regex": "^/photos(/|$)", "$options": "i"}},
+	}, filter[0].(bson.M)["$or"])
+	assert.Equal(t, bson.A{
+		bson.M{"trashed": true},
+		bson.M{"providerId": "disk", "path": bson.M{"$regex": "^/home/private(/|$)", "$options": "i"}},
+	}, filter[1].(bson.M)["$nor"])
+
+	// the whole space provider
+	filter = spaceFilter(userSpaces, []string{"/media"}, nil)
+	assert.Equal(t, bson.A{bson.M{"providerId": "nas"}}, filter[0].(bson.M)["$or"])
+}
+
+func TestSpaceFilterMatchesMemberBoundaries(t *testing.T) {
+	userSpaces := []spaces.Space{{
+		FileProviders: []spaces.SpaceFileProvider{
+			{SpaceProviderId: "photos", ProviderId: "disk", Path: "/photos (2024)"},
+		},
+	}}
+
+	filter := spaceFilter(userSpaces, nil, nil)
+	assert.Equal(t, bson.A{
+		bson.M{"providerId": "disk", "path": bson.M{"$regex": `^/photos \(2024\)(/|$)`}},
+	}, filter[0].(bson.M)["$or"])
+}
+
+func TestMapSpaceMatchesMemberBoundaries(t *testing.T) {
+	userSpaces := []spaces.Space{{
+		FileProviders: []spaces.SpaceFileProvider{
+			{SpaceProviderId: "photos", ProviderId: "disk", Path: "/photos"},
+			{SpaceProviderId: "private", ProviderId: "disk", Path: "/photos-private"},
+		},
+	}}
+
+	file := File{ProviderId: "disk", Path: "/photos-private/a.jpg"}
+	mapSpace(userSpaces, &file)
+	assert.Equal(t, "private", file.ProviderId)
+	assert.Equal(t, "a.jpg", file.Path)
+
+	file = File{ProviderId: "disk", Path: "/photos/a.jpg"}
+	mapSpace(userSpaces, &file)
+	assert.Equal(t, "photos", file.ProviderId)
+	assert.Equal(t, "a.jpg", file.Path)
+}
@@ -0,0 +1,113 @@
+package fileindexer
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"slices"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/spaces/spaces"
+)
+
+// default number of entries per space provider in the storage breakdown
+const defaultStorageEntries = 20
+
+func (l *listing) handleStorageRequest(msg *nats.Msg) {
+	ctx := messaging.ExtractTraceContext(context.Background(), msg)
+	ctx, span := l.tracer.Start(ctx, "storage")
+	defer span.End()
+
+	req := events.StorageRequest{}
+	res := &events.StorageResponse{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil {
+		res, err = l.storage(ctx, &req)
+	}
+	if err != nil {
+		l.log.Error("error while reading storage", "error", err)
+		res = &events.StorageResponse{Error: err.Error()}
+	}
+
+	data, _ := json.Marshal(res)
+	msg.Respond(data)
+}
+
+// storage returns the size of each space provider of a space and its largest entries
+func (l *listing) storage(ctx context.Context, req *events.StorageRequest) (*events.StorageResponse, error) {
+	userSpaces, err := spaces.GetSpacesForUser(ctx, l.nc, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(userSpaces, func(space spaces.Space) bool {
+		return space.Id.Hex() == req.SpaceId
+	})
+	if i < 0 {
+		return &events.StorageResponse{NotFound: true, Error: "space not found"}, nil
+	}
+	space := userSpaces[i]
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultStorageEntries
+	}
+
+	res := &events.StorageResponse{
+		SpaceId:   req.SpaceId,
+		Providers: make([]events.ProviderStorage, 0, len(space.FileProviders)),
+	}
+	for _, provider := range space.FileProviders {
+		members := provider.Members()
+		providerStorage := events.ProviderStorage{
+			ProviderId: provider.SpaceProviderId,
+			Entries:    make([]events.FileEntry, 0),
+		}
+		for _, member := range members {
+			size, count, err := l.dirTotals(ctx, member.ProviderId, path.Join("/", member.Path))
+			if err != nil {
+				return nil, err
+			}
+			providerStorage.Size += size
+			providerStorage.FileCount += count
+		}
+
+		entries, err := l.readDir(ctx, userSpaces, members, "/")
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(entries, func(a, b scoredFile) int {
+			return cmp.Or(cmp.Compare(b.File.Size, a.File.Size), cmp.Compare(a.File.Path, b.File.Path))
+		})
+		for _, entry := range entries[:min(limit, len(entries))] {
+			providerStorage.Entries = append(providerStorage.Entries, newFileEntry(provider.SpaceProviderId, "/", &entry.File))
+		}
+
+		res.Size += providerStorage.Size
+		res.FileCount += providerStorage.FileCount
+		res.Providers = append(res.Providers, providerStorage)
+	}
+	return res, nil
+}
+
+// dirTotals returns the size and number of all files below a directory, including hidden files
+func (l *listing) dirTotals(ctx context.Context, providerId string, dir string) (int64, int64, error) {
+	filter := FilePrototype{}
+	filter.ProviderId.Set(providerId)
+	filter.Path.Set(dir)
+
+	file := File{}
+	err := l.files.FindOne(ctx, filter).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	size, count := file.sizeAndCount()
+	return size, count, nil
+}
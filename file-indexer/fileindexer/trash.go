@@ -0,0 +1,166 @@
+package fileindexer
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"umbasa.net/seraph/events"
+)
+
+// handleTrashEvent updates the index for files that were moved to or restored from the trash, or purged.
+// Events that can't be read are dropped, an error is returned if the event should be processed again.
+func (c *consumer) handleTrashEvent(ctx context.Context, data []byte) error {
+	ctx, span := c.tracer.Start(ctx, "handleTrash")
+	defer span.End()
+
+	ev := events.FileTrashEvent{}
+	err := json.Unmarshal(data, &ev)
+	if err != nil {
+		c.log.Error("failed to deserialize trash event", "error", err)
+		return nil
+	}
+
+	switch ev.Action {
+	case events.FileTrashActionTrash:
+		err = c.moveFiles(ctx, ev.ProviderId, ev.Path, ev.TrashPath, true, events.FileChangedEventTrashed)
+	case events.FileTrashActionRestore:
+		err = c.moveFiles(ctx, ev.ProviderId, ev.TrashPath, ev.Path, false, events.FileChangedEventRestored)
+	case events.FileTrashActionPurge:
+		// the directory that was created in the trash for the item is removed along with it
+		err = c.deleteFiles(ctx, ev.ProviderId, path.Dir(ev.TrashPath))
+	default:
+		c.log.Error("unknown trash action", "event", ev)
+		return nil
+	}
+
+	if err != nil {
+		c.log.Error("error processing trash event", "error", err, "event", ev)
+	}
+	return err
+}
+
+// moveFiles updates the path of a file and all files below it in place,
+// so that files that were moved, or moved in or out of the trash, keep their id and metadata
+func (c *consumer) moveFiles(ctx context.Context, providerId string, from string, to string, trashed bool, change string) error {
+	ctx, span := c.tracer.Start(ctx, "moveFiles")
+	defer span.End()
+
+	from = path.Clean(from)
+	to = path.Clean(to)
+	c.dirs.invalidate(providerId, from)
+	c.dirs.invalidate(providerId, to)
+
+	parentDir := FilePrototype{}
+	parentDir.ProviderId.Set(providerId)
+	parentDir.Path.Set(path.Dir(to))
+	parentDir.IsDir.Set(true)
+	parent, _, err := c.upsertFile(ctx, &parentDir)
+	if err != nil {
+		return err
+	}
+
+	// the files at the destination may already have been picked up from the file provider,
+	// or they were overwritten by the move
+	err = c.deleteFiles(ctx, providerId, to)
+	if err != nil {
+		return err
+	}
+
+	cur, err := c.files.Find(ctx, subtreeFilter(providerId, from))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		file := File{}
+		err := cur.Decode(&file)
+		if err != nil {
+			return err
+		}
+
+		oldPath := file.Path
+		file.Path = to + strings.TrimPrefix(file.Path, from)
+		file.Trashed = trashed
+
+		proto := FilePrototype{}
+		proto.Path.Set(file.Path)
+		proto.SearchWords.Set(strings.TrimSpace(searchWordsRegex.ReplaceAllString(file.Path, " ")))
+		proto.NameGrams.Set(nameGrams(file.Path))
+		proto.Trashed.Set(trashed)
+		if file.Path == to {
+			// the moved files are counted in the new parent directories
+			size, count := file.sizeAndCount()
+			if err := c.updateDirSizes(ctx, file.ParentDir, -size, -count); err != nil {
+				return err
+			}
+			if err := c.updateDirSizes(ctx, parent.Id, size, count); err != nil {
+				return err
+			}
+			file.ParentDir = parent.Id
+			proto.ParentDir.Set(parent.Id)
+		}
+
+		filter := FilePrototype{}
+		filter.Id.Set(file.Id)
+
+		_, err = c.files.UpdateOne(ctx, filter, bson.M{"$set": proto})
+		if err != nil {
+			return err
+		}
+
+		c.publishChangeFrom(ctx, &file, change, oldPath)
+	}
+
+	return cur.Err()
+}
+
+// deleteFiles removes a file and all files below it from the index
+func (c *consumer) deleteFiles(ctx context.Context, providerId string, filePath string) error {
+	ctx, span := c.tracer.Start(ctx, "deleteFiles")
+	defer span.End()
+
+	filePath = path.Clean(filePath)
+	c.dirs.invalidate(providerId, filePath)
+	err := c.removeDirSize(ctx, providerId, filePath)
+	if err != nil {
+		return err
+	}
+
+	filter := subtreeFilter(providerId, filePath)
+	cur, err := c.files.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	deletedIds := make([]primitive.ObjectID, 0)
+	for cur.Next(ctx) {
+		var f File
+		cur.Decode(&f)
+		c.publishChange(ctx, &f, events.FileChangedEventDeleted)
+		deletedIds = append(deletedIds, f.Id)
+	}
+
+	_, err = c.files.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	c.removeContents(ctx, deletedIds)
+	return nil
+}
+
+func subtreeFilter(providerId string, filePath string) bson.M {
+	return bson.M{
+		"providerId": providerId,
+		"$or": bson.A{
+			bson.M{"path": filePath},
+			bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(filePath) + "/"}},
+		},
+	}
+}
@@ -0,0 +1,446 @@
+package fileindexer
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"umbasa.net/seraph/entities"
+	"umbasa.net/seraph/events"
+)
+
+// how long the id of a directory is cached,
+// directories can be removed from the index by other instances of the file indexer
+var dirCacheTTL = time.Minute
+
+// maximum number of cached directory ids, the cache is emptied when it is full
+const dirCacheSize = 100000
+
+// registry that encodes prototypes, to apply them to files in memory
+var prototypeRegistry = func() *bsoncodec.Registry {
+	r := bson.NewRegistry()
+	entities.RegisterEncoders(r)
+	return r
+}()
+
+type dirKey struct {
+	providerId string
+	path       string
+}
+
+func fileKey(file *FilePrototype) dirKey {
+	return dirKey{file.ProviderId.Get(), file.Path.Get()}
+}
+
+func (k dirKey) parent() dirKey {
+	return dirKey{k.providerId, path.Dir(k.path)}
+}
+
+// dirCache keeps the ids of recently used directories, so that the parents of a file
+// don't have to be looked up or created for every file
+type dirCache struct {
+	mu      sync.Mutex
+	entries map[dirKey]dirCacheEntry
+}
+
+type dirCacheEntry struct {
+	id   primitive.ObjectID
+	time time.Time
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{entries: make(map[dirKey]dirCacheEntry)}
+}
+
+func (d *dirCache) get(key dirKey) (primitive.ObjectID, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[key]
+	if !ok || time.Since(entry.time) > dirCacheTTL {
+		return primitive.NilObjectID, false
+	}
+	return entry.id, true
+}
+
+func (d *dirCache) put(key dirKey, id primitive.ObjectID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) >= dirCacheSize {
+		d.entries = make(map[dirKey]dirCacheEntry)
+	}
+	d.entries[key] = dirCacheEntry{id, time.Now()}
+}
+
+// invalidate removes the directory at dirPath and all directories below it from the cache
+func (d *dirCache) invalidate(providerId string, dirPath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+	for key := range d.entries {
+		if key.providerId == providerId && (key.path == dirPath || strings.HasPrefix(key.path, prefix)) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// upsertResult is the outcome of adding or updating one file in the index
+type upsertResult struct {
+	file   *File
+	change string
+	err    error
+}
+
+func (c *consumer) upsertFile(ctx context.Context, file *FilePrototype) (*File, string, error) {
+	results, err := c.upsertFiles(ctx, []*FilePrototype{file})
+	if err != nil {
+		return nil, "", err
+	}
+	return results[0].file, results[0].change, results[0].err
+}
+
+// upsertFiles adds files to the index or updates them, and creates their parent directories.
+// The files are written with one bulk write, a file that appears more than once starts a new bulk write.
+func (c *consumer) upsertFiles(ctx context.Context, files []*FilePrototype) ([]upsertResult, error) {
+	ctx, span := c.tracer.Start(ctx, "upsertFiles")
+	defer span.End()
+
+	results := make([]upsertResult, 0, len(files))
+	for start := 0; start < len(files); {
+		end := start
+		seen := make(map[dirKey]bool)
+		for end < len(files) && !seen[fileKey(files[end])] {
+			seen[fileKey(files[end])] = true
+			end++
+		}
+		chunk, err := c.upsertChunk(ctx, files[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, chunk...)
+		start = end
+	}
+	return results, nil
+}
+
+// upsertChunk writes files with distinct paths to the index
+func (c *consumer) upsertChunk(ctx context.Context, files []*FilePrototype) ([]upsertResult, error) {
+	dirs, err := c.ensureParents(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]dirKey, len(files))
+	for i, file := range files {
+		keys[i] = fileKey(file)
+	}
+	existing, err := c.findFiles(ctx, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]upsertResult, len(files))
+	models := make([]mongo.WriteModel, len(files))
+	for i, file := range files {
+		if keys[i].path != "/" {
+			file.ParentDir.Set(dirs[keys[i].parent()])
+		}
+
+		old := existing[keys[i]]
+		newFile := &File{Id: primitive.NewObjectID()}
+		if old != nil {
+			*newFile = *old
+		}
+		if err := applyPrototype(newFile, file); err != nil {
+			return nil, err
+		}
+		change := fileChange(old, newFile)
+		if change == "" && !newFile.IsDir {
+			// unchanged files don't need to be processed
+			file.Pending.Set(false)
+			newFile.Pending = false
+		}
+		results[i] = upsertResult{file: newFile, change: change}
+
+		onInsert := bson.M{"_id": newFile.Id}
+		if newFile.IsDir {
+			onInsert["totalSize"] = int64(0)
+			onInsert["fileCount"] = int64(0)
+		}
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"providerId": keys[i].providerId, "path": keys[i].path}).
+			SetUpdate(bson.M{"$set": file, "$setOnInsert": onInsert}).
+			SetUpsert(true)
+	}
+
+	res, err := c.files.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err := writeErrors(err, results); err != nil {
+		return nil, err
+	}
+
+	// files that were added by someone else since they were looked up
+	raced := make(map[dirKey]bool)
+	for i, result := range results {
+		if _, upserted := res.UpsertedIDs[int64(i)]; result.err == nil && existing[keys[i]] == nil && !upserted {
+			raced[keys[i]] = true
+		}
+	}
+	if len(raced) > 0 {
+		racedKeys := make([]dirKey, 0, len(raced))
+		for key := range raced {
+			racedKeys = append(racedKeys, key)
+		}
+		current, err := c.findFiles(ctx, racedKeys, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := range results {
+			if file, ok := current[keys[i]]; ok && raced[keys[i]] {
+				results[i] = upsertResult{file: file, change: events.FileChangedEventChanged}
+			}
+		}
+	}
+
+	// the sizes of files that were added by someone else were counted by them
+	sizes := make(map[dirKey]dirSize)
+	for i, result := range results {
+		if result.err != nil || result.file == nil {
+			continue
+		}
+		if result.file.IsDir {
+			c.dirs.put(keys[i], result.file.Id)
+		}
+		old := existing[keys[i]]
+		switch {
+		case raced[keys[i]] || result.file.IsDir:
+		case old == nil:
+			addDirSize(sizes, keys[i], result.file.Size, 1)
+		case !old.IsDir:
+			addDirSize(sizes, keys[i], result.file.Size-old.Size, 0)
+		}
+	}
+
+	err = c.incDirSizes(ctx, dirs, sizes)
+	return results, err
+}
+
+// ensureParents returns the ids of all directories above the files, directories that are not in the index are created
+func (c *consumer) ensureParents(ctx context.Context, files []*FilePrototype) (map[dirKey]primitive.ObjectID, error) {
+	ctx, span := c.tracer.Start(ctx, "ensureParents")
+	defer span.End()
+
+	// all directories up to the root are needed to update the directory sizes
+	dirs := make(map[dirKey]primitive.ObjectID)
+	missing := make(map[dirKey]bool)
+	for _, file := range files {
+		for key := fileKey(file); key.path != "/"; {
+			key = key.parent()
+			if _, ok := dirs[key]; ok || missing[key] {
+				// the directories above were visited with another file
+				break
+			}
+			if id, ok := c.dirs.get(key); ok {
+				dirs[key] = id
+			} else {
+				missing[key] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return dirs, nil
+	}
+
+	keys := make([]dirKey, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	found, err := c.findFiles(ctx, keys, bson.M{"_id": 1, "providerId": 1, "path": 1})
+	if err != nil {
+		return nil, err
+	}
+	for key, dir := range found {
+		dirs[key] = dir.Id
+		c.dirs.put(key, dir.Id)
+		delete(missing, key)
+	}
+
+	// directories are created from the top, so that each level knows the ids of its parents
+	levels := make(map[int][]dirKey)
+	for key := range missing {
+		depth := strings.Count(key.path, "/")
+		if key.path == "/" {
+			depth = 0
+		}
+		levels[depth] = append(levels[depth], key)
+	}
+	depths := make([]int, 0, len(levels))
+	for depth := range levels {
+		depths = append(depths, depth)
+	}
+	sort.Ints(depths)
+
+	for _, depth := range depths {
+		if err := c.createDirs(ctx, levels[depth], dirs); err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// createDirs adds directories whose parents are in dirs to the index, and adds their ids to dirs
+func (c *consumer) createDirs(ctx context.Context, keys []dirKey, dirs map[dirKey]primitive.ObjectID) error {
+	ids := make([]primitive.ObjectID, len(keys))
+	models := make([]mongo.WriteModel, len(keys))
+	for i, key := range keys {
+		ids[i] = primitive.NewObjectID()
+		dir := FilePrototype{}
+		dir.ProviderId.Set(key.providerId)
+		dir.Path.Set(key.path)
+		dir.IsDir.Set(true)
+		if key.path != "/" {
+			dir.ParentDir.Set(dirs[key.parent()])
+		}
+		onInsert := bson.M{"_id": ids[i], "totalSize": int64(0), "fileCount": int64(0)}
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"providerId": key.providerId, "path": key.path}).
+			SetUpdate(bson.M{"$set": dir, "$setOnInsert": onInsert}).
+			SetUpsert(true)
+	}
+
+	res, err := c.files.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+
+	raced := make([]dirKey, 0)
+	for i, key := range keys {
+		if _, ok := res.UpsertedIDs[int64(i)]; ok {
+			dirs[key] = ids[i]
+			c.dirs.put(key, ids[i])
+		} else {
+			// the directory was created by someone else in the meantime
+			raced = append(raced, key)
+		}
+	}
+	if len(raced) == 0 {
+		return nil
+	}
+	found, err := c.findFiles(ctx, raced, bson.M{"_id": 1, "providerId": 1, "path": 1})
+	if err != nil {
+		return err
+	}
+	for key, dir := range found {
+		dirs[key] = dir.Id
+		c.dirs.put(key, dir.Id)
+	}
+	return nil
+}
+
+// findFiles looks up files in the index by provider and path
+func (c *consumer) findFiles(ctx context.Context, keys []dirKey, projection bson.M) (map[dirKey]*File, error) {
+	paths := make(map[string][]string)
+	for _, key := range keys {
+		paths[key.providerId] = append(paths[key.providerId], key.path)
+	}
+	or := bson.A{}
+	for providerId, providerPaths := range paths {
+		or = append(or, bson.M{"providerId": providerId, "path": bson.M{"$in": providerPaths}})
+	}
+
+	opts := options.Find()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	cur, err := c.files.Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	files := make(map[dirKey]*File, len(keys))
+	for cur.Next(ctx) {
+		file := &File{}
+		if err := cur.Decode(file); err != nil {
+			return nil, err
+		}
+		files[dirKey{file.ProviderId, file.Path}] = file
+	}
+	return files, cur.Err()
+}
+
+// incDirSizes adds the sizes to the directories above the files in one bulk write
+func (c *consumer) incDirSizes(ctx context.Context, dirs map[dirKey]primitive.ObjectID, sizes map[dirKey]dirSize) error {
+	models := make([]mongo.WriteModel, 0, len(sizes))
+	for key, sum := range sizes {
+		id, ok := dirs[key]
+		if !ok || (sum.size == 0 && sum.count == 0) {
+			continue
+		}
+		update := bson.M{"$inc": bson.M{"totalSize": sum.size, "fileCount": sum.count}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id}).SetUpdate(update))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err := c.files.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// addDirSize adds size and count to all directories above the file
+func addDirSize(sizes map[dirKey]dirSize, file dirKey, size int64, count int64) {
+	for key := file; key.path != "/"; {
+		key = key.parent()
+		sum := sizes[key]
+		sum.size += size
+		sum.count += count
+		sizes[key] = sum
+	}
+}
+
+// applyPrototype sets the fields of the file that are defined in the prototype
+func applyPrototype(file *File, proto *FilePrototype) error {
+	data, err := bson.MarshalWithRegistry(prototypeRegistry, proto)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, file)
+}
+
+// fileChange returns how the file changed compared to the file that was in the index before
+func fileChange(old *File, file *File) string {
+	if old == nil {
+		return events.FileChangedEventCreated
+	}
+	if old.Pending {
+		// consumer was previously interrupted while processing changes for the file
+		return events.FileChangedEventChanged
+	}
+	if old.ModTime != file.ModTime || old.Size != file.Size || old.Mode != file.Mode {
+		return events.FileChangedEventChanged
+	}
+	return ""
+}
+
+// writeErrors sets the errors of the files that could not be written,
+// it returns err if it doesn't belong to single files
+func writeErrors(err error, results []upsertResult) error {
+	if err == nil {
+		return nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 || bulkErr.WriteConcernError != nil {
+		return err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		results[writeErr.Index].err = writeErr
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package fileindexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/trace/noop"
+	"umbasa.net/seraph/events"
+)
+
+var benchMongo struct {
+	once     sync.Once
+	client   *mongo.Client
+	commands atomic.Int64
+	err      error
+}
+
+// benchMongoClient starts mongodb in a container for the benchmarks, they are skipped if docker is not available
+func benchMongoClient(b *testing.B) *mongo.Client {
+	benchMongo.once.Do(func() {
+		defer func() {
+			// testcontainers panics if it can't find docker
+			if r := recover(); r != nil {
+				benchMongo.err = fmt.Errorf("%v", r)
+			}
+		}()
+		ctx := context.Background()
+		container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+			ContainerRequest: testcontainers.ContainerRequest{
+				Image:        "mongo:8",
+				ExposedPorts: []string{"27017/tcp"},
+			},
+			Started: true,
+		})
+		if err != nil {
+			benchMongo.err = err
+			return
+		}
+		endpoint, err := container.Endpoint(ctx, "")
+		if err != nil {
+			benchMongo.err = err
+			return
+		}
+
+		opts := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s/", endpoint))
+		opts.SetRegistry(prototypeRegistry)
+		opts.SetMonitor(&event.CommandMonitor{
+			Started: func(context.Context, *event.CommandStartedEvent) {
+				benchMongo.commands.Add(1)
+			},
+		})
+		benchMongo.client, benchMongo.err = mongo.Connect(ctx, opts)
+	})
+	if benchMongo.err != nil {
+		b.Skip("mongodb is not available: ", benchMongo.err)
+	}
+	return benchMongo.client
+}
+
+// benchTree returns the file events of a directory tree with the given depth and number of directories and files per directory
+func benchTree(depth int, dirs int, files int) []events.FileInfoEvent {
+	tree := make([]events.FileInfoEvent, 0)
+	var walk func(dir string, level int)
+	walk = func(dir string, level int) {
+		for i := 0; i < files; i++ {
+			tree = append(tree, events.FileInfoEvent{
+				ProviderID: "bench",
+				Path:       fmt.Sprintf("%s/file%d.jpg", dir, i),
+				Size:       int64(1000 + i),
+				ModTime:    int64(level*100 + i),
+			})
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < dirs; i++ {
+			sub := fmt.Sprintf("%s/dir%d", dir, i)
+			tree = append(tree, events.FileInfoEvent{ProviderID: "bench", Path: sub, IsDir: true})
+			walk(sub, level+1)
+		}
+	}
+	walk("", 0)
+	return tree
+}
+
+func BenchmarkUpsertFiles(b *testing.B) {
+	tree := benchTree(4, 4, 10)
+
+	for _, batchSize := range []int{1, 100} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			client := benchMongoClient(b)
+			ctx := context.Background()
+			files := client.Database("fileindexer_bench").Collection(filesCollection)
+
+			var commands int64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := files.Drop(ctx); err != nil {
+					b.Fatal(err)
+				}
+				_, err := files.Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "providerId", Value: 1}, {Key: "path", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				c := &consumer{
+					tracer: noop.NewTracerProvider().Tracer("bench"),
+					files:  files,
+					dirs:   newDirCache(),
+				}
+				start := benchMongo.commands.Load()
+				b.StartTimer()
+
+				for offset := 0; offset < len(tree); offset += batchSize {
+					batch := make([]*FilePrototype, 0, batchSize)
+					for j := offset; j < min(offset+batchSize, len(tree)); j++ {
+						file := newFilePrototype(&tree[j])
+						batch = append(batch, &file)
+					}
+					if _, err := c.upsertFiles(ctx, batch); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.StopTimer()
+				commands += benchMongo.commands.Load() - start
+				b.StartTimer()
+			}
+
+			b.ReportMetric(float64(len(tree)*b.N)/b.Elapsed().Seconds(), "files/s")
+			b.ReportMetric(float64(commands)/float64(len(tree)*b.N), "commands/file")
+		})
+	}
+}
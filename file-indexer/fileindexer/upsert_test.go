@@ -0,0 +1,103 @@
+package fileindexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"umbasa.net/seraph/events"
+)
+
+func TestDirCache(t *testing.T) {
+	cache := newDirCache()
+	photos := dirKey{"p", "/photos"}
+	trip := dirKey{"p", "/photos/trip"}
+	other := dirKey{"q", "/photos"}
+	photosId := primitive.NewObjectID()
+	tripId := primitive.NewObjectID()
+
+	_, ok := cache.get(photos)
+	assert.False(t, ok)
+
+	cache.put(photos, photosId)
+	cache.put(trip, tripId)
+	cache.put(other, primitive.NewObjectID())
+	cache.put(dirKey{"p", "/photoshop"}, primitive.NewObjectID())
+
+	id, ok := cache.get(trip)
+	assert.True(t, ok)
+	assert.Equal(t, tripId, id)
+
+	cache.invalidate("p", "/photos")
+	_, ok = cache.get(photos)
+	assert.False(t, ok)
+	_, ok = cache.get(trip)
+	assert.False(t, ok)
+	_, ok = cache.get(other)
+	assert.True(t, ok)
+	_, ok = cache.get(dirKey{"p", "/photoshop"})
+	assert.True(t, ok)
+}
+
+func TestDirCacheTTL(t *testing.T) {
+	defer func(ttl time.Duration) { dirCacheTTL = ttl }(dirCacheTTL)
+	dirCacheTTL = time.Millisecond
+
+	cache := newDirCache()
+	cache.put(dirKey{"p", "/photos"}, primitive.NewObjectID())
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := cache.get(dirKey{"p", "/photos"})
+	assert.False(t, ok)
+}
+
+func TestAddDirSize(t *testing.T) {
+	sizes := make(map[dirKey]dirSize)
+	addDirSize(sizes, dirKey{"p", "/photos/trip/a.jpg"}, 100, 1)
+	addDirSize(sizes, dirKey{"p", "/photos/b.jpg"}, 10, 1)
+	addDirSize(sizes, dirKey{"p", "/photos/trip/c.jpg"}, -5, 0)
+
+	assert.Equal(t, map[dirKey]dirSize{
+		{"p", "/"}:            {size: 105, count: 2},
+		{"p", "/photos"}:      {size: 105, count: 2},
+		{"p", "/photos/trip"}: {size: 95, count: 1},
+	}, sizes)
+}
+
+func TestApplyPrototype(t *testing.T) {
+	id := primitive.NewObjectID()
+	file := File{Id: id, ProviderId: "p", Path: "/a.txt", Size: 10, Mime: "text/plain", Pending: true}
+
+	proto := FilePrototype{}
+	proto.Size.Set(20)
+	proto.Pending.Set(false)
+
+	err := applyPrototype(&file, &proto)
+	assert.NoError(t, err)
+	assert.Equal(t, File{Id: id, ProviderId: "p", Path: "/a.txt", Size: 20, Mime: "text/plain"}, file)
+}
+
+func TestFileChange(t *testing.T) {
+	file := &File{Size: 10, ModTime: 100, Mode: 0644}
+
+	assert.Equal(t, events.FileChangedEventCreated, fileChange(nil, file))
+	assert.Equal(t, "", fileChange(&File{Size: 10, ModTime: 100, Mode: 0644}, file))
+	assert.Equal(t, events.FileChangedEventChanged, fileChange(&File{Size: 10, ModTime: 99, Mode: 0644}, file))
+	assert.Equal(t, events.FileChangedEventChanged, fileChange(&File{Size: 10, ModTime: 100, Mode: 0644, Pending: true}, file))
+}
+
+func TestWriteErrors(t *testing.T) {
+	results := make([]upsertResult, 3)
+	err := writeErrors(mongo.BulkWriteException{
+		WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000}}},
+	}, results)
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].err)
+	assert.Error(t, results[1].err)
+	assert.NoError(t, results[2].err)
+
+	err = writeErrors(mongo.ErrClientDisconnected, results)
+	assert.ErrorIs(t, err, mongo.ErrClientDisconnected)
+}
@@ -0,0 +1,473 @@
+package fileindexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"umbasa.net/seraph/events"
+)
+
+// seconds between the ISO BMFF epoch (1904-01-01) and the unix epoch
+const bmffEpochOffset = 2082844800
+
+// matroska dates are nanoseconds since 2001-01-01
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maximum number of top level elements of a matroska segment that are visited,
+// to avoid walking through all clusters of files where the tracks are stored at the end
+const maxMatroskaElements = 256
+
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".qt": true, ".3gp": true, ".3g2": true,
+	".mkv": true, ".mk3d": true, ".webm": true,
+}
+
+// common names for the codec identifiers of ISO BMFF sample entries
+var bmffCodecs = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "av01": "av1",
+	"vp08": "vp8", "vp09": "vp9", "mp4v": "mpeg4", "jpeg": "mjpeg", "mjpa": "mjpeg",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+	"mp4a": "aac", "ac-3": "ac3", "ec-3": "eac3", "Opus": "opus", "fLaC": "flac",
+	"alac": "alac", ".mp3": "mp3", "lpcm": "pcm", "sowt": "pcm", "twos": "pcm",
+}
+
+// common names for matroska codec ids, matched by prefix
+var matroskaCodecs = []struct{ prefix, name string }{
+	{"V_MPEG4/ISO/AVC", "h264"}, {"V_MPEGH/ISO/HEVC", "hevc"}, {"V_AV1", "av1"},
+	{"V_VP8", "vp8"}, {"V_VP9", "vp9"}, {"V_MPEG4/", "mpeg4"}, {"V_MPEG2", "mpeg2"},
+	{"V_MJPEG", "mjpeg"}, {"V_PRORES", "prores"}, {"V_THEORA", "theora"},
+	{"A_AAC", "aac"}, {"A_OPUS", "opus"}, {"A_VORBIS", "vorbis"}, {"A_MPEG/L3", "mp3"},
+	{"A_AC3", "ac3"}, {"A_EAC3", "eac3"}, {"A_FLAC", "flac"}, {"A_DTS", "dts"},
+	{"A_PCM", "pcm"}, {"A_ALAC", "alac"}, {"A_TRUEHD", "truehd"},
+}
+
+// matroska element ids
+const (
+	ebmlHeaderId      = 0x1A45DFA3
+	ebmlDocTypeId     = 0x4282
+	mkvSegmentId      = 0x18538067
+	mkvInfoId         = 0x1549A966
+	mkvTimestampScale = 0x2AD7B1
+	mkvDurationId     = 0x4489
+	mkvDateUtcId      = 0x4461
+	mkvTracksId       = 0x1654AE6B
+	mkvTrackEntryId   = 0xAE
+	mkvTrackTypeId    = 0x83
+	mkvCodecId        = 0x86
+	mkvVideoId        = 0xE0
+	mkvPixelWidthId   = 0xB0
+	mkvPixelHeightId  = 0xBA
+	mkvProjectionId   = 0x7670
+	mkvPoseRollId     = 0x7675
+	mkvClusterId      = 0x1F43B675
+)
+
+var errNoVideoMetadata = errors.New("no video metadata found")
+
+func isVideo(mimeType string, name string) bool {
+	return strings.HasPrefix(mimeType, "video/") || videoExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// videoAnalyzer reads duration, dimensions and codecs from the headers of a video file.
+// The metadata is cleared if the file is not a video or the headers can not be parsed.
+type videoAnalyzer struct {
+	c *consumer
+}
+
+func (a videoAnalyzer) Name() string {
+	return "video"
+}
+
+func (a videoAnalyzer) Handles(mimeType string, name string) bool {
+	return a.c.videoEnabled && isVideo(mimeType, name)
+}
+
+func (a videoAnalyzer) Ranges(file *File) []ByteRange {
+	// the movie header is at the start or at the end of the file, all other headers are read as needed
+	return []ByteRange{Head(readAheadSize), Tail(readAheadSize)}
+}
+
+func (a videoAnalyzer) Analyze(ctx context.Context, file *File, r io.ReaderAt, proto *FilePrototype) error {
+	video, err := readVideoMetadata(r, file.Size)
+	if errors.Is(err, errNoVideoMetadata) {
+		a.c.log.Debug("No video metadata found", "path", file.Path)
+		proto.Video.Set(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	a.c.log.Debug("Extracted video metadata", "path", file.Path, "duration", video.Duration, "codec", video.VideoCodec)
+	proto.Video.Set(video)
+	return nil
+}
+
+func (a videoAnalyzer) Reset(ctx context.Context, file *File, proto *FilePrototype) {
+	proto.Video.Set(nil)
+}
+
+// readVideoMetadata parses the headers of MP4/MOV (ISO BMFF) and Matroska/WebM files
+func readVideoMetadata(r io.ReaderAt, size int64) (*events.VideoMetadata, error) {
+	head := readAt(r, 0, 12)
+	if len(head) < 12 {
+		return nil, errNoVideoMetadata
+	}
+
+	video := &events.VideoMetadata{}
+	switch {
+	case binary.BigEndian.Uint32(head[0:4]) == ebmlHeaderId:
+		readMatroska(r, size, video)
+	case isBmffBox(string(head[4:8])):
+		readBmffVideo(r, size, video)
+	}
+
+	if video.Duration == 0 && video.Width == 0 && video.VideoCodec == "" {
+		return nil, errNoVideoMetadata
+	}
+	return video, nil
+}
+
+func isBmffBox(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+func readBmffVideo(r io.ReaderAt, size int64, video *events.VideoMetadata) {
+	moov := findBox(bmffBoxes(r, 0, size), "moov")
+	if moov == nil {
+		return
+	}
+	boxes := bmffBoxes(r, moov.offset, moov.offset+moov.size)
+
+	if mvhd := findBox(boxes, "mvhd"); mvhd != nil {
+		data := readAt(r, mvhd.offset, min(mvhd.size, 32))
+		var created, timescale, duration uint64
+		switch {
+		case len(data) >= 20 && data[0] == 0:
+			created = uint64(binary.BigEndian.Uint32(data[4:8]))
+			timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+		case len(data) >= 32 && data[0] == 1:
+			created = binary.BigEndian.Uint64(data[4:12])
+			timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+			duration = binary.BigEndian.Uint64(data[24:32])
+		}
+		if timescale > 0 && duration != math.MaxUint32 && duration != math.MaxUint64 {
+			video.Duration = int64(duration * 1000 / timescale)
+		}
+		if created > bmffEpochOffset {
+			video.CreationTime = int64(created - bmffEpochOffset)
+		}
+	}
+
+	for _, trak := range boxes {
+		if trak.typ != "trak" {
+			continue
+		}
+		children := bmffBoxes(r, trak.offset, trak.offset+trak.size)
+		mdia := findBox(children, "mdia")
+		if mdia == nil {
+			continue
+		}
+		mdiaChildren := bmffBoxes(r, mdia.offset, mdia.offset+mdia.size)
+		hdlr := findBox(mdiaChildren, "hdlr")
+		if hdlr == nil {
+			continue
+		}
+		handler := string(readAt(r, hdlr.offset+8, 4))
+		codec, entry := bmffSampleEntry(r, mdiaChildren)
+
+		switch handler {
+		case "vide":
+			if video.VideoCodec != "" {
+				continue
+			}
+			video.VideoCodec = codec
+			// coded dimensions of the visual sample entry
+			if len(entry) >= 36 {
+				video.Width = int(binary.BigEndian.Uint16(entry[32:34]))
+				video.Height = int(binary.BigEndian.Uint16(entry[34:36]))
+			}
+			if tkhd := findBox(children, "tkhd"); tkhd != nil {
+				width, height, rotation := bmffTrackHeader(readAt(r, tkhd.offset, min(tkhd.size, 104)))
+				if video.Width == 0 {
+					video.Width, video.Height = width, height
+				}
+				video.Rotation = rotation
+			}
+		case "soun":
+			if video.AudioCodec == "" {
+				video.AudioCodec = codec
+			}
+		}
+	}
+}
+
+// bmffSampleEntry returns the codec name and the data of the first sample entry of a track
+func bmffSampleEntry(r io.ReaderAt, mdiaChildren []bmffBox) (string, []byte) {
+	minf := findBox(mdiaChildren, "minf")
+	if minf == nil {
+		return "", nil
+	}
+	stbl := findBox(bmffBoxes(r, minf.offset, minf.offset+minf.size), "stbl")
+	if stbl == nil {
+		return "", nil
+	}
+	stsd := findBox(bmffBoxes(r, stbl.offset, stbl.offset+stbl.size), "stsd")
+	if stsd == nil {
+		return "", nil
+	}
+	// full box header and entry count, followed by the sample entries
+	entry := readAt(r, stsd.offset+8, min(stsd.size-8, 64))
+	if len(entry) < 8 {
+		return "", nil
+	}
+	format := string(entry[4:8])
+	if name, ok := bmffCodecs[format]; ok {
+		return name, entry
+	}
+	return strings.TrimSpace(format), entry
+}
+
+// bmffTrackHeader returns the presentation size and the rotation of the transformation matrix of a track header
+func bmffTrackHeader(data []byte) (width int, height int, rotation int) {
+	// the matrix follows the track id, duration and some reserved fields
+	matrixOffset := 40
+	if len(data) > 0 && data[0] == 1 {
+		matrixOffset = 52
+	}
+	if len(data) < matrixOffset+44 {
+		return
+	}
+	a := int32(binary.BigEndian.Uint32(data[matrixOffset : matrixOffset+4]))
+	b := int32(binary.BigEndian.Uint32(data[matrixOffset+4 : matrixOffset+8]))
+	width = int(binary.BigEndian.Uint32(data[matrixOffset+36:matrixOffset+40]) >> 16)
+	height = int(binary.BigEndian.Uint32(data[matrixOffset+40:matrixOffset+44]) >> 16)
+	rotation = normalizeRotation(math.Atan2(float64(b), float64(a)) * 180 / math.Pi)
+	return
+}
+
+// normalizeRotation rounds the angle to a multiple of 90 degrees between 0 and 270
+func normalizeRotation(degrees float64) int {
+	rotation := int(math.Round(degrees/90)) * 90
+	return ((rotation % 360) + 360) % 360
+}
+
+// ebmlElement is a matroska element with the offset and size of its data
+type ebmlElement struct {
+	id     uint64
+	offset int64
+	size   int64
+}
+
+func readMatroska(r io.ReaderAt, size int64, video *events.VideoMetadata) {
+	header, ok := readEbmlElement(r, 0, size)
+	if !ok || header.id != ebmlHeaderId {
+		return
+	}
+	docType := ""
+	for _, element := range ebmlChildren(r, header) {
+		if element.id == ebmlDocTypeId {
+			docType = string(readAt(r, element.offset, min(element.size, 16)))
+		}
+	}
+	if docType != "matroska" && docType != "webm" {
+		return
+	}
+
+	segment, ok := readEbmlElement(r, header.offset+header.size, size)
+	if !ok || segment.id != mkvSegmentId {
+		return
+	}
+
+	foundInfo, foundTracks := false, false
+	offset := segment.offset
+	for i := 0; i < maxMatroskaElements && offset < segment.offset+segment.size; i++ {
+		element, ok := readEbmlElement(r, offset, segment.offset+segment.size)
+		if !ok {
+			return
+		}
+		switch element.id {
+		case mkvInfoId:
+			readMatroskaInfo(r, element, video)
+			foundInfo = true
+		case mkvTracksId:
+			readMatroskaTracks(r, element, video)
+			foundTracks = true
+		case mkvClusterId:
+			// clusters hold the media data, tracks are usually stored before them
+			if foundTracks {
+				return
+			}
+		}
+		if foundInfo && foundTracks {
+			return
+		}
+		offset = element.offset + element.size
+	}
+}
+
+func readMatroskaInfo(r io.ReaderAt, info ebmlElement, video *events.VideoMetadata) {
+	scale := uint64(1000000)
+	duration := 0.0
+	for _, element := range ebmlChildren(r, info) {
+		data := readAt(r, element.offset, min(element.size, 8))
+		switch element.id {
+		case mkvTimestampScale:
+			if s := ebmlUint(data); s > 0 {
+				scale = s
+			}
+		case mkvDurationId:
+			duration = ebmlFloat(data)
+		case mkvDateUtcId:
+			if len(data) == 8 {
+				video.CreationTime = matroskaEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(data)))).Unix()
+			}
+		}
+	}
+	// the duration is given in units of the timestamp scale (in nanoseconds)
+	video.Duration = int64(duration * float64(scale) / 1e6)
+}
+
+func readMatroskaTracks(r io.ReaderAt, tracks ebmlElement, video *events.VideoMetadata) {
+	for _, entry := range ebmlChildren(r, tracks) {
+		if entry.id != mkvTrackEntryId {
+			continue
+		}
+		var trackType uint64
+		var codec string
+		var videoElement *ebmlElement
+		for _, element := range ebmlChildren(r, entry) {
+			switch element.id {
+			case mkvTrackTypeId:
+				trackType = ebmlUint(readAt(r, element.offset, min(element.size, 8)))
+			case mkvCodecId:
+				codec = matroskaCodec(string(bytes.TrimRight(readAt(r, element.offset, min(element.size, 64)), "\x00")))
+			case mkvVideoId:
+				videoElement = &element
+			}
+		}
+
+		switch {
+		case trackType == 1 && video.VideoCodec == "":
+			video.VideoCodec = codec
+			if videoElement != nil {
+				readMatroskaVideo(r, *videoElement, video)
+			}
+		case trackType == 2 && video.AudioCodec == "":
+			video.AudioCodec = codec
+		}
+	}
+}
+
+func readMatroskaVideo(r io.ReaderAt, element ebmlElement, video *events.VideoMetadata) {
+	for _, child := range ebmlChildren(r, element) {
+		switch child.id {
+		case mkvPixelWidthId:
+			video.Width = int(ebmlUint(readAt(r, child.offset, min(child.size, 8))))
+		case mkvPixelHeightId:
+			video.Height = int(ebmlUint(readAt(r, child.offset, min(child.size, 8))))
+		case mkvProjectionId:
+			for _, projection := range ebmlChildren(r, child) {
+				if projection.id == mkvPoseRollId {
+					// the roll is counter-clockwise, the rotation is clockwise
+					video.Rotation = normalizeRotation(-ebmlFloat(readAt(r, projection.offset, min(projection.size, 8))))
+				}
+			}
+		}
+	}
+}
+
+func matroskaCodec(codecId string) string {
+	for _, codec := range matroskaCodecs {
+		if strings.HasPrefix(codecId, codec.prefix) {
+			return codec.name
+		}
+	}
+	return codecId
+}
+
+// readEbmlElement reads the id and size of the element at the offset.
+// Elements with unknown size extend to the end.
+func readEbmlElement(r io.ReaderAt, offset int64, end int64) (ebmlElement, bool) {
+	data := readAt(r, offset, min(12, end-offset))
+	if len(data) < 2 {
+		return ebmlElement{}, false
+	}
+	idLength := bitsLeadingZeros(data[0]) + 1
+	if idLength > 4 || idLength >= len(data) {
+		return ebmlElement{}, false
+	}
+	id := uint64(0)
+	for _, b := range data[:idLength] {
+		id = id<<8 | uint64(b)
+	}
+
+	sizeLength := bitsLeadingZeros(data[idLength]) + 1
+	if sizeLength > 8 || idLength+sizeLength > len(data) {
+		return ebmlElement{}, false
+	}
+	size := uint64(data[idLength]) & (0xFF >> sizeLength)
+	unknown := size == 0xFF>>sizeLength
+	for _, b := range data[idLength+1 : idLength+sizeLength] {
+		size = size<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+
+	element := ebmlElement{id: id, offset: offset + int64(idLength+sizeLength), size: int64(size)}
+	if unknown || element.size < 0 || element.offset+element.size > end {
+		element.size = end - element.offset
+	}
+	return element, true
+}
+
+func ebmlChildren(r io.ReaderAt, parent ebmlElement) []ebmlElement {
+	children := make([]ebmlElement, 0)
+	end := parent.offset + parent.size
+	for offset := parent.offset; offset < end; {
+		element, ok := readEbmlElement(r, offset, end)
+		if !ok {
+			break
+		}
+		children = append(children, element)
+		offset = element.offset + element.size
+	}
+	return children
+}
+
+func bitsLeadingZeros(b byte) int {
+	n := 0
+	for mask := byte(0x80); mask != 0 && b&mask == 0; mask >>= 1 {
+		n++
+	}
+	return n
+}
+
+func ebmlUint(data []byte) uint64 {
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package fileindexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReader counts the bytes that are read
+type countingReader struct {
+	r    *bytes.Reader
+	read int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+func fullBox(typ string, version byte, payload ...[]byte) []byte {
+	return bmffBoxData(typ, append([][]byte{{version, 0, 0, 0}}, payload...)...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// bmffMatrix returns a transformation matrix for the rotation in multiples of 90 degrees
+func bmffMatrix(rotation int) []byte {
+	sin, cos := int32(0), int32(0)
+	switch rotation {
+	case 0:
+		cos = 1
+	case 90:
+		sin = 1
+	case 180:
+		cos = -1
+	case 270:
+		sin = -1
+	}
+	matrix := []int32{cos << 16, sin << 16, 0, -sin << 16, cos << 16, 0, 0, 0, 1 << 30}
+	data := make([]byte, 0, 36)
+	for _, v := range matrix {
+		data = binary.BigEndian.AppendUint32(data, uint32(v))
+	}
+	return data
+}
+
+func bmffTrack(handler string, format string, width uint16, height uint16, rotation int) []byte {
+	tkhd := fullBox("tkhd", 0, make([]byte, 20), make([]byte, 16), bmffMatrix(rotation), u32(uint32(width)<<16), u32(uint32(height)<<16))
+	hdlr := fullBox("hdlr", 0, u32(0), []byte(handler), make([]byte, 12), []byte("\x00"))
+
+	entry := bytes.Join([][]byte{make([]byte, 6), u16(1), make([]byte, 16), u16(width), u16(height), make([]byte, 50)}, nil)
+	stsd := fullBox("stsd", 0, u32(1), bmffBoxData(format, entry))
+	minf := bmffBoxData("minf", bmffBoxData("stbl", stsd))
+
+	return bmffBoxData("trak", tkhd, bmffBoxData("mdia", fullBox("mdhd", 0, make([]byte, 20)), hdlr, minf))
+}
+
+func testMp4(created time.Time) []byte {
+	ftyp := bmffBoxData("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41"))
+	// media data before the movie header, as written by most cameras
+	mdat := bmffBoxData("mdat", make([]byte, 1024*1024))
+
+	mvhd := fullBox("mvhd", 0,
+		u32(uint32(created.Unix()+bmffEpochOffset)), u32(0),
+		u32(600), u32(600*95+300),
+		make([]byte, 80))
+	moov := bmffBoxData("moov", mvhd,
+		bmffTrack("vide", "hvc1", 3840, 2160, 90),
+		bmffTrack("soun", "mp4a", 0, 0, 0),
+	)
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+// ebml returns a matroska element, the size is always encoded with 8 bytes
+func ebml(id uint32, payload ...[]byte) []byte {
+	data := make([]byte, 0)
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(data) > 0 {
+			data = append(data, b)
+		}
+	}
+	content := bytes.Join(payload, nil)
+	data = binary.BigEndian.AppendUint64(data, uint64(len(content))|1<<56)
+	return append(data, content...)
+}
+
+func ebmlUintData(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func testMatroska(docType string) []byte {
+	header := ebml(ebmlHeaderId, ebml(ebmlDocTypeId, []byte(docType)))
+	date := time.Date(2022, 8, 1, 9, 30, 0, 0, time.UTC).Sub(matroskaEpoch)
+
+	info := ebml(mkvInfoId,
+		ebml(mkvTimestampScale, ebmlUintData(1000000)),
+		ebml(mkvDurationId, binary.BigEndian.AppendUint64(nil, math.Float64bits(61500))),
+		ebml(mkvDateUtcId, ebmlUintData(uint64(date))),
+	)
+	tracks := ebml(mkvTracksId,
+		ebml(mkvTrackEntryId,
+			ebml(mkvTrackTypeId, []byte{2}),
+			ebml(mkvCodecId, []byte("A_OPUS")),
+		),
+		ebml(mkvTrackEntryId,
+			ebml(mkvTrackTypeId, []byte{1}),
+			ebml(mkvCodecId, []byte("V_VP9")),
+			ebml(mkvVideoId,
+				ebml(mkvPixelWidthId, u16(1920)),
+				ebml(mkvPixelHeightId, u16(1080)),
+				ebml(mkvProjectionId, ebml(mkvPoseRollId, binary.BigEndian.AppendUint32(nil, math.Float32bits(-90)))),
+			),
+		),
+	)
+	cluster := ebml(mkvClusterId, make([]byte, 4096))
+	return append(header, ebml(mkvSegmentId, info, tracks, cluster)...)
+}
+
+func TestIsVideo(t *testing.T) {
+	assert.True(t, isVideo("video/mp4", "/a/clip.mp4"))
+	assert.True(t, isVideo("", "/a/clip.MKV"))
+	assert.False(t, isVideo("image/heic", "/a/photo.heic"))
+	assert.False(t, isVideo("audio/mpeg", "/a/song.mp3"))
+}
+
+func TestReadVideoMetadataMp4(t *testing.T) {
+	created := time.Date(2023, 5, 17, 14, 2, 11, 0, time.UTC)
+	data := testMp4(created)
+	r := &countingReader{r: bytes.NewReader(data)}
+
+	video, err := readVideoMetadata(r, int64(len(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(95500), video.Duration)
+	assert.Equal(t, 3840, video.Width)
+	assert.Equal(t, 2160, video.Height)
+	assert.Equal(t, "hevc", video.VideoCodec)
+	assert.Equal(t, "aac", video.AudioCodec)
+	assert.Equal(t, 90, video.Rotation)
+	assert.Equal(t, created.Unix(), video.CreationTime)
+
+	// the media data is skipped
+	assert.Less(t, r.read, int64(4096))
+}
+
+func TestReadVideoMetadataMatroska(t *testing.T) {
+	for _, docType := range []string{"matroska", "webm"} {
+		data := testMatroska(docType)
+		video, err := readVideoMetadata(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err, docType)
+		assert.Equal(t, int64(61500), video.Duration)
+		assert.Equal(t, 1920, video.Width)
+		assert.Equal(t, 1080, video.Height)
+		assert.Equal(t, "vp9", video.VideoCodec)
+		assert.Equal(t, "opus", video.AudioCodec)
+		assert.Equal(t, 90, video.Rotation)
+		assert.Equal(t, time.Date(2022, 8, 1, 9, 30, 0, 0, time.UTC).Unix(), video.CreationTime)
+	}
+
+	_, err := readVideoMetadata(bytes.NewReader(testMatroska("other")), 1000)
+	assert.ErrorIs(t, err, errNoVideoMetadata)
+}
+
+func TestReadVideoMetadataMissing(t *testing.T) {
+	data := []byte("not a video file at all")
+	_, err := readVideoMetadata(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, errNoVideoMetadata)
+
+	// movie header is missing
+	data = bmffBoxData("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	_, err = readVideoMetadata(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, errNoVideoMetadata)
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	assert.Equal(t, 0, normalizeRotation(0))
+	assert.Equal(t, 90, normalizeRotation(89.9))
+	assert.Equal(t, 180, normalizeRotation(-180))
+	assert.Equal(t, 270, normalizeRotation(-90))
+	assert.Equal(t, 0, normalizeRotation(360))
+}
@@ -19,7 +19,9 @@
 package main
 
 import (
+	"flag"
 	"runtime"
+	"time"
 
 	"github.com/spf13/viper"
 	"go.uber.org/fx"
@@ -33,6 +35,10 @@ import (
 )
 
 func main() {
+	// the file indexer is configured with the configuration file and environment,
+	// flags are only parsed so that --help prints the usage instead of starting the indexer
+	flag.Parse()
+
 	fx.New(
 		logging.Module,
 		config.Module,
@@ -45,12 +51,26 @@ func main() {
 			viper.SetDefault("tracing.serviceName", "fileindexer")
 			viper.SetDefault("fileindexer.parallel", runtime.NumCPU())
 			viper.SetDefault("mongo.db", "seraph-files")
+			viper.SetDefault("fileindexer.content.enabled", true)
+			viper.SetDefault("fileindexer.content.maxSize", 20*1024*1024)
+			viper.SetDefault("fileindexer.content.maxTextLength", 1024*1024)
+			viper.SetDefault("fileindexer.content.timeout", 30*time.Second)
+			viper.SetDefault("fileindexer.photo.enabled", true)
+			viper.SetDefault("fileindexer.audio.enabled", true)
+			viper.SetDefault("fileindexer.video.enabled", true)
+			viper.SetDefault("fileindexer.reindex.parallel", 2)
+			viper.SetDefault("fileindexer.batchSize", 100)
+			viper.SetDefault("fileindexer.retry.maxDeliver", 5)
+			viper.SetDefault("fileindexer.retry.delay", 10*time.Second)
+			viper.SetDefault("fileindexer.readdir.expiry", 5*time.Minute)
 			return viper
 		}),
 		fx.Provide(fileindexer.NewMigrations),
 		fx.Provide(fileindexer.NewConsumer),
 		fx.Provide(fileindexer.NewSearch),
-		fx.Invoke(func(consumer fileindexer.Consumer, search fileindexer.Search, discovery servicediscovery.ServiceDiscovery, lc fx.Lifecycle) {
+		fx.Provide(fileindexer.NewDuplicates),
+		fx.Provide(fileindexer.NewListing),
+		fx.Invoke(func(consumer fileindexer.Consumer, search fileindexer.Search, duplicates fileindexer.Duplicates, listing fileindexer.Listing, discovery servicediscovery.ServiceDiscovery, lc fx.Lifecycle) {
 
 			service := discovery.AnnounceService("file-indexer", map[string]string{})
 
@@ -19,9 +19,7 @@
 package main
 
 import (
-	"errors"
-	"strings"
-
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 	"go.uber.org/fx"
 	"umbasa.net/seraph/config"
@@ -44,55 +42,47 @@ func main() {
 		logging.FxLogger(),
 		fx.Decorate(func(viper *viper.Viper) *viper.Viper {
 			id := viper.GetString("fileprovider.id")
-			viper.SetDefault("tracing.serviceName", "fileprovider."+id)
+			if id != "" {
+				viper.SetDefault("tracing.serviceName", "fileprovider."+id)
+			} else {
+				viper.SetDefault("tracing.serviceName", "fileprovider.smb")
+			}
 			return viper
 		}),
 		fx.Invoke(func(params fileprovider.ServerParams, viper *viper.Viper, logger *logging.Logger, discovery servicediscovery.ServiceDiscovery, lc fx.Lifecycle) error {
-			id := viper.GetString("fileprovider.id")
-			addr := viper.GetString("fileprovider.addr")
-			username := viper.GetString("fileprovider.username")
-			password := viper.GetString("fileprovider.password")
-			sharename := viper.GetString("fileprovider.sharename")
-			pathPrefix := viper.GetString("fileprovider.pathPrefix")
-			readOnly := viper.GetBool("fileprovider.readOnly")
-
-			if id == "" {
-				return errors.New("missing fileprovider.id argument")
-			}
-			if addr == "" {
-				return errors.New("missing fileprovider.addr argument")
-			}
-			if sharename == "" {
-				return errors.New("missing fileprovider.sharename argument")
-			}
-			if username == "" {
-				username = "guest"
-			}
-
-			if !strings.ContainsAny(addr, ":") {
-				// addr does not contain port - use default
-				addr = addr + ":445"
-			}
-
-			fs := smbprovider.NewSmbFileSystem(logger, addr, sharename, username, password, pathPrefix)
-			server, err := fileprovider.NewFileProviderServer(params, id, fs, readOnly)
+			configs, err := smbprovider.LoadProviderConfigs(viper)
 			if err != nil {
 				return err
 			}
 
-			service := discovery.AnnounceService("file-provider", map[string]string{
-				"kind": "smb",
-				"id":   id,
-			})
-
-			lc.Append(fx.StartHook(func() {
-				server.Start()
+			manager := newProviderManager(params, logger, discovery)
+			log := logger.GetLogger("smbprovider")
+
+			lc.Append(fx.StartHook(func() error {
+				err := manager.apply(configs)
+				if err != nil {
+					manager.stopAll()
+					return err
+				}
+
+				if viper.ConfigFileUsed() != "" {
+					// add and remove providers when the configuration file changes
+					viper.OnConfigChange(func(e fsnotify.Event) {
+						configs, err := smbprovider.LoadProviderConfigs(viper)
+						if err != nil {
+							log.Error("invalid configuration, keeping current file providers", "error", err)
+							return
+						}
+						log.Info("configuration changed, updating file providers")
+						manager.apply(configs)
+					})
+					viper.WatchConfig()
+				}
+				return nil
 			}))
 
 			lc.Append(fx.StopHook(func() {
-				service.Remove()
-				server.Stop(false)
-				fs.Close()
+				manager.stopAll()
 			}))
 
 			return nil
@@ -0,0 +1,159 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"umbasa.net/seraph/file-provider-smb/smbprovider"
+	"umbasa.net/seraph/file-provider/fileprovider"
+	"umbasa.net/seraph/logging"
+	servicediscovery "umbasa.net/seraph/service-discovery"
+)
+
+// providerManager runs a file provider server for each configured SMB share
+type providerManager struct {
+	params    fileprovider.ServerParams
+	logger    *logging.Logger
+	discovery servicediscovery.ServiceDiscovery
+	log       *slog.Logger
+
+	mu        sync.Mutex
+	providers map[string]*provider
+	// set by stopAll, configuration changes are ignored afterwards
+	stopped bool
+}
+
+type provider struct {
+	config  smbprovider.ProviderConfig
+	fs      *smbprovider.SmbFileSystem
+	server  *fileprovider.FileProviderServer
+	service servicediscovery.LocalService
+}
+
+func newProviderManager(params fileprovider.ServerParams, logger *logging.Logger, discovery servicediscovery.ServiceDiscovery) *providerManager {
+	return &providerManager{
+		params:    params,
+		logger:    logger,
+		discovery: discovery,
+		log:       logger.GetLogger("smbprovider"),
+		providers: make(map[string]*provider),
+	}
+}
+
+// apply starts and stops providers so that exactly the given providers are running.
+// Providers whose configuration did not change keep running.
+// Nothing is started once the manager has been stopped.
+func (m *providerManager) apply(configs []smbprovider.ProviderConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return nil
+	}
+
+	wanted := make(map[string]smbprovider.ProviderConfig)
+	for _, config := range configs {
+		wanted[config.Id] = config
+	}
+
+	for id, p := range m.providers {
+		config, ok := wanted[id]
+		if ok && reflect.DeepEqual(config, p.config) {
+			continue
+		}
+		m.stop(p)
+		delete(m.providers, id)
+	}
+
+	var errs []error
+	for _, config := range configs {
+		if _, ok := m.providers[config.Id]; ok {
+			continue
+		}
+		p, err := m.start(config)
+		if err != nil {
+			m.log.Error("failed to start file provider", "id", config.Id, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		m.providers[config.Id] = p
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *providerManager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+
+	for id, p := range m.providers {
+		m.stop(p)
+		delete(m.providers, id)
+	}
+}
+
+func (m *providerManager) start(config smbprovider.ProviderConfig) (*provider, error) {
+	fs := smbprovider.NewSmbFileSystem(m.logger, config.Addr, config.Sharename, config.Username, config.Password, config.PathPrefix, config.Pool)
+	server, err := fileprovider.NewFileProviderServer(m.params, config.Id, fs, config.ReadOnly)
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	err = server.Start()
+	if err != nil {
+		server.Stop(true)
+		fs.Close()
+		return nil, err
+	}
+
+	serviceProperties := func(stats smbprovider.PoolStats) map[string]string {
+		properties := stats.Properties()
+		properties["kind"] = "smb"
+		properties["id"] = config.Id
+		return properties
+	}
+
+	service := m.discovery.AnnounceService("file-provider", serviceProperties(fs.Stats()))
+	fs.OnStats(func(stats smbprovider.PoolStats) {
+		service.Update(serviceProperties(stats))
+	})
+
+	m.log.Info("started file provider", "id", config.Id, "addr", config.Addr, "share", config.Sharename)
+
+	return &provider{
+		config:  config,
+		fs:      fs,
+		server:  server,
+		service: service,
+	}, nil
+}
+
+func (m *providerManager) stop(p *provider) {
+	p.service.Remove()
+	p.server.Stop(false)
+	p.fs.Close()
+
+	m.log.Info("stopped file provider", "id", p.config.Id)
+}
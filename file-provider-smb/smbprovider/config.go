@@ -0,0 +1,115 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package smbprovider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ProviderConfig describes one SMB share that is served as a file provider
+type ProviderConfig struct {
+	Id        string
+	Addr      string
+	Username  string
+	Password  string
+	Sharename string
+	// file that the password is read from, takes precedence over Password
+	PasswordFile string
+	PathPrefix   string
+	ReadOnly     bool
+	Pool         PoolConfig
+}
+
+// LoadProviderConfigs reads the providers from the "fileprovider.providers" list.
+// If the list is empty, a single provider is read from the "fileprovider" section.
+func LoadProviderConfigs(v *viper.Viper) ([]ProviderConfig, error) {
+	configs := make([]ProviderConfig, 0)
+	err := v.UnmarshalKey("fileprovider.providers", &configs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fileprovider.providers: %w", err)
+	}
+
+	if len(configs) == 0 {
+		configs = append(configs, ProviderConfig{
+			Id:           v.GetString("fileprovider.id"),
+			Addr:         v.GetString("fileprovider.addr"),
+			Username:     v.GetString("fileprovider.username"),
+			Password:     v.GetString("fileprovider.password"),
+			PasswordFile: v.GetString("fileprovider.passwordFile"),
+			Sharename:    v.GetString("fileprovider.sharename"),
+			PathPrefix:   v.GetString("fileprovider.pathPrefix"),
+			ReadOnly:     v.GetBool("fileprovider.readOnly"),
+			Pool: PoolConfig{
+				Size:                v.GetInt("fileprovider.pool.size"),
+				IdleTimeout:         v.GetDuration("fileprovider.pool.idleTimeout"),
+				HealthCheckInterval: v.GetDuration("fileprovider.pool.healthCheckInterval"),
+			},
+		})
+	}
+
+	ids := make(map[string]bool)
+	for i := range configs {
+		err := configs[i].prepare()
+		if err != nil {
+			return nil, err
+		}
+		if ids[configs[i].Id] {
+			return nil, fmt.Errorf("duplicate file provider id %s", configs[i].Id)
+		}
+		ids[configs[i].Id] = true
+	}
+
+	return configs, nil
+}
+
+// prepare validates the config, fills in defaults and loads the password file
+func (c *ProviderConfig) prepare() error {
+	if c.Id == "" {
+		return errors.New("missing fileprovider.id argument")
+	}
+	if c.Addr == "" {
+		return fmt.Errorf("missing fileprovider.addr argument for provider %s", c.Id)
+	}
+	if c.Sharename == "" {
+		return fmt.Errorf("missing fileprovider.sharename argument for provider %s", c.Id)
+	}
+	if c.Username == "" {
+		c.Username = "guest"
+	}
+
+	if !strings.ContainsAny(c.Addr, ":") {
+		// addr does not contain port - use default
+		c.Addr = c.Addr + ":445"
+	}
+
+	if c.PasswordFile != "" {
+		password, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read password file for provider %s: %w", c.Id, err)
+		}
+		c.Password = strings.TrimRight(string(password), "\r\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package smbprovider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func readConfig(t *testing.T, config string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(config)))
+	return v
+}
+
+func TestLoadProviderConfigsList(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	v := readConfig(t, `
+fileprovider:
+  providers:
+    - id: photos
+      addr: nas.local
+      username: pi
+      passwordFile: `+passwordFile+`
+      sharename: storage
+      pathPrefix: Photos
+      pool:
+        size: 2
+        idleTimeout: 5m
+    - id: music
+      addr: nas.local:1445
+      sharename: music
+      readOnly: true
+`)
+
+	configs, err := LoadProviderConfigs(v)
+	assert.NoError(t, err)
+	assert.Equal(t, []ProviderConfig{
+		{
+			Id:           "photos",
+			Addr:         "nas.local:445",
+			Username:     "pi",
+			Password:     "secret",
+			PasswordFile: passwordFile,
+			Sharename:    "storage",
+			PathPrefix:   "Photos",
+			Pool:         PoolConfig{Size: 2, IdleTimeout: 5 * time.Minute},
+		},
+		{
+			Id:        "music",
+			Addr:      "nas.local:1445",
+			Username:  "guest",
+			Sharename: "music",
+			ReadOnly:  true,
+		},
+	}, configs)
+}
+
+func TestLoadProviderConfigsSingle(t *testing.T) {
+	v := readConfig(t, `
+fileprovider:
+  id: foo
+  addr: nas.local
+  username: pi
+  password: naspi
+  sharename: storage
+`)
+
+	configs, err := LoadProviderConfigs(v)
+	assert.NoError(t, err)
+	assert.Equal(t, []ProviderConfig{{
+		Id:        "foo",
+		Addr:      "nas.local:445",
+		Username:  "pi",
+		Password:  "naspi",
+		Sharename: "storage",
+	}}, configs)
+}
+
+func TestLoadProviderConfigsInvalid(t *testing.T) {
+	_, err := LoadProviderConfigs(readConfig(t, `
+fileprovider:
+  providers:
+    - id: foo
+      addr: nas.local
+      sharename: a
+    - id: foo
+      addr: nas.local
+      sharename: b
+`))
+	assert.ErrorContains(t, err, "duplicate")
+
+	_, err = LoadProviderConfigs(readConfig(t, `
+fileprovider:
+  providers:
+    - id: foo
+      addr: nas.local
+`))
+	assert.ErrorContains(t, err, "sharename")
+}
@@ -19,8 +19,6 @@
 package smbprovider
 
 import (
-	"context"
-	"errors"
 	"io"
 	"io/fs"
 	"os"
@@ -37,15 +35,22 @@ type smbFile struct {
 
 	offset int64
 	file   webdav.File
+
+	// the session that the file was opened with, released on Close()
+	session *pooledSession
+	share   *smb2.Share
 }
 
 func (f *smbFile) Close() error {
-	f.fs.factory.keep()
-	return f.file.Close()
+	err := f.file.Close()
+	if f.session != nil {
+		f.fs.pool.release(f.session)
+		f.session = nil
+	}
+	return err
 }
 
 func (f *smbFile) Read(p []byte) (n int, err error) {
-	f.fs.factory.keep()
 	n, err = retryFile(f, func() (int, error) {
 		return f.file.Read(p)
 	})
@@ -56,7 +61,6 @@ func (f *smbFile) Read(p []byte) (n int, err error) {
 }
 
 func (f *smbFile) Seek(offset int64, whence int) (position int64, err error) {
-	f.fs.factory.keep()
 	position, err = retryFile(f, func() (int64, error) {
 		return f.file.Seek(offset, whence)
 	})
@@ -67,21 +71,18 @@ func (f *smbFile) Seek(offset int64, whence int) (position int64, err error) {
 }
 
 func (f *smbFile) Readdir(count int) ([]fs.FileInfo, error) {
-	f.fs.factory.keep()
 	return retryFile(f, func() ([]fs.FileInfo, error) {
 		return f.file.Readdir(count)
 	})
 }
 
 func (f *smbFile) Stat() (fs.FileInfo, error) {
-	f.fs.factory.keep()
 	return retryFile(f, func() (fs.FileInfo, error) {
 		return f.file.Stat()
 	})
 }
 
 func (f *smbFile) Write(p []byte) (n int, err error) {
-	f.fs.factory.keep()
 	n, err = retryFile(f, func() (int, error) {
 		return f.file.Write(p)
 	})
@@ -95,13 +96,20 @@ func retryFile[T any](f *smbFile, fun func() (T, error)) (T, error) {
 	var ret T
 
 	ret, err := fun()
-	if errors.Is(err, &smb2.TransportError{}) {
-		newFile, err := f.fs.OpenFile(context.Background(), f.name, f.flag, f.perm)
+	if isTransportError(err) && f.session != nil {
+		// the session of the file is broken, reopen the file with another session
+		f.fs.pool.release(f.session)
+		f.fs.pool.invalidate(f.session, f.share, err)
+		f.session = nil
+
+		newFile, err := f.fs.openFile(f.name, f.flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC), f.perm)
 		if err != nil {
 			return ret, err
 		}
-		newFile.Seek(f.offset, io.SeekStart)
-		f.file = newFile
+		newFile.file.Seek(f.offset, io.SeekStart)
+		f.file = newFile.file
+		f.session = newFile.session
+		f.share = newFile.share
 
 		return fun()
 	}
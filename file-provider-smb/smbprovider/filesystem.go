@@ -34,23 +34,13 @@ import (
 )
 
 type SmbFileSystem struct {
-	factory    *shareFactory
+	pool       *sessionPool
 	pathPrefix string
 }
 
-func NewSmbFileSystem(log *logging.Logger, addr string, sharename string, username string, password string, pathPrefix string) *SmbFileSystem {
-	factory := &shareFactory{
-		log:       log,
-		addr:      addr,
-		username:  username,
-		password:  password,
-		sharename: sharename,
-	}
-
-	factory.init()
-
+func NewSmbFileSystem(log *logging.Logger, addr string, sharename string, username string, password string, pathPrefix string, poolConfig PoolConfig) *SmbFileSystem {
 	return &SmbFileSystem{
-		factory:    factory,
+		pool:       newSessionPool(log, addr, sharename, username, password, poolConfig),
 		pathPrefix: strings.TrimPrefix(pathPrefix, "/"),
 	}
 }
@@ -60,13 +50,26 @@ func (smbfs *SmbFileSystem) getPath(p string) string {
 }
 
 func (smbfs *SmbFileSystem) Close() {
-	smbfs.factory.close()
+	smbfs.pool.close()
+}
+
+// Stats returns the current state of the session pool
+func (smbfs *SmbFileSystem) Stats() PoolStats {
+	return smbfs.pool.stats()
+}
+
+// OnStats registers a callback that receives the state of the session pool
+// after each health check. Must be called before the file system is used.
+func (smbfs *SmbFileSystem) OnStats(f func(PoolStats)) {
+	smbfs.pool.mu.Lock()
+	defer smbfs.pool.mu.Unlock()
+	smbfs.pool.onStats = f
 }
 
 func (smbfs *SmbFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
 	name = smbfs.getPath(name)
 
-	return retryVoid(smbfs.factory, func(share *smb2.Share) error {
+	return doVoid(smbfs.pool, func(share *smb2.Share) error {
 		return share.Mkdir(name, perm)
 	})
 }
@@ -74,8 +77,20 @@ func (smbfs *SmbFileSystem) Mkdir(ctx context.Context, name string, perm os.File
 func (smbfs *SmbFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
 	name = smbfs.getPath(name)
 
-	file, err := retry(smbfs.factory, func(share *smb2.Share) (*smb2.File, error) {
-		return share.OpenFile(name, flag, perm)
+	file, err := smbfs.openFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// openFile opens the file with an already prefixed name.
+// The file keeps its session until it is closed.
+func (smbfs *SmbFileSystem) openFile(name string, flag int, perm os.FileMode) (*smbFile, error) {
+	var share *smb2.Share
+	file, session, err := doKeep(smbfs.pool, func(s *smb2.Share) (*smb2.File, error) {
+		share = s
+		return s.OpenFile(name, flag, perm)
 	})
 
 	if err != nil {
@@ -83,12 +98,14 @@ func (smbfs *SmbFileSystem) OpenFile(ctx context.Context, name string, flag int,
 	}
 
 	return &smbFile{
-		fs:     smbfs,
-		name:   name,
-		flag:   flag,
-		perm:   perm,
-		offset: 0,
-		file:   file,
+		fs:      smbfs,
+		name:    name,
+		flag:    flag,
+		perm:    perm,
+		offset:  0,
+		file:    file,
+		session: session,
+		share:   share,
 	}, nil
 
 }
@@ -96,7 +113,7 @@ func (smbfs *SmbFileSystem) OpenFile(ctx context.Context, name string, flag int,
 func (smbfs *SmbFileSystem) RemoveAll(ctx context.Context, name string) error {
 	name = smbfs.getPath(name)
 
-	return retryVoid(smbfs.factory, func(share *smb2.Share) error {
+	return doVoid(smbfs.pool, func(share *smb2.Share) error {
 		return share.RemoveAll(name)
 	})
 }
@@ -105,7 +122,7 @@ func (smbfs *SmbFileSystem) Rename(ctx context.Context, oldName string, newName
 	oldName = smbfs.getPath(oldName)
 	newName = smbfs.getPath(newName)
 
-	return retryVoid(smbfs.factory, func(share *smb2.Share) error {
+	return doVoid(smbfs.pool, func(share *smb2.Share) error {
 		return share.Rename(oldName, newName)
 	})
 }
@@ -113,7 +130,7 @@ func (smbfs *SmbFileSystem) Rename(ctx context.Context, oldName string, newName
 func (smbfs *SmbFileSystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
 	name = smbfs.getPath(name)
 
-	info, err := retry(smbfs.factory, func(share *smb2.Share) (fs.FileInfo, error) {
+	info, err := do(smbfs.pool, func(share *smb2.Share) (fs.FileInfo, error) {
 		return share.Stat(name)
 	})
 
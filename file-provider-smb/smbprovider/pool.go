@@ -0,0 +1,459 @@
+// Copyright © 2024 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package smbprovider
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+	"umbasa.net/seraph/logging"
+)
+
+type PoolConfig struct {
+	// number of parallel SMB sessions
+	Size int
+	// time after which an idle SMB session is closed
+	IdleTimeout time.Duration
+	// interval in which idle sessions are checked for liveness
+	HealthCheckInterval time.Duration
+}
+
+var DefaultPoolConfig = PoolConfig{
+	Size:                4,
+	IdleTimeout:         10 * time.Minute,
+	HealthCheckInterval: time.Minute,
+}
+
+type PoolStats struct {
+	Size       int
+	Open       int
+	InUse      int
+	Operations uint64
+	Reconnects uint64
+	Failures   uint64
+}
+
+// Properties returns the stats in a form suitable for service discovery
+func (s PoolStats) Properties() map[string]string {
+	return map[string]string{
+		"pool.size":       strconv.Itoa(s.Size),
+		"pool.open":       strconv.Itoa(s.Open),
+		"pool.inUse":      strconv.Itoa(s.InUse),
+		"pool.operations": strconv.FormatUint(s.Operations, 10),
+		"pool.reconnects": strconv.FormatUint(s.Reconnects, 10),
+		"pool.failures":   strconv.FormatUint(s.Failures, 10),
+	}
+}
+
+// sessionPool maintains a fixed number of SMB sessions that are opened on demand.
+// Operations are spread over the sessions, choosing the session with the fewest active users.
+type sessionPool struct {
+	addr      string
+	username  string
+	password  string
+	sharename string
+	config    PoolConfig
+
+	mu       sync.Mutex
+	sessions []*pooledSession
+	closed   bool
+	done     chan struct{}
+
+	onStats func(PoolStats)
+	// opens a session with the share, replaced in tests
+	dial func() (smbConn, error)
+
+	operations atomic.Uint64
+	reconnects atomic.Uint64
+	failures   atomic.Uint64
+
+	log *slog.Logger
+}
+
+type pooledSession struct {
+	index int
+
+	// guards conn
+	mu   sync.Mutex
+	conn smbConn
+	// set while the share is open, readable without holding mu
+	connected atomic.Bool
+
+	// guarded by the pool mutex
+	users    int
+	lastUsed time.Time
+}
+
+func newSessionPool(log *logging.Logger, addr string, sharename string, username string, password string, config PoolConfig) *sessionPool {
+	if config.Size <= 0 {
+		config.Size = DefaultPoolConfig.Size
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultPoolConfig.IdleTimeout
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = DefaultPoolConfig.HealthCheckInterval
+	}
+
+	pool := &sessionPool{
+		addr:      addr,
+		username:  username,
+		password:  password,
+		sharename: sharename,
+		config:    config,
+		sessions:  make([]*pooledSession, config.Size),
+		done:      make(chan struct{}),
+		log:       log.GetLogger("smbprovider"),
+	}
+	for i := range pool.sessions {
+		pool.sessions[i] = &pooledSession{index: i}
+	}
+	pool.dial = pool.dialSession
+
+	go pool.maintain()
+
+	return pool
+}
+
+// acquire returns a session with an open share.
+// The session must be given back with release() when the share is no longer used.
+func (p *sessionPool) acquire() (*pooledSession, *smb2.Share, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, nil, errors.New("SMB session pool is closed")
+	}
+
+	// prefer the open session with the fewest users, but open another session
+	// before sharing one that is already busy
+	var best *pooledSession
+	for _, s := range p.sessions {
+		if best == nil ||
+			s.users < best.users ||
+			(s.users == best.users && s.isOpen() && !best.isOpen()) {
+			best = s
+		}
+	}
+	best.users++
+	p.mu.Unlock()
+
+	p.operations.Add(1)
+
+	share, err := best.open(p)
+	if err != nil {
+		p.failures.Add(1)
+		p.release(best)
+		return nil, nil, err
+	}
+	return best, share, nil
+}
+
+func (p *sessionPool) release(s *pooledSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s.users--
+	s.lastUsed = time.Now()
+}
+
+// invalidate closes the session if it still uses the given share,
+// so that it is reopened on the next use
+func (p *sessionPool) invalidate(s *pooledSession, share *smb2.Share, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil || s.conn.Share() != share {
+		// already reopened by somebody else
+		return
+	}
+	s.close()
+	p.reconnects.Add(1)
+	p.log.Warn("closed SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "session", s.index, "reason", "transport error", "error", err)
+}
+
+func (p *sessionPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, s := range p.sessions {
+		s.mu.Lock()
+		if s.conn != nil {
+			s.close()
+			p.log.Info("closed SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "session", s.index, "reason", "shutdown")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (p *sessionPool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := PoolStats{
+		Size:       len(p.sessions),
+		Operations: p.operations.Load(),
+		Reconnects: p.reconnects.Load(),
+		Failures:   p.failures.Load(),
+	}
+	for _, s := range p.sessions {
+		if s.isOpen() {
+			stats.Open++
+		}
+		if s.users > 0 {
+			stats.InUse++
+		}
+	}
+	return stats
+}
+
+// maintain closes idle sessions and checks that the remaining sessions are alive
+func (p *sessionPool) maintain() {
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.sweep()
+
+		stats := p.stats()
+		p.log.Debug("SMB session pool stats", "addr", p.addr, "share", p.sharename, "size", stats.Size, "open", stats.Open, "inUse", stats.InUse,
+			"operations", stats.Operations, "reconnects", stats.Reconnects, "failures", stats.Failures)
+		p.mu.Lock()
+		onStats := p.onStats
+		p.mu.Unlock()
+		if onStats != nil {
+			onStats(stats)
+		}
+	}
+}
+
+// sweep closes the sessions that have been idle for longer than the idle timeout
+// and health checks the other idle sessions
+func (p *sessionPool) sweep() {
+	for _, s := range p.sessions {
+		p.mu.Lock()
+		idle := s.users == 0
+		idleSince := s.lastUsed
+		p.mu.Unlock()
+
+		if !idle || !s.isOpen() {
+			continue
+		}
+
+		if time.Since(idleSince) > p.config.IdleTimeout {
+			p.closeIdle(s)
+			continue
+		}
+
+		p.healthCheck(s)
+	}
+}
+
+// closeIdle closes the session if it is still idle.
+// The session may have been acquired since it was found to be idle, so this is checked again
+// while holding both locks.
+func (p *sessionPool) closeIdle(s *pooledSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.mu.Lock()
+	idle := s.users == 0 && time.Since(s.lastUsed) > p.config.IdleTimeout
+	p.mu.Unlock()
+
+	if !idle || s.conn == nil {
+		return
+	}
+	s.close()
+	p.log.Info("closed SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "session", s.index, "reason", "idle")
+}
+
+func (p *sessionPool) healthCheck(s *pooledSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return
+	}
+	err := s.conn.Check()
+	if err != nil {
+		s.close()
+		p.failures.Add(1)
+		p.log.Warn("closed SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "session", s.index, "reason", "health check failed", "error", err)
+	}
+}
+
+func (s *pooledSession) isOpen() bool {
+	return s.connected.Load()
+}
+
+// open returns the share of the session, opening the session if required
+func (s *pooledSession) open(p *sessionPool) (*smb2.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return s.conn.Share(), nil
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	s.connected.Store(true)
+
+	p.log.Info("opened SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "username", p.username, "session", s.index)
+
+	return conn.Share(), nil
+}
+
+// must be called with s.mu held
+func (s *pooledSession) close() {
+	s.conn.Close()
+	s.conn = nil
+	s.connected.Store(false)
+}
+
+// smbConn is an SMB session with a mounted share
+type smbConn interface {
+	Share() *smb2.Share
+	// Check returns an error if the session is no longer usable
+	Check() error
+	Close()
+}
+
+type smbSession struct {
+	conn    net.Conn
+	session *smb2.Session
+	share   *smb2.Share
+}
+
+func (p *sessionPool) dialSession() (smbConn, error) {
+	conn, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		p.log.Error("failed to open SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "username", p.username, "error", err)
+		return nil, err
+	}
+
+	d := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     p.username,
+			Password: p.password,
+		},
+	}
+
+	sess, err := d.Dial(conn)
+	if err != nil {
+		conn.Close()
+		p.log.Error("failed to open SMB session with "+p.addr, "addr", p.addr, "share", p.sharename, "username", p.username, "error", err)
+		return nil, err
+	}
+
+	fs, err := sess.Mount(p.sharename)
+	if err != nil {
+		sess.Logoff()
+		conn.Close()
+		p.log.Error("failed to mount SMB share "+p.sharename, "addr", p.addr, "share", p.sharename, "username", p.username, "error", err)
+		return nil, err
+	}
+
+	return &smbSession{conn: conn, session: sess, share: fs}, nil
+}
+
+func (s *smbSession) Share() *smb2.Share {
+	return s.share
+}
+
+func (s *smbSession) Check() error {
+	_, err := s.share.Stat("")
+	return err
+}
+
+func (s *smbSession) Close() {
+	s.share.Umount()
+	s.session.Logoff()
+	s.conn.Close()
+}
+
+// isTransportError reports whether the session of err is broken and has to be reopened
+func isTransportError(err error) bool {
+	var transportErr *smb2.TransportError
+	return errors.As(err, &transportErr)
+}
+
+// do runs f with a share from the pool.
+// If f fails with a transport error, the session is reopened and f is tried once more.
+func do[T any](pool *sessionPool, f func(*smb2.Share) (T, error)) (T, error) {
+	ret, s, err := doKeep(pool, f)
+	if s != nil {
+		pool.release(s)
+	}
+	return ret, err
+}
+
+func doVoid(pool *sessionPool, f func(*smb2.Share) error) error {
+	_, err := do(pool, func(share *smb2.Share) (struct{}, error) {
+		return struct{}{}, f(share)
+	})
+	return err
+}
+
+// doKeep is like do, but if f succeeds the session stays acquired and is returned.
+// This is used for open files, which are bound to the session that they were opened with.
+func doKeep[T any](pool *sessionPool, f func(*smb2.Share) (T, error)) (T, *pooledSession, error) {
+	var ret T
+	for attempt := 0; ; attempt++ {
+		s, share, err := pool.acquire()
+		if err != nil {
+			if attempt == 0 && isTransportError(err) {
+				continue
+			}
+			return ret, nil, err
+		}
+
+		ret, err = f(share)
+		if attempt == 0 && isTransportError(err) {
+			pool.release(s)
+			pool.invalidate(s, share, err)
+			continue
+		}
+		if err != nil {
+			pool.release(s)
+			return ret, nil, err
+		}
+		return ret, s, nil
+	}
+}
@@ -0,0 +1,189 @@
+package smbprovider
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/logging"
+)
+
+// fakeConn is a session that doesn't connect anywhere
+type fakeConn struct {
+	share    *smb2.Share
+	checkErr error
+	closed   atomic.Bool
+}
+
+func (c *fakeConn) Share() *smb2.Share {
+	return c.share
+}
+
+func (c *fakeConn) Check() error {
+	return c.checkErr
+}
+
+func (c *fakeConn) Close() {
+	c.closed.Store(true)
+}
+
+// newTestPool returns a pool that opens fake sessions and records them
+func newTestPool(t *testing.T, size int) (*sessionPool, *[]*fakeConn) {
+	pool := newSessionPool(logging.New(logging.Params{}), "nas.local:445", "share", "user", "password", PoolConfig{Size: size, HealthCheckInterval: time.Hour})
+	t.Cleanup(pool.close)
+
+	var mu sync.Mutex
+	conns := make([]*fakeConn, 0)
+	pool.dial = func() (smbConn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		conn := &fakeConn{share: &smb2.Share{}}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+	return pool, &conns
+}
+
+func TestPoolReusesSessions(t *testing.T) {
+	pool, conns := newTestPool(t, 2)
+
+	shares := make(map[*smb2.Share]bool)
+	for range 5 {
+		err := doVoid(pool, func(share *smb2.Share) error {
+			shares[share] = true
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	// operations one after another use the same session
+	assert.Len(t, *conns, 1)
+	assert.Len(t, shares, 1)
+	stats := pool.stats()
+	assert.Equal(t, 1, stats.Open)
+	assert.Equal(t, 0, stats.InUse)
+	assert.Equal(t, uint64(5), stats.Operations)
+}
+
+func TestPoolSizeLimit(t *testing.T) {
+	pool, conns := newTestPool(t, 2)
+
+	acquired := make([]*pooledSession, 0)
+	for range 6 {
+		s, _, err := pool.acquire()
+		assert.NoError(t, err)
+		acquired = append(acquired, s)
+	}
+
+	// no more sessions than the size of the pool are opened, the users are spread over them
+	assert.Len(t, *conns, 2)
+	stats := pool.stats()
+	assert.Equal(t, 2, stats.Open)
+	assert.Equal(t, 2, stats.InUse)
+	for _, s := range pool.sessions {
+		assert.Equal(t, 3, s.users)
+	}
+
+	for _, s := range acquired {
+		pool.release(s)
+	}
+	assert.Equal(t, 0, pool.stats().InUse)
+}
+
+func TestPoolEvictsSessionOnTransportError(t *testing.T) {
+	pool, conns := newTestPool(t, 1)
+
+	attempts := 0
+	err := doVoid(pool, func(share *smb2.Share) error {
+		attempts++
+		if attempts == 1 {
+			return &smb2.TransportError{Err: errors.New("connection reset")}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// the broken session was closed and the operation was tried again with a new one
+	if assert.Len(t, *conns, 2) {
+		assert.True(t, (*conns)[0].closed.Load())
+		assert.False(t, (*conns)[1].closed.Load())
+	}
+	assert.Equal(t, uint64(1), pool.stats().Reconnects)
+	assert.Equal(t, 0, pool.stats().InUse)
+}
+
+func TestPoolKeepsSessionOnOtherErrors(t *testing.T) {
+	pool, conns := newTestPool(t, 1)
+
+	err := doVoid(pool, func(share *smb2.Share) error {
+		return errors.New("file not found")
+	})
+	assert.Error(t, err)
+
+	assert.Len(t, *conns, 1)
+	assert.False(t, (*conns)[0].closed.Load())
+	assert.Equal(t, uint64(0), pool.stats().Reconnects)
+}
+
+func TestPoolDialError(t *testing.T) {
+	pool, _ := newTestPool(t, 1)
+	pool.dial = func() (smbConn, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	_, _, err := pool.acquire()
+	assert.Error(t, err)
+	stats := pool.stats()
+	assert.Equal(t, 0, stats.Open)
+	assert.Equal(t, 0, stats.InUse)
+	assert.Equal(t, uint64(1), stats.Failures)
+}
+
+func TestPoolEvictsSessionOnFailedHealthCheck(t *testing.T) {
+	pool, conns := newTestPool(t, 1)
+
+	assert.NoError(t, doVoid(pool, func(share *smb2.Share) error { return nil }))
+	(*conns)[0].checkErr = errors.New("session expired")
+
+	pool.healthCheck(pool.sessions[0])
+	assert.True(t, (*conns)[0].closed.Load())
+	assert.Equal(t, 0, pool.stats().Open)
+
+	// the next operation opens a new session
+	assert.NoError(t, doVoid(pool, func(share *smb2.Share) error { return nil }))
+	assert.Len(t, *conns, 2)
+}
+
+func TestPoolIdleSweepKeepsAcquiredSessions(t *testing.T) {
+	pool, conns := newTestPool(t, 1)
+	// every session that is not in use is idle
+	pool.config.IdleTimeout = time.Nanosecond
+
+	assert.NoError(t, doVoid(pool, func(share *smb2.Share) error { return nil }))
+	s := pool.sessions[0]
+
+	// hold the session, so that the sweep stops after it found the session idle
+	s.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.sweep()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the session is acquired before the sweep closes it
+	pool.mu.Lock()
+	s.users++
+	pool.mu.Unlock()
+	s.mu.Unlock()
+	<-done
+
+	assert.False(t, (*conns)[0].closed.Load())
+	assert.Equal(t, 1, pool.stats().Open)
+	pool.release(s)
+}
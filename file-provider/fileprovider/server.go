@@ -28,6 +28,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hamba/avro/v2"
@@ -42,6 +43,9 @@ import (
 	"umbasa.net/seraph/tracing"
 )
 
+// time to publish the file info events that are left in the outbox when the server is stopped
+const outboxCloseTimeout = 10 * time.Second
+
 type FileProviderServer struct {
 	providerId string
 	readOnly   bool
@@ -49,9 +53,13 @@ type FileProviderServer struct {
 	log    *slog.Logger
 	tracer trace.Tracer
 	nc     *nats.Conn
+	js     jetstream.JetStream
 	msgApi avro.API
 	fs     webdav.FileSystem
 
+	// publishes file info events, if JetStream is available
+	outbox *messaging.Outbox
+
 	requestSub  *nats.Subscription
 	requestChan chan *nats.Msg
 	wg          sync.WaitGroup
@@ -116,6 +124,7 @@ func NewFileProviderServer(p ServerParams, providerId string, fileSystem webdav.
 		log:        log,
 		tracer:     tracer,
 		nc:         p.Nc,
+		js:         p.Js,
 		msgApi:     msgApi,
 		fs:         fileSystem,
 	}, nil
@@ -126,6 +135,9 @@ func (s *FileProviderServer) Start() (err error) {
 	s.requestChan = make(chan *nats.Msg, nats.DefaultSubPendingMsgsLimit)
 	s.requestSub, err = s.nc.ChanQueueSubscribe(providerTopic, providerTopic, s.requestChan)
 	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.js != nil {
+		s.outbox = messaging.NewOutbox(s.js, s.log)
+	}
 	s.wg.Add(1)
 	go s.messageLoop()
 	return
@@ -158,6 +170,15 @@ func (s *FileProviderServer) Stop(force bool) (err error) {
 
 	s.wg.Wait()
 
+	if s.outbox != nil {
+		ctx, cancelClose := context.WithTimeout(context.Background(), outboxCloseTimeout)
+		defer cancelClose()
+		if closeErr := s.outbox.Close(ctx); closeErr != nil {
+			s.log.Error("error while publishing remaining file info events", "error", closeErr)
+		}
+		s.outbox = nil
+	}
+
 	return
 }
 
@@ -345,7 +366,9 @@ func (s *FileProviderServer) handleStat(ctx context.Context, uid string, req *St
 		response.Mode = fileInfo.Mode()
 		response.ModTime = fileInfo.ModTime().Unix()
 
-		s.publishFileInfoEvent(ctx, req.Name, fileInfo, nil)
+		if e := s.publishFileInfoEvent(ctx, req.Name, fileInfo, nil); e != nil {
+			s.log.Error("unable to publish file info event", "uid", uid, "path", req.Name, "error", e)
+		}
 	} else {
 		response.Error = toIoError(err)
 	}
@@ -370,8 +393,16 @@ func (s *FileProviderServer) publishFileInfoEvent(ctx context.Context, path stri
 		Mode:       int64(fileInfo.Mode()),
 		ModTime:    fileInfo.ModTime().Unix(),
 	}
-	fileInfoEventData, _ := fileInfoEvent.Marshal()
-	return s.nc.Publish(fmt.Sprintf(events.FileProviderFileInfoTopicPattern, s.providerId), fileInfoEventData)
+	fileInfoEventData, err := fileInfoEvent.Marshal()
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf(events.FileProviderFileInfoTopicPattern, s.providerId)
+	if s.outbox == nil {
+		return messaging.PublishEvent(ctx, s.nc, subject, fileInfoEventData)
+	}
+	// the file indexer receives each event once, even if it has to be published again
+	return s.outbox.Publish(ctx, subject, fileInfoEvent.Event.ID, fileInfoEventData)
 }
 
 func ensureAbsolutePath(p string) string {
@@ -241,6 +241,9 @@ func (f *serverFile) handleReaddir(ctx context.Context, uid string, fileId strin
 	}
 
 	if err == nil {
+		// the listing is answered even if the events can't be published, failures are logged once for the listing
+		var publishErr error
+		publishFailed := 0
 		for i, fileInfo := range fileInfos {
 			fileInfoResponse := FileInfoResponse{
 				Name:    fileInfo.Name(),
@@ -274,7 +277,13 @@ func (f *serverFile) handleReaddir(ctx context.Context, uid string, fileId strin
 				readdir = nil
 			}
 
-			f.server.publishFileInfoEvent(ctx, f.fileName+"/"+fileInfo.Name(), fileInfo, readdir)
+			if e := f.server.publishFileInfoEvent(ctx, f.fileName+"/"+fileInfo.Name(), fileInfo, readdir); e != nil {
+				publishErr = e
+				publishFailed++
+			}
+		}
+		if publishErr != nil {
+			f.server.log.Error("unable to publish file info events", "uid", uid, "fileId", fileId, "failed", publishFailed, "error", publishErr)
 		}
 	}
 
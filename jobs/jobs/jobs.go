@@ -25,17 +25,21 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 	"umbasa.net/seraph/events"
 	"umbasa.net/seraph/logging"
+	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/tracing"
 )
 
 type Params struct {
 	fx.In
 
-	Nc     *nats.Conn
-	Js     jetstream.JetStream
-	Logger *logging.Logger
+	Nc      *nats.Conn
+	Js      jetstream.JetStream
+	Logger  *logging.Logger
+	Tracing *tracing.Tracing
 }
 
 type Jobs interface {
@@ -46,6 +50,7 @@ type Jobs interface {
 type jobs struct {
 	logger   *logging.Logger
 	log      *slog.Logger
+	tracer   trace.Tracer
 	nc       *nats.Conn
 	js       jetstream.JetStream
 	consumer jetstream.Consumer
@@ -87,6 +92,7 @@ func NewJobs(params Params) (Jobs, error) {
 	j := jobs{
 		logger:   params.Logger,
 		log:      log,
+		tracer:   params.Tracing.TracerProvider.Tracer("jobs"),
 		nc:       params.Nc,
 		js:       params.Js,
 		consumer: consumer,
@@ -99,9 +105,12 @@ func NewJobs(params Params) (Jobs, error) {
 func (j *jobs) Start() error {
 	var err error
 	j.ctx, err = j.consumer.Consume(func(msg jetstream.Msg) {
+		ctx, span := j.tracer.Start(context.Background(), "updateJob", trace.WithLinks(messaging.EventLink(msg.Headers())))
+		defer span.End()
+
 		ev := events.JobEvent{}
 		ev.Unmarshal(msg.Data())
-		j.kv.Put(context.Background(), ev.Key, msg.Data())
+		j.kv.Put(ctx, ev.Key, msg.Data())
 		j.log.Debug("update "+ev.Key, "key", ev.Key, "description", ev.Description, "statusMessage", ev.StatusMessage)
 	})
 	return err
@@ -24,6 +24,7 @@ import (
 	"umbasa.net/seraph/jobs/jobs"
 	"umbasa.net/seraph/logging"
 	"umbasa.net/seraph/messaging"
+	"umbasa.net/seraph/tracing"
 )
 
 func main() {
@@ -31,6 +32,7 @@ func main() {
 		logging.Module,
 		config.Module,
 		messaging.Module,
+		tracing.Module,
 		logging.FxLogger(),
 		fx.Invoke(func(params jobs.Params, lc fx.Lifecycle) error {
 			jobs, err := jobs.NewJobs(params)
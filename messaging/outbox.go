@@ -0,0 +1,223 @@
+// Copyright © 2024 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// maximum number of messages that are kept in an outbox
+var outboxLimit = 10000
+
+// number of messages that are taken from the outbox at once,
+// messages with different subjects in a batch are published in parallel
+const outboxBatchSize = 256
+
+// time to wait for the stream to acknowledge a batch of messages
+var publishTimeout = 5 * time.Second
+
+// delay before messages in the outbox are published again, doubled after each failed attempt
+var outboxRetryDelay = time.Second
+
+const maxOutboxRetryDelay = 30 * time.Second
+
+var ErrOutboxFull = errors.New("outbox is full")
+var ErrOutboxClosed = errors.New("outbox is closed")
+
+// Outbox publishes messages to JetStream in the background and waits for the stream to acknowledge them.
+// Messages carry an id that the stream uses to discard duplicates, so they can safely be published again.
+// Messages with the same subject are published one after another, so that they are stored in the order they were added.
+// Messages that were not acknowledged are kept in memory and published again in order, until the outbox is closed.
+type Outbox struct {
+	js  jetstream.JetStream
+	log *slog.Logger
+
+	mu      sync.Mutex
+	pending []*nats.Msg
+	wake    chan struct{}
+	closed  bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewOutbox(js jetstream.JetStream, log *slog.Logger) *Outbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &Outbox{
+		js:     js,
+		log:    log,
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go o.run(ctx)
+	return o
+}
+
+// Publish adds a message with the given id for de-duplication and the trace context of ctx to the outbox,
+// it returns ErrOutboxFull if the stream didn't acknowledge the messages in the outbox for too long,
+// or ErrOutboxClosed after Close was called
+func (o *Outbox) Publish(ctx context.Context, subject string, id string, data []byte) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(jetstream.MsgIDHeader, id)
+	InjectTraceContext(ctx, msg.Header)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrOutboxClosed
+	}
+	if len(o.pending) >= outboxLimit {
+		return ErrOutboxFull
+	}
+	o.pending = append(o.pending, msg)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of messages in the outbox
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Close stops publishing in the background and tries to publish the remaining messages until ctx is done.
+// Messages that could not be published are lost.
+func (o *Outbox) Close(ctx context.Context) error {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	o.cancel()
+	<-o.done
+
+	for o.Len() > 0 {
+		if ctx.Err() != nil || !o.flush(ctx) {
+			return fmt.Errorf("%d messages in outbox could not be published", o.Len())
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) run(ctx context.Context) {
+	defer close(o.done)
+
+	delay := outboxRetryDelay
+	for {
+		var retry <-chan time.Time
+		if o.flush(ctx) {
+			delay = outboxRetryDelay
+		} else {
+			retry = time.After(delay)
+			delay = min(delay*2, maxOutboxRetryDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-retry:
+		}
+	}
+}
+
+// flush publishes the messages in the outbox, it returns false if a message was not acknowledged
+func (o *Outbox) flush(ctx context.Context) bool {
+	for {
+		o.mu.Lock()
+		batch := o.pending[:min(len(o.pending), outboxBatchSize)]
+		o.mu.Unlock()
+		if len(batch) == 0 {
+			return true
+		}
+
+		failed := o.publishBatch(ctx, batch)
+
+		o.mu.Lock()
+		// messages that were added in the meantime wait behind the failed messages
+		o.pending = append(failed, o.pending[len(batch):]...)
+		o.mu.Unlock()
+
+		if len(failed) > 0 {
+			return false
+		}
+	}
+}
+
+// publishBatch publishes the messages of each subject in order, waiting for each acknowledgement.
+// Publishing a subject stops at the first message that was not acknowledged, the messages that were not published are returned in order.
+func (o *Outbox) publishBatch(ctx context.Context, batch []*nats.Msg) []*nats.Msg {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	subjects := make(map[string][]int)
+	for i, msg := range batch {
+		subjects[msg.Subject] = append(subjects[msg.Subject], i)
+	}
+
+	var mu sync.Mutex
+	var lastErr error
+	// index of the first message of each subject that was not published
+	failedFrom := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for subject, indices := range subjects {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range indices {
+				msg := batch[i]
+				// the message is copied, since publishing sets its reply subject
+				_, err := o.js.PublishMsg(ctx, &nats.Msg{Subject: msg.Subject, Header: msg.Header, Data: msg.Data})
+				if err != nil {
+					mu.Lock()
+					lastErr = err
+					failedFrom[subject] = i
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := make([]*nats.Msg, 0)
+	for i, msg := range batch {
+		if from, ok := failedFrom[msg.Subject]; ok && i >= from {
+			failed = append(failed, msg)
+		}
+	}
+	if len(failed) > 0 {
+		o.log.Warn("publish failed, keeping messages in outbox", "failed", len(failed), "error", lastErr)
+	}
+	return failed
+}
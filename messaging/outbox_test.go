@@ -0,0 +1,166 @@
+// Copyright © 2024 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestJetStream(t *testing.T) jetstream.JetStream {
+	js, err := jetstream.New(connectNats(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server is shared by all tests
+	t.Cleanup(func() { js.DeleteStream(context.Background(), "TEST") })
+	return js
+}
+
+func streamMessages(t *testing.T, js jetstream.JetStream) uint64 {
+	stream, err := js.Stream(context.Background(), "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.State.Msgs
+}
+
+func TestOutboxDeduplicates(t *testing.T) {
+	js := newTestJetStream(t)
+	_, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "TEST", Subjects: []string{"test.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+	defer outbox.Close(context.Background())
+
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a")))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a")))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.b", "2", []byte("b")))
+
+	assert.Eventually(t, func() bool { return outbox.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(2), streamMessages(t, js))
+}
+
+func TestOutboxRetries(t *testing.T) {
+	defer func(delay time.Duration, timeout time.Duration) {
+		outboxRetryDelay = delay
+		publishTimeout = timeout
+	}(outboxRetryDelay, publishTimeout)
+	outboxRetryDelay = 10 * time.Millisecond
+	publishTimeout = 100 * time.Millisecond
+
+	js := newTestJetStream(t)
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+	defer outbox.Close(context.Background())
+
+	// there is no stream for the subject yet
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a")))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "2", []byte("b")))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, outbox.Len())
+
+	_, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "TEST", Subjects: []string{"test.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Eventually(t, func() bool { return outbox.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(2), streamMessages(t, js))
+
+	// the messages are kept in order
+	stream, _ := js.Stream(context.Background(), "TEST")
+	msg, err := stream.GetMsg(context.Background(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("a"), msg.Data)
+	}
+}
+
+func TestOutboxKeepsOrderAfterFailure(t *testing.T) {
+	defer func(delay time.Duration) {
+		outboxRetryDelay = delay
+	}(outboxRetryDelay)
+	outboxRetryDelay = time.Hour
+
+	js := newTestJetStream(t)
+	// messages that are too large are rejected
+	_, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:       "TEST",
+		Subjects:   []string{"test.>"},
+		MaxMsgSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+	defer outbox.Close(context.Background())
+
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a1")))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "2", make([]byte, 2048)))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "3", []byte("a3")))
+	assert.NoError(t, outbox.Publish(context.Background(), "test.b", "4", []byte("b4")))
+
+	// the messages after the rejected message of the subject are not published before it,
+	// other subjects are not held back
+	assert.Eventually(t, func() bool { return streamMessages(t, js) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return outbox.Len() == 2 }, 5*time.Second, 10*time.Millisecond)
+	outbox.mu.Lock()
+	assert.Len(t, outbox.pending[0].Data, 2048)
+	assert.Equal(t, []byte("a3"), outbox.pending[1].Data)
+	outbox.mu.Unlock()
+}
+
+func TestOutboxLimit(t *testing.T) {
+	defer func(limit int, timeout time.Duration) {
+		outboxLimit = limit
+		publishTimeout = timeout
+	}(outboxLimit, publishTimeout)
+	outboxLimit = 1
+	publishTimeout = 100 * time.Millisecond
+
+	js := newTestJetStream(t)
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+
+	assert.NoError(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a")))
+	assert.ErrorIs(t, outbox.Publish(context.Background(), "test.b", "2", []byte("b")), ErrOutboxFull)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Error(t, outbox.Close(ctx))
+}
+
+func TestOutboxClosed(t *testing.T) {
+	js := newTestJetStream(t)
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+	assert.NoError(t, outbox.Close(context.Background()))
+
+	assert.ErrorIs(t, outbox.Publish(context.Background(), "test.a", "1", []byte("a")), ErrOutboxClosed)
+	assert.Equal(t, 0, outbox.Len())
+}
@@ -0,0 +1,286 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package messaging
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"umbasa.net/seraph/events"
+)
+
+// time for which cancelled request ids are remembered, in case the cancel arrives before the request
+var searchCancelRetention = time.Minute
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SearchHandler answers a search request for the given types.
+// Results are published with replies.Reply. The context is cancelled when the
+// client is no longer interested in the results, the handler should stop early in that case.
+type SearchHandler func(ctx context.Context, req *events.SearchRequest, types []string, replies *SearchReplies) error
+
+// SearchReplies publishes the results of a search participant
+type SearchReplies struct {
+	nc        *nats.Conn
+	topic     string
+	requestId string
+	replyId   string
+
+	// estimated number of results in total, sent with the last reply
+	Total int64
+	// cursor for the next page, sent with the last reply
+	Cursor string
+}
+
+func (r *SearchReplies) Reply(typ string, reply map[string]any) error {
+	data, err := json.Marshal(events.SearchReply{
+		RequestId: r.requestId,
+		ReplyId:   r.replyId,
+		Type:      typ,
+		Reply:     reply,
+	})
+	if err != nil {
+		return err
+	}
+	return r.nc.Publish(r.topic, data)
+}
+
+func (r *SearchReplies) finish(err error) {
+	if err != nil {
+		data, _ := json.Marshal(events.SearchReply{
+			RequestId: r.requestId,
+			ReplyId:   r.replyId,
+			Error:     err.Error(),
+		})
+		r.nc.Publish(r.topic, data)
+	}
+
+	data, _ := json.Marshal(events.SearchReply{
+		RequestId: r.requestId,
+		ReplyId:   r.replyId,
+		Last:      true,
+		Total:     r.Total,
+		Cursor:    r.Cursor,
+	})
+	r.nc.Publish(r.topic, data)
+}
+
+// SearchParticipant answers search requests for one or more result types.
+// Only one instance of each named participant answers a request.
+type SearchParticipant struct {
+	name    string
+	types   []string
+	handler SearchHandler
+	nc      *nats.Conn
+	log     *slog.Logger
+
+	mu     sync.Mutex
+	active map[string]context.CancelFunc
+	// requests that were cancelled before they were started, with the time of the cancel
+	cancelled map[string]time.Time
+
+	requestSub *nats.Subscription
+	cancelSub  *nats.Subscription
+}
+
+func NewSearchParticipant(nc *nats.Conn, log *slog.Logger, name string, types []string, handler SearchHandler) *SearchParticipant {
+	return &SearchParticipant{
+		name:      name,
+		types:     types,
+		handler:   handler,
+		nc:        nc,
+		log:       log,
+		active:    make(map[string]context.CancelFunc),
+		cancelled: make(map[string]time.Time),
+	}
+}
+
+func (p *SearchParticipant) Start() error {
+	var err error
+	p.cancelSub, err = p.nc.Subscribe(events.SearchCancelTopic, p.handleCancel)
+	if err != nil {
+		return fmt.Errorf("while starting search participant %s: %w", p.name, err)
+	}
+	p.requestSub, err = p.nc.QueueSubscribe(events.SearchRequestTopic, "seraph.search."+p.name, func(msg *nats.Msg) {
+		go p.handleRequest(msg)
+	})
+	if err != nil {
+		p.cancelSub.Unsubscribe()
+		return fmt.Errorf("while starting search participant %s: %w", p.name, err)
+	}
+	return nil
+}
+
+func (p *SearchParticipant) Stop() {
+	if p.requestSub != nil {
+		p.requestSub.Unsubscribe()
+		p.requestSub = nil
+	}
+	if p.cancelSub != nil {
+		p.cancelSub.Unsubscribe()
+		p.cancelSub = nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, cancel := range p.active {
+		cancel()
+	}
+}
+
+func (p *SearchParticipant) handleRequest(msg *nats.Msg) {
+	req := events.SearchRequest{}
+	err := json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		p.log.Error("invalid search request", "error", err)
+		return
+	}
+
+	replyId := uuid.NewString()
+	ackTopic := fmt.Sprintf(events.SearchAckTopicPattern, req.RequestId)
+
+	types := p.matchTypes(req.Types)
+	if len(types) == 0 {
+		p.log.Debug("nack search request", "requestId", req.RequestId, "replyId", replyId, "types", req.Types, "query", req.Query)
+		data, _ := json.Marshal(events.SearchAck{
+			RequestId: req.RequestId,
+			ReplyId:   replyId,
+			Ack:       false,
+		})
+		p.nc.Publish(ackTopic, data)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ExtractTraceContext(context.Background(), msg))
+	defer cancel()
+	p.mu.Lock()
+	if _, ok := p.cancelled[req.RequestId]; ok {
+		delete(p.cancelled, req.RequestId)
+		p.mu.Unlock()
+		p.log.Debug("search request cancelled before it was started", "requestId", req.RequestId, "replyId", replyId)
+		return
+	}
+	p.active[req.RequestId] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.active, req.RequestId)
+		p.mu.Unlock()
+	}()
+
+	p.log.Debug("ack search request", "requestId", req.RequestId, "replyId", replyId, "types", types, "query", req.Query)
+	data, _ := json.Marshal(events.SearchAck{
+		RequestId: req.RequestId,
+		ReplyId:   replyId,
+		Ack:       true,
+		Types:     types,
+	})
+	p.nc.Publish(ackTopic, data)
+
+	replies := &SearchReplies{
+		nc:        p.nc,
+		topic:     fmt.Sprintf(events.SearchReplyTopicPattern, req.RequestId),
+		requestId: req.RequestId,
+		replyId:   replyId,
+	}
+	err = p.handler(ctx, &req, types, replies)
+	if ctx.Err() != nil {
+		p.log.Debug("search request cancelled", "requestId", req.RequestId, "replyId", replyId)
+		return
+	}
+	replies.finish(err)
+}
+
+func (p *SearchParticipant) handleCancel(msg *nats.Msg) {
+	cancelReq := events.SearchCancel{}
+	err := json.Unmarshal(msg.Data, &cancelReq)
+	if err != nil {
+		// the request id is also part of the topic
+		cancelReq.RequestId = strings.Split(msg.Subject, ".")[2]
+	}
+
+	p.mu.Lock()
+	cancel, ok := p.active[cancelReq.RequestId]
+	if !ok {
+		// the request may not have been received yet
+		now := time.Now()
+		for requestId, cancelled := range p.cancelled {
+			if now.Sub(cancelled) > searchCancelRetention {
+				delete(p.cancelled, requestId)
+			}
+		}
+		p.cancelled[cancelReq.RequestId] = now
+	}
+	p.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// matchTypes returns the requested types that the participant can answer
+func (p *SearchParticipant) matchTypes(requested []string) []string {
+	types := make([]string, 0)
+	for _, typ := range requested {
+		if slices.Contains(p.types, typ) {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+// SearchOffset returns the number of results to skip for the request.
+// The offset is taken from the cursor if the request has one.
+func SearchOffset(req *events.SearchRequest) (int, error) {
+	if req.Cursor == "" {
+		if req.Offset < 0 {
+			return 0, fmt.Errorf("invalid offset %d", req.Offset)
+		}
+		return req.Offset, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(req.Cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+// NextSearchCursor returns the cursor for the page after the page with the given offset and limit,
+// or "" if there are no more results
+func NextSearchCursor(offset int, limit int, total int64) string {
+	next := offset + limit
+	if int64(next) >= total {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(next)))
+}
@@ -0,0 +1,215 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"umbasa.net/seraph/events"
+)
+
+var natsServer *server.Server
+
+func TestMain(m *testing.M) {
+	setup()
+	code := m.Run()
+	shutdown()
+	os.Exit(code)
+}
+
+func setup() {
+	tmpDir, err := os.MkdirTemp("", "seraph-messaging-test-")
+	if err != nil {
+		panic(err)
+	}
+	natsServer, err = server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: tmpDir})
+	if err != nil {
+		panic(err)
+	}
+	natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		panic("nats server not ready")
+	}
+}
+
+func shutdown() {
+	if natsServer != nil {
+		storeDir := natsServer.JetStreamConfig().StoreDir
+		natsServer.Shutdown()
+		natsServer = nil
+		os.RemoveAll(storeDir)
+	}
+}
+
+func connectNats(t *testing.T) *nats.Conn {
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func search(t *testing.T, nc *nats.Conn, req events.SearchRequest) (chan events.SearchAck, chan events.SearchReply) {
+	acks := make(chan events.SearchAck, 10)
+	replies := make(chan events.SearchReply, 10)
+	ackSub, err := nc.Subscribe(fmt.Sprintf(events.SearchAckTopicPattern, req.RequestId), func(msg *nats.Msg) {
+		ack := events.SearchAck{}
+		json.Unmarshal(msg.Data, &ack)
+		acks <- ack
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { ackSub.Unsubscribe() })
+	replySub, err := nc.Subscribe(fmt.Sprintf(events.SearchReplyTopicPattern, req.RequestId), func(msg *nats.Msg) {
+		reply := events.SearchReply{}
+		json.Unmarshal(msg.Data, &reply)
+		replies <- reply
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { replySub.Unsubscribe() })
+
+	data, _ := json.Marshal(req)
+	assert.NoError(t, nc.Publish(events.SearchRequestTopic, data))
+	return acks, replies
+}
+
+func receive[T any](t *testing.T, ch chan T) T {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
+
+func TestSearchParticipantReplies(t *testing.T) {
+	nc := connectNats(t)
+
+	participant := NewSearchParticipant(nc, slog.Default(), "test", []string{events.SearchTypeSpaces}, func(ctx context.Context, req *events.SearchRequest, types []string, replies *SearchReplies) error {
+		if req.Query == "fail" {
+			return errors.New("failed")
+		}
+		replies.Total = 1
+		return replies.Reply(events.SearchTypeSpaces, map[string]any{"title": req.Query})
+	})
+	assert.NoError(t, participant.Start())
+	defer participant.Stop()
+
+	acks, replies := search(t, nc, events.SearchRequest{RequestId: "r1", Query: "photos", Types: []string{events.SearchTypeFiles, events.SearchTypeSpaces}})
+	ack := receive(t, acks)
+	assert.True(t, ack.Ack)
+	assert.Equal(t, []string{events.SearchTypeSpaces}, ack.Types)
+	reply := receive(t, replies)
+	assert.Equal(t, "photos", reply.Reply["title"])
+	assert.Equal(t, ack.ReplyId, reply.ReplyId)
+	reply = receive(t, replies)
+	assert.True(t, reply.Last)
+	assert.Equal(t, int64(1), reply.Total)
+
+	acks, replies = search(t, nc, events.SearchRequest{RequestId: "r2", Query: "fail", Types: []string{events.SearchTypeSpaces}})
+	assert.True(t, receive(t, acks).Ack)
+	assert.Equal(t, "failed", receive(t, replies).Error)
+	assert.True(t, receive(t, replies).Last)
+
+	acks, _ = search(t, nc, events.SearchRequest{RequestId: "r3", Query: "photos", Types: []string{events.SearchTypeFiles}})
+	assert.False(t, receive(t, acks).Ack)
+
+	// requests without types are not answered
+	acks, _ = search(t, nc, events.SearchRequest{RequestId: "r4", Query: "photos"})
+	assert.False(t, receive(t, acks).Ack)
+}
+
+func TestSearchParticipantCancel(t *testing.T) {
+	nc := connectNats(t)
+
+	cancelled := make(chan struct{})
+	participant := NewSearchParticipant(nc, slog.Default(), "test", []string{events.SearchTypeFiles}, func(ctx context.Context, req *events.SearchRequest, types []string, replies *SearchReplies) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	assert.NoError(t, participant.Start())
+	defer participant.Stop()
+
+	acks, replies := search(t, nc, events.SearchRequest{RequestId: "r1", Query: "photos", Types: []string{events.SearchTypeFiles}})
+	assert.True(t, receive(t, acks).Ack)
+
+	data, _ := json.Marshal(events.SearchCancel{RequestId: "r1"})
+	assert.NoError(t, nc.Publish(fmt.Sprintf(events.SearchCancelTopicPattern, "r1"), data))
+	receive(t, cancelled)
+
+	// no replies are sent for cancelled requests
+	select {
+	case reply := <-replies:
+		t.Fatalf("unexpected reply %+v", reply)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSearchParticipantCancelBeforeRequest(t *testing.T) {
+	nc := connectNats(t)
+
+	handled := make(chan string, 2)
+	participant := NewSearchParticipant(nc, slog.Default(), "test", []string{events.SearchTypeFiles}, func(ctx context.Context, req *events.SearchRequest, types []string, replies *SearchReplies) error {
+		handled <- req.RequestId
+		return nil
+	})
+	assert.NoError(t, participant.Start())
+	defer participant.Stop()
+
+	data, _ := json.Marshal(events.SearchCancel{RequestId: "r1"})
+	assert.NoError(t, nc.Publish(fmt.Sprintf(events.SearchCancelTopicPattern, "r1"), data))
+	assert.NoError(t, nc.Flush())
+
+	// the cancelled request is not answered, other requests are
+	search(t, nc, events.SearchRequest{RequestId: "r1", Query: "photos", Types: []string{events.SearchTypeFiles}})
+	search(t, nc, events.SearchRequest{RequestId: "r2", Query: "photos", Types: []string{events.SearchTypeFiles}})
+	assert.Equal(t, "r2", receive(t, handled))
+}
+
+func TestSearchCursor(t *testing.T) {
+	cursor := NextSearchCursor(10, 5, 20)
+	assert.NotEmpty(t, cursor)
+	offset, err := SearchOffset(&events.SearchRequest{Offset: 3, Cursor: cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 15, offset)
+
+	assert.Empty(t, NextSearchCursor(15, 5, 20))
+
+	offset, err = SearchOffset(&events.SearchRequest{Offset: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, offset)
+
+	_, err = SearchOffset(&events.SearchRequest{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = SearchOffset(&events.SearchRequest{Offset: -1})
+	assert.Error(t, err)
+}
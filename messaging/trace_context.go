@@ -5,11 +5,17 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func ExtractTraceContext(ctx context.Context, msg *nats.Msg) context.Context {
+	return ExtractTraceContextHeader(ctx, msg.Header)
+}
+
+// ExtractTraceContextHeader extracts the trace context from message headers, for messages that are received from JetStream
+func ExtractTraceContextHeader(ctx context.Context, header nats.Header) context.Context {
 	propagator := propagation.TraceContext{}
-	return propagator.Extract(ctx, propagation.HeaderCarrier(msg.Header))
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
 }
 
 func InjectTraceContext(ctx context.Context, header nats.Header) nats.Header {
@@ -17,3 +23,16 @@ func InjectTraceContext(ctx context.Context, header nats.Header) nats.Header {
 	propagator.Inject(ctx, propagation.HeaderCarrier(header))
 	return header
 }
+
+// EventLink links the span of a consumer to the span that published the event in header
+func EventLink(header nats.Header) trace.Link {
+	return trace.LinkFromContext(ExtractTraceContextHeader(context.Background(), header))
+}
+
+// PublishEvent publishes an event with the trace context of ctx, so that consumers can link their spans to it
+func PublishEvent(ctx context.Context, nc *nats.Conn, subject string, data []byte) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	InjectTraceContext(ctx, msg.Header)
+	return nc.PublishMsg(msg)
+}
@@ -0,0 +1,118 @@
+// Copyright © 2024 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer(t *testing.T) (trace.Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return provider.Tracer("test"), exporter
+}
+
+func exportedSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %s was not exported", name)
+	return tracetest.SpanStub{}
+}
+
+func TestOutboxTraceContext(t *testing.T) {
+	tracer, exporter := newTestTracer(t)
+	js := newTestJetStream(t)
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "TEST", Subjects: []string{"test.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := NewOutbox(js, slog.New(slog.DiscardHandler))
+	defer outbox.Close(context.Background())
+
+	ctx, publishSpan := tracer.Start(context.Background(), "publish")
+	assert.NoError(t, outbox.Publish(ctx, "test.a", "1", []byte("a")))
+	publishSpan.End()
+
+	consumer, err := stream.CreateOrUpdateConsumer(context.Background(), jetstream.ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := consumer.Next(jetstream.FetchMaxWait(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer continues the trace of the publisher and links to its span
+	_, handleSpan := tracer.Start(ExtractTraceContextHeader(context.Background(), msg.Headers()), "handle",
+		trace.WithLinks(EventLink(msg.Headers())))
+	handleSpan.End()
+
+	published := exportedSpan(t, exporter, "publish")
+	handled := exportedSpan(t, exporter, "handle")
+	assert.Equal(t, published.SpanContext.TraceID(), handled.SpanContext.TraceID())
+	assert.Equal(t, published.SpanContext.SpanID(), handled.Parent.SpanID())
+	if assert.Len(t, handled.Links, 1) {
+		assert.Equal(t, published.SpanContext.SpanID(), handled.Links[0].SpanContext.SpanID())
+	}
+}
+
+func TestPublishEventTraceContext(t *testing.T) {
+	tracer, exporter := newTestTracer(t)
+	nc := connectNats(t)
+
+	sub, err := nc.SubscribeSync("test.event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, publishSpan := tracer.Start(context.Background(), "publish")
+	assert.NoError(t, PublishEvent(ctx, nc, "test.event", []byte("a")))
+	publishSpan.End()
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, handleSpan := tracer.Start(ExtractTraceContext(context.Background(), msg), "handle")
+	handleSpan.End()
+
+	published := exportedSpan(t, exporter, "publish")
+	handled := exportedSpan(t, exporter, "handle")
+	assert.Equal(t, published.SpanContext.SpanID(), handled.Parent.SpanID())
+	assert.Equal(t, []byte("a"), msg.Data)
+}
+
+func TestEventLinkWithoutTraceContext(t *testing.T) {
+	link := EventLink(nil)
+	assert.False(t, link.SpanContext.IsValid())
+}
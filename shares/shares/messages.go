@@ -18,6 +18,8 @@
 
 package shares
 
+import "umbasa.net/seraph/spaces/spaces"
+
 type ShareResolveRequest struct {
 	ShareId string `bson:"shareId" json:"shareId"`
 	Path    string `bson:"path" json:"path"`
@@ -28,6 +30,21 @@ type ShareResolveResponse struct {
 	ProviderId string `bson:"providerId" json:"providerId"`
 	Path       string `bson:"path" json:"path"`
 	ReadOnly   bool   `bson:"readOnly" json:"readOnly"`
+
+	// Union is set instead of ProviderId and Path if the share points into a union space provider.
+	// The paths of the members are already resolved to the shared location.
+	Union      []spaces.UnionMember `bson:"union" json:"union"`
+	Precedence string               `bson:"precedence" json:"precedence"`
+
+	// set if versioning is enabled for the space that contains the share
+	Versioning *spaces.VersioningConfig `bson:"versioning" json:"versioning"`
+	// set if the trash is enabled for the space that contains the share
+	Trash *spaces.TrashConfig `bson:"trash" json:"trash"`
+}
+
+// Found returns true if the share was resolved successfully
+func (r *ShareResolveResponse) Found() bool {
+	return r.ProviderId != "" || len(r.Union) > 0
 }
 
 type ShareCrudRequest struct {
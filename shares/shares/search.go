@@ -0,0 +1,98 @@
+// Copyright © 2025 Benjamin Schmitz
+
+// This file is part of Seraph <https://github.com/Vortex375/seraph>.
+
+// Seraph is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License
+// as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option)
+// any later version.
+
+// Seraph is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with Seraph.  If not, see <http://www.gnu.org/licenses/>.
+
+package shares
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"umbasa.net/seraph/events"
+	"umbasa.net/seraph/messaging"
+)
+
+// handleSearch answers search requests for shares owned by the user
+// whose title, description or path contain all words of the query
+func (s *SharesProvider) handleSearch(ctx context.Context, req *events.SearchRequest, types []string, replies *messaging.SearchReplies) error {
+	ctx, span := s.tracer.Start(ctx, "search")
+	defer span.End()
+
+	filter := bson.M{"owner": req.UserId}
+	words := strings.Fields(req.Query)
+	if len(words) > 0 {
+		conditions := bson.A{}
+		for _, word := range words {
+			regex := bson.M{"$regex": regexp.QuoteMeta(word), "$options": "i"}
+			conditions = append(conditions, bson.M{"$or": bson.A{
+				bson.M{"title": regex},
+				bson.M{"description": regex},
+				bson.M{"path": regex},
+			}})
+		}
+		filter["$and"] = conditions
+	}
+
+	limit := req.PageLimit()
+	offset, err := messaging.SearchOffset(req)
+	if err != nil {
+		return err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "title", Value: 1}, {Key: "shareId", Value: 1}}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cur, err := s.shares.Find(ctx, filter, opts)
+	if err != nil {
+		s.log.Error("error while searching shares", "error", err)
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		share := Share{}
+		if err := cur.Decode(&share); err != nil {
+			s.log.Error("error while decoding shares", "error", err)
+			return err
+		}
+		err := replies.Reply(events.SearchTypeShares, map[string]any{
+			"shareId":     share.ShareId,
+			"title":       share.Title,
+			"description": share.Description,
+			"providerId":  share.ProviderId,
+			"path":        share.Path,
+			"isDir":       share.IsDir,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		s.log.Error("error while searching shares", "error", err)
+		return err
+	}
+
+	total, err := s.shares.CountDocuments(ctx, filter)
+	if err != nil {
+		s.log.Error("error while counting shares", "error", err)
+		return err
+	}
+	replies.Total = total
+	replies.Cursor = messaging.NextSearchCursor(offset, limit, total)
+	return nil
+}
@@ -34,6 +34,7 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 	"umbasa.net/seraph/entities"
+	"umbasa.net/seraph/events"
 	"umbasa.net/seraph/logging"
 	"umbasa.net/seraph/messaging"
 	"umbasa.net/seraph/spaces/spaces"
@@ -66,6 +67,7 @@ type SharesProvider struct {
 	shares     *mongo.Collection
 	resolveSub *nats.Subscription
 	crudSub    *nats.Subscription
+	search     *messaging.SearchParticipant
 }
 
 func New(p Params) (Result, error) {
@@ -116,10 +118,19 @@ func (s *SharesProvider) Start() error {
 		return fmt.Errorf("While starting SharesProvider: %w", err)
 	}
 	s.crudSub = sub
+	s.search = messaging.NewSearchParticipant(s.nc, s.log, "shares", []string{events.SearchTypeShares}, s.handleSearch)
+	err = s.search.Start()
+	if err != nil {
+		return fmt.Errorf("While starting SharesProvider: %w", err)
+	}
 	return nil
 }
 
 func (s *SharesProvider) Stop() error {
+	if s.search != nil {
+		s.search.Stop()
+		s.search = nil
+	}
 	var err error
 	if s.crudSub != nil {
 		err = s.crudSub.Unsubscribe()
@@ -177,17 +188,40 @@ func (s *SharesProvider) resolveShare(ctx context.Context, req *ShareResolveRequ
 		}
 	}
 
-	if space.ProviderId == "" {
+	if !space.Found() {
 		s.log.Warn("space not found for share "+req.ShareId, "shareId", req.ShareId)
 		return &ShareResolveResponse{}
 	}
 
+	if len(space.Union) > 0 {
+		union := make([]spaces.UnionMember, len(space.Union))
+		for i, member := range space.Union {
+			member.Path = path.Join(member.Path, share.Path, cleanPath)
+			union[i] = member
+		}
+		versioning := space.Versioning
+		if versioning != nil {
+			// paths in a union are relative to the share, so the versions of the share are found below the share path
+			unionVersioning := *versioning
+			unionVersioning.Path = path.Join(unionVersioning.Path, share.Path)
+			versioning = &unionVersioning
+		}
+		return &ShareResolveResponse{
+			ReadOnly:   share.ReadOnly || space.ReadOnly,
+			Union:      union,
+			Precedence: space.Precedence,
+			Versioning: versioning,
+		}
+	}
+
 	resolvedPath := path.Join(space.Path, share.Path, cleanPath)
 
 	return &ShareResolveResponse{
 		ProviderId: space.ProviderId,
 		Path:       resolvedPath,
 		ReadOnly:   share.ReadOnly || space.ReadOnly,
+		Versioning: space.Versioning,
+		Trash:      space.Trash,
 	}
 }
 
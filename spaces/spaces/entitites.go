@@ -1,10 +1,24 @@
 package spaces
 
 import (
+	"path"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"umbasa.net/seraph/entities"
 )
 
+// name collisions in a union are resolved by the order of the members: the first member containing a name wins
+const UnionPrecedenceOrder = "order"
+
+// name collisions in a union are resolved by modification time: the most recently modified file wins
+const UnionPrecedenceNewest = "newest"
+
+// name of the hidden directory that holds previous versions of files if no separate location is configured
+const VersionsDir = ".versions"
+
+// name of the hidden directory that holds deleted files if the trash is enabled
+const TrashDir = ".trash"
+
 type SpacePrototype struct {
 	entities.Prototype
 
@@ -28,4 +42,85 @@ type SpaceFileProvider struct {
 	ProviderId      string `bson:"providerId" json:"providerId"`
 	Path            string `bson:"path" json:"path"`
 	ReadOnly        bool   `bson:"readOnly" json:"readOnly"`
+
+	// if set, the entry is a union that merges the paths of several providers into one tree.
+	// ProviderId and Path are not used in this case.
+	Union []UnionMember `bson:"union,omitempty" json:"union,omitempty"`
+	// how name collisions between members of a union are resolved, one of UnionPrecedenceOrder (default) or UnionPrecedenceNewest
+	Precedence string `bson:"precedence,omitempty" json:"precedence,omitempty"`
+
+	Versioning *VersioningConfig `bson:"versioning,omitempty" json:"versioning,omitempty"`
+	Trash      *TrashConfig      `bson:"trash,omitempty" json:"trash,omitempty"`
+}
+
+// Keeps the previous content of files that are overwritten.
+// Versions are stored in the hidden VersionsDir of the entry, unless ProviderId and Path are set.
+// Retention limits apply to the versions of each file, a value of 0 means "unlimited".
+type VersioningConfig struct {
+	Enabled     bool  `bson:"enabled" json:"enabled"`
+	MaxVersions int   `bson:"maxVersions" json:"maxVersions"`
+	MaxAgeDays  int   `bson:"maxAgeDays" json:"maxAgeDays"`
+	MaxSize     int64 `bson:"maxSize" json:"maxSize"`
+
+	ProviderId string `bson:"providerId,omitempty" json:"providerId,omitempty"`
+	Path       string `bson:"path,omitempty" json:"path,omitempty"`
+
+	// path of the entry on its provider that version paths are relative to, set when resolving the entry
+	Root string `bson:"-" json:"root,omitempty"`
+}
+
+// A provider path that is part of a union
+type UnionMember struct {
+	ProviderId string `bson:"providerId" json:"providerId"`
+	Path       string `bson:"path" json:"path"`
+	ReadOnly   bool   `bson:"readOnly" json:"readOnly"`
+
+	// new files and directories are created in the first member that is a write target
+	Write bool `bson:"write" json:"write"`
+}
+
+// Returns the provider paths that make up the entry.
+// For an entry that is not a union this is a single member.
+func (p *SpaceFileProvider) Members() []UnionMember {
+	if len(p.Union) > 0 {
+		return p.Union
+	}
+	return []UnionMember{{
+		ProviderId: p.ProviderId,
+		Path:       p.Path,
+		ReadOnly:   p.ReadOnly,
+		Write:      !p.ReadOnly,
+	}}
+}
+
+// Moves deleted files into the hidden TrashDir of the entry instead of deleting them permanently.
+// Items are purged automatically after RetentionDays, a value of 0 means "never".
+type TrashConfig struct {
+	Enabled       bool `bson:"enabled" json:"enabled"`
+	RetentionDays int  `bson:"retentionDays" json:"retentionDays"`
+
+	// set when resolving the entry
+	SpaceProviderId string `bson:"-" json:"spaceProviderId,omitempty"`
+	Root            string `bson:"-" json:"root,omitempty"`
+}
+
+// Returns the provider and path where deleted files are kept.
+// Returns empty strings if the trash is not enabled.
+func (p *SpaceFileProvider) TrashLocation() (string, string) {
+	if p.Trash == nil || !p.Trash.Enabled || len(p.Union) > 0 {
+		return "", ""
+	}
+	return p.ProviderId, path.Join(p.Path, TrashDir)
+}
+
+// Returns the provider and path where previous versions of files are stored.
+// Returns empty strings if versioning is not enabled.
+func (p *SpaceFileProvider) VersionsLocation() (string, string) {
+	if p.Versioning == nil || !p.Versioning.Enabled {
+		return "", ""
+	}
+	if p.Versioning.ProviderId != "" {
+		return p.Versioning.ProviderId, p.Versioning.Path
+	}
+	return p.ProviderId, path.Join(p.Path, VersionsDir)
 }
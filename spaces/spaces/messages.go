@@ -28,6 +28,21 @@ type SpaceResolveResponse struct {
 	ProviderId string `bson:"providerId" json:"providerId"`
 	Path       string `bson:"path" json:"path"`
 	ReadOnly   bool   `bson:"readOnly" json:"readOnly"`
+
+	// set instead of ProviderId and Path if the space provider is a union
+	Union      []UnionMember `bson:"union" json:"union"`
+	Precedence string        `bson:"precedence" json:"precedence"`
+
+	// set if versioning is enabled, with the location of the versions filled in
+	Versioning *VersioningConfig `bson:"versioning" json:"versioning"`
+	// set if the trash is enabled
+	Trash *TrashConfig `bson:"trash" json:"trash"`
+}
+
+// Returns true if the space provider was resolved successfully.
+// An empty response indicates "not found".
+func (r *SpaceResolveResponse) Found() bool {
+	return r.ProviderId != "" || len(r.Union) > 0
 }
 
 type SpaceCrudRequest struct {
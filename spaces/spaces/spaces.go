@@ -33,6 +33,7 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 	"umbasa.net/seraph/entities"
+	"umbasa.net/seraph/events"
 	"umbasa.net/seraph/logging"
 	"umbasa.net/seraph/messaging"
 	"umbasa.net/seraph/tracing"
@@ -64,6 +65,7 @@ type SpacesProvider struct {
 	spaces     *mongo.Collection
 	resolveSub *nats.Subscription
 	crudSub    *nats.Subscription
+	search     *messaging.SearchParticipant
 }
 
 func New(p Params) (Result, error) {
@@ -114,10 +116,19 @@ func (s *SpacesProvider) Start() error {
 		return fmt.Errorf("while starting SpacesProvider: %w", err)
 	}
 	s.crudSub = sub
+	s.search = messaging.NewSearchParticipant(s.nc, s.log, "spaces", []string{events.SearchTypeSpaces}, s.handleSearch)
+	err = s.search.Start()
+	if err != nil {
+		return fmt.Errorf("while starting SpacesProvider: %w", err)
+	}
 	return nil
 }
 
 func (s *SpacesProvider) Stop() error {
+	if s.search != nil {
+		s.search.Stop()
+		s.search = nil
+	}
 	var err error
 	if s.crudSub != nil {
 		err = s.crudSub.Unsubscribe()
@@ -160,10 +171,21 @@ func (s *SpacesProvider) resolveSpace(ctx context.Context, req *SpaceResolveRequ
 
 	for _, provider := range space.FileProviders {
 		if provider.SpaceProviderId == req.SpaceProviderId {
+			versioning := resolveVersioning(&provider)
+			if len(provider.Union) > 0 {
+				return &SpaceResolveResponse{
+					ReadOnly:   provider.ReadOnly,
+					Union:      provider.Union,
+					Precedence: provider.Precedence,
+					Versioning: versioning,
+				}
+			}
 			return &SpaceResolveResponse{
 				ProviderId: provider.ProviderId,
 				Path:       provider.Path,
 				ReadOnly:   provider.ReadOnly,
+				Versioning: versioning,
+				Trash:      resolveTrash(&provider),
 			}
 		}
 	}
@@ -308,7 +330,19 @@ func (s *SpacesProvider) validateSpaceProviderUnique(ctx context.Context, proto
 				return errors.New("duplicate spaceProviderId: " + provider.SpaceProviderId)
 			}
 			uniq[provider.SpaceProviderId] = true
-			if !strings.HasPrefix(provider.Path, "/") {
+			err := validateUnion(&provider)
+			if err != nil {
+				return err
+			}
+			err = validateVersioning(&provider)
+			if err != nil {
+				return err
+			}
+			err = validateTrash(&provider)
+			if err != nil {
+				return err
+			}
+			if len(provider.Union) == 0 && !strings.HasPrefix(provider.Path, "/") {
 				return errors.New("fileProvider " + provider.SpaceProviderId + ": path must start with '/'")
 			}
 		}
@@ -337,3 +371,84 @@ func (s *SpacesProvider) validateSpaceProviderUnique(ctx context.Context, proto
 
 	return nil
 }
+
+func validateUnion(provider *SpaceFileProvider) error {
+	switch provider.Precedence {
+	case "", UnionPrecedenceOrder, UnionPrecedenceNewest:
+	default:
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": invalid precedence: " + provider.Precedence)
+	}
+
+	for _, member := range provider.Union {
+		if member.ProviderId == "" {
+			return errors.New("fileProvider " + provider.SpaceProviderId + ": union member is missing providerId")
+		}
+		if !strings.HasPrefix(member.Path, "/") {
+			return errors.New("fileProvider " + provider.SpaceProviderId + ": path of union member " + member.ProviderId + " must start with '/'")
+		}
+	}
+
+	return nil
+}
+
+func validateVersioning(provider *SpaceFileProvider) error {
+	versioning := provider.Versioning
+	if versioning == nil {
+		return nil
+	}
+
+	if versioning.MaxVersions < 0 || versioning.MaxAgeDays < 0 || versioning.MaxSize < 0 {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": versioning limits must not be negative")
+	}
+	if versioning.ProviderId == "" && versioning.Path != "" {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": versioning path requires providerId")
+	}
+	if versioning.ProviderId != "" && !strings.HasPrefix(versioning.Path, "/") {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": versioning path must start with '/'")
+	}
+	if versioning.Enabled && len(provider.Union) > 0 && versioning.ProviderId == "" {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": versioning of a union requires providerId and path")
+	}
+
+	return nil
+}
+
+func resolveVersioning(provider *SpaceFileProvider) *VersioningConfig {
+	if provider.Versioning == nil || !provider.Versioning.Enabled {
+		return nil
+	}
+
+	versioning := *provider.Versioning
+	versioning.ProviderId, versioning.Path = provider.VersionsLocation()
+	if len(provider.Union) == 0 {
+		versioning.Root = provider.Path
+	}
+	return &versioning
+}
+
+func validateTrash(provider *SpaceFileProvider) error {
+	trash := provider.Trash
+	if trash == nil {
+		return nil
+	}
+
+	if trash.RetentionDays < 0 {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": trash retentionDays must not be negative")
+	}
+	if trash.Enabled && len(provider.Union) > 0 {
+		return errors.New("fileProvider " + provider.SpaceProviderId + ": trash is not supported for a union")
+	}
+
+	return nil
+}
+
+func resolveTrash(provider *SpaceFileProvider) *TrashConfig {
+	if provider.Trash == nil || !provider.Trash.Enabled {
+		return nil
+	}
+
+	trash := *provider.Trash
+	trash.SpaceProviderId = provider.SpaceProviderId
+	trash.Root = provider.Path
+	return &trash
+}